@@ -0,0 +1,319 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"unicode"
+)
+
+// errInterrupted is returned by ReadLine on Ctrl-C, the line is discarded
+var errInterrupted = errors.New("interrupted")
+
+// editor reads the lines of the shell, with the history and the completion when the input is a terminal.
+// The messages printed while a line is edited (i.e: the events) are written above it.
+type editor struct {
+	in       *bufio.Reader
+	out      io.Writer
+	raw      bool
+	restore  func()
+	mutex    sync.Mutex
+	editing  bool
+	prompt   string
+	line     []rune
+	pos      int
+	history  []string
+	index    int
+	complete func(head string) (int, []string) // returns the start of the word at the cursor and its candidates
+	tabbed   bool
+}
+
+// newEditor creates the editor of the stdin, in raw mode if it is a terminal
+func newEditor(complete func(head string) (int, []string)) *editor {
+	e := &editor{in: bufio.NewReader(os.Stdin), out: os.Stdout, complete: complete}
+	if restore, err := makeRaw(int(os.Stdin.Fd())); err == nil {
+		e.raw = true
+		e.restore = restore
+	}
+	return e
+}
+
+// Close restores the terminal.
+func (e *editor) Close() {
+	if e.restore != nil {
+		e.restore()
+	}
+}
+
+// SetHistory sets the lines recalled by the up and down keys, the oldest first.
+func (e *editor) SetHistory(lines []string) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	e.history = lines
+}
+
+// AddHistory appends the line to the history, unless it repeats the last one.
+func (e *editor) AddHistory(line string) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	if n := len(e.history); n > 0 && e.history[n-1] == line {
+		return
+	}
+	e.history = append(e.history, line)
+}
+
+// Print writes the message, above the line being edited if any.
+func (e *editor) Print(message string) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	if !strings.HasSuffix(message, "\n") {
+		message += "\n"
+	}
+	if !e.editing {
+		fmt.Fprint(e.out, message)
+		return
+	}
+	fmt.Fprint(e.out, "\r\x1b[K"+message)
+	e.redraw()
+}
+
+// ReadLine reads the next line after the prompt.
+func (e *editor) ReadLine(prompt string) (string, error) {
+	if !e.raw {
+		fmt.Fprint(e.out, prompt)
+		line, err := e.in.ReadString('\n')
+		if err != nil && (err != io.EOF || line == "") {
+			return "", err
+		}
+		return strings.TrimRight(line, "\r\n"), nil
+	}
+	e.mutex.Lock()
+	e.editing, e.prompt, e.line, e.pos, e.index, e.tabbed = true, prompt, nil, 0, len(e.history), false
+	e.redraw()
+	e.mutex.Unlock()
+	defer func() {
+		e.mutex.Lock()
+		e.editing = false
+		e.mutex.Unlock()
+	}()
+	for {
+		r, _, err := e.in.ReadRune()
+		if err != nil {
+			return "", err
+		}
+		e.mutex.Lock()
+		line, done, err := e.key(r)
+		e.mutex.Unlock()
+		if done || err != nil {
+			return line, err
+		}
+	}
+}
+
+// key applies the key to the line, the lock must be held
+func (e *editor) key(r rune) (string, bool, error) {
+	tabbed := e.tabbed
+	e.tabbed = false
+	switch r {
+	case '\r', '\n':
+		fmt.Fprint(e.out, "\n")
+		return string(e.line), true, nil
+	case 3: // Ctrl-C
+		fmt.Fprint(e.out, "^C\n")
+		return "", true, errInterrupted
+	case 4: // Ctrl-D
+		if len(e.line) == 0 {
+			fmt.Fprint(e.out, "\n")
+			return "", true, io.EOF
+		}
+		e.delete(e.pos, e.pos+1)
+	case 127, 8: // Backspace
+		e.delete(e.pos-1, e.pos)
+	case 1: // Ctrl-A
+		e.pos = 0
+	case 5: // Ctrl-E
+		e.pos = len(e.line)
+	case 11: // Ctrl-K
+		e.delete(e.pos, len(e.line))
+	case 21: // Ctrl-U
+		e.delete(0, e.pos)
+	case 23: // Ctrl-W
+		start := e.pos
+		for start > 0 && e.line[start-1] == ' ' {
+			start--
+		}
+		for start > 0 && e.line[start-1] != ' ' {
+			start--
+		}
+		e.delete(start, e.pos)
+	case 12: // Ctrl-L
+		fmt.Fprint(e.out, "\x1b[H\x1b[2J")
+	case '\t':
+		e.tab(tabbed)
+	case 27:
+		e.escape()
+	default:
+		if !unicode.IsPrint(r) {
+			return "", false, nil
+		}
+		e.line = append(e.line[:e.pos], append([]rune{r}, e.line[e.pos:]...)...)
+		e.pos++
+	}
+	e.redraw()
+	return "", false, nil
+}
+
+// escape applies the escape sequence of the arrow, home, end and delete keys
+func (e *editor) escape() {
+	r, _, err := e.in.ReadRune()
+	if err != nil || (r != '[' && r != 'O') {
+		return
+	}
+	r, _, err = e.in.ReadRune()
+	if err != nil {
+		return
+	}
+	switch r {
+	case 'A':
+		e.recall(-1)
+	case 'B':
+		e.recall(1)
+	case 'C':
+		if e.pos < len(e.line) {
+			e.pos++
+		}
+	case 'D':
+		if e.pos > 0 {
+			e.pos--
+		}
+	case 'H':
+		e.pos = 0
+	case 'F':
+		e.pos = len(e.line)
+	case '1', '3', '4', '7', '8':
+		if next, _, err := e.in.ReadRune(); err != nil || next != '~' {
+			return
+		}
+		switch r {
+		case '1', '7':
+			e.pos = 0
+		case '4', '8':
+			e.pos = len(e.line)
+		case '3':
+			e.delete(e.pos, e.pos+1)
+		}
+	}
+}
+
+// recall replaces the line by the previous (-1) or the next (+1) line of the history
+func (e *editor) recall(step int) {
+	index := e.index + step
+	if index < 0 || index > len(e.history) {
+		return
+	}
+	e.index = index
+	if index == len(e.history) {
+		e.line = nil
+	} else {
+		e.line = []rune(e.history[index])
+	}
+	e.pos = len(e.line)
+}
+
+// tab completes the word at the cursor by its candidates, the candidates are listed on the second tab
+func (e *editor) tab(listing bool) {
+	if e.complete == nil {
+		return
+	}
+	start, candidates := e.complete(string(e.line[:e.pos]))
+	if len(candidates) == 0 {
+		return
+	}
+	start = len([]rune(string(e.line[:e.pos])[:start]))
+	word := string(e.line[start:e.pos])
+	completion := candidates[0]
+	for _, c := range candidates[1:] {
+		completion = commonPrefix(completion, c)
+	}
+	if len(candidates) == 1 && !strings.HasSuffix(completion, "=") && !strings.HasSuffix(completion, " ") {
+		completion += " "
+	}
+	if len([]rune(completion)) > len([]rune(word)) {
+		rest := append([]rune(completion), e.line[e.pos:]...)
+		e.line = append(e.line[:start:start], rest...)
+		e.pos = start + len([]rune(completion))
+		return
+	}
+	if !listing {
+		e.tabbed = true
+		return
+	}
+	sort.Strings(candidates)
+	fmt.Fprint(e.out, "\r\x1b[K"+columnize(candidates, 100))
+}
+
+// delete removes the runes of the line between from and to
+func (e *editor) delete(from, to int) {
+	if from < 0 {
+		from = 0
+	}
+	if to > len(e.line) {
+		to = len(e.line)
+	}
+	if from >= to {
+		return
+	}
+	e.line = append(e.line[:from], e.line[to:]...)
+	if e.pos > to {
+		e.pos -= to - from
+	} else if e.pos > from {
+		e.pos = from
+	}
+}
+
+// redraw writes the prompt and the line, then moves the cursor to its position, the lock must be held
+func (e *editor) redraw() {
+	fmt.Fprint(e.out, "\r\x1b[K"+e.prompt+string(e.line))
+	if back := len(e.line) - e.pos; back > 0 {
+		fmt.Fprintf(e.out, "\x1b[%dD", back)
+	}
+}
+
+// commonPrefix returns the common prefix of a and b, case insensitive, as written in b
+func commonPrefix(a, b string) string {
+	ra, rb := []rune(a), []rune(b)
+	n := 0
+	for n < len(ra) && n < len(rb) && unicode.ToLower(ra[n]) == unicode.ToLower(rb[n]) {
+		n++
+	}
+	return string(rb[:n])
+}
+
+// columnize lays out the words in columns within the width
+func columnize(words []string, width int) string {
+	size := 0
+	for _, w := range words {
+		if len(w) > size {
+			size = len(w)
+		}
+	}
+	size += 2
+	perLine := width / size
+	if perLine < 1 {
+		perLine = 1
+	}
+	var b strings.Builder
+	for i, w := range words {
+		if i > 0 && i%perLine == 0 {
+			b.WriteString("\n")
+		}
+		fmt.Fprintf(&b, "%-*s", size, w)
+	}
+	b.WriteString("\n")
+	return b.String()
+}
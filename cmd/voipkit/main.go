@@ -0,0 +1,423 @@
+// Command voipkit sends actions to the Asterisk Manager Interface from the terminal.
+//
+// Usage:
+//
+//	voipkit [-config file] [-profile name] [-host host] [-port port] [-username name] [-timeout 10s] [-debug] [-tap text|jsonl] <command> [arguments]
+//
+// The commands:
+//
+//	action <Action> [Key=Value...]       send any action, i.e: action Originate Channel=PJSIP/1001 Exten=1002 Context=default Priority=1
+//	command <cli>                        run an Asterisk CLI command, i.e: command "core show uptime"
+//	events [-filter Hangup,Queue*]       tail the events in the raw, json or table format
+//	peers                                list the chan_sip peers
+//	endpoints                            list the chan_pjsip endpoints
+//	queues [queue]                       list the queues
+//	channels                             list the active channels
+//	hangup <channel> [cause]             hang up the channel
+//	redirect <channel> <context> <exten> [priority]
+//	shell                                the interactive shell, with the completion of the actions, the history and the macros
+//
+// The connection is the profile of the config file (~/.voipkit.json or $VOIPKIT_CONFIG),
+// overridden by the environment variables VOIPKIT_HOST, VOIPKIT_PORT, VOIPKIT_USERNAME, VOIPKIT_PASSWORD and VOIPKIT_TIMEOUT,
+// then by the flags.
+//
+// The -tap flag writes the frames sent and received to stderr, with the Secret and Key headers redacted,
+// i.e: to see why Asterisk rejects an action.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"path"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"github.com/pnguyen215/voipkit/pkg/ami"
+	"github.com/pnguyen215/voipkit/pkg/ami/config"
+)
+
+// command is a subcommand of the tool
+type command struct {
+	usage string
+	run   func(ctx context.Context, c *client, args []string) error
+}
+
+var commands = map[string]command{
+	"action":    {usage: "action [-format raw|json] <Action> [Key=Value...]", run: runAction},
+	"command":   {usage: "command <cli>", run: runCommand},
+	"events":    {usage: "events [-filter patterns] [-format raw|json|table] [-fields Channel,Uniqueid]", run: runEvents},
+	"peers":     {usage: "peers [-format table|json]", run: runPeers},
+	"endpoints": {usage: "endpoints [-format table|json]", run: runEndpoints},
+	"queues":    {usage: "queues [-format table|json] [queue]", run: runQueues},
+	"channels":  {usage: "channels [-format table|json]", run: runChannels},
+	"hangup":    {usage: "hangup <channel> [cause]", run: runHangup},
+	"redirect":  {usage: "redirect <channel> <context> <exten> [priority]", run: runRedirect},
+	"shell":     {usage: "shell [-events] [-filter patterns] [-history file] [-macros file]", run: runShell},
+}
+
+// errUsage is returned on invalid arguments, the usage of the command is printed
+type errUsage struct {
+	message string
+}
+
+func (e errUsage) Error() string {
+	return e.message
+}
+
+func main() {
+	os.Exit(run(os.Args[1:]))
+}
+
+func run(args []string) int {
+	flags := flag.NewFlagSet("voipkit", flag.ContinueOnError)
+	flags.Usage = func() { usage(flags) }
+	file := flags.String("config", os.Getenv("VOIPKIT_CONFIG"), "config file of the profiles (default ~/.voipkit.json)")
+	name := flags.String("profile", os.Getenv("VOIPKIT_PROFILE"), "profile of the config file (default the default profile)")
+	host := flags.String("host", "", "host of the Asterisk Manager Interface")
+	port := flags.Int("port", 0, "port of the Asterisk Manager Interface")
+	username := flags.String("username", "", "username of the manager")
+	timeout := flags.Duration("timeout", 0, "timeout of the connection and the actions (default 10s)")
+	debug := flags.Bool("debug", false, "log the library")
+	tap := flags.String("tap", "", "write the frames of the connection to stderr, secrets redacted, in the text or jsonl format")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if flags.NArg() == 0 {
+		usage(flags)
+		return 2
+	}
+	cmd, ok := commands[flags.Arg(0)]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "voipkit: unknown command '%v'\n", flags.Arg(0))
+		usage(flags)
+		return 2
+	}
+	if *debug {
+		ami.SetLogger(ami.NewAmiJsonLogger(os.Stderr, ami.Debug))
+	}
+
+	explicit := !ami.IsStringEmpty(*file)
+	if !explicit {
+		*file = defaultHomeFile(".voipkit.json")
+	}
+	p, err := loadProfile(*file, *name, explicit)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "voipkit: %v\n", err)
+		return 1
+	}
+	p = merge(p, profile{Host: *host, Port: *port, Username: *username, Tap: *tap})
+	if *timeout > 0 {
+		p.Timeout = timeout.String()
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	c, err := connect(p)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "voipkit: connecting to %v:%v got an error: %v\n", p.Host, p.Port, err)
+		return 1
+	}
+	defer c.Close()
+	if err := cmd.run(ctx, c, flags.Args()[1:]); err != nil {
+		fmt.Fprintf(os.Stderr, "voipkit: %v\n", err)
+		if _, ok := err.(errUsage); ok {
+			fmt.Fprintf(os.Stderr, "usage: voipkit %v\n", cmd.usage)
+			return 2
+		}
+		return 1
+	}
+	return 0
+}
+
+func usage(flags *flag.FlagSet) {
+	fmt.Fprintln(os.Stderr, "usage: voipkit [flags] <command> [arguments]")
+	fmt.Fprintln(os.Stderr, "\nflags:")
+	flags.PrintDefaults()
+	fmt.Fprintln(os.Stderr, "\ncommands:")
+	for _, name := range []string{"action", "command", "events", "peers", "endpoints", "queues", "channels", "hangup", "redirect", "shell"} {
+		fmt.Fprintf(os.Stderr, "  %v\n", commands[name].usage)
+	}
+}
+
+// parse parses the flags of the command, the format flag is added if any formats
+func parse(name string, args []string, formats ...string) (*flag.FlagSet, *string, error) {
+	flags := flag.NewFlagSet(name, flag.ContinueOnError)
+	flags.SetOutput(os.Stderr)
+	var format *string
+	if len(formats) > 0 {
+		format = flags.String("format", formats[0], "output format: "+strings.Join(formats, ", "))
+	}
+	if err := flags.Parse(args); err != nil {
+		return nil, nil, errUsage{message: err.Error()}
+	}
+	if format != nil {
+		*format = strings.ToLower(*format)
+		supported := false
+		for _, f := range formats {
+			supported = supported || f == *format
+		}
+		if !supported {
+			return nil, nil, errUsage{message: fmt.Sprintf("format '%v' is not supported", *format)}
+		}
+	}
+	return flags, format, nil
+}
+
+// failed returns the error of the response of the action if Asterisk answered with an error
+func failed(action string, reply ami.AmiReply) error {
+	if ami.IsFailure(reply) {
+		return fmt.Errorf(config.AmiErrorActionFailed, action, reply.GetFold(config.AmiFieldMessage))
+	}
+	return nil
+}
+
+func runAction(ctx context.Context, c *client, args []string) error {
+	flags, format, err := parse("action", args, formatRaw, formatJson)
+	if err != nil {
+		return err
+	}
+	if flags.NArg() == 0 {
+		return errUsage{message: "action is required"}
+	}
+	action := flags.Arg(0)
+	fields := make(map[string]string)
+	for _, arg := range flags.Args()[1:] {
+		key, value, ok := strings.Cut(arg, "=")
+		if !ok || ami.IsStringEmpty(key) {
+			return errUsage{message: fmt.Sprintf("field '%v' must be Key=Value", arg)}
+		}
+		fields[ami.TrimStringSpaces(key)] = value
+	}
+	ctx, cancel := c.timeout(ctx)
+	defer cancel()
+	replies, err := c.Core().SendAction(ctx, action, fields)
+	if err != nil {
+		return err
+	}
+	if err := writeReplies(os.Stdout, *format, replies); err != nil {
+		return err
+	}
+	return failed(action, replies[0])
+}
+
+func runCommand(ctx context.Context, c *client, args []string) error {
+	if len(args) == 0 {
+		return errUsage{message: "cli command is required"}
+	}
+	ctx, cancel := c.timeout(ctx)
+	defer cancel()
+	replies, err := c.Core().Command(ctx, strings.Join(args, " "))
+	if err != nil {
+		return err
+	}
+	for k, values := range replies {
+		if !strings.EqualFold(strings.ReplaceAll(k, "_", ""), "output") {
+			continue
+		}
+		for _, line := range values {
+			fmt.Println(line)
+		}
+		return nil
+	}
+	// the response of Asterisk before 14 has no Output field
+	fmt.Println(ami.JsonString(replies))
+	return nil
+}
+
+func runEvents(ctx context.Context, c *client, args []string) error {
+	flags := flag.NewFlagSet("events", flag.ContinueOnError)
+	filter := flags.String("filter", "", "comma separated event patterns, i.e: Hangup,Queue*")
+	format := flags.String("format", formatRaw, "output format: raw, json, table")
+	fields := flags.String("fields", "Channel,Uniqueid", "comma separated fields of the table format")
+	if err := flags.Parse(args); err != nil {
+		return errUsage{message: err.Error()}
+	}
+	var patterns []string
+	for _, pattern := range strings.Split(*filter, ",") {
+		pattern = strings.ToLower(ami.TrimStringSpaces(pattern))
+		if ami.IsStringEmpty(pattern) {
+			continue
+		}
+		if _, err := path.Match(pattern, ""); err != nil {
+			return errUsage{message: fmt.Sprintf("event pattern '%v' is invalid", pattern)}
+		}
+		patterns = append(patterns, pattern)
+	}
+	var cols []column
+	switch *format {
+	case formatRaw, formatJson:
+	case formatTable:
+		cols = columns(append([]string{config.AmiEventKey}, strings.Split(*fields, ",")...))
+		headers := make([]string, 0, len(cols))
+		for _, col := range cols {
+			headers = append(headers, col.header)
+		}
+		fmt.Println(row(headers))
+	default:
+		return errUsage{message: fmt.Sprintf("format '%v' is not supported", *format)}
+	}
+	events := c.AllEvents()
+	for {
+		select {
+		case message, ok := <-events:
+			if !ok {
+				return nil
+			}
+			if !match(patterns, message.Field(config.AmiEventKey)) {
+				continue
+			}
+			switch *format {
+			case formatJson:
+				fmt.Println(message.Json())
+			case formatTable:
+				values := make([]string, 0, len(cols))
+				for _, col := range cols {
+					values = append(values, cell(message.Field(col.field)))
+				}
+				fmt.Println(row(values))
+			default:
+				fmt.Print(message.String())
+			}
+		case err, ok := <-c.Error():
+			if ok && err != nil {
+				fmt.Fprintf(os.Stderr, "voipkit: %v\n", err)
+			}
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
+
+func runPeers(ctx context.Context, c *client, args []string) error {
+	_, format, err := parse("peers", args, formatTable, formatJson)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := c.timeout(ctx)
+	defer cancel()
+	replies, err := c.Core().SendAction(ctx, config.AmiActionSIPPeers, nil)
+	if err != nil {
+		return err
+	}
+	if err := failed(config.AmiActionSIPPeers, replies[0]); err != nil {
+		return err
+	}
+	return list(*format, []string{"ObjectName", "IPaddress", "IPport", "Status"}, entries(replies))
+}
+
+func runEndpoints(ctx context.Context, c *client, args []string) error {
+	_, format, err := parse("endpoints", args, formatTable, formatJson)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := c.timeout(ctx)
+	defer cancel()
+	replies, err := c.Core().PJSIPShowEndpoints(ctx)
+	if err != nil {
+		return err
+	}
+	return list(*format, []string{"ObjectName", "Transport", "Aor", "DeviceState", "ActiveChannels"}, replies)
+}
+
+func runQueues(ctx context.Context, c *client, args []string) error {
+	flags, format, err := parse("queues", args, formatTable, formatJson)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := c.timeout(ctx)
+	defer cancel()
+	replies, err := c.Core().GetQueueSummary(ctx, flags.Arg(0))
+	if err != nil {
+		return err
+	}
+	return list(*format, []string{"Queue", "LoggedIn", "Available", "Callers", "HoldTime", "TalkTime", "LongestHoldTime"}, replies)
+}
+
+func runChannels(ctx context.Context, c *client, args []string) error {
+	_, format, err := parse("channels", args, formatTable, formatJson)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := c.timeout(ctx)
+	defer cancel()
+	replies, err := c.Core().CoreShowChannels(ctx)
+	if err != nil {
+		return err
+	}
+	return list(*format, []string{"Channel", "ChannelStateDesc", "CallerIDNum", "Context", "Exten", "Application", "Duration", "Uniqueid"}, replies)
+}
+
+func runHangup(ctx context.Context, c *client, args []string) error {
+	if len(args) == 0 || len(args) > 2 {
+		return errUsage{message: "channel is required"}
+	}
+	cause := ""
+	if len(args) == 2 {
+		cause = args[1]
+	}
+	ctx, cancel := c.timeout(ctx)
+	defer cancel()
+	reply, err := c.Core().Hangup(ctx, args[0], cause)
+	if err != nil {
+		return err
+	}
+	return failed(config.AmiActionHangup, reply)
+}
+
+func runRedirect(ctx context.Context, c *client, args []string) error {
+	if len(args) < 3 || len(args) > 4 {
+		return errUsage{message: "channel, context and exten are required"}
+	}
+	call := ami.AMIPayloadCall{Channel: args[0], Context: args[1], Exten: args[2], Priority: "1"}
+	if len(args) == 4 {
+		if _, err := strconv.Atoi(args[3]); err != nil {
+			return errUsage{message: fmt.Sprintf("priority '%v' is invalid", args[3])}
+		}
+		call.Priority = args[3]
+	}
+	ctx, cancel := c.timeout(ctx)
+	defer cancel()
+	reply, err := c.Core().Redirect(ctx, call)
+	if err != nil {
+		return err
+	}
+	return failed(config.AmiActionRedirect, reply)
+}
+
+// list writes the replies of a list as a table of the fields or as json
+func list(format string, fields []string, replies []ami.AmiReply) error {
+	if format == formatJson {
+		return writeReplies(os.Stdout, format, replies)
+	}
+	return writeTable(os.Stdout, columns(fields), replies)
+}
+
+// entries returns the events of the list of SendAction, without the response and the completion event
+func entries(replies []ami.AmiReply) []ami.AmiReply {
+	var result []ami.AmiReply
+	for _, reply := range replies[1:] {
+		if strings.EqualFold(reply.GetFold("EventList"), "Complete") {
+			continue
+		}
+		result = append(result, reply)
+	}
+	return result
+}
+
+// match returns true if the event matches one of the patterns, all events if none
+func match(patterns []string, event string) bool {
+	if len(patterns) == 0 {
+		return true
+	}
+	event = strings.ToLower(event)
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(pattern, event); ok {
+			return true
+		}
+	}
+	return false
+}
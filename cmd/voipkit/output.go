@@ -0,0 +1,98 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/pnguyen215/voipkit/pkg/ami"
+)
+
+const (
+	formatRaw   = "raw"
+	formatJson  = "json"
+	formatTable = "table"
+)
+
+// column is a column of the table and the field of the replies it shows
+type column struct {
+	header string
+	field  string
+}
+
+// columns creates the columns of the fields, i.e: Channel,Uniqueid
+func columns(fields []string) []column {
+	result := make([]column, 0, len(fields))
+	for _, field := range fields {
+		result = append(result, column{header: strings.ToUpper(field), field: field})
+	}
+	return result
+}
+
+// writeTable writes the replies as a table, the fields are found regardless of case and underscores
+func writeTable(w io.Writer, cols []column, replies []ami.AmiReply) error {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	headers := make([]string, 0, len(cols))
+	for _, c := range cols {
+		headers = append(headers, c.header)
+	}
+	fmt.Fprintln(tw, strings.Join(headers, "\t"))
+	for _, reply := range replies {
+		values := make([]string, 0, len(cols))
+		for _, c := range cols {
+			values = append(values, cell(reply.GetFold(c.field)))
+		}
+		fmt.Fprintln(tw, strings.Join(values, "\t"))
+	}
+	return tw.Flush()
+}
+
+// writeReplies writes the replies in the format, raw writes the fields of each reply sorted by name
+func writeReplies(w io.Writer, format string, replies []ami.AmiReply) error {
+	switch format {
+	case formatJson:
+		_, err := fmt.Fprintln(w, ami.JsonString(replies))
+		return err
+	case formatRaw:
+		for _, reply := range replies {
+			keys := make([]string, 0, len(reply))
+			for k := range reply {
+				keys = append(keys, k)
+			}
+			sort.Strings(keys)
+			for _, k := range keys {
+				fmt.Fprintf(w, "%v: %v\n", k, reply[k])
+			}
+			if _, err := fmt.Fprintln(w); err != nil {
+				return err
+			}
+		}
+		return nil
+	default:
+		return fmt.Errorf("format '%v' is not supported", format)
+	}
+}
+
+// row returns the cells padded to a fixed width, the events are streamed so they cannot be aligned by a tabwriter
+func row(cells []string) string {
+	var b strings.Builder
+	for i, v := range cells {
+		if i < len(cells)-1 {
+			fmt.Fprintf(&b, "%-24s ", v)
+		} else {
+			b.WriteString(v)
+		}
+	}
+	return b.String()
+}
+
+// cell returns the value of a table cell, '-' if empty
+func cell(value string) string {
+	value = strings.NewReplacer("\t", " ", "\r", " ", "\n", " ").Replace(ami.TrimStringSpaces(value))
+	if ami.IsStringEmpty(value) {
+		return "-"
+	}
+	return value
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/pnguyen215/voipkit/pkg/ami"
+	"github.com/pnguyen215/voipkit/pkg/ami/config"
+)
+
+// profile is the connection to an Asterisk Manager Interface
+type profile struct {
+	Host     string `json:"host"`
+	Port     int    `json:"port"`
+	Username string `json:"username"`
+	Password string `json:"password"`
+	Timeout  string `json:"timeout,omitempty"` // i.e: 10s
+	Tap      string `json:"tap,omitempty"`     // the format of the frames written to stderr, text or jsonl
+}
+
+// profiles is the config file, i.e: ~/.voipkit.json
+//
+//	{
+//	  "default": "local",
+//	  "profiles": {
+//	    "local": {"host": "127.0.0.1", "port": 5038, "username": "admin", "password": "password"}
+//	  }
+//	}
+type profiles struct {
+	Default  string             `json:"default"`
+	Profiles map[string]profile `json:"profiles"`
+}
+
+// defaultHomeFile returns the file of the home directory, or empty if the home directory is unknown
+func defaultHomeFile(name string) string {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(home, name)
+}
+
+// loadProfile resolves the profile of the name from the config file (the default profile if the name is empty),
+// then overrides it by the environment variables VOIPKIT_HOST, VOIPKIT_PORT, VOIPKIT_USERNAME, VOIPKIT_PASSWORD and VOIPKIT_TIMEOUT.
+// The missing config file is not an error unless it or the profile is set explicitly.
+func loadProfile(file, name string, explicit bool) (profile, error) {
+	p := profile{Host: "127.0.0.1", Port: 5038}
+	if !ami.IsStringEmpty(file) {
+		bytes, err := os.ReadFile(file)
+		switch {
+		case err == nil:
+			var config profiles
+			if err := json.Unmarshal(bytes, &config); err != nil {
+				return p, fmt.Errorf("config file '%v' is invalid: %v", file, err)
+			}
+			if ami.IsStringEmpty(name) {
+				name = config.Default
+			}
+			if !ami.IsStringEmpty(name) {
+				v, ok := config.Profiles[name]
+				if !ok {
+					return p, fmt.Errorf("profile '%v' not found in config file '%v'", name, file)
+				}
+				p = merge(p, v)
+			}
+		case explicit || !ami.IsStringEmpty(name) || !os.IsNotExist(err):
+			return p, err
+		}
+	} else if !ami.IsStringEmpty(name) {
+		return p, fmt.Errorf("profile '%v' requires a config file", name)
+	}
+	env := profile{
+		Host:     os.Getenv("VOIPKIT_HOST"),
+		Username: os.Getenv("VOIPKIT_USERNAME"),
+		Password: os.Getenv("VOIPKIT_PASSWORD"),
+		Timeout:  os.Getenv("VOIPKIT_TIMEOUT"),
+	}
+	if v := os.Getenv("VOIPKIT_PORT"); !ami.IsStringEmpty(v) {
+		port, err := strconv.Atoi(v)
+		if err != nil {
+			return p, fmt.Errorf("VOIPKIT_PORT '%v' is invalid", v)
+		}
+		env.Port = port
+	}
+	return merge(p, env), nil
+}
+
+// merge overrides the fields of p by the non empty fields of v
+func merge(p, v profile) profile {
+	if !ami.IsStringEmpty(v.Host) {
+		p.Host = v.Host
+	}
+	if v.Port > 0 {
+		p.Port = v.Port
+	}
+	if !ami.IsStringEmpty(v.Username) {
+		p.Username = v.Username
+	}
+	if !ami.IsStringEmpty(v.Password) {
+		p.Password = v.Password
+	}
+	if !ami.IsStringEmpty(v.Timeout) {
+		p.Timeout = v.Timeout
+	}
+	if !ami.IsStringEmpty(v.Tap) {
+		p.Tap = v.Tap
+	}
+	return p
+}
+
+// client is the client of the profile, with the timeout of its actions
+type client struct {
+	*ami.AMI
+	actionTimeout time.Duration // the timeout of the connection
+}
+
+// timeout returns the context of an action, within the timeout of the profile
+func (c *client) timeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	return context.WithTimeout(ctx, c.actionTimeout)
+}
+
+// connect opens the client of the profile and the session of its core
+func connect(p profile) (*client, error) {
+	timeout := 10 * time.Second
+	if !ami.IsStringEmpty(p.Timeout) {
+		v, err := time.ParseDuration(p.Timeout)
+		if err != nil {
+			return nil, fmt.Errorf("timeout '%v' is invalid", p.Timeout)
+		}
+		timeout = v
+	}
+	request := ami.GetAmiClientSample().
+		SetEnabled(true).
+		SetHost(p.Host).
+		SetPort(p.Port).
+		SetUsername(p.Username).
+		SetPassword(p.Password).
+		SetTimeout(timeout)
+	if !ami.IsStringEmpty(p.Tap) {
+		if p.Tap != config.AmiTapFormatText && p.Tap != config.AmiTapFormatJson {
+			return nil, fmt.Errorf("tap format '%v' is not supported", p.Tap)
+		}
+		request.SetTap(ami.NewAMITap(os.Stderr).SetFormat(p.Tap))
+	}
+	c, err := ami.NewClient(ami.NewTcp(), *request)
+	if err != nil {
+		return nil, err
+	}
+	c.Core().AddSession()
+	return &client{AMI: c, actionTimeout: timeout}, nil
+}
@@ -0,0 +1,597 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/pnguyen215/voipkit/pkg/ami"
+	"github.com/pnguyen215/voipkit/pkg/ami/config"
+)
+
+const (
+	shellPrompt        = "voipkit> "
+	shellPromptPending = "...> "
+	shellHistoryLimit  = 1000
+	shellMacroDepth    = 8
+	shellHistoryFile   = ".voipkit_history"
+	shellMacrosFile    = ".voipkit_macros.json"
+	shellHelp          = `Actions:
+  Originate Channel=PJSIP/1001 Exten=1002 Context=default Priority=1   send the action on one line
+  Action: Originate                                                    send the action on many lines,
+  Channel: PJSIP/1001                                                  ended by an empty line
+  !core show channels                                                  run an Asterisk CLI command
+  @dial 1001 1002                                                      run the macro with its arguments
+Commands:
+  /events [on|off]             show or hide the events
+  /filter [Hangup,Queue*]      select the events shown, all if empty
+  /format [raw|json]           set the format of the replies and the events
+  /history [n]                 list the last lines
+  /macro                       list the macros
+  /macro <name> <line>; ...    define the macro, $1..$9 and $* are its arguments
+  /macro -d <name>             delete the macro
+  /help, /quit
+Tab completes the actions and their fields.`
+)
+
+// shellCommands are the commands of the shell, completed by tab
+var shellCommands = []string{"/events", "/filter", "/format", "/history", "/macro", "/help", "/quit", "/exit"}
+
+// shellSecret matches the lines holding a secret, they are not saved in the history
+var shellSecret = regexp.MustCompile(`(?i)(secret|password)\s*[:=]`)
+
+// shellActions are the names of the actions, sorted
+var shellActions = func() []string {
+	actions := make([]string, 0, len(config.AmiActionFields))
+	for action := range config.AmiActionFields {
+		actions = append(actions, action)
+	}
+	sort.Strings(actions)
+	return actions
+}()
+
+// shell is the interactive session of the shell command
+type shell struct {
+	c           *client
+	editor      *editor
+	mutex       sync.Mutex
+	events      bool
+	patterns    []string
+	format      string
+	pending     []string // the lines of the action entered on many lines
+	macros      map[string][]string
+	macrosFile  string
+	historyFile string
+}
+
+func runShell(ctx context.Context, c *client, args []string) error {
+	flags := flag.NewFlagSet("shell", flag.ContinueOnError)
+	history := flags.String("history", defaultHomeFile(shellHistoryFile), "history file, none if empty")
+	macros := flags.String("macros", defaultHomeFile(shellMacrosFile), "macros file, none if empty")
+	events := flags.Bool("events", false, "show the events from the start")
+	filter := flags.String("filter", "", "comma separated event patterns, i.e: Hangup,Queue*")
+	if err := flags.Parse(args); err != nil {
+		return errUsage{message: err.Error()}
+	}
+	s := &shell{c: c, events: *events, format: formatRaw, macrosFile: *macros, historyFile: *history}
+	if err := s.setFilter(*filter); err != nil {
+		return errUsage{message: err.Error()}
+	}
+	if err := s.loadMacros(); err != nil {
+		return err
+	}
+	s.editor = newEditor(s.complete)
+	defer s.editor.Close()
+	s.editor.SetHistory(s.loadHistory())
+	go s.stream(ctx)
+	s.editor.Print(fmt.Sprintf("connected to %v, type /help for help", c.Conn().RemoteAddr()))
+	for ctx.Err() == nil {
+		prompt := shellPrompt
+		if len(s.pending) > 0 {
+			prompt = shellPromptPending
+		}
+		line, err := s.editor.ReadLine(prompt)
+		if errors.Is(err, errInterrupted) {
+			s.pending = nil
+			continue
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if ami.IsStringEmpty(line) && len(s.pending) == 0 {
+			continue
+		}
+		if !ami.IsStringEmpty(line) {
+			s.saveHistory(strings.TrimSpace(line))
+		}
+		if quit := s.eval(ctx, line, 0); quit {
+			return nil
+		}
+	}
+	return nil
+}
+
+// eval evaluates the line, it returns true to quit the shell
+func (s *shell) eval(ctx context.Context, line string, depth int) bool {
+	trimmed := strings.TrimSpace(line)
+	if len(s.pending) > 0 {
+		if !ami.IsStringEmpty(trimmed) {
+			s.pending = append(s.pending, trimmed)
+			return false
+		}
+		lines := s.pending
+		s.pending = nil
+		action, fields, err := parseHeaders(lines)
+		if err != nil {
+			s.editor.Print("error: " + err.Error())
+			return false
+		}
+		s.send(ctx, action, fields)
+		return false
+	}
+	switch {
+	case strings.HasPrefix(strings.ToLower(trimmed), "action:"):
+		s.pending = []string{trimmed}
+	case strings.HasPrefix(trimmed, "/"):
+		return s.command(trimmed)
+	case strings.HasPrefix(trimmed, "!"):
+		s.cli(ctx, strings.TrimSpace(trimmed[1:]))
+	case strings.HasPrefix(trimmed, "@"):
+		s.macro(ctx, trimmed[1:], depth)
+	default:
+		words, err := splitWords(trimmed)
+		if err != nil {
+			s.editor.Print("error: " + err.Error())
+			return false
+		}
+		fields := make(map[string]string)
+		for _, word := range words[1:] {
+			key, value, ok := strings.Cut(word, "=")
+			if !ok || ami.IsStringEmpty(key) {
+				s.editor.Print(fmt.Sprintf("error: field '%v' must be Key=Value", word))
+				return false
+			}
+			fields[key] = value
+		}
+		s.send(ctx, words[0], fields)
+	}
+	return false
+}
+
+// send sends the action and prints its response, followed by the events of its list if any
+func (s *shell) send(ctx context.Context, action string, fields map[string]string) {
+	ctx, cancel := s.c.timeout(ctx)
+	defer cancel()
+	replies, err := s.c.Core().SendAction(ctx, action, fields)
+	if err != nil {
+		s.editor.Print("error: " + err.Error())
+		return
+	}
+	var b bytes.Buffer
+	writeReplies(&b, s.format, replies)
+	if len(replies) > 1 {
+		fmt.Fprintf(&b, "(%d events)\n", len(replies)-1)
+	}
+	s.editor.Print(b.String())
+}
+
+// cli runs the Asterisk CLI command and prints its output
+func (s *shell) cli(ctx context.Context, command string) {
+	if ami.IsStringEmpty(command) {
+		return
+	}
+	ctx, cancel := s.c.timeout(ctx)
+	defer cancel()
+	replies, err := s.c.Core().Command(ctx, command)
+	if err != nil {
+		s.editor.Print("error: " + err.Error())
+		return
+	}
+	for k, values := range replies {
+		if strings.EqualFold(strings.ReplaceAll(k, "_", ""), "output") {
+			s.editor.Print(strings.Join(values, "\n"))
+			return
+		}
+	}
+	s.editor.Print(ami.JsonString(replies))
+}
+
+// command runs the command of the shell, it returns true to quit the shell
+func (s *shell) command(line string) bool {
+	name, arg, _ := strings.Cut(line, " ")
+	arg = ami.TrimStringSpaces(arg)
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	switch strings.ToLower(name) {
+	case "/quit", "/exit":
+		return true
+	case "/help":
+		s.editor.Print(shellHelp)
+	case "/events":
+		switch strings.ToLower(arg) {
+		case "":
+			s.events = !s.events
+		case "on":
+			s.events = true
+		case "off":
+			s.events = false
+		default:
+			s.editor.Print("usage: /events [on|off]")
+			return false
+		}
+		s.editor.Print(fmt.Sprintf("events %v", map[bool]string{true: "on", false: "off"}[s.events]))
+	case "/filter":
+		if err := s.setFilter(arg); err != nil {
+			s.editor.Print("error: " + err.Error())
+			return false
+		}
+		if len(s.patterns) == 0 {
+			s.editor.Print("filter cleared, all events are shown")
+		} else {
+			s.editor.Print("filter " + strings.Join(s.patterns, ","))
+		}
+	case "/format":
+		switch strings.ToLower(arg) {
+		case "":
+		case formatRaw, formatJson:
+			s.format = strings.ToLower(arg)
+		default:
+			s.editor.Print("usage: /format [raw|json]")
+			return false
+		}
+		s.editor.Print("format " + s.format)
+	case "/history":
+		s.editor.mutex.Lock()
+		lines := s.editor.history
+		s.editor.mutex.Unlock()
+		n := 20
+		fmt.Sscan(arg, &n)
+		if n < len(lines) {
+			lines = lines[len(lines)-n:]
+		}
+		s.editor.Print(strings.Join(lines, "\n"))
+	case "/macro":
+		s.defineMacro(arg)
+	default:
+		s.editor.Print(fmt.Sprintf("unknown command '%v', type /help for help", name))
+	}
+	return false
+}
+
+// setFilter sets the event patterns of the comma separated value, the lock must be held once the shell runs
+func (s *shell) setFilter(value string) error {
+	var patterns []string
+	for _, pattern := range strings.Split(value, ",") {
+		pattern = strings.ToLower(ami.TrimStringSpaces(pattern))
+		if ami.IsStringEmpty(pattern) {
+			continue
+		}
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("event pattern '%v' is invalid", pattern)
+		}
+		patterns = append(patterns, pattern)
+	}
+	s.patterns = patterns
+	return nil
+}
+
+// stream prints the events matching the filter while they are on
+func (s *shell) stream(ctx context.Context) {
+	events := s.c.AllEvents()
+	for {
+		select {
+		case message, ok := <-events:
+			if !ok {
+				return
+			}
+			s.mutex.Lock()
+			show := s.events && match(s.patterns, message.Field(config.AmiEventKey))
+			format := s.format
+			s.mutex.Unlock()
+			if !show {
+				continue
+			}
+			if format == formatJson {
+				s.editor.Print("<< " + message.Json())
+			} else {
+				s.editor.Print("<< " + strings.ReplaceAll(strings.TrimRight(message.String(), "\r\n"), "\r\n", "\n   "))
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// complete returns the start of the word at the end of the head and its candidates:
+// the commands, the macros, the actions or the fields of the action being entered
+func (s *shell) complete(head string) (int, []string) {
+	start := strings.LastIndex(head, " ") + 1
+	word := head[start:]
+	if len(s.pending) > 0 {
+		if start > 0 {
+			return start, nil
+		}
+		action, fields, _ := parseHeaders(s.pending)
+		return 0, fieldCandidates(action, word, ": ", fields)
+	}
+	first := start == 0
+	switch {
+	case first && strings.HasPrefix(word, "/"):
+		return 0, prefixed(shellCommands, word, "")
+	case first && strings.HasPrefix(word, "@"):
+		s.mutex.Lock()
+		names := make([]string, 0, len(s.macros))
+		for name := range s.macros {
+			names = append(names, "@"+name)
+		}
+		s.mutex.Unlock()
+		return 0, prefixed(names, word, "")
+	case first:
+		return 0, prefixed(shellActions, word, "")
+	case strings.HasPrefix(strings.ToLower(head), "action:"):
+		return start, prefixed(shellActions, word, "")
+	case strings.HasPrefix(head, "/") || strings.HasPrefix(head, "!") || strings.HasPrefix(head, "@"):
+		return start, nil
+	}
+	words := strings.Fields(head)
+	present := make(map[string]string)
+	for _, w := range words[1:] {
+		if key, _, ok := strings.Cut(w, "="); ok {
+			present[key] = ""
+		}
+	}
+	return start, fieldCandidates(words[0], word, "=", present)
+}
+
+// fieldCandidates returns the fields of the action starting by the word, without the present ones
+func fieldCandidates(action, word, separator string, present map[string]string) []string {
+	var fields []string
+	for name, v := range config.AmiActionFields {
+		if strings.EqualFold(name, action) {
+			fields = v
+			break
+		}
+	}
+	var candidates []string
+	for _, field := range fields {
+		if _, ok := present[field]; ok {
+			continue
+		}
+		if strings.HasPrefix(strings.ToLower(field), strings.ToLower(word)) {
+			candidates = append(candidates, field+separator)
+		}
+	}
+	return candidates
+}
+
+// prefixed returns the values starting by the word, case insensitive
+func prefixed(values []string, word, suffix string) []string {
+	var result []string
+	for _, v := range values {
+		if strings.HasPrefix(strings.ToLower(v), strings.ToLower(word)) {
+			result = append(result, v+suffix)
+		}
+	}
+	return result
+}
+
+// macro runs the steps of the macro, replacing $1..$9 by its arguments and $* by all of them
+func (s *shell) macro(ctx context.Context, line string, depth int) {
+	words, err := splitWords(line)
+	if err != nil || len(words) == 0 {
+		s.editor.Print("usage: @<macro> [arguments]")
+		return
+	}
+	if depth >= shellMacroDepth {
+		s.editor.Print(fmt.Sprintf("error: macro '%v' is nested too deeply", words[0]))
+		return
+	}
+	s.mutex.Lock()
+	steps, ok := s.macros[words[0]]
+	s.mutex.Unlock()
+	if !ok {
+		s.editor.Print(fmt.Sprintf("error: macro '%v' not found", words[0]))
+		return
+	}
+	args := words[1:]
+	replacements := []string{"$*", strings.Join(args, " ")}
+	for i := 9; i >= 1; i-- {
+		value := ""
+		if i <= len(args) {
+			value = args[i-1]
+		}
+		replacements = append(replacements, fmt.Sprintf("$%d", i), value)
+	}
+	replacer := strings.NewReplacer(replacements...)
+	for _, step := range steps {
+		step = replacer.Replace(step)
+		s.editor.Print(shellPrompt + step)
+		if s.eval(ctx, step, depth+1) {
+			return
+		}
+	}
+	// the action of many lines in the macro is sent at its end
+	if len(s.pending) > 0 {
+		s.eval(ctx, "", depth+1)
+	}
+}
+
+// defineMacro lists, defines or deletes the macros, the lock must be held
+func (s *shell) defineMacro(arg string) {
+	if ami.IsStringEmpty(arg) {
+		if len(s.macros) == 0 {
+			s.editor.Print("no macros")
+			return
+		}
+		names := make([]string, 0, len(s.macros))
+		for name := range s.macros {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		var b strings.Builder
+		for _, name := range names {
+			fmt.Fprintf(&b, "@%v: %v\n", name, strings.Join(s.macros[name], "; "))
+		}
+		s.editor.Print(b.String())
+		return
+	}
+	name, steps, _ := strings.Cut(arg, " ")
+	if name == "-d" {
+		name = ami.TrimStringSpaces(steps)
+		if _, ok := s.macros[name]; !ok {
+			s.editor.Print(fmt.Sprintf("error: macro '%v' not found", name))
+			return
+		}
+		delete(s.macros, name)
+	} else {
+		var lines []string
+		for _, step := range strings.Split(steps, ";") {
+			if step = strings.TrimSpace(step); !ami.IsStringEmpty(step) {
+				lines = append(lines, step)
+			}
+		}
+		if len(lines) == 0 || strings.ContainsAny(name, "@$") {
+			s.editor.Print("usage: /macro <name> <line>; <line>...")
+			return
+		}
+		s.macros[name] = lines
+	}
+	if err := s.saveMacros(); err != nil {
+		s.editor.Print("error: " + err.Error())
+	}
+}
+
+func (s *shell) loadMacros() error {
+	s.macros = make(map[string][]string)
+	if ami.IsStringEmpty(s.macrosFile) {
+		return nil
+	}
+	bytes, err := os.ReadFile(s.macrosFile)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(bytes, &s.macros); err != nil {
+		return fmt.Errorf("macros file '%v' is invalid: %v", s.macrosFile, err)
+	}
+	return nil
+}
+
+func (s *shell) saveMacros() error {
+	if ami.IsStringEmpty(s.macrosFile) {
+		return nil
+	}
+	bytes, err := json.MarshalIndent(s.macros, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(s.macrosFile, bytes, 0o600)
+}
+
+// loadHistory returns the last lines of the history file
+func (s *shell) loadHistory() []string {
+	if ami.IsStringEmpty(s.historyFile) {
+		return nil
+	}
+	bytes, err := os.ReadFile(s.historyFile)
+	if err != nil {
+		return nil
+	}
+	lines := strings.Split(strings.TrimRight(string(bytes), "\n"), "\n")
+	if len(lines) > shellHistoryLimit {
+		lines = lines[len(lines)-shellHistoryLimit:]
+	}
+	return lines
+}
+
+// saveHistory appends the line to the history, the lines holding a secret are kept for the session only
+func (s *shell) saveHistory(line string) {
+	s.editor.AddHistory(line)
+	if ami.IsStringEmpty(s.historyFile) || shellSecret.MatchString(line) {
+		return
+	}
+	f, err := os.OpenFile(s.historyFile, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+	fmt.Fprintln(f, line)
+}
+
+// parseHeaders parses the lines "Key: Value" of an action, the first one is "Action: <name>"
+func parseHeaders(lines []string) (string, map[string]string, error) {
+	action := ""
+	fields := make(map[string]string)
+	for _, line := range lines {
+		key, value, ok := strings.Cut(line, ":")
+		key = ami.TrimStringSpaces(key)
+		if !ok || ami.IsStringEmpty(key) {
+			return "", nil, fmt.Errorf("line '%v' must be Key: Value", line)
+		}
+		if strings.EqualFold(key, config.AmiActionKey) {
+			action = ami.TrimStringSpaces(value)
+			continue
+		}
+		fields[key] = strings.TrimSpace(value)
+	}
+	if ami.IsStringEmpty(action) {
+		return "", nil, errors.New("action is required")
+	}
+	return action, fields, nil
+}
+
+// splitWords splits the line by the spaces, except within the quotes, i.e: Command Command="core show uptime"
+func splitWords(line string) ([]string, error) {
+	var words []string
+	var word strings.Builder
+	quote, escaped, inWord := rune(0), false, false
+	for _, r := range line {
+		switch {
+		case escaped:
+			word.WriteRune(r)
+			escaped = false
+		case r == '\\':
+			escaped, inWord = true, true
+		case quote != 0 && r == quote:
+			quote = 0
+		case quote != 0:
+			word.WriteRune(r)
+		case r == '"' || r == '\'':
+			quote, inWord = r, true
+		case r == ' ' || r == '\t':
+			if inWord {
+				words = append(words, word.String())
+				word.Reset()
+				inWord = false
+			}
+		default:
+			word.WriteRune(r)
+			inWord = true
+		}
+	}
+	if quote != 0 {
+		return nil, errors.New("quote is not closed")
+	}
+	if inWord {
+		words = append(words, word.String())
+	}
+	if len(words) == 0 {
+		return nil, errors.New("action is required")
+	}
+	return words, nil
+}
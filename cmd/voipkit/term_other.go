@@ -0,0 +1,10 @@
+//go:build !(linux || darwin || freebsd || netbsd || openbsd)
+
+package main
+
+import "errors"
+
+// makeRaw is not supported on this platform, the shell reads the lines without editing
+func makeRaw(fd int) (func(), error) {
+	return nil, errors.New("raw terminal is not supported")
+}
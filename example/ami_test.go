@@ -1,12 +1,28 @@
 package example
 
 import (
+	"bufio"
+	"bytes"
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
 	"github.com/pnguyen215/voipkit/pkg/ami"
+	"github.com/pnguyen215/voipkit/pkg/ami/config"
 )
 
 func createConn() (*ami.AMI, error) {
@@ -366,3 +382,1637 @@ func TestAddSIPExtension(t *testing.T) {
 	}
 	fmt.Println("Add SIP Extension Response:", reply)
 }
+
+func TestRegistrationTrackerFlapping(t *testing.T) {
+	tracker := ami.NewAMIRegistrationTracker().
+		SetFlapThreshold(3).
+		SetFlapWindow(time.Minute).
+		SetUnreachableAfter(2 * time.Minute)
+	var alerts []string
+	tracker.OnAlert(func(a ami.AMIRegistrationAlert) {
+		alerts = append(alerts, a.Kind)
+	})
+	at := time.Now()
+	for i, status := range []string{"Reachable", "Unreachable", "Reachable", "Unreachable"} {
+		e := ami.NewMessage()
+		e.AddField("Event", "PeerStatus")
+		e.AddField("Peer", "SIP/1000")
+		e.AddField("ChannelType", "SIP")
+		e.AddField("PeerStatus", status)
+		tracker.ApplyAt(e, at.Add(time.Duration(i)*10*time.Second))
+	}
+	state, ok := tracker.State("SIP/1000")
+	if !ok || !state.Flapping || state.Reachable {
+		t.Fatalf("expected SIP/1000 to be flapping and unreachable, got: %v", state.Json())
+	}
+	if len(tracker.Transitions("SIP/1000")) != 4 {
+		t.Fatalf("expected 4 transitions, got: %v", len(tracker.Transitions("SIP/1000")))
+	}
+	tracker.Inspect(at.Add(5 * time.Minute))
+	if len(alerts) != 2 || alerts[0] != "flapping" || alerts[1] != "unreachable" {
+		t.Fatalf("unexpected alerts: %v", alerts)
+	}
+}
+
+func TestPresenceServiceNotifyOnChange(t *testing.T) {
+	service := ami.NewAMIPresenceService()
+	service.Seed([]ami.AMIExtensionStatus{
+		{Extension: "1010", Context: "ext-local", Hint: "SIP/1010&Custom:DND1010,CustomPresence:1010", Status: 0, StatusText: "Idle"},
+	})
+	var statuses []string
+	service.Subscribe(func(c ami.AMIPresenceChange) {
+		statuses = append(statuses, c.Current.Status)
+	})
+	events := []map[string]string{
+		{"Event": "DeviceStateChange", "Device": "SIP/1010", "State": "RINGING"},
+		{"Event": "ExtensionStatus", "Exten": "1010", "Context": "ext-local", "Status": "8", "StatusText": "Ringing"},
+		{"Event": "DeviceStateChange", "Device": "SIP/1010", "State": "NOT_INUSE"},
+		{"Event": "DeviceStateChange", "Device": "Custom:DND1010", "State": "BUSY"},
+		{"Event": "ExtensionStatus", "Exten": "1010", "Context": "ext-local", "Status": "2", "StatusText": "Busy"},
+		{"Event": "PresenceStateChange", "Presentity": "CustomPresence:1010", "Status": "away", "Message": "lunch"},
+	}
+	for _, fields := range events {
+		e := ami.NewMessage()
+		e.AddFields(fields)
+		service.Apply(e)
+	}
+	// the away presence is hidden by dnd, only its message changes
+	expected := []string{"ringing", "idle", "dnd", "dnd"}
+	if fmt.Sprint(statuses) != fmt.Sprint(expected) {
+		t.Fatalf("expected notifications %v, got %v", expected, statuses)
+	}
+	if v, _ := service.Status("1010", "ext-local"); v.Status != "dnd" || v.Message != "lunch" {
+		t.Fatalf("unexpected presence: %v", v.Json())
+	}
+}
+
+func TestConfBridgeRoomLifecycle(t *testing.T) {
+	core, _ := fakeAmiCore(t, func(action string) string {
+		return "Response: Success\r\nMessage: Conference locked\r\n\r\n"
+	})
+	manager := ami.NewAMIConfBridgeManager(core)
+	var changes []string
+	manager.OnChange(func(event string, room ami.AMIConfRoom) {
+		changes = append(changes, fmt.Sprintf("%v:%v", event, len(room.Participants)))
+	})
+	events := []map[string]string{
+		{"Event": "ConfbridgeStart", "Conference": "100"},
+		{"Event": "ConfbridgeJoin", "Conference": "100", "Channel": "PJSIP/1001-01", "Admin": "Yes"},
+		{"Event": "ConfbridgeJoin", "Conference": "100", "Channel": "PJSIP/1002-02"},
+		{"Event": "ConfbridgeTalking", "Conference": "100", "Channel": "PJSIP/1002-02", "TalkingStatus": "on"},
+		{"Event": "ConfbridgeMute", "Conference": "100", "Channel": "PJSIP/1002-02"},
+		{"Event": "ConfbridgeLeave", "Conference": "100", "Channel": "PJSIP/1001-01"},
+	}
+	for _, fields := range events {
+		e := ami.NewMessage()
+		e.AddFields(fields)
+		manager.Apply(e)
+	}
+	room, ok := manager.Room("100")
+	if !ok || len(room.Participants) != 1 || !room.Participants[0].Talking || !room.Participants[0].Muted {
+		t.Fatalf("expected the room with the talking and muted participant, got %v", ami.JsonString(room))
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if _, err := manager.Lock(ctx, "100"); err != nil {
+		t.Fatal(err)
+	}
+	if room, _ := manager.Room("100"); !room.Locked {
+		t.Fatalf("expected the room locked once ConfbridgeLock succeeded, got %v", ami.JsonString(room))
+	}
+	if _, err := manager.Unlock(ctx, "100"); err != nil {
+		t.Fatal(err)
+	}
+	if room, _ := manager.Room("100"); room.Locked {
+		t.Fatalf("expected the room unlocked once ConfbridgeUnlock succeeded, got %v", ami.JsonString(room))
+	}
+	e := ami.NewMessage()
+	e.AddFields(map[string]string{"Event": "ConfbridgeEnd", "Conference": "100"})
+	manager.Apply(e)
+	if _, ok := manager.Room("100"); ok || len(manager.Rooms()) != 0 {
+		t.Fatalf("expected the room removed at the end, got %v", manager.Json())
+	}
+	expected := "[ConfbridgeStart:0 ConfbridgeJoin:1 ConfbridgeJoin:2 ConfbridgeTalking:2 ConfbridgeMute:2 ConfbridgeLeave:1 ConfbridgeEnd:1]"
+	if fmt.Sprint(changes) != expected {
+		t.Fatalf("expected the changes %v, got %v", expected, changes)
+	}
+}
+
+func TestConfBridgeIgnoresLateEvents(t *testing.T) {
+	manager := ami.NewAMIConfBridgeManager(nil)
+	for _, fields := range []map[string]string{
+		{"Event": "ConfbridgeJoin", "Conference": "200", "Channel": "PJSIP/1001-01"},
+		{"Event": "ConfbridgeEnd", "Conference": "200"},
+		{"Event": "ConfbridgeLeave", "Conference": "200", "Channel": "PJSIP/1001-01"},
+		{"Event": "ConfbridgeTalking", "Conference": "200", "Channel": "PJSIP/1001-01", "TalkingStatus": "off"},
+	} {
+		e := ami.NewMessage()
+		e.AddFields(fields)
+		room := manager.Apply(e)
+		if fields["Event"] == "ConfbridgeLeave" && room != nil {
+			t.Fatalf("expected the late leave ignored, got %v", ami.JsonString(room))
+		}
+	}
+	if rooms := manager.Rooms(); len(rooms) != 0 {
+		t.Fatalf("expected no ghost room, got %v", ami.JsonString(rooms))
+	}
+}
+
+func TestOriginateOutcome(t *testing.T) {
+	cases := map[int]string{0: "failed", 1: "no-answer", 3: "no-answer", 4: "answered", 5: "busy", 8: "congestion"}
+	for reason, expected := range cases {
+		if outcome := ami.OriginateOutcome("Failure", reason); outcome != expected {
+			t.Fatalf("reason %v: expected %v, got %v", reason, expected, outcome)
+		}
+	}
+}
+
+func TestOriginatorResolvesHandleFromEvents(t *testing.T) {
+	core, actions := fakeAmiCore(t, func(action string) string {
+		return "Response: Success\r\nMessage: Originate successfully queued\r\n\r\n"
+	})
+	originator := ami.NewAMIOriginator(core)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	h, err := originator.Originate(ctx, ami.AMIOriginate{Channel: "PJSIP/1001", Exten: "1002", Context: "default", Priority: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	action := <-actions
+	uniqueId := h.UniqueId()
+	if !strings.Contains(action, "Async: true") || uniqueId == "" || !strings.Contains(action, uniqueId) {
+		t.Fatalf("expected the async originate with the assigned channel id %v, got %q", uniqueId, action)
+	}
+	resolved := func(ch <-chan struct{}) bool {
+		select {
+		case <-ch:
+			return true
+		default:
+			return false
+		}
+	}
+	apply := func(fields map[string]string) {
+		e := ami.NewMessage()
+		e.AddFields(fields)
+		originator.Apply(e)
+	}
+	apply(map[string]string{"Event": "Newchannel", "Channel": "PJSIP/1001-00000001", "Uniqueid": uniqueId})
+	if !resolved(h.Known()) || resolved(h.Done()) || h.Channel() != "PJSIP/1001-00000001" {
+		t.Fatalf("expected the channel known before the response, got %v", h.Channel())
+	}
+	apply(map[string]string{"Event": "OriginateResponse", "ActionID": h.ActionId, "Response": "Success", "Reason": "4",
+		"Channel": "PJSIP/1001-00000001", "Uniqueid": uniqueId})
+	result, err := h.Wait(ctx)
+	if err != nil || !h.Answered() || result.UniqueId != uniqueId || resolved(h.Ended()) || originator.Pending() != 0 {
+		t.Fatalf("expected the answered call not ended yet, got %v, %v", ami.JsonString(result), err)
+	}
+	apply(map[string]string{"Event": "Hangup", "Channel": "PJSIP/1001-00000001", "Uniqueid": uniqueId, "Cause": "16", "Cause-txt": "Normal Clearing"})
+	if !resolved(h.Ended()) || h.HangupCause() != "Normal Clearing" {
+		t.Fatalf("expected the leg ended by the hangup, got %q", h.HangupCause())
+	}
+}
+
+func TestOriginatorExpiresLostResponse(t *testing.T) {
+	core, _ := fakeAmiCore(t, func(action string) string {
+		return "Response: Success\r\nMessage: Originate successfully queued\r\n\r\n"
+	})
+	originator := ami.NewAMIOriginator(core).SetResponseGrace(50 * time.Millisecond)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	h, err := originator.Originate(ctx, ami.AMIOriginate{Channel: "PJSIP/1001", Exten: "1002", Context: "default", Priority: 1, Timeout: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	result, err := h.Wait(ctx) // the OriginateResponse event never arrives
+	if err != nil || !result.Expired || result.Outcome != "failed" || originator.Pending() != 0 {
+		t.Fatalf("expected the call given up as failed, got %v, %v", ami.JsonString(result), err)
+	}
+	select {
+	case <-h.Ended():
+	default:
+		t.Fatal("expected the leg of the given up call ended")
+	}
+}
+
+func TestCdrClassifierFirstMatchWins(t *testing.T) {
+	c, err := ami.LoadCdrClassifier([]byte(`{
+		"regions": ["VN", "SG"],
+		"rules": [
+			{"name": "spy", "applications": ["ChanSpy"], "direction": "spy", "type": "chan_spy", "desc_to": "last_data"},
+			{"name": "trunk_in", "technologies": ["PJSIP"], "trunks": ["trunk-sg*"], "dids": [{"from": "6531580000", "to": "6531580099"}],
+				"direction": "inbound", "type": "inbound_dial", "extension_by": "destination_channel", "number_by": "source", "strip_tech": true},
+			{"name": "internal", "contexts": ["from-internal"], "phone": false, "direction": "internal", "type": "internal_dial"}
+		]
+	}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	in := ami.NewAMICdr().
+		SetChannel("PJSIP/trunk-sg-01-0000001a").
+		SetDestinationChannel("PJSIP/1001-0000001b").
+		SetSource("6598765432").
+		SetDestination("6531580042").
+		SetDestinationContext("from-trunk").
+		SetLastApplication("Dial")
+	if !c.Classify(in) || !in.IsCdrInbound() || in.Extension != "1001" || in.Number != "6598765432" {
+		t.Fatalf("unexpected inbound classification: %v", in.Json())
+	}
+	internal := ami.NewAMICdr().
+		SetChannel("PJSIP/1001-0000001c").
+		SetDestination("1002").
+		SetDestinationContext("from-internal").
+		SetLastApplication("Dial")
+	if !c.Classify(internal) || !internal.IsCdrInternal() || internal.Extension != "PJSIP/1001" {
+		t.Fatalf("unexpected internal classification: %v", internal.Json())
+	}
+	if _, err := ami.LoadCdrClassifier([]byte(`{"rules": [{"name": "bad", "direction": "sideways"}]}`)); err == nil {
+		t.Fatal("expected an invalid direction error")
+	}
+}
+
+type flakyCdrSink struct {
+	failures int
+	records  []ami.AMICdr
+}
+
+func (s *flakyCdrSink) Write(ctx context.Context, records []ami.AMICdr) error {
+	if s.failures > 0 {
+		s.failures--
+		return fmt.Errorf("sink unavailable")
+	}
+	s.records = append(s.records, records...)
+	return nil
+}
+
+func (s *flakyCdrSink) Close() error {
+	return nil
+}
+
+func TestCdrWriterSpoolReplay(t *testing.T) {
+	sink := &flakyCdrSink{failures: 1}
+	dir := t.TempDir()
+	corrupt := filepath.Join(dir, "cdr-00000000000000000001.jsonl")
+	if err := os.WriteFile(corrupt, []byte("{not json\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	w := ami.NewAMICdrWriter(sink).SetRetries(0).SetSpoolDir(dir)
+	w.Push(ami.NewAMICdr().SetUniqueId("1700000000.1"))
+	w.Push(ami.NewAMICdr().SetUniqueId("1700000000.2"))
+	if err := w.Flush(); err == nil {
+		t.Fatal("expected the first flush to fail")
+	}
+	if w.Pending() != 0 {
+		t.Fatalf("expected the failed batch to be spooled, got %v pending", w.Pending())
+	}
+	w.Push(ami.NewAMICdr().SetUniqueId("1700000000.3"))
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if len(sink.records) != 3 || sink.records[0].UniqueId != "1700000000.1" {
+		t.Fatalf("expected the spool replayed first, got %v", sink.records)
+	}
+	if _, err := os.Stat(corrupt + ".bad"); err != nil {
+		t.Fatalf("expected the corrupt spool set aside, got %v", err)
+	}
+
+	var buf bytes.Buffer
+	csvSink := ami.NewAMICdrCsvSink(&buf).SetColumns("unique_id", "duration", "disposition")
+	csvSink.Write(context.Background(), []ami.AMICdr{*ami.NewAMICdr().SetUniqueId("1700000000.1").SetDuration(42).SetDisposition("ANSWERED")})
+	if buf.String() != "unique_id,duration,disposition\n1700000000.1,42,ANSWERED\n" {
+		t.Fatalf("unexpected csv: %q", buf.String())
+	}
+}
+
+func TestCdrJsonLinesSinkRotation(t *testing.T) {
+	dir := t.TempDir()
+	filename := filepath.Join(dir, "cdr.jsonl")
+	if err := os.WriteFile(filename+".bak", []byte("keep"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	sink, err := ami.NewAMICdrJsonLinesSink(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sink.Close()
+	sink.SetMaxSize(1).SetMaxBackups(1)
+	for i := 1; i <= 3; i++ {
+		if err := sink.Write(context.Background(), []ami.AMICdr{*ami.NewAMICdr().SetUniqueId(fmt.Sprintf("1700000000.%v", i))}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	backups, _ := filepath.Glob(filename + ".2*")
+	if len(backups) != 1 {
+		t.Fatalf("expected one backup kept, got %v", backups)
+	}
+	if _, err := os.Stat(filename + ".bak"); err != nil {
+		t.Fatalf("expected the unrelated file kept: %v", err)
+	}
+	if v, _ := os.ReadFile(filename); !strings.Contains(string(v), "1700000000.3") || strings.Count(string(v), "\n") != 1 {
+		t.Fatalf("expected the last record in the current file, got %q", v)
+	}
+}
+
+func TestCdrAnalyticsTrunkReport(t *testing.T) {
+	day := time.Now().UTC().Truncate(24 * time.Hour)
+	cdr := func(trunk, disposition string, duration, billable int) ami.AMICdr {
+		return *ami.NewAMICdr().
+			SetChannel("PJSIP/1001-00000001").
+			SetDestinationChannel("PJSIP/" + trunk + "-00000002").
+			SetDirection("outbound").
+			SetDisposition(disposition).
+			SetDuration(duration).
+			SetBillableSecond(billable).
+			SetStartTime(day.Add(time.Hour))
+	}
+	a := ami.NewAMICdrAnalytics().Add(
+		cdr("trunk-vn", "ANSWERED", 70, 61),
+		cdr("trunk-vn", "ANSWERED", 35, 29),
+		cdr("trunk-vn", "BUSY", 5, 0),
+		cdr("trunk-vn", "NO ANSWER", 30, 0),
+		cdr("trunk-sg", "CONGESTION", 1, 0),
+	)
+	stats := a.Report(ami.AMICdrQuery{From: day, To: day.Add(24 * time.Hour), GroupBy: "trunk", Bucket: "day"})
+	if len(stats) != 2 || stats[1].Key != "trunk-vn" {
+		t.Fatalf("unexpected report: %v", stats)
+	}
+	vn := stats[1]
+	if vn.ASR != 50 || vn.ACD != 45 || vn.AvgRingTime != 7.5 || vn.BillableMinutesRounded != 3 || !vn.BucketAt.Equal(day) {
+		t.Fatalf("unexpected trunk stats: %v", vn.Json())
+	}
+	// the records out of the retention are evicted by the next records
+	a.Add(*ami.NewAMICdr().SetStartTime(day.Add(-40 * 24 * time.Hour)).SetDisposition("ANSWERED"))
+	if a.Len() != 5 || a.Summary(time.Time{}, time.Time{}).Calls != 5 {
+		t.Fatalf("expected the old record evicted, got %v records", a.Len())
+	}
+}
+
+func TestCelAggregatorTransferredCall(t *testing.T) {
+	a := ami.NewAMICelAggregator()
+	var calls []ami.AMICelCall
+	a.OnCall(func(c ami.AMICelCall) {
+		calls = append(calls, c)
+	})
+	events := []map[string]string{
+		{"Event": "CEL", "EventName": "CHAN_START", "EventTime": "1700000000.000000", "UniqueID": "1.1", "LinkedID": "1.1", "Channel": "PJSIP/trunk-0001", "CallerIDnum": "0901234567", "Exten": "1900"},
+		{"Event": "CEL", "EventName": "CHAN_START", "EventTime": "1700000005.000000", "UniqueID": "1.2", "LinkedID": "1.1", "Channel": "PJSIP/1001-0002"},
+		{"Event": "CEL", "EventName": "BRIDGE_ENTER", "EventTime": "1700000010.000000", "UniqueID": "1.2", "LinkedID": "1.1"},
+		{"Event": "CEL", "EventName": "BLINDTRANSFER", "EventTime": "1700000040.000000", "UniqueID": "1.2", "LinkedID": "1.1"},
+		{"Event": "CEL", "EventName": "HANGUP", "EventTime": "1700000070.000000", "UniqueID": "1.1", "LinkedID": "1.1", "Extra": `{"hangupcause":16,"dialstatus":"ANSWER"}`},
+		{"Event": "CEL", "EventName": "LINKEDID_END", "EventTime": "1700000070.500000", "UniqueID": "1.1", "LinkedID": "1.1"},
+	}
+	for _, fields := range events {
+		e := ami.NewMessage()
+		e.AddFields(fields)
+		a.Apply(e)
+	}
+	if len(calls) != 1 || a.Pending() != 0 {
+		t.Fatalf("expected one completed call, got %v (pending %v)", len(calls), a.Pending())
+	}
+	call := calls[0]
+	if call.Caller != "0901234567" || call.Dnid != "1900" || call.Transfers != 1 || call.Duration != 70 || call.BillableSeconds != 60 {
+		t.Fatalf("unexpected call: %v", call.Json())
+	}
+	if call.Channels["1.1"].HangupCause != 16 || len(call.Channels) != 2 {
+		t.Fatalf("unexpected channels: %v", call.Channels)
+	}
+}
+
+func TestRatingEngineLongestPrefix(t *testing.T) {
+	table, err := ami.LoadRateTableCsv("trunk-vn", strings.NewReader(`prefix,description,rate,connection_fee,increment,minimum_duration,time_from,time_to,currency
+84,Vietnam fixed,0.06,0,60/60,,,,USD
+8490,Vietnam mobile,0.12,0.01,30/6,,,,USD
+8490,Vietnam mobile off-peak,0.06,0.01,30/6,,22:00,06:00,USD
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	engine := ami.NewAMIRatingEngine().SetRegion("VN").SetTable("trunk-vn", table)
+	r := ami.NewAMICdr().
+		SetDirection("outbound").
+		SetDestinationChannel("PJSIP/trunk-vn-00000002").
+		SetNumber("0901234567").
+		SetBillableSecond(41).
+		SetAnswerTime(time.Date(2024, 1, 2, 10, 0, 0, 0, time.UTC))
+	rating, err := engine.Apply(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 30 seconds then 2 increments of 6 seconds
+	if rating.Rate.Description != "Vietnam mobile" || rating.BilledSeconds != 42 || r.Cost != 0.094 {
+		t.Fatalf("unexpected rating: %v", rating.Json())
+	}
+	r.SetAnswerTime(time.Date(2024, 1, 2, 23, 0, 0, 0, time.UTC))
+	if rating, _ := engine.Apply(r); rating.Rate.Description != "Vietnam mobile off-peak" || r.Cost != 0.052 {
+		t.Fatalf("unexpected off-peak rating: %v", rating.Json())
+	}
+}
+
+func TestRatingEngineSkipsInbound(t *testing.T) {
+	table := ami.NewAMIRateTable("toll-free").AddRate(ami.AMIRate{Prefix: "841800", PerMinute: 0.03})
+	engine := ami.NewAMIRatingEngine().SetRegion("VN").SetTable("trunk-vn", table)
+	r := ami.NewAMICdr().
+		SetDirection("inbound").
+		SetChannel("PJSIP/trunk-vn-00000001").
+		SetNumber("18001234").
+		SetBillableSecond(60)
+	if rating, err := engine.Apply(r); err == nil || engine.Eligible(r) || r.Cost != 0 {
+		t.Fatalf("expected the inbound record not rated, got %v", rating.Json())
+	}
+	engine.SetDirections("outbound", "inbound")
+	rating, err := engine.Apply(r)
+	if err != nil || rating.Trunk != "trunk-vn" || r.Cost != 0.03 {
+		t.Fatalf("expected the inbound record rated on the trunk of party A, got %v, %v", rating.Json(), err)
+	}
+}
+
+func TestRecordingManagerMediaLink(t *testing.T) {
+	m := ami.NewAMIRecordingManager(nil).
+		SetDirectory("/var/spool/asterisk/monitor").
+		SetBaseURL("https://pbx.local/recordings/")
+	at := time.Date(2024, 3, 1, 9, 30, 0, 0, time.UTC)
+	file := m.FileName(ami.AMIRecordingRequest{Channel: "PJSIP/1001-0001", UniqueId: "1709285400.12", Extension: "1001"}, at)
+	if file != "20240301/1709285400.12-1001.wav" {
+		t.Fatalf("unexpected file: %v", file)
+	}
+	if link := m.Link("/var/spool/asterisk/monitor/" + file); link != "https://pbx.local/recordings/20240301/1709285400.12-1001.wav" {
+		t.Fatalf("unexpected link: %v", link)
+	}
+	var statuses []string
+	m.OnChange(func(r ami.AMIRecording) {
+		statuses = append(statuses, r.Status)
+	})
+	events := []map[string]string{
+		{"Event": "MixMonitorStart", "Channel": "PJSIP/1001-0001", "Uniqueid": "1709285400.12", "Linkedid": "1709285400.11"},
+		{"Event": "MixMonitorMute", "Channel": "PJSIP/1001-0001", "Direction": "both", "State": "1"},
+		{"Event": "MixMonitorMute", "Channel": "PJSIP/1001-0001", "Direction": "both", "State": "0"},
+		{"Event": "MixMonitorStop", "Channel": "PJSIP/1001-0001"},
+	}
+	for _, fields := range events {
+		e := ami.NewMessage()
+		e.AddFields(fields)
+		m.Apply(e)
+	}
+	if fmt.Sprint(statuses) != "[recording paused recording stopped]" {
+		t.Fatalf("unexpected statuses: %v", statuses)
+	}
+	if r, ok := m.Find("1709285400.11"); !ok || r.UniqueId != "1709285400.12" || len(r.Mutes) != 2 {
+		t.Fatalf("expected the recording found by linkedid, got %v", r)
+	}
+}
+
+func TestRecordingManagerAttachesLinkedLeg(t *testing.T) {
+	core, _ := fakeAmiCore(t, func(action string) string {
+		return "Response: Success\r\nMixMonitorID: 0x7f00\r\n\r\n"
+	})
+	m := ami.NewAMIRecordingManager(core).SetDirectory("/var/spool/asterisk/monitor").SetBaseURL("https://pbx.local/recordings")
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	recording, err := m.Start(ctx, ami.AMIRecordingRequest{Channel: "PJSIP/trunk-0001", UniqueId: "1709285400.11", LinkedId: "1709285400.11"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the CDR of the agent leg, the recording is on the trunk leg
+	r := ami.NewAMICdr().SetUniqueId("1709285400.12").SetLinkedId("1709285400.11")
+	if !m.AttachMediaLink(r) || r.MediaLink != recording.Link || r.MediaLink == "" {
+		t.Fatalf("expected the recording of the linked leg attached, got %q", r.MediaLink)
+	}
+	if other := ami.NewAMICdr().SetUniqueId("1709285400.99"); m.AttachMediaLink(other) {
+		t.Fatalf("expected no recording for an unrelated call, got %q", other.MediaLink)
+	}
+}
+
+func TestQueueLogParseAndTail(t *testing.T) {
+	filename := t.TempDir() + "/queue_log"
+	lines := "1700000000|1700000000.1|support|NONE|ENTERQUEUE||0901234567|1\n" +
+		"1700000012|1700000000.1|support|PJSIP/1001|CONNECT|12|1700000005.2|4\n"
+	if err := os.WriteFile(filename, []byte(lines), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	records, err := ami.ReadQueueLogFile(filename)
+	if err != nil || len(records) != 2 {
+		t.Fatalf("unexpected records: %v, %v", records, err)
+	}
+	if records[0].CallerId != "0901234567" || records[1].HoldTime != 12 || records[1].RingTime != 4 {
+		t.Fatalf("unexpected typed fields: %v", records)
+	}
+	if e := records[0].Message(); e.Field("Event") != "QueueCallerJoin" || e.Field("Uniqueid") != "1700000000.1" {
+		t.Fatalf("unexpected message: %v", e.Json())
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	followed := make(chan *ami.AMIQueueLog, 1)
+	go ami.NewAMIQueueLogTail(filename).SetInterval(10*time.Millisecond).Follow(ctx, func(r *ami.AMIQueueLog) {
+		followed <- r
+	})
+	time.Sleep(50 * time.Millisecond)
+	file, _ := os.OpenFile(filename, os.O_APPEND|os.O_WRONLY, 0o644)
+	file.WriteString("1700000100|1700000000.1|support|PJSIP/1001|COMPLETECALLER|12|88|1\n")
+	file.Close()
+	select {
+	case r := <-followed:
+		if !r.Is("COMPLETECALLER") || r.CallTime != 88 {
+			t.Fatalf("unexpected followed record: %v", r.Json())
+		}
+	case <-ctx.Done():
+		t.Fatal("expected the appended record to be followed")
+	}
+}
+
+func TestFastAgiServerHandlesSession(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	digits := make(chan string, 1)
+	hungup := make(chan bool, 1)
+	server := ami.NewAMIAgiServer("").Handle("/ivr/main", func(ctx context.Context, s *ami.AMIAgiSession) error {
+		if err := s.Answer(ctx); err != nil {
+			return err
+		}
+		value, _, err := s.GetData(ctx, "enter-account", 5*time.Second, 4)
+		if err != nil {
+			return err
+		}
+		digits <- value + "/" + s.Query().Get("lang") + "/" + strings.Join(s.Arguments, ",")
+		<-ctx.Done()
+		hungup <- s.IsHungup()
+		return nil
+	})
+	go server.Serve(ctx, listener)
+
+	conn, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	reader := bufio.NewReader(conn)
+	fmt.Fprint(conn, "agi_network: yes\nagi_network_script: ivr/main?lang=en\nagi_request: agi://127.0.0.1/ivr/main?lang=en\n"+
+		"agi_channel: PJSIP/1001-00000001\nagi_arg_1: a\nagi_arg_2: b\n\n")
+	for _, reply := range []string{"200 result=0", "200 result=0123 (timeout)"} {
+		command, err := reader.ReadString('\n')
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.HasPrefix(command, "ANSWER") && !strings.HasPrefix(command, `GET DATA enter-account 5000 4`) {
+			t.Fatalf("unexpected command: %q", command)
+		}
+		fmt.Fprintln(conn, reply)
+	}
+	if value := <-digits; value != "0123/en/a,b" {
+		t.Fatalf("unexpected digits: %v", value)
+	}
+	fmt.Fprintln(conn, "HANGUP")
+	select {
+	case ok := <-hungup:
+		if !ok {
+			t.Fatal("expected the session to be hung up")
+		}
+	case <-ctx.Done():
+		t.Fatal("expected HANGUP to cancel the session context")
+	}
+
+	reply, err := ami.ParseAgiReply("200 result=1 (test variable) endpos=1234")
+	if err != nil || reply.Data != "test variable" || reply.EndPos != 1234 {
+		t.Fatalf("unexpected reply: %v, %v", reply, err)
+	}
+}
+
+func TestFastAgiReaderEndsWithHungupSession(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	ended := make(chan error, 1)
+	release := make(chan struct{})
+	server := ami.NewAMIAgiServer("").Handle("/ivr/main", func(ctx context.Context, s *ami.AMIAgiSession) error {
+		_, err := s.StreamFile(ctx, "welcome", "", 0)
+		ended <- err
+		<-release // the handler cleans up, without sending more commands
+		return err
+	})
+	go server.Serve(ctx, listener)
+
+	conn, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close() // kept open, the reader must not wait for it
+	reader := bufio.NewReader(conn)
+	fmt.Fprint(conn, "agi_network: yes\nagi_network_script: ivr/main\nagi_channel: PJSIP/1001-00000001\n\n")
+	if _, err := reader.ReadString('\n'); err != nil {
+		t.Fatal(err)
+	}
+	// the caller hangs up during the prompt, then the reply of the interrupted command arrives
+	fmt.Fprint(conn, "HANGUP\n")
+	if err := <-ended; err == nil {
+		t.Fatal("expected the command interrupted by the hangup")
+	}
+	fmt.Fprint(conn, "200 result=-1\n")
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		buf := make([]byte, 1<<20)
+		if !strings.Contains(string(buf[:runtime.Stack(buf, true)]), "amiFastAgiTransport).read") {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("expected the reader of the ended session to return")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestAsyncAgiCorrelatesCommandResults(t *testing.T) {
+	agi := ami.NewAMIAsyncAgi(nil)
+	commands := make(chan string, 4)
+	agi.SetCommandFunc(func(ctx context.Context, channel, command, commandId string) (ami.AmiReply, error) {
+		commands <- command
+		go func() {
+			result := "200%20result%3D1%20%28gold%29%0A"
+			if strings.HasPrefix(command, "ASYNCAGI BREAK") {
+				result = "200%20result%3D0%0A"
+			}
+			e := ami.NewMessage()
+			e.AddField("Event", "AsyncAGIExec")
+			e.AddField("Channel", channel)
+			e.AddField("CommandId", commandId)
+			e.AddField("Result", result)
+			agi.Apply(e)
+		}()
+		return ami.AmiReply{"response": "Success"}, nil
+	})
+	values := make(chan string, 1)
+	agi.SetHandler(func(ctx context.Context, s *ami.AMIAgiSession) error {
+		value, ok, err := s.GetVariable(ctx, "TIER")
+		if err != nil || !ok {
+			return fmt.Errorf("unexpected variable: %v, %v", ok, err)
+		}
+		values <- value + "@" + s.Extension()
+		return nil
+	})
+	e := ami.NewMessage()
+	e.AddField("Event", "AsyncAGIStart")
+	e.AddField("Channel", "PJSIP/1001-00000001")
+	e.AddField("Env", "agi_request%3A%20async%0Aagi_channel%3A%20PJSIP%2F1001-00000001%0Aagi_extension%3A%20%2B8490%0A%0A")
+	agi.Apply(e)
+
+	select {
+	case value := <-values:
+		if value != "gold@+8490" {
+			t.Fatalf("unexpected value: %v", value)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("expected the handler to receive the command result")
+	}
+	if command := <-commands; command != "GET VARIABLE TIER" {
+		t.Fatalf("unexpected command: %v", command)
+	}
+	select {
+	case command := <-commands:
+		if command != "ASYNCAGI BREAK" {
+			t.Fatalf("expected the session to return to the dialplan, got: %v", command)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("expected ASYNCAGI BREAK once handled")
+	}
+	end := ami.NewMessage()
+	end.AddField("Event", "AsyncAGIEnd")
+	end.AddField("Channel", "PJSIP/1001-00000001")
+	agi.Apply(end)
+	if agi.Len() != 0 {
+		t.Fatal("expected AsyncAGIEnd to end the session")
+	}
+}
+
+// scriptedAgiTransport replies to the AGI commands by their prefix
+type scriptedAgiTransport struct {
+	commands []string
+	replies  map[string]string
+}
+
+func (t *scriptedAgiTransport) Command(ctx context.Context, command string) (*ami.AMIAgiReply, error) {
+	t.commands = append(t.commands, command)
+	for prefix, reply := range t.replies {
+		if strings.HasPrefix(command, prefix) {
+			return ami.ParseAgiReply(reply)
+		}
+	}
+	return ami.ParseAgiReply("200 result=0")
+}
+
+func TestIvrFlowRunsJsonDefinition(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"customer":{"tier":"gold"}}`))
+	}))
+	defer server.Close()
+	flow, err := ami.LoadIvrFlow([]byte(`{
+		"name": "support",
+		"start": "menu",
+		"nodes": [
+			{"id": "menu", "kind": "collect", "prompt": "main-menu", "max_digits": 1, "retries": 1,
+				"branches": {"1": "account", "2": "sales", "timeout": "bye"}},
+			{"id": "account", "kind": "collect", "prompt": "enter-account", "min_digits": 4, "max_digits": 6, "variable": "account", "next": "lookup"},
+			{"id": "lookup", "kind": "http", "path": "/customers/{account}", "assign": {"tier": "customer.tier"}, "next": "route",
+				"branches": {"error": "sales"}},
+			{"id": "route", "kind": "branch", "variable": "tier", "branches": {"gold": "vip", "default": "sales"}},
+			{"id": "vip", "kind": "transfer", "context": "vip", "extension": "100"},
+			{"id": "sales", "kind": "queue", "queue": "sales"},
+			{"id": "bye", "kind": "hangup"}
+		]
+	}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	flow.SetRestify(ami.NewRestify(server.URL))
+	transport := &scriptedAgiTransport{replies: map[string]string{
+		"GET DATA main-menu":     "200 result=1",
+		"GET DATA enter-account": "200 result=123456",
+	}}
+	session := ami.NewAMIAgiSession(context.Background(), map[string]string{"agi_channel": "PJSIP/1001-00000001"}, transport)
+	run, err := flow.Run(context.Background(), session)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if run.Variables["tier"] != "gold" || len(run.Timings) != 5 {
+		t.Fatalf("unexpected run: %v", run.Json())
+	}
+	if last := transport.commands[len(transport.commands)-3:]; last[0] != "SET CONTEXT vip" || last[1] != "SET EXTENSION 100" || last[2] != "SET PRIORITY 1" {
+		t.Fatalf("expected the transfer to vip, got: %v", transport.commands)
+	}
+	if _, err := ami.LoadIvrFlow([]byte(`{"name": "broken", "start": "menu", "nodes": [{"id": "menu", "kind": "play", "next": "missing"}]}`)); err == nil {
+		t.Fatal("expected the unknown next node to be rejected")
+	}
+}
+
+func TestIvrFlowLookupEndsWithSession(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done() // the service hangs until the request is cancelled
+	}))
+	defer server.Close()
+	flow, err := ami.LoadIvrFlow([]byte(`{"name": "slow", "start": "lookup",
+		"nodes": [{"id": "lookup", "kind": "http", "path": "/customers/{callerid}", "assign": {"tier": "customer.tier"}}]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	flow.SetRestify(ami.NewRestify(server.URL))
+	ctx, cancel := context.WithCancel(context.Background())
+	session := ami.NewAMIAgiSession(ctx, map[string]string{"agi_channel": "PJSIP/1001-00000001"}, &scriptedAgiTransport{})
+	time.AfterFunc(50*time.Millisecond, cancel) // the caller hangs up during the lookup
+	started := time.Now()
+	if _, err := flow.Run(session.Context(), session); err == nil || time.Since(started) > 2*time.Second {
+		t.Fatalf("expected the lookup cancelled with the session, got %v after %v", err, time.Since(started))
+	}
+}
+
+func TestAriClientSnoopAndErrors(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if username, password, ok := r.BasicAuth(); !ok || username != "asterisk" || password != "secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		switch {
+		case r.Method == http.MethodPost && r.URL.Path == "/ari/channels/1700000000.1/snoop":
+			if r.URL.Query().Get("spy") != "both" || r.URL.Query().Get("app") != "supervisor" {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			w.Write([]byte(`{"id":"snoop-1","name":"Snoop/1700000000.1-00000001","state":"Up"}`))
+		case r.Method == http.MethodDelete && r.URL.Path == "/ari/playbacks/pb-1":
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"message":"Channel not found"}`))
+		}
+	}))
+	defer server.Close()
+	ari := ami.NewAMIAri(server.URL, "asterisk", "secret").SetApplication("supervisor")
+	ctx := context.Background()
+	snoop, err := ari.Snoop(ctx, "1700000000.1", ami.AMIAriSnoop{})
+	if err != nil || snoop.Id != "snoop-1" {
+		t.Fatalf("unexpected snoop: %v, %v", snoop, err)
+	}
+	if err := ari.StopPlayback(ctx, "pb-1"); err != nil {
+		t.Fatalf("expected 204 to succeed, got: %v", err)
+	}
+	_, err = ari.Channel(ctx, "missing")
+	if !ami.IsAriNotFound(err) || !strings.Contains(err.Error(), "Channel not found") {
+		t.Fatalf("expected the ARI not found error, got: %v", err)
+	}
+	if _, err := ami.NewAMIAri(server.URL, "asterisk", "wrong").Info(ctx); !errors.Is(err, ami.ErrorAriUnauthorized) {
+		t.Fatalf("expected the ARI unauthorized error, got: %v", err)
+	}
+}
+
+func TestStasisAppDispatchesAcrossReconnect(t *testing.T) {
+	var connections int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/ari/events" || r.URL.Query().Get("app") != "callcontrol" || r.URL.Query().Get("api_key") != "asterisk:secret" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		ws, err := ami.UpgradeWebSocket(w, r)
+		if err != nil {
+			return
+		}
+		defer ws.Close()
+		channel := `"channel":{"id":"ch-1","name":"PJSIP/1001-00000001","state":"Up"}`
+		if atomic.AddInt32(&connections, 1) == 1 {
+			ws.WriteText(`{"type":"StasisStart","application":"callcontrol","args":["en"],` + channel + `}`)
+			return // drop the stream, the client reconnects
+		}
+		ws.WriteText(`{"type":"ChannelDtmfReceived","application":"callcontrol","digit":"5",` + channel + `}`)
+		ws.WriteText(`{"type":"StasisEnd","application":"callcontrol",` + channel + `}`)
+		ws.ReadMessage()
+	}))
+	defer server.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	digits := make(chan string, 1)
+	ended := make(chan bool, 1)
+	app := ami.NewAMIStasisApp(ami.NewAMIAri(server.URL, "asterisk", "secret"), "callcontrol")
+	app.Stream().SetReconnectDelay(10 * time.Millisecond)
+	app.SetHandler(func(ctx context.Context, c *ami.AMIStasisChannel) error {
+		digit, err := c.WaitDigit(ctx)
+		if err != nil {
+			return err
+		}
+		digits <- digit + "/" + strings.Join(c.Args, ",")
+		<-ctx.Done()
+		ended <- true
+		return nil
+	})
+	app.OpenAsyncFunc(ctx)
+
+	select {
+	case digit := <-digits:
+		if digit != "5/en" {
+			t.Fatalf("unexpected digit: %v", digit)
+		}
+	case <-ctx.Done():
+		t.Fatal("expected the DTMF of the channel after the reconnection")
+	}
+	select {
+	case <-ended:
+	case <-ctx.Done():
+		t.Fatal("expected StasisEnd to cancel the channel context")
+	}
+	if atomic.LoadInt32(&connections) < 2 {
+		t.Fatal("expected the event stream to reconnect")
+	}
+}
+
+// fakeAmiCore returns the core connected to the fake Asterisk answering the actions by respond,
+// the actions received are sent to the channel
+func fakeAmiCore(t *testing.T, respond func(action string) string) (*ami.AMICore, <-chan string) {
+	socket, actions := fakeAmiSocket(t, respond)
+	return ami.NewCore().SetSocket(socket), actions
+}
+
+// fakeAmiSocket returns the socket of fakeAmiCore
+func fakeAmiSocket(t *testing.T, respond func(action string) string) (*ami.AMISocket, <-chan string) {
+	client, server := net.Pipe()
+	actions := make(chan string, 32)
+	go func() {
+		reader := bufio.NewReader(server)
+		var action strings.Builder
+		for {
+			line, err := reader.ReadString('\n')
+			if err != nil {
+				return
+			}
+			if line != "\r\n" {
+				action.WriteString(line)
+				continue
+			}
+			actions <- action.String()
+			server.Write([]byte(respond(action.String())))
+			action.Reset()
+		}
+	}()
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(func() {
+		cancel()
+		client.Close()
+	})
+	socket := ami.NewAmiSocket().SetConn(client)
+	go socket.Run(ctx, client)
+	return socket, actions
+}
+
+func TestGatewayAuthorizesAndValidates(t *testing.T) {
+	core, actions := fakeAmiCore(t, func(action string) string {
+		if strings.Contains(action, "Action: Hangup") {
+			return "Response: Error\r\nMessage: No such channel\r\n\r\n"
+		}
+		return "Response: Success\r\nMessage: Interface paused successfully\r\n\r\n"
+	})
+	gateway := ami.NewAMIGateway(core).
+		AddToken("ops", "calls:write", "queues:write").
+		AddToken("viewer", "peers:read").
+		AddToken("admin", "*")
+	do := func(method, path, token, body string) (int, string) {
+		r := httptest.NewRequest(method, path, strings.NewReader(body))
+		if token != "" {
+			r.Header.Set("Authorization", "Bearer "+token)
+		}
+		w := httptest.NewRecorder()
+		gateway.ServeHTTP(w, r)
+		return w.Code, w.Body.String()
+	}
+	if code, body := do(http.MethodGet, "/peers", "", ""); code != http.StatusUnauthorized || !strings.Contains(body, `"code":"unauthorized"`) {
+		t.Fatalf("expected 401, got %v %v", code, body)
+	}
+	if code, body := do(http.MethodPost, "/calls", "viewer", `{}`); code != http.StatusForbidden || !strings.Contains(body, "calls:write") {
+		t.Fatalf("expected 403, got %v %v", code, body)
+	}
+	if code, _ := do(http.MethodGet, "/calls", "ops", ""); code != http.StatusMethodNotAllowed {
+		t.Fatalf("expected 405, got %v", code)
+	}
+	if code, _ := do(http.MethodGet, "/unknown", "ops", ""); code != http.StatusNotFound {
+		t.Fatalf("expected 404, got %v", code)
+	}
+	injected := `{"channel":"SIP/1001\r\nAction: Hangup","exten":"1002","context":"default"}`
+	if code, body := do(http.MethodPost, "/calls", "ops", injected); code != http.StatusBadRequest || !strings.Contains(body, "invalid_request") {
+		t.Fatalf("expected 400, got %v %v", code, body)
+	}
+	code, body := do(http.MethodPost, "/queues/8001/members/SIP%2F1001/pause", "ops", `{"reason":"lunch"}`)
+	if code != http.StatusOK || !strings.Contains(body, "Interface paused successfully") {
+		t.Fatalf("expected the member to be paused, got %v %v", code, body)
+	}
+	action := <-actions
+	for _, field := range []string{"Action: QueuePause", "Queue: 8001", "Interface: SIP/1001", "Paused: true", "Reason: lunch"} {
+		if !strings.Contains(action, field) {
+			t.Fatalf("expected %q in the action sent, got %q", field, action)
+		}
+	}
+	if code, body := do(http.MethodDelete, "/channels/SIP%2F1001-00000001", "admin", ""); code != http.StatusUnprocessableEntity || !strings.Contains(body, "No such channel") {
+		t.Fatalf("expected 422, got %v %v", code, body)
+	}
+	if len(actions) != 1 || !strings.Contains(<-actions, "Channel: SIP/1001-00000001") {
+		t.Fatalf("expected only the hangup to be sent")
+	}
+}
+
+func TestSocketSerializesConcurrentActions(t *testing.T) {
+	// the fake Asterisk reads the actions ahead and writes the replies line by line,
+	// so that the replies of the concurrent actions would be mixed up on the socket
+	client, server := net.Pipe()
+	channels := make(chan string, 16)
+	go func() {
+		reader := bufio.NewReader(server)
+		for {
+			line, err := reader.ReadString('\n')
+			if err != nil {
+				close(channels)
+				return
+			}
+			if v, ok := strings.CutPrefix(strings.TrimSpace(line), "Channel: "); ok {
+				channels <- v
+			}
+		}
+	}()
+	go func() {
+		for channel := range channels {
+			for _, line := range []string{"Response: Success\r\n", "Message: Channel " + channel + " hung up\r\n", "\r\n"} {
+				server.Write([]byte(line))
+				time.Sleep(time.Millisecond)
+			}
+		}
+	}()
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(func() {
+		cancel()
+		client.Close()
+	})
+	socket := ami.NewAmiSocket().SetConn(client)
+	go socket.Run(ctx, client)
+	core := ami.NewCore().SetSocket(socket)
+	gateway := ami.NewAMIGateway(core).AddToken("admin", "*")
+	var wg sync.WaitGroup
+	errs := make(chan string, 16)
+	for i := 0; i < 16; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			channel := fmt.Sprintf("SIP/10%02d-00000001", i)
+			if i%2 == 1 {
+				// the other users of the core share its socket with the gateway
+				reply, err := core.Hangup(context.Background(), channel, "")
+				if err != nil || reply.Get("message") != "Channel "+channel+" hung up" {
+					errs <- fmt.Sprintf("%v: %v %v", channel, reply, err)
+				}
+				return
+			}
+			r := httptest.NewRequest(http.MethodDelete, "/channels/"+url.PathEscape(channel), nil)
+			r.Header.Set("Authorization", "Bearer admin")
+			w := httptest.NewRecorder()
+			gateway.ServeHTTP(w, r)
+			if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "Channel "+channel+" hung up") {
+				errs <- fmt.Sprintf("%v: %v %v", channel, w.Code, w.Body.String())
+			}
+		}(i)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Errorf("expected the reply of its own action, got %v", err)
+	}
+}
+
+func TestEventStreamResumesSseAndWebSocket(t *testing.T) {
+	stream := ami.NewAMIEventStream().
+		SetHeartbeatInterval(20 * time.Millisecond).
+		SetAuthorizer(func(r *http.Request) error {
+			if r.URL.Query().Get("token") != "wallboard" {
+				return errors.New("invalid token")
+			}
+			return nil
+		})
+	server := httptest.NewServer(stream)
+	defer server.Close()
+	event := func(name string) *ami.AMIMessage {
+		m := ami.NewMessage()
+		m.AddField("Event", name)
+		m.AddField("Queue", "8001")
+		return m
+	}
+	stream.Apply(event("QueueCallerJoin"))
+	stream.Apply(event("Hangup"))
+	stream.Apply(event("QueueCallerLeave"))
+	if response, err := http.Get(server.URL + "?token=other"); err != nil || response.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("expected 401, got %v, %v", response, err)
+	}
+	request, _ := http.NewRequest(http.MethodGet, server.URL+"?token=wallboard&events=Queue*", nil)
+	request.Header.Set("Last-Event-ID", "1")
+	response, err := http.DefaultClient.Do(request)
+	if err != nil {
+		t.Fatalf("expected the stream to open, got: %v", err)
+	}
+	defer response.Body.Close()
+	reader := bufio.NewReader(response.Body)
+	heartbeats := 0
+	next := func() string {
+		var frame strings.Builder
+		for {
+			line, err := reader.ReadString('\n')
+			if err != nil {
+				t.Fatalf("reading the stream got an error: %v", err)
+			}
+			if strings.HasPrefix(line, ":") {
+				heartbeats++
+				reader.ReadString('\n')
+				continue
+			}
+			if line == "\n" {
+				return frame.String()
+			}
+			frame.WriteString(line)
+		}
+	}
+	if frame := next(); !strings.HasPrefix(frame, "id: 3\nevent: QueueCallerLeave\n") {
+		t.Fatalf("expected the replay of the event after 1, got %q", frame)
+	}
+	stream.Apply(event("Hangup"))
+	stream.Apply(event("QueueMemberPause"))
+	if frame := next(); !strings.HasPrefix(frame, "id: 5\nevent: QueueMemberPause\n") || !strings.Contains(frame, `"queue":"8001"`) {
+		t.Fatalf("expected the live event matching the pattern, got %q", frame)
+	}
+	time.Sleep(50 * time.Millisecond)
+	stream.Apply(event("QueueMemberPause"))
+	if next(); heartbeats == 0 {
+		t.Fatalf("expected heartbeats while idle")
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	ws, err := ami.DialWebSocket(ctx, "ws"+strings.TrimPrefix(server.URL, "http")+"?token=wallboard&events=hangup&format=pure&last_event_id=3", nil)
+	if err != nil {
+		t.Fatalf("expected the WebSocket to open, got: %v", err)
+	}
+	_, data, err := ws.ReadMessage()
+	if err != nil || !strings.Contains(string(data), `"id":4`) || !strings.Contains(string(data), `"event":"Hangup"`) {
+		t.Fatalf("expected the replay of the hangup, got %s, %v", data, err)
+	}
+	ws.Close()
+	response.Body.Close()
+	for deadline := time.Now().Add(2 * time.Second); stream.Len() > 0; time.Sleep(10 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatalf("expected the clients to be unsubscribed, got %v", stream.Len())
+		}
+	}
+}
+
+func TestDoGetResultCountsFirstReply(t *testing.T) {
+	socket, _ := fakeAmiSocket(t, func(action string) string {
+		return "Response: Error\r\nMessage: Permission denied\r\n\r\n"
+	})
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	c := ami.NewCommand().SetAction("VoicemailRefresh").SetId("refresh-1")
+	if _, err := ami.DoGetResult(ctx, *socket, c, nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	ami.Metrics().WriteTo(&buf)
+	if !strings.Contains(buf.String(), `voipkit_ami_actions_total{action="VoicemailRefresh",outcome="failure"} 1`) {
+		t.Fatalf("expected the error reply counted as failure:\n%v", buf.String())
+	}
+}
+
+func TestMetricsExporterScrapesOnce(t *testing.T) {
+	core, actions := fakeAmiCore(t, func(action string) string {
+		switch {
+		case strings.Contains(action, "Action: CoreStatus"):
+			return "Response: Success\r\nCoreCurrentCalls: 3\r\n\r\n"
+		case strings.Contains(action, "Action: QueueSummary"):
+			return "Response: Success\r\nEventList: start\r\n\r\n" +
+				"Event: QueueSummary\r\nQueue: 8001\r\nLoggedIn: 2\r\nAvailable: 1\r\nCallers: 4\r\nLongestHoldTime: 37\r\n\r\n" +
+				"Event: QueueSummaryComplete\r\n\r\n"
+		}
+		return "Response: Error\r\nMessage: Invalid/unknown command\r\n\r\n"
+	})
+	exporter := ami.NewAMIMetricsExporter(core).SetSip(false).SetMinInterval(time.Minute)
+	ami.Metrics().ObserveEvent("Hangup")
+	scrape := func() string {
+		w := httptest.NewRecorder()
+		exporter.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+		return w.Body.String()
+	}
+	body := scrape()
+	for _, line := range []string{
+		"asterisk_up 1",
+		"asterisk_active_calls 3",
+		`asterisk_queue_callers{queue="8001"} 4`,
+		`asterisk_queue_longest_wait_seconds{queue="8001"} 37`,
+		`voipkit_collector_success{collector="core"} 1`,
+		`voipkit_ami_actions_total{action="CoreStatus",outcome="success"}`,
+		`voipkit_ami_actions_total{action="ConfbridgeListRooms",outcome="failure"}`,
+		`voipkit_ami_action_duration_seconds_bucket{action="CoreStatus",le="+Inf"}`,
+		`voipkit_ami_events_total{event="Hangup"}`,
+	} {
+		if !strings.Contains(body, line) {
+			t.Fatalf("expected %q in the metrics:\n%v", line, body)
+		}
+	}
+	polled := len(actions)
+	scrape()
+	if len(actions) != polled {
+		t.Fatalf("expected the gauges to be reused within the min interval, got %v actions after %v", len(actions), polled)
+	}
+}
+
+func TestSendActionReadsEventList(t *testing.T) {
+	core, actions := fakeAmiCore(t, func(action string) string {
+		if strings.Contains(action, "Action: PJSIPShowEndpoints") {
+			return "Response: Success\r\nEventList: start\r\nMessage: A listing of Endpoints follows\r\n\r\n" +
+				"Event: EndpointList\r\nObjectName: 1001\r\nDeviceState: Not in use\r\n\r\n" +
+				"Event: EndpointList\r\nObjectName: 1002\r\nDeviceState: Unavailable\r\n\r\n" +
+				"Event: EndpointListComplete\r\nEventList: Complete\r\nListItems: 2\r\n\r\n"
+		}
+		return "Response: Success\r\nPing: Pong\r\n\r\n"
+	})
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	replies, err := core.SendAction(ctx, "Ping", nil)
+	if err != nil || len(replies) != 1 || replies[0].GetFold("Ping") != "Pong" {
+		t.Fatalf("expected the response of Ping only, got %v (%v)", ami.JsonString(replies), err)
+	}
+	<-actions
+	replies, err = core.SendAction(ctx, "PJSIPShowEndpoints", map[string]string{"Filter": "10*"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(replies) != 4 || replies[2].GetFold("ObjectName") != "1002" || replies[3].GetFold("ListItems") != "2" {
+		t.Fatalf("expected the response and the 3 events of the list, got %v", ami.JsonString(replies))
+	}
+	if action := <-actions; !strings.Contains(action, "Filter: 10*") {
+		t.Fatalf("expected the fields in the action, got %q", action)
+	}
+}
+
+func TestActionFieldsFromSyntax(t *testing.T) {
+	if fields := config.AmiActionFields[config.AmiActionOriginate]; len(fields) == 0 || fields[0] != "Channel" {
+		t.Fatalf("expected the fields of Originate, got %v", fields)
+	}
+	for action, fields := range config.AmiActionFields {
+		for _, field := range fields {
+			if field == config.AmiActionKey || field == "ActionID" {
+				t.Fatalf("expected %v without the %v field", action, field)
+			}
+		}
+	}
+	// the optional fields are written [Parkinglot:] in the Syntax
+	if fields := config.AmiActionFields[config.AmiActionPark]; strings.Join(fields, ",") != "Channel,TimeoutChannel,AnnounceChannel,Timeout,Parkinglot" {
+		t.Fatalf("expected the optional fields of Park, got %v", fields)
+	}
+	// the Syntax header is missing from the definition of AGI
+	if fields := config.AmiActionFields[config.AmiActionAgi]; strings.Join(fields, ",") != "Channel,Command,CommandID" {
+		t.Fatalf("expected the fields of AGI, got %v", fields)
+	}
+	if fields := config.AmiActionFields[config.AmiActionAocMessage]; !ami.Contains(fields, "UnitAmount(0)") {
+		t.Fatalf("expected the indexed fields of AOCMessage, got %v", fields)
+	}
+}
+
+func TestLevelLoggerFiltersByComponent(t *testing.T) {
+	var buf bytes.Buffer
+	logger := ami.NewAmiLevelLogger(ami.NewAmiJsonLogger(&buf, ami.Debug), ami.Warn).
+		SetComponentLevel(config.AmiLogComponentCommand, ami.Debug)
+	ami.SetLogger(logger)
+	t.Cleanup(func() { ami.SetLogger(nil) })
+
+	core, _ := fakeAmiCore(t, func(action string) string {
+		return "Response: Success\r\nPing: Pong\r\n\r\n"
+	})
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if _, err := core.SendAction(ctx, "Ping", nil); err != nil {
+		t.Fatal(err)
+	}
+	ami.NewChannel().Verify("", "1001") // debug of the call component, filtered
+	ami.NewChannel().Verify("(", "1001")
+
+	logs := buf.String()
+	if !strings.Contains(logs, `"action":"Ping"`) || !strings.Contains(logs, `"action_id":`) {
+		t.Fatalf("expected the debug of the command with its fields, got %v", logs)
+	}
+	if strings.Contains(logs, "regex is required") || !strings.Contains(logs, "regex is invalid") {
+		t.Fatalf("expected the warnings only of the call component, got %v", logs)
+	}
+}
+
+func TestTapRedactsAndSamplesFrames(t *testing.T) {
+	var buf bytes.Buffer
+	tap := ami.NewAMITap(&buf).SetFormat(config.AmiTapFormatJson).SetSampling(2).SetSensitiveHeaders("Variable")
+	client, server := net.Pipe()
+	defer client.Close()
+	go func() {
+		defer server.Close()
+		server.Write([]byte("Asterisk Call Manager/5.0.1\r\nResponse: Success\r\nMessage: Authentication accepted\r\n\r\n"))
+		for i := 0; i < 4; i++ {
+			server.Write([]byte(fmt.Sprintf("Event: Newchannel\r\nUniqueid: 1.%v\r\n\r\n", i)))
+		}
+	}()
+	go io.Copy(io.Discard, server)
+	conn := tap.Wrap(client)
+	conn.Write([]byte("Action: Login\r\nUsername: admin\r\nSecret: s3cret\r\nVariable: PIN=1234\r\n\r\n"))
+	io.Copy(io.Discard, conn)
+
+	var frames []ami.AmiTapFrame
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		var f ami.AmiTapFrame
+		if err := json.Unmarshal([]byte(line), &f); err != nil {
+			t.Fatalf("expected JSON lines, got %q: %v", line, err)
+		}
+		frames = append(frames, f)
+	}
+	// the greeting, the login, its response and 2 of the 4 events
+	if len(frames) != 5 {
+		t.Fatalf("expected 5 frames, got %v", ami.JsonString(frames))
+	}
+	var events []ami.AmiTapFrame
+	for _, f := range frames {
+		if f.Direction == config.AmiTapDirectionOut &&
+			(f.Action != "Login" || strings.Contains(f.Message, "s3cret") || strings.Contains(f.Message, "1234")) {
+			t.Fatalf("expected the login redacted, got %v", ami.JsonString(f))
+		}
+		if f.Event != "" {
+			events = append(events, f)
+		}
+	}
+	if len(events) != 2 || !strings.Contains(events[1].Message, "Uniqueid: 1.2") {
+		t.Fatalf("expected one event of 2, got %v", ami.JsonString(events))
+	}
+}
+
+func TestSocketDebugRedactsSecret(t *testing.T) {
+	var buf bytes.Buffer
+	socket, _ := fakeAmiSocket(t, func(action string) string {
+		return "Response: Success\r\nMessage: Authentication accepted\r\n\r\n"
+	})
+	socket.SetDebugMode(true).SetLogger(ami.NewAmiJsonLogger(&buf, ami.Debug))
+	if err := socket.Send("Action: Login\r\nUsername: admin\r\nsecret: s3cret\r\n\r\n"); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	socket.Received(ctx)
+	if logs := buf.String(); strings.Contains(logs, "s3cret") || !strings.Contains(logs, "Username: admin") {
+		t.Fatalf("expected the secret redacted from the debug log, got %v", logs)
+	}
+}
+
+func TestSocketDebugRedactsTapHeaders(t *testing.T) {
+	var buf bytes.Buffer
+	c, _ := fakeAmi(t, func(request *ami.AmiClient) {
+		request.SetTap(ami.NewAMITap(io.Discard).SetSensitiveHeaders("Variable"))
+	})
+	socket := c.Socket()
+	socket.SetDebugMode(true).SetLogger(ami.NewAmiJsonLogger(&buf, ami.Debug))
+	if err := socket.Send("Action: Setvar\r\nVariable: PIN=1234\r\nSecret: s3cret\r\n\r\n"); err != nil {
+		t.Fatal(err)
+	}
+	if logs := buf.String(); strings.Contains(logs, "1234") || strings.Contains(logs, "s3cret") || !strings.Contains(logs, "Action: Setvar") {
+		t.Fatalf("expected the sensitive headers of the tap redacted from the debug log, got %v", logs)
+	}
+}
+
+// recordedSpan is the span recorded by the starter of TestSpanTracerPropagatesContext
+type recordedSpan struct {
+	name       string
+	parent     interface{}
+	attributes map[string]string
+	events     []string
+	ended      bool
+}
+
+func (s *recordedSpan) SetAttributes(attributes map[string]string) {
+	for k, v := range attributes {
+		s.attributes[k] = v
+	}
+}
+
+func (s *recordedSpan) AddEvent(name string, attributes map[string]string) {
+	s.events = append(s.events, name)
+}
+
+func (s *recordedSpan) RecordError(err error) { s.attributes["error"] = err.Error() }
+func (s *recordedSpan) End()                  { s.ended = true }
+
+func TestSpanTracerPropagatesContext(t *testing.T) {
+	type parentKey struct{}
+	var mutex sync.Mutex
+	var spans []*recordedSpan
+	ami.SetTracer(ami.NewAMISpanTracer(func(ctx context.Context, name string, attributes map[string]string) (context.Context, ami.AmiSpan) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		span := &recordedSpan{name: name, parent: ctx.Value(parentKey{}), attributes: attributes}
+		spans = append(spans, span)
+		return ctx, span
+	}).SetEventSpans(true))
+	t.Cleanup(func() { ami.SetTracer(nil) })
+
+	core, _ := fakeAmiCore(t, func(action string) string {
+		id := ""
+		for _, line := range strings.Split(action, "\r\n") {
+			if strings.HasPrefix(line, "ActionID: ") {
+				id = strings.TrimPrefix(line, "ActionID: ")
+			}
+		}
+		if strings.Contains(action, "Action: Ping") {
+			return "Response: Success\r\nActionID: " + id + "\r\nPing: Pong\r\n\r\n"
+		}
+		return "Response: Success\r\nActionID: " + id + "\r\nEventList: start\r\n\r\n" +
+			"Event: EndpointList\r\nActionID: " + id + "\r\nObjectName: 1001\r\n\r\n" +
+			"Event: EndpointListComplete\r\nActionID: " + id + "\r\nEventList: Complete\r\n\r\n"
+	})
+	ctx, cancel := context.WithTimeout(context.WithValue(context.Background(), parentKey{}, "http"), 5*time.Second)
+	defer cancel()
+	if _, err := core.SendAction(ami.WithActionId(ctx, "trace-1"), "Ping", nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := core.SendAction(ctx, "PJSIPShowEndpoints", nil); err != nil {
+		t.Fatal(err)
+	}
+
+	mutex.Lock()
+	defer mutex.Unlock()
+	if len(spans) != 4 {
+		t.Fatalf("expected the spans of 2 actions and 2 events, got %v", len(spans))
+	}
+	ping := spans[0]
+	if ping.name != "ami Ping" || ping.parent != "http" || !ping.ended ||
+		ping.attributes[config.AmiTraceAttributeActionId] != "trace-1" || ping.attributes[config.AmiTraceAttributeResponse] != "Success" {
+		t.Fatalf("expected the span of Ping child of the caller, got %+v", ping)
+	}
+	event := spans[2]
+	if event.name != "ami EndpointList" || event.parent != "http" || event.attributes[config.AmiTraceAttributeActionId] != spans[1].attributes[config.AmiTraceAttributeActionId] {
+		t.Fatalf("expected the span of the event with the ActionID of its action, got %+v", event)
+	}
+}
+
+func TestSpanTracerConcurrentActions(t *testing.T) {
+	var mutex sync.Mutex
+	var spans []*recordedSpan
+	tracer := ami.NewAMISpanTracer(func(ctx context.Context, name string, attributes map[string]string) (context.Context, ami.AmiSpan) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		span := &recordedSpan{name: name, attributes: attributes}
+		spans = append(spans, span)
+		return ctx, span
+	})
+	socket, actions := fakeAmiSocket(t, func(action string) string {
+		id, object := "", ""
+		for _, line := range strings.Split(action, "\r\n") {
+			if v, ok := strings.CutPrefix(line, "ActionID: "); ok {
+				id = v
+			}
+			if v, ok := strings.CutPrefix(line, "Endpoint: "); ok {
+				object = v
+			}
+		}
+		return "Response: Success\r\nActionID: " + id + "\r\nEventList: start\r\n\r\n" +
+			"Event: EndpointDetail\r\nActionID: " + id + "\r\nObjectName: " + object + "\r\n\r\n" +
+			"Event: EndpointDetailComplete\r\nActionID: " + id + "\r\nEventList: Complete\r\n\r\n"
+	})
+	socket.SetUUID("socket-1").SetTracer(tracer)
+	core := ami.NewCore().SetSocket(socket)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	var wg sync.WaitGroup
+	for _, endpoint := range []string{"1001", "1002"} {
+		wg.Add(1)
+		go func(endpoint string) {
+			defer wg.Done()
+			if _, err := core.PJSIPShowEndpoint(ctx, endpoint); err != nil {
+				t.Error(err)
+			}
+		}(endpoint)
+	}
+	wg.Wait()
+
+	ids := make(map[string]bool)
+	for i := 0; i < 2; i++ {
+		action := <-actions
+		for _, line := range strings.Split(action, "\r\n") {
+			if v, ok := strings.CutPrefix(line, "ActionID: "); ok && strings.HasPrefix(v, "socket-1-") {
+				ids[v] = true
+			}
+		}
+	}
+	mutex.Lock()
+	defer mutex.Unlock()
+	if len(ids) != 2 || len(spans) != 2 {
+		t.Fatalf("expected 2 actions with their own ActionID, got %v and %v spans", ids, len(spans))
+	}
+	for _, span := range spans {
+		if !ids[span.attributes[config.AmiTraceAttributeActionId]] || !span.ended || len(span.events) != 2 || span.events[0] != "EndpointDetail" {
+			t.Fatalf("expected the span of each action with its event, got %+v", span)
+		}
+	}
+}
+
+func TestPubSubKeepsOrderAndDropsWhenFull(t *testing.T) {
+	pub := ami.NewPubSubQueue()
+	slow := pub.Subscribes("Newchannel", "Hangup")
+	buffered := pub.SubscribesBuffered(10, "Newchannel", "Hangup")
+	all := pub.Subscribe(config.AmiPubSubKeyRef)
+	total := config.AmiPubSubBufferSize + 10
+	for i := 0; i < total; i++ {
+		m := ami.NewMessage()
+		m.AddField(config.AmiEventKey, []string{"Newchannel", "Hangup"}[i%2])
+		m.AddField(config.AmiFieldUniqueId, fmt.Sprint(i))
+		pub.Publish(m) // never blocks on the subscribers not reading
+	}
+	if pub.Dropped() != uint64(total-10) {
+		t.Fatalf("expected only the events over the buffer of the buffered subscriber dropped, got %v", pub.Dropped())
+	}
+	for i := 0; i < total; i++ {
+		if m := <-slow; m.Field(config.AmiFieldUniqueId) != fmt.Sprint(i) {
+			t.Fatalf("expected the event %v in order, got %v", i, m.Field(config.AmiFieldUniqueId))
+		}
+	}
+	for i := 0; i < 10; i++ {
+		if m := <-buffered; m.Field(config.AmiFieldUniqueId) != fmt.Sprint(i) {
+			t.Fatalf("expected the buffered event %v in order, got %v", i, m.Field(config.AmiFieldUniqueId))
+		}
+	}
+	pub.Unsubscribes(slow, "Newchannel")
+	pub.Unsubscribes(slow, "Hangup")
+	if _, ok := <-slow; ok {
+		t.Fatal("expected the channel closed once unsubscribed from all its keys")
+	}
+	pub.Unsubscribes(buffered, "Newchannel", "Hangup")
+	if _, ok := <-buffered; ok {
+		t.Fatal("expected the buffered channel closed once unsubscribed")
+	}
+	pub.Unsubscribe(config.AmiPubSubKeyRef, all)
+	for range all { // closed once unsubscribed, its pending events are discarded
+	}
+	pub.Destroy()
+	if pub.Publish(ami.NewMessage()) {
+		t.Fatal("expected no publish once destroyed")
+	}
+}
+
+// fakeAmi returns the client logged in to the fake Asterisk answering every action with success,
+// the events sent to the channel are written on its connection of the events (the first one accepted)
+func fakeAmi(t *testing.T, options ...func(*ami.AmiClient)) (*ami.AMI, chan<- string) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	events := make(chan string, 32)
+	go func() {
+		for i := 0; ; i++ {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			conn.Write([]byte("Asterisk Call Manager/5.0.1\r\n"))
+			if i == 0 {
+				go func() {
+					for e := range events {
+						conn.Write([]byte(e))
+					}
+				}()
+			}
+			go func() {
+				reader := bufio.NewReader(conn)
+				id := ""
+				for {
+					line, err := reader.ReadString('\n')
+					if err != nil {
+						return
+					}
+					if v, ok := strings.CutPrefix(strings.TrimSpace(line), "ActionID: "); ok {
+						id = v
+					}
+					if strings.TrimSpace(line) == "" {
+						conn.Write([]byte("Response: Success\r\nActionID: " + id + "\r\nMessage: Authentication accepted\r\n\r\n"))
+						id = ""
+					}
+				}
+			}()
+		}
+	}()
+	addr := listener.Addr().(*net.TCPAddr)
+	request := ami.NewAmiClient().SetEnabled(true).SetHost(addr.IP.String()).SetPort(addr.Port).
+		SetUsername("admin").SetPassword("secret").SetTimeout(5 * time.Second)
+	for _, option := range options {
+		option(request)
+	}
+	c, err := ami.NewClient(ami.NewTcp(), *request)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		c.Close()
+		listener.Close()
+	})
+	return c, events
+}
+
+func TestParkingParksFromEvent(t *testing.T) {
+	c, events := fakeAmi(t)
+	core, actions := fakeAmiCore(t, func(action string) string {
+		if strings.Contains(action, "Action: Park\r\n") {
+			events <- "Event: ParkedCall\r\nParkinglot: default\r\nParkingSpace: 701\r\nParkeeChannel: PJSIP/1001-01\r\nParkeeUniqueid: 1.1\r\n\r\n"
+			return "Response: Success\r\nMessage: Park successful\r\n\r\n"
+		}
+		return "Response: Error\r\nMessage: Invalid/unknown command\r\n\r\n"
+	})
+	parking := ami.NewAMIParkingService(core).SetWaitTimeout(time.Minute)
+	parking.OpenAsyncFunc(c)
+	time.Sleep(50 * time.Millisecond)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	call, err := parking.Park(ctx, "PJSIP/1001-01", "default", 0, "")
+	if err != nil || call.Space != "701" || call.UniqueId != "1.1" {
+		t.Fatalf("expected the space of the ParkedCall event, got %v, %v", ami.JsonString(call), err)
+	}
+	if action := <-actions; !strings.Contains(action, "Action: Park\r\n") || !strings.Contains(action, "Parkinglot: default") {
+		t.Fatalf("unexpected action: %q", action)
+	}
+	select {
+	case action := <-actions:
+		t.Fatalf("expected no ParkedCalls lookup, got %q", action)
+	default:
+	}
+}
+
+func TestParkingLooksUpWhenNotOpen(t *testing.T) {
+	core, actions := fakeAmiCore(t, func(action string) string {
+		switch {
+		case strings.Contains(action, "Action: Park\r\n"):
+			return "Response: Success\r\nMessage: Park successful\r\n\r\n"
+		case strings.Contains(action, "Action: ParkedCalls"):
+			return "Response: Success\r\nEventList: start\r\n\r\n" +
+				"Event: ParkedCall\r\nParkinglot: default\r\nParkingSpace: 702\r\nParkeeChannel: PJSIP/1002-02\r\n\r\n" +
+				"Event: ParkedCallsComplete\r\n\r\n"
+		}
+		return "Response: Error\r\nMessage: Invalid/unknown command\r\n\r\n"
+	})
+	parking := ami.NewAMIParkingService(core).SetWaitTimeout(time.Minute)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	call, err := parking.Park(ctx, "PJSIP/1002-02", "default", 30*time.Second, "")
+	if err != nil || call.Space != "702" {
+		t.Fatalf("expected the space looked up by ParkedCalls, got %v, %v", ami.JsonString(call), err)
+	}
+	if action := <-actions; !strings.Contains(action, "Timeout: 30000") {
+		t.Fatalf("expected the timeout in milliseconds, got %q", action)
+	}
+	if action := <-actions; !strings.Contains(action, "Action: ParkedCalls") {
+		t.Fatalf("expected the ParkedCalls lookup, got %q", action)
+	}
+	if _, ok := parking.Call("default", "702"); !ok {
+		t.Fatal("expected the looked up call to be tracked")
+	}
+}
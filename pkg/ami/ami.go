@@ -28,6 +28,17 @@ func (c *AMI) Core() *AMICore {
 	return c.c
 }
 
+// SetAri
+// SetAri sets the ARI client of the same Asterisk, so that the application mixes AMI and ARI.
+func (c *AMI) SetAri(value *AMIAri) *AMI {
+	c.ari = value
+	return c
+}
+
+func (c *AMI) Ari() *AMIAri {
+	return c.ari
+}
+
 func (c *AMI) setContext(value context.Context) *AMI {
 	c.ctx = value
 	return c
@@ -60,10 +71,35 @@ func (c *AMI) Conn() net.Conn {
 }
 
 func (c *AMI) setSocket(value *AMISocket) *AMI {
+	if value != nil && c.logger != nil {
+		value.SetLogger(c.logger)
+	}
+	if value != nil && c.tracer != nil {
+		value.SetTracer(c.tracer)
+	}
 	c.socket = value
 	return c
 }
 
+// Logger
+// Logger returns the logger of the component on the client, i.e: Logger(config.AmiLogComponentEvent).
+func (c *AMI) Logger(component string) AmiLogger {
+	l := c.logger
+	if l == nil {
+		l = Log()
+	}
+	return WithLogFields(l, config.AmiLogFieldComponent, component)
+}
+
+// Tracer
+// Tracer returns the tracer of the client, the tracer of the library if not set.
+func (c *AMI) Tracer() AmiTracer {
+	if c.tracer == nil {
+		return Tracer()
+	}
+	return c.tracer
+}
+
 // Action sends an AMI action message to the Asterisk server.
 // If the action message does not have an ActionID, it adds one automatically.
 // The method returns true if the action message is successfully sent, otherwise false.
@@ -125,6 +161,23 @@ func (c *AMI) OnEvents(keys ...string) <-chan *AMIMessage {
 	return c.subs.Subscribes(keys...)
 }
 
+// OnEventsBuffered returns a channel of the events of the keys buffered up to size, the next ones are dropped
+// while the buffer is full (see AMIPubSubQueue.SubscribeBuffered).
+func (c *AMI) OnEventsBuffered(size int, keys ...string) <-chan *AMIMessage {
+	return c.subs.SubscribesBuffered(size, keys...)
+}
+
+// Unsubscribe removes the channel of AllEvents or OnEvent from the subscribers of the event name and closes it.
+// The channels must be unsubscribed once they are not read anymore, the next events are queued otherwise.
+func (c *AMI) Unsubscribe(name string, ch <-chan *AMIMessage) {
+	c.subs.Unsubscribe(name, ch)
+}
+
+// Unsubscribes removes the channel of OnEvents from the subscribers of the events name and closes it.
+func (c *AMI) Unsubscribes(ch <-chan *AMIMessage, keys ...string) {
+	c.subs.Unsubscribes(ch, keys...)
+}
+
 // EmitError sends an error to the error channel (c.Err) in a non-blocking manner.
 // If the error or the error channel is nil, it returns immediately.
 //
@@ -344,6 +397,12 @@ func (c *AMI) release(ctx context.Context) {
 					}
 				}
 				c.message = message
+				if message != nil {
+					Metrics().ObserveEvent(message.Field(config.AmiEventKey))
+					if message.IsEvent() {
+						c.Tracer().OnEvent(traceContext(ctx, message.Field(config.AmiActionIdKey)), message.Reply())
+					}
+				}
 				c.publish(message)
 			}
 		}
@@ -416,6 +475,7 @@ func (c *AMI) authenticate(_ctx context.Context, request AmiClient) error {
 //
 // Parameters:
 //   - conn: The network connection used by the AMI client.
+//   - request: The AmiClient configuration, its logger, tap and tracer are set to the client.
 //
 // Returns:
 //   - An initialized AMI client (*AMI).
@@ -428,25 +488,30 @@ func (c *AMI) authenticate(_ctx context.Context, request AmiClient) error {
 //	if err != nil {
 //	    log.Fatal(err)
 //	}
-//	client, ctx := create(conn)
+//	client, ctx := create(conn, AmiClient{})
 //	// Use the client and context for AMI operations.
 //	// Make sure to close the connection and cancel the context when done.
 //	defer client.Close()
 //	defer client.Cancel()
-func create(conn net.Conn) (*AMI, context.Context) {
+func create(conn net.Conn, request AmiClient) (*AMI, context.Context) {
+	if request.tap != nil {
+		conn = request.tap.Wrap(conn)
+	}
 	ctx, cancel := context.WithCancel(context.Background())
 	c := &AMI{
 		reader: textproto.NewReader(bufio.NewReader(conn)),
 		writer: bufio.NewWriter(conn),
 		conn:   conn,
 		cancel: cancel,
+		logger: request.logger,
+		tracer: request.tracer,
 	}
 	if conn != nil {
 		addr := conn.RemoteAddr().String()
-		_socket, err := WithSocket(ctx, addr)
+		_socket, err := withSocket(ctx, addr, request.tap)
 		if err == nil {
-			c.socket = _socket
-			D().Info("Ami network cloning (addr: %v) socket connection succeeded", addr)
+			c.setSocket(_socket)
+			c.Logger(config.AmiLogComponentClient).Debug("socket connection cloned", "addr", addr)
 		}
 	}
 	return c, ctx
@@ -475,7 +540,7 @@ func create(conn net.Conn) (*AMI, context.Context) {
 // fails, it returns an appropriate error. If the initialization and authentication are successful, it returns a pointer to
 // the AMI client ready for further interaction with the Asterisk server.
 func serve(conn net.Conn, request AmiClient) (*AMI, error) {
-	ins, ctx := create(conn)
+	ins, ctx := create(conn, request)
 	err := ins.apply(ctx, request.timeout)
 	if err != nil {
 		return nil, err
@@ -0,0 +1,677 @@
+package ami
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pnguyen215/voipkit/pkg/ami/config"
+)
+
+// AmiAgiTransport
+// AmiAgiTransport sends an AGI command line and waits for its reply,
+// i.e: the FastAGI connection or the Async AGI over AMI.
+type AmiAgiTransport interface {
+	Command(ctx context.Context, command string) (*AMIAgiReply, error)
+}
+
+// AmiAgiHandlerFunc
+// AmiAgiHandlerFunc handles the AGI session, the context is cancelled when the channel hangs up.
+type AmiAgiHandlerFunc func(ctx context.Context, s *AMIAgiSession) error
+
+// ParseAgiReply
+// ParseAgiReply parses the AGI reply, i.e: 200 result=1 (test variable) endpos=1234
+// The multi-line usage of the 520 reply is kept in Usage.
+func ParseAgiReply(value string) (*AMIAgiReply, error) {
+	value = strings.TrimRight(value, "\r\n")
+	lines := strings.Split(value, "\n")
+	line := strings.TrimRight(lines[len(lines)-1], "\r")
+	if len(lines) > 1 {
+		line = strings.TrimRight(lines[0], "\r")
+	}
+	if len(line) < 3 {
+		return nil, fmt.Errorf(config.AmiErrorAgiReplyInvalid, value)
+	}
+	code, err := strconv.Atoi(line[:3])
+	if err != nil {
+		return nil, fmt.Errorf(config.AmiErrorAgiReplyInvalid, value)
+	}
+	r := &AMIAgiReply{Code: code, Raw: value}
+	if code != config.AmiAgiCodeSuccess {
+		r.Result = -1
+		r.Data = strings.TrimLeft(line[3:], " -")
+		if len(lines) > 1 {
+			r.Usage = strings.Join(lines[1:len(lines)-1], "\n")
+		}
+		return r, nil
+	}
+	rest := strings.TrimSpace(line[3:])
+	for !IsStringEmpty(rest) {
+		if strings.HasPrefix(rest, "(") {
+			end := strings.LastIndex(rest, ")")
+			if end < 0 {
+				return nil, fmt.Errorf(config.AmiErrorAgiReplyInvalid, value)
+			}
+			r.Data = rest[1:end]
+			rest = strings.TrimSpace(rest[end+1:])
+			continue
+		}
+		token := rest
+		if i := strings.IndexByte(rest, ' '); i >= 0 {
+			token, rest = rest[:i], strings.TrimSpace(rest[i+1:])
+		} else {
+			rest = ""
+		}
+		key, v, _ := strings.Cut(token, "=")
+		switch key {
+		case config.AmiAgiResultKey:
+			r.Value = v
+			r.Result, _ = strconv.Atoi(v) // the digits of GET DATA may be * or #
+		case config.AmiAgiEndPosKey:
+			r.EndPos, _ = strconv.ParseInt(v, 10, 64)
+		default:
+			if r.Extra == nil {
+				r.Extra = make(map[string]string)
+			}
+			r.Extra[key] = v
+		}
+	}
+	return r, nil
+}
+
+func (r *AMIAgiReply) Json() string {
+	return JsonString(r)
+}
+
+// IsSuccess
+// IsSuccess returns true if the command was accepted (200) and did not fail (result is not -1).
+func (r *AMIAgiReply) IsSuccess() bool {
+	return r.Code == config.AmiAgiCodeSuccess && r.Result != -1
+}
+
+// IsTimeout
+// IsTimeout returns true if the reply data is timeout, i.e: GET DATA without the max digits entered.
+func (r *AMIAgiReply) IsTimeout() bool {
+	return r.Data == "timeout"
+}
+
+// Digit
+// Digit returns the DTMF digit of the result (the ASCII code of the digit), empty if no digit was pressed.
+func (r *AMIAgiReply) Digit() string {
+	if r.Result <= 0 {
+		return ""
+	}
+	return string(rune(r.Result))
+}
+
+// AgiCommand
+// AgiCommand builds the AGI command line, the arguments are quoted if needed.
+func AgiCommand(command string, args ...interface{}) string {
+	var builder strings.Builder
+	builder.WriteString(command)
+	for _, arg := range args {
+		builder.WriteByte(' ')
+		builder.WriteString(agiQuote(fmt.Sprintf("%v", arg)))
+	}
+	return builder.String()
+}
+
+func agiQuote(value string) string {
+	if !IsStringEmpty(value) && !strings.ContainsAny(value, " \t\"\\") {
+		return value
+	}
+	replacer := strings.NewReplacer(`\`, `\\`, `"`, `\"`)
+	return `"` + replacer.Replace(value) + `"`
+}
+
+func agiMillis(d time.Duration) int64 {
+	if d < 0 {
+		return -1
+	}
+	return d.Milliseconds()
+}
+
+// NewAMIAgiSession
+// NewAMIAgiSession creates the session of the environment over the transport.
+func NewAMIAgiSession(ctx context.Context, env map[string]string, transport AmiAgiTransport) *AMIAgiSession {
+	s := &AMIAgiSession{Env: env, transport: transport}
+	if s.Env == nil {
+		s.Env = make(map[string]string)
+	}
+	s.ctx, s.cancel = context.WithCancel(ctx)
+	var keys []int
+	for key := range s.Env {
+		if n, err := strconv.Atoi(strings.TrimPrefix(key, config.AmiAgiArgumentPrefix)); err == nil && strings.HasPrefix(key, config.AmiAgiArgumentPrefix) {
+			keys = append(keys, n)
+		}
+	}
+	sort.Ints(keys)
+	for _, n := range keys {
+		s.Arguments = append(s.Arguments, s.Env[fmt.Sprintf("%v%v", config.AmiAgiArgumentPrefix, n)])
+	}
+	return s
+}
+
+// Context
+// Context returns the context of the session, it is cancelled when the channel hangs up.
+func (s *AMIAgiSession) Context() context.Context {
+	return s.ctx
+}
+
+// hangup marks the channel hung up and cancels the session
+func (s *AMIAgiSession) hangup() {
+	s.mutex.Lock()
+	s.hungup = true
+	s.mutex.Unlock()
+	s.cancel()
+}
+
+// IsHungup
+// IsHungup returns true if the channel hung up.
+func (s *AMIAgiSession) IsHungup() bool {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	return s.hungup
+}
+
+func (s *AMIAgiSession) Get(key string) string {
+	return s.Env[key]
+}
+
+func (s *AMIAgiSession) Channel() string {
+	return s.Env[config.AmiAgiChannelKey]
+}
+
+func (s *AMIAgiSession) UniqueId() string {
+	return s.Env[config.AmiAgiUniqueIdKey]
+}
+
+func (s *AMIAgiSession) CallerId() string {
+	return s.Env[config.AmiAgiCallerIdKey]
+}
+
+func (s *AMIAgiSession) CallerIdName() string {
+	return s.Env[config.AmiAgiCallerIdNameKey]
+}
+
+func (s *AMIAgiSession) Extension() string {
+	return s.Env[config.AmiAgiExtensionKey]
+}
+
+func (s *AMIAgiSession) DialContext() string {
+	return s.Env[config.AmiAgiContextKey]
+}
+
+func (s *AMIAgiSession) Request() string {
+	return s.Env[config.AmiAgiRequestKey]
+}
+
+// Script
+// Script returns the script path of the request without the query, i.e: agi://host/ivr/main?lang=en returns ivr/main
+func (s *AMIAgiSession) Script() string {
+	script := s.Env[config.AmiAgiNetworkScriptKey]
+	if IsStringEmpty(script) {
+		if u, err := url.Parse(s.Request()); err == nil {
+			script = u.Path
+		}
+	}
+	return agiScriptPath(script)
+}
+
+// Query
+// Query returns the query parameters of the request, i.e: agi://host/ivr?lang=en
+func (s *AMIAgiSession) Query() url.Values {
+	u, err := url.Parse(s.Request())
+	if err != nil {
+		return url.Values{}
+	}
+	return u.Query()
+}
+
+func (s *AMIAgiSession) Json() string {
+	return JsonString(s)
+}
+
+func agiScriptPath(value string) string {
+	value, _, _ = strings.Cut(value, "?")
+	return strings.Trim(TrimStringSpaces(value), "/")
+}
+
+// Command
+// Command sends the raw AGI command line, the reply is returned as is.
+func (s *AMIAgiSession) Command(ctx context.Context, command string) (*AMIAgiReply, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return s.transport.Command(ctx, command)
+}
+
+// Execute
+// Execute sends the AGI command, an error is returned if the command was rejected or its result is -1.
+func (s *AMIAgiSession) Execute(ctx context.Context, command string, args ...interface{}) (*AMIAgiReply, error) {
+	line := AgiCommand(command, args...)
+	reply, err := s.Command(ctx, line)
+	if err != nil {
+		return reply, err
+	}
+	if !reply.IsSuccess() {
+		return reply, fmt.Errorf(config.AmiErrorAgiCommandFailed, line, reply.Raw)
+	}
+	return reply, nil
+}
+
+// expect sends the command and checks its result is expected
+func (s *AMIAgiSession) expect(ctx context.Context, result int, command string, args ...interface{}) error {
+	reply, err := s.Execute(ctx, command, args...)
+	if err != nil {
+		return err
+	}
+	if reply.Result != result {
+		return fmt.Errorf(config.AmiErrorAgiCommandFailed, AgiCommand(command, args...), reply.Raw)
+	}
+	return nil
+}
+
+// value sends the command returning 1 and the value in parentheses if the value is set
+func (s *AMIAgiSession) value(ctx context.Context, command string, args ...interface{}) (string, bool, error) {
+	reply, err := s.Execute(ctx, command, args...)
+	if err != nil {
+		return "", false, err
+	}
+	return reply.Data, reply.Result == 1, nil
+}
+
+// Answer
+// Answer answers the channel if not already in answer state.
+func (s *AMIAgiSession) Answer(ctx context.Context) error {
+	return s.expect(ctx, 0, config.AmiAgiCommandAnswer)
+}
+
+// AsyncAgiBreak
+// AsyncAgiBreak returns the control to the dialplan, it is only valid in Async AGI.
+func (s *AMIAgiSession) AsyncAgiBreak(ctx context.Context) error {
+	_, err := s.Execute(ctx, config.AmiAgiCommandAsyncAgiBreak)
+	return err
+}
+
+// ChannelStatus
+// ChannelStatus returns the status of the channel (0 down and available ... 6 up, 7 busy),
+// the current channel if the channel is empty.
+func (s *AMIAgiSession) ChannelStatus(ctx context.Context, channel string) (int, error) {
+	var args []interface{}
+	if !IsStringEmpty(channel) {
+		args = append(args, channel)
+	}
+	reply, err := s.Execute(ctx, config.AmiAgiCommandChannelStatus, args...)
+	if err != nil {
+		return -1, err
+	}
+	return reply.Result, nil
+}
+
+// ControlStreamFile
+// ControlStreamFile plays the file, the listener can control the stream by the skip, forward, rewind and pause digits.
+func (s *AMIAgiSession) ControlStreamFile(ctx context.Context, filename, escapeDigits string, skip time.Duration, forward, rewind, pause string) (*AMIAgiReply, error) {
+	return s.Execute(ctx, config.AmiAgiCommandControlStreamFile, filename, escapeDigits, agiMillis(skip), forward, rewind, pause)
+}
+
+// DatabaseDel
+// DatabaseDel deletes the key of the family from the Asterisk database.
+func (s *AMIAgiSession) DatabaseDel(ctx context.Context, family, key string) error {
+	return s.expect(ctx, 1, config.AmiAgiCommandDatabaseDelete, family, key)
+}
+
+// DatabaseDelTree
+// DatabaseDelTree deletes the family, or the key tree of the family if the key tree is not empty.
+func (s *AMIAgiSession) DatabaseDelTree(ctx context.Context, family, keyTree string) error {
+	args := []interface{}{family}
+	if !IsStringEmpty(keyTree) {
+		args = append(args, keyTree)
+	}
+	return s.expect(ctx, 1, config.AmiAgiCommandDatabaseDeleteTree, args...)
+}
+
+// DatabaseGet
+// DatabaseGet returns the value of the key of the family, and false if the key is not set.
+func (s *AMIAgiSession) DatabaseGet(ctx context.Context, family, key string) (string, bool, error) {
+	return s.value(ctx, config.AmiAgiCommandDatabaseGet, family, key)
+}
+
+// DatabasePut
+// DatabasePut adds or updates the value of the key of the family.
+func (s *AMIAgiSession) DatabasePut(ctx context.Context, family, key, value string) error {
+	return s.expect(ctx, 1, config.AmiAgiCommandDatabasePut, family, key, value)
+}
+
+// Exec
+// Exec executes the dialplan application with the options, it returns the result of the application.
+func (s *AMIAgiSession) Exec(ctx context.Context, application string, options ...string) (int, error) {
+	line := AgiCommand(config.AmiAgiCommandExecute, application)
+	if len(options) > 0 {
+		line += " " + agiQuote(strings.Join(options, ","))
+	}
+	reply, err := s.Command(ctx, line)
+	if err != nil {
+		return -1, err
+	}
+	if reply.Code != config.AmiAgiCodeSuccess || reply.Result == -2 {
+		return reply.Result, fmt.Errorf(config.AmiErrorAgiCommandFailed, line, reply.Raw)
+	}
+	return reply.Result, nil
+}
+
+// GetData
+// GetData plays the file and reads up to max digits, the timeout is true if the caller stopped before the max digits.
+func (s *AMIAgiSession) GetData(ctx context.Context, filename string, timeout time.Duration, maxDigits int) (string, bool, error) {
+	args := []interface{}{filename}
+	if timeout > 0 || maxDigits > 0 {
+		args = append(args, agiMillis(timeout))
+	}
+	if maxDigits > 0 {
+		args = append(args, maxDigits)
+	}
+	reply, err := s.Execute(ctx, config.AmiAgiCommandGetData, args...)
+	if err != nil {
+		return "", false, err
+	}
+	return reply.Value, reply.IsTimeout(), nil
+}
+
+// GetFullVariable
+// GetFullVariable evaluates the expression on the channel (the current channel if empty), i.e: ${CALLERID(num)}
+func (s *AMIAgiSession) GetFullVariable(ctx context.Context, expression, channel string) (string, bool, error) {
+	args := []interface{}{expression}
+	if !IsStringEmpty(channel) {
+		args = append(args, channel)
+	}
+	return s.value(ctx, config.AmiAgiCommandGetFullVariable, args...)
+}
+
+// GetOption
+// GetOption plays the file and waits for a digit of the escape digits up to the timeout.
+func (s *AMIAgiSession) GetOption(ctx context.Context, filename, escapeDigits string, timeout time.Duration) (*AMIAgiReply, error) {
+	return s.Execute(ctx, config.AmiAgiCommandGetOption, filename, escapeDigits, agiMillis(timeout))
+}
+
+// GetVariable
+// GetVariable returns the value of the channel variable, and false if the variable is not set.
+func (s *AMIAgiSession) GetVariable(ctx context.Context, name string) (string, bool, error) {
+	return s.value(ctx, config.AmiAgiCommandGetVariable, name)
+}
+
+// GoSub
+// GoSub runs the dialplan subroutine, then the control returns to the AGI session.
+func (s *AMIAgiSession) GoSub(ctx context.Context, context, extension string, priority string, args ...string) error {
+	values := []interface{}{context, extension, priority}
+	if len(args) > 0 {
+		values = append(values, strings.Join(args, ","))
+	}
+	_, err := s.Execute(ctx, config.AmiAgiCommandGoSub, values...)
+	return err
+}
+
+// Hangup
+// Hangup hangs up the channel, the current channel if empty.
+func (s *AMIAgiSession) Hangup(ctx context.Context, channel string) error {
+	var args []interface{}
+	if !IsStringEmpty(channel) {
+		args = append(args, channel)
+	}
+	return s.expect(ctx, 1, config.AmiAgiCommandHangup, args...)
+}
+
+// Noop
+// Noop does nothing, it is used to check the session is alive.
+func (s *AMIAgiSession) Noop(ctx context.Context) error {
+	_, err := s.Execute(ctx, config.AmiAgiCommandNoop)
+	return err
+}
+
+// ReceiveChar
+// ReceiveChar receives a character of text on the channel (if supported), empty on timeout.
+func (s *AMIAgiSession) ReceiveChar(ctx context.Context, timeout time.Duration) (string, error) {
+	reply, err := s.Execute(ctx, config.AmiAgiCommandReceiveChar, agiMillis(timeout))
+	if err != nil {
+		return "", err
+	}
+	return reply.Digit(), nil
+}
+
+// ReceiveText
+// ReceiveText receives a string of text on the channel (if supported).
+func (s *AMIAgiSession) ReceiveText(ctx context.Context, timeout time.Duration) (string, error) {
+	reply, err := s.Execute(ctx, config.AmiAgiCommandReceiveText, agiMillis(timeout))
+	if err != nil {
+		return "", err
+	}
+	return reply.Data, nil
+}
+
+// RecordFile
+// RecordFile records the channel into the file of the format (without extension) until a digit of the escape digits,
+// the timeout (-1 for no timeout) or the silence (0 for none).
+func (s *AMIAgiSession) RecordFile(ctx context.Context, filename, format, escapeDigits string, timeout time.Duration, beep bool, silence time.Duration) (*AMIAgiReply, error) {
+	args := []interface{}{filename, format, escapeDigits, agiMillis(timeout)}
+	if beep {
+		args = append(args, "BEEP")
+	}
+	if silence > 0 {
+		args = append(args, fmt.Sprintf("s=%v", int(silence.Seconds())))
+	}
+	return s.Execute(ctx, config.AmiAgiCommandRecordFile, args...)
+}
+
+// SayAlpha
+// SayAlpha says the characters of the value.
+func (s *AMIAgiSession) SayAlpha(ctx context.Context, value, escapeDigits string) (*AMIAgiReply, error) {
+	return s.Execute(ctx, config.AmiAgiCommandSayAlpha, value, escapeDigits)
+}
+
+// SayDate
+// SayDate says the date of the time.
+func (s *AMIAgiSession) SayDate(ctx context.Context, at time.Time, escapeDigits string) (*AMIAgiReply, error) {
+	return s.Execute(ctx, config.AmiAgiCommandSayDate, at.Unix(), escapeDigits)
+}
+
+// SayDateTime
+// SayDateTime says the time by the format (i.e: ABdY 'digits/at' IMp) and the timezone, both are optional.
+func (s *AMIAgiSession) SayDateTime(ctx context.Context, at time.Time, escapeDigits, format, timezone string) (*AMIAgiReply, error) {
+	args := []interface{}{at.Unix(), escapeDigits}
+	if !IsStringEmpty(format) || !IsStringEmpty(timezone) {
+		args = append(args, format)
+	}
+	if !IsStringEmpty(timezone) {
+		args = append(args, timezone)
+	}
+	return s.Execute(ctx, config.AmiAgiCommandSayDateTime, args...)
+}
+
+// SayDigits
+// SayDigits says the digits of the number one by one.
+func (s *AMIAgiSession) SayDigits(ctx context.Context, number, escapeDigits string) (*AMIAgiReply, error) {
+	return s.Execute(ctx, config.AmiAgiCommandSayDigits, number, escapeDigits)
+}
+
+// SayNumber
+// SayNumber says the number, the gender is optional (i.e: m, f, n).
+func (s *AMIAgiSession) SayNumber(ctx context.Context, number int, escapeDigits, gender string) (*AMIAgiReply, error) {
+	args := []interface{}{number, escapeDigits}
+	if !IsStringEmpty(gender) {
+		args = append(args, gender)
+	}
+	return s.Execute(ctx, config.AmiAgiCommandSayNumber, args...)
+}
+
+// SayPhonetic
+// SayPhonetic says the characters of the value with the phonetic alphabet.
+func (s *AMIAgiSession) SayPhonetic(ctx context.Context, value, escapeDigits string) (*AMIAgiReply, error) {
+	return s.Execute(ctx, config.AmiAgiCommandSayPhonetic, value, escapeDigits)
+}
+
+// SayTime
+// SayTime says the time of day of the time.
+func (s *AMIAgiSession) SayTime(ctx context.Context, at time.Time, escapeDigits string) (*AMIAgiReply, error) {
+	return s.Execute(ctx, config.AmiAgiCommandSayTime, at.Unix(), escapeDigits)
+}
+
+// SendImage
+// SendImage sends the image on the channel (if supported).
+func (s *AMIAgiSession) SendImage(ctx context.Context, image string) error {
+	return s.expect(ctx, 0, config.AmiAgiCommandSendImage, image)
+}
+
+// SendText
+// SendText sends the text on the channel (if supported).
+func (s *AMIAgiSession) SendText(ctx context.Context, text string) error {
+	return s.expect(ctx, 0, config.AmiAgiCommandSendText, text)
+}
+
+// SetAutoHangup
+// SetAutoHangup hangs up the channel after the duration, 0 disables the auto hangup.
+func (s *AMIAgiSession) SetAutoHangup(ctx context.Context, after time.Duration) error {
+	_, err := s.Execute(ctx, config.AmiAgiCommandSetAutoHangup, int(after.Seconds()))
+	return err
+}
+
+// SetCallerId
+// SetCallerId sets the caller id of the channel, i.e: "Sales" <1000>
+func (s *AMIAgiSession) SetCallerId(ctx context.Context, callerId string) error {
+	_, err := s.Execute(ctx, config.AmiAgiCommandSetCallerId, callerId)
+	return err
+}
+
+// SetContext
+// SetContext sets the context to continue on exit of the session.
+func (s *AMIAgiSession) SetContext(ctx context.Context, context string) error {
+	_, err := s.Execute(ctx, config.AmiAgiCommandSetContext, context)
+	return err
+}
+
+// SetExtension
+// SetExtension sets the extension to continue on exit of the session.
+func (s *AMIAgiSession) SetExtension(ctx context.Context, extension string) error {
+	_, err := s.Execute(ctx, config.AmiAgiCommandSetExtension, extension)
+	return err
+}
+
+// SetMusic
+// SetMusic enables or disables the music on hold of the class, the default class if empty.
+func (s *AMIAgiSession) SetMusic(ctx context.Context, enabled bool, class string) error {
+	args := []interface{}{map[bool]string{true: "on", false: "off"}[enabled]}
+	if !IsStringEmpty(class) {
+		args = append(args, class)
+	}
+	_, err := s.Execute(ctx, config.AmiAgiCommandSetMusic, args...)
+	return err
+}
+
+// SetPriority
+// SetPriority sets the priority (number or label) to continue on exit of the session.
+func (s *AMIAgiSession) SetPriority(ctx context.Context, priority string) error {
+	_, err := s.Execute(ctx, config.AmiAgiCommandSetPriority, priority)
+	return err
+}
+
+// SetVariable
+// SetVariable sets the channel variable.
+func (s *AMIAgiSession) SetVariable(ctx context.Context, name, value string) error {
+	_, err := s.Execute(ctx, config.AmiAgiCommandSetVariable, name, value)
+	return err
+}
+
+// SpeechActivateGrammar
+// SpeechActivateGrammar activates the grammar of the speech object.
+func (s *AMIAgiSession) SpeechActivateGrammar(ctx context.Context, grammar string) error {
+	_, err := s.Execute(ctx, config.AmiAgiCommandSpeechActivateGrammar, grammar)
+	return err
+}
+
+// SpeechCreate
+// SpeechCreate creates the speech object of the engine.
+func (s *AMIAgiSession) SpeechCreate(ctx context.Context, engine string) error {
+	return s.expect(ctx, 1, config.AmiAgiCommandSpeechCreate, engine)
+}
+
+// SpeechDeactivateGrammar
+// SpeechDeactivateGrammar deactivates the grammar of the speech object.
+func (s *AMIAgiSession) SpeechDeactivateGrammar(ctx context.Context, grammar string) error {
+	_, err := s.Execute(ctx, config.AmiAgiCommandSpeechDeactivateGrammar, grammar)
+	return err
+}
+
+// SpeechDestroy
+// SpeechDestroy destroys the speech object.
+func (s *AMIAgiSession) SpeechDestroy(ctx context.Context) error {
+	_, err := s.Execute(ctx, config.AmiAgiCommandSpeechDestroy)
+	return err
+}
+
+// SpeechLoadGrammar
+// SpeechLoadGrammar loads the grammar of the path with the name.
+func (s *AMIAgiSession) SpeechLoadGrammar(ctx context.Context, grammar, path string) error {
+	_, err := s.Execute(ctx, config.AmiAgiCommandSpeechLoadGrammar, grammar, path)
+	return err
+}
+
+// SpeechRecognize
+// SpeechRecognize plays the prompt and recognizes the speech up to the timeout,
+// the results are in the extra of the reply (i.e: score0, text0, grammar0).
+func (s *AMIAgiSession) SpeechRecognize(ctx context.Context, prompt string, timeout time.Duration, offset int64) (*AMIAgiReply, error) {
+	args := []interface{}{prompt, agiMillis(timeout)}
+	if offset > 0 {
+		args = append(args, offset)
+	}
+	return s.Execute(ctx, config.AmiAgiCommandSpeechRecognize, args...)
+}
+
+// SpeechSet
+// SpeechSet sets the setting of the speech engine.
+func (s *AMIAgiSession) SpeechSet(ctx context.Context, name, value string) error {
+	_, err := s.Execute(ctx, config.AmiAgiCommandSpeechSet, name, value)
+	return err
+}
+
+// SpeechUnloadGrammar
+// SpeechUnloadGrammar unloads the grammar.
+func (s *AMIAgiSession) SpeechUnloadGrammar(ctx context.Context, grammar string) error {
+	_, err := s.Execute(ctx, config.AmiAgiCommandSpeechUnloadGrammar, grammar)
+	return err
+}
+
+// StreamFile
+// StreamFile plays the file (without extension) from the offset, it stops on a digit of the escape digits.
+// The digit pressed is the Digit of the reply and the position where the playback stopped is its EndPos.
+func (s *AMIAgiSession) StreamFile(ctx context.Context, filename, escapeDigits string, offset int64) (*AMIAgiReply, error) {
+	args := []interface{}{filename, escapeDigits}
+	if offset > 0 {
+		args = append(args, offset)
+	}
+	return s.Execute(ctx, config.AmiAgiCommandStreamFile, args...)
+}
+
+// TddMode
+// TddMode toggles the TDD mode of the channel (on, off or mate).
+func (s *AMIAgiSession) TddMode(ctx context.Context, mode string) error {
+	return s.expect(ctx, 1, config.AmiAgiCommandTddMode, mode)
+}
+
+// Verbose
+// Verbose logs the message into the Asterisk verbose log at the level (1 to 4).
+func (s *AMIAgiSession) Verbose(ctx context.Context, message string, level int) error {
+	_, err := s.Execute(ctx, config.AmiAgiCommandVerbose, message, level)
+	return err
+}
+
+// WaitForDigit
+// WaitForDigit waits for a DTMF digit up to the timeout (-1 to block indefinitely), empty on timeout.
+func (s *AMIAgiSession) WaitForDigit(ctx context.Context, timeout time.Duration) (string, error) {
+	reply, err := s.Execute(ctx, config.AmiAgiCommandWaitForDigit, agiMillis(timeout))
+	if err != nil {
+		return "", err
+	}
+	return reply.Digit(), nil
+}
@@ -0,0 +1,522 @@
+package ami
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/pnguyen215/voipkit/pkg/ami/config"
+)
+
+var (
+	// ErrorAriBadRequest the parameters of the ARI request are invalid (400)
+	ErrorAriBadRequest = &AMIAriError{StatusCode: http.StatusBadRequest}
+	// ErrorAriUnauthorized the ARI credentials are rejected (401)
+	ErrorAriUnauthorized = &AMIAriError{StatusCode: http.StatusUnauthorized}
+	// ErrorAriNotFound the channel, bridge, playback, recording or endpoint is not found (404)
+	ErrorAriNotFound = &AMIAriError{StatusCode: http.StatusNotFound}
+	// ErrorAriConflict the channel is not in a Stasis application, or the resource already exists (409)
+	ErrorAriConflict = &AMIAriError{StatusCode: http.StatusConflict}
+	// ErrorAriPreconditionFailed the channel or the bridge is in a state not allowing the operation (412)
+	ErrorAriPreconditionFailed = &AMIAriError{StatusCode: http.StatusPreconditionFailed}
+	// ErrorAriUnprocessable the request body is valid JSON but not processable (422)
+	ErrorAriUnprocessable = &AMIAriError{StatusCode: http.StatusUnprocessableEntity}
+	// ErrorAriServer ARI failed to process the request (500)
+	ErrorAriServer = &AMIAriError{StatusCode: http.StatusInternalServerError}
+)
+
+func (e *AMIAriError) Error() string {
+	return fmt.Sprintf(config.AmiErrorAriRequestFailed, e.Method, e.Path, e.StatusCode, e.Message)
+}
+
+// Is
+// Is matches the ARI errors by their status code, i.e: errors.Is(err, ErrorAriNotFound)
+func (e *AMIAriError) Is(target error) bool {
+	t, ok := target.(*AMIAriError)
+	return ok && t.StatusCode == e.StatusCode
+}
+
+// IsAriNotFound
+// IsAriNotFound returns true if the error is the ARI status 404.
+func IsAriNotFound(err error) bool {
+	return errors.Is(err, ErrorAriNotFound)
+}
+
+// IsAriConflict
+// IsAriConflict returns true if the error is the ARI status 409, i.e: the channel is not in a Stasis application.
+func IsAriConflict(err error) bool {
+	return errors.Is(err, ErrorAriConflict)
+}
+
+// NewAMIAri
+// NewAMIAri creates the ARI client of the Asterisk HTTP server, i.e: http://127.0.0.1:8088
+// The /ari path is added if missing, the user is one of ari.conf.
+func NewAMIAri(baseURL, username, password string) *AMIAri {
+	baseURL = strings.TrimRight(TrimStringSpaces(baseURL), "/")
+	if !strings.HasSuffix(baseURL, config.AmiAriBasePath) {
+		baseURL += config.AmiAriBasePath
+	}
+	a := &AMIAri{BaseURL: baseURL, username: username, password: password}
+	a.restify = NewRestify(baseURL).SetBasicAuth(username, password)
+	return a
+}
+
+// SetApplication
+// SetApplication sets the Stasis application of the client, it is the default app of Originate and Snoop.
+func (a *AMIAri) SetApplication(value string) *AMIAri {
+	a.Application = TrimStringSpaces(value)
+	return a
+}
+
+// Restify
+// Restify returns the HTTP client, i.e: to set the retry condition or the debug mode.
+func (a *AMIAri) Restify() *AmiRestify {
+	return a.restify
+}
+
+// Do
+// Do sends the ARI request, the status codes out of 2xx are returned as *AMIAriError.
+func (a *AMIAri) Do(ctx context.Context, method, path string, query map[string]string, body interface{}, result interface{}) error {
+	err := a.restify.Do(ctx, method, path, query, body, result)
+	var e *AmiRestifyError
+	if !errors.As(err, &e) {
+		return err
+	}
+	message := e.Body
+	var reply struct {
+		Message string `json:"message"`
+		Error   string `json:"error"`
+	}
+	if json.Unmarshal([]byte(e.Body), &reply) == nil {
+		message = reply.Message
+		if IsStringEmpty(message) {
+			message = reply.Error
+		}
+	}
+	return &AMIAriError{Method: method, Path: path, StatusCode: e.StatusCode, Message: message}
+}
+
+// ariQuery converts the request into the query parameters, the variables are sent in the body
+func ariQuery(request interface{}) map[string]string {
+	query := make(map[string]string)
+	data, err := json.Marshal(request)
+	if err != nil {
+		return query
+	}
+	var fields map[string]interface{}
+	json.Unmarshal(data, &fields)
+	for key, value := range fields {
+		if key == "variables" {
+			continue
+		}
+		query[key] = fmt.Sprintf("%v", value)
+	}
+	return query
+}
+
+func ariPath(format string, ids ...string) string {
+	args := make([]interface{}, len(ids))
+	for i, id := range ids {
+		args[i] = url.PathEscape(id)
+	}
+	return fmt.Sprintf(format, args...)
+}
+
+// Info
+// Info returns the build, system, config and status of Asterisk.
+func (a *AMIAri) Info(ctx context.Context) (*AMIAriAsteriskInfo, error) {
+	var info AMIAriAsteriskInfo
+	err := a.Do(ctx, http.MethodGet, "/asterisk/info", nil, nil, &info)
+	return &info, err
+}
+
+// Variable
+// Variable returns the global variable, or the channel variable if the channel id is not empty.
+func (a *AMIAri) Variable(ctx context.Context, channelId, name string) (string, error) {
+	var reply struct {
+		Value string `json:"value"`
+	}
+	path := "/asterisk/variable"
+	if !IsStringEmpty(channelId) {
+		path = ariPath("/channels/%s/variable", channelId)
+	}
+	err := a.Do(ctx, http.MethodGet, path, map[string]string{"variable": name}, nil, &reply)
+	return reply.Value, err
+}
+
+// SetVariable
+// SetVariable sets the global variable, or the channel variable if the channel id is not empty.
+func (a *AMIAri) SetVariable(ctx context.Context, channelId, name, value string) error {
+	path := "/asterisk/variable"
+	if !IsStringEmpty(channelId) {
+		path = ariPath("/channels/%s/variable", channelId)
+	}
+	return a.Do(ctx, http.MethodPost, path, map[string]string{"variable": name, "value": value}, nil, nil)
+}
+
+// Channels
+// Channels returns the active channels.
+func (a *AMIAri) Channels(ctx context.Context) ([]AMIAriChannel, error) {
+	var channels []AMIAriChannel
+	err := a.Do(ctx, http.MethodGet, "/channels", nil, nil, &channels)
+	return channels, err
+}
+
+// Channel
+// Channel returns the channel of the id (the uniqueid).
+func (a *AMIAri) Channel(ctx context.Context, channelId string) (*AMIAriChannel, error) {
+	var channel AMIAriChannel
+	if err := a.Do(ctx, http.MethodGet, ariPath("/channels/%s", channelId), nil, nil, &channel); err != nil {
+		return nil, err
+	}
+	return &channel, nil
+}
+
+// Originate
+// Originate creates the channel to the endpoint, it enters the dialplan or the Stasis application (the client one by default).
+func (a *AMIAri) Originate(ctx context.Context, request AMIAriOriginate) (*AMIAriChannel, error) {
+	if IsStringEmpty(request.Extension) && IsStringEmpty(request.App) {
+		request.App = a.Application
+	}
+	var body interface{}
+	if len(request.Variables) > 0 {
+		body = map[string]interface{}{"variables": request.Variables}
+	}
+	var channel AMIAriChannel
+	if err := a.Do(ctx, http.MethodPost, "/channels", ariQuery(request), body, &channel); err != nil {
+		return nil, err
+	}
+	return &channel, nil
+}
+
+// Hangup
+// Hangup hangs up the channel, the reason is optional (i.e: normal, busy, congestion, no_answer).
+func (a *AMIAri) Hangup(ctx context.Context, channelId, reason string) error {
+	var query map[string]string
+	if !IsStringEmpty(reason) {
+		query = map[string]string{"reason": reason}
+	}
+	return a.Do(ctx, http.MethodDelete, ariPath("/channels/%s", channelId), query, nil, nil)
+}
+
+// Answer
+// Answer answers the channel, it must be in a Stasis application.
+func (a *AMIAri) Answer(ctx context.Context, channelId string) error {
+	return a.Do(ctx, http.MethodPost, ariPath("/channels/%s/answer", channelId), nil, nil, nil)
+}
+
+// Ring
+// Ring indicates ringing to the channel.
+func (a *AMIAri) Ring(ctx context.Context, channelId string) error {
+	return a.Do(ctx, http.MethodPost, ariPath("/channels/%s/ring", channelId), nil, nil, nil)
+}
+
+// Continue
+// Continue exits the channel from the Stasis application to the dialplan, at the current location if empty.
+func (a *AMIAri) Continue(ctx context.Context, channelId, context, extension string, priority int) error {
+	query := make(map[string]string)
+	if !IsStringEmpty(context) {
+		query["context"] = context
+	}
+	if !IsStringEmpty(extension) {
+		query["extension"] = extension
+	}
+	if priority > 0 {
+		query["priority"] = fmt.Sprintf("%v", priority)
+	}
+	return a.Do(ctx, http.MethodPost, ariPath("/channels/%s/continue", channelId), query, nil, nil)
+}
+
+// Redirect
+// Redirect redirects the channel to the endpoint, i.e: PJSIP/1001
+func (a *AMIAri) Redirect(ctx context.Context, channelId, endpoint string) error {
+	return a.Do(ctx, http.MethodPost, ariPath("/channels/%s/redirect", channelId), map[string]string{"endpoint": endpoint}, nil, nil)
+}
+
+// SendDtmf
+// SendDtmf sends the DTMF digits to the channel.
+func (a *AMIAri) SendDtmf(ctx context.Context, channelId, dtmf string) error {
+	return a.Do(ctx, http.MethodPost, ariPath("/channels/%s/dtmf", channelId), map[string]string{"dtmf": dtmf}, nil, nil)
+}
+
+// Hold
+// Hold puts the channel on hold, or takes it off hold if false.
+func (a *AMIAri) Hold(ctx context.Context, channelId string, hold bool) error {
+	method := http.MethodPost
+	if !hold {
+		method = http.MethodDelete
+	}
+	return a.Do(ctx, method, ariPath("/channels/%s/hold", channelId), nil, nil, nil)
+}
+
+// Mute
+// Mute mutes the direction of the channel (in, out or both), or unmutes it if false.
+func (a *AMIAri) Mute(ctx context.Context, channelId, direction string, mute bool) error {
+	method := http.MethodPost
+	if !mute {
+		method = http.MethodDelete
+	}
+	if IsStringEmpty(direction) {
+		direction = config.AmiAriSnoopBoth
+	}
+	return a.Do(ctx, method, ariPath("/channels/%s/mute", channelId), map[string]string{"direction": direction}, nil, nil)
+}
+
+// Snoop
+// Snoop creates the snoop channel of the channel into the Stasis application (the client one by default),
+// spy is the audio heard by the snoop channel and whisper the audio it sends.
+func (a *AMIAri) Snoop(ctx context.Context, channelId string, request AMIAriSnoop) (*AMIAriChannel, error) {
+	if IsStringEmpty(request.App) {
+		request.App = a.Application
+	}
+	if IsStringEmpty(request.Spy) && IsStringEmpty(request.Whisper) {
+		request.Spy = config.AmiAriSnoopBoth
+	}
+	var channel AMIAriChannel
+	if err := a.Do(ctx, http.MethodPost, ariPath("/channels/%s/snoop", channelId), ariQuery(request), nil, &channel); err != nil {
+		return nil, err
+	}
+	return &channel, nil
+}
+
+// Play
+// Play plays the media to the channel (i.e: sound:hello-world, recording:name, tone:ring),
+// the playback id is optional and allows the control of the playback by id.
+func (a *AMIAri) Play(ctx context.Context, channelId, playbackId string, media ...string) (*AMIAriPlayback, error) {
+	return a.play(ctx, "channels", channelId, playbackId, media...)
+}
+
+// PlayOnBridge
+// PlayOnBridge plays the media to the channels of the bridge.
+func (a *AMIAri) PlayOnBridge(ctx context.Context, bridgeId, playbackId string, media ...string) (*AMIAriPlayback, error) {
+	return a.play(ctx, "bridges", bridgeId, playbackId, media...)
+}
+
+func (a *AMIAri) play(ctx context.Context, resource, id, playbackId string, media ...string) (*AMIAriPlayback, error) {
+	query := map[string]string{"media": strings.Join(media, ",")}
+	path := ariPath("/"+resource+"/%s/play", id)
+	if !IsStringEmpty(playbackId) {
+		path = ariPath("/"+resource+"/%s/play/%s", id, playbackId)
+	}
+	var playback AMIAriPlayback
+	if err := a.Do(ctx, http.MethodPost, path, query, nil, &playback); err != nil {
+		return nil, err
+	}
+	return &playback, nil
+}
+
+// Record
+// Record starts the live recording of the channel.
+func (a *AMIAri) Record(ctx context.Context, channelId string, request AMIAriRecord) (*AMIAriLiveRecording, error) {
+	return a.record(ctx, "channels", channelId, request)
+}
+
+// RecordBridge
+// RecordBridge starts the live recording of the bridge.
+func (a *AMIAri) RecordBridge(ctx context.Context, bridgeId string, request AMIAriRecord) (*AMIAriLiveRecording, error) {
+	return a.record(ctx, "bridges", bridgeId, request)
+}
+
+func (a *AMIAri) record(ctx context.Context, resource, id string, request AMIAriRecord) (*AMIAriLiveRecording, error) {
+	if IsStringEmpty(request.Format) {
+		request.Format = "wav"
+	}
+	var recording AMIAriLiveRecording
+	if err := a.Do(ctx, http.MethodPost, ariPath("/"+resource+"/%s/record", id), ariQuery(request), nil, &recording); err != nil {
+		return nil, err
+	}
+	return &recording, nil
+}
+
+// Bridges
+// Bridges returns the active bridges.
+func (a *AMIAri) Bridges(ctx context.Context) ([]AMIAriBridge, error) {
+	var bridges []AMIAriBridge
+	err := a.Do(ctx, http.MethodGet, "/bridges", nil, nil, &bridges)
+	return bridges, err
+}
+
+// Bridge
+// Bridge returns the bridge of the id.
+func (a *AMIAri) Bridge(ctx context.Context, bridgeId string) (*AMIAriBridge, error) {
+	var bridge AMIAriBridge
+	if err := a.Do(ctx, http.MethodGet, ariPath("/bridges/%s", bridgeId), nil, nil, &bridge); err != nil {
+		return nil, err
+	}
+	return &bridge, nil
+}
+
+// CreateBridge
+// CreateBridge creates the bridge of the types (i.e: mixing, holding, dtmf_events), the id and the name are optional.
+func (a *AMIAri) CreateBridge(ctx context.Context, bridgeId, name string, types ...string) (*AMIAriBridge, error) {
+	if len(types) == 0 {
+		types = []string{config.AmiAriBridgeMixing}
+	}
+	query := map[string]string{"type": strings.Join(types, ",")}
+	if !IsStringEmpty(name) {
+		query["name"] = name
+	}
+	path := "/bridges"
+	if !IsStringEmpty(bridgeId) {
+		path = ariPath("/bridges/%s", bridgeId)
+	}
+	var bridge AMIAriBridge
+	if err := a.Do(ctx, http.MethodPost, path, query, nil, &bridge); err != nil {
+		return nil, err
+	}
+	return &bridge, nil
+}
+
+// DestroyBridge
+// DestroyBridge destroys the bridge, its channels are not hung up.
+func (a *AMIAri) DestroyBridge(ctx context.Context, bridgeId string) error {
+	return a.Do(ctx, http.MethodDelete, ariPath("/bridges/%s", bridgeId), nil, nil, nil)
+}
+
+// AddChannel
+// AddChannel adds the channels into the bridge.
+func (a *AMIAri) AddChannel(ctx context.Context, bridgeId string, channelIds ...string) error {
+	query := map[string]string{"channel": strings.Join(channelIds, ",")}
+	return a.Do(ctx, http.MethodPost, ariPath("/bridges/%s/addChannel", bridgeId), query, nil, nil)
+}
+
+// RemoveChannel
+// RemoveChannel removes the channels from the bridge.
+func (a *AMIAri) RemoveChannel(ctx context.Context, bridgeId string, channelIds ...string) error {
+	query := map[string]string{"channel": strings.Join(channelIds, ",")}
+	return a.Do(ctx, http.MethodPost, ariPath("/bridges/%s/removeChannel", bridgeId), query, nil, nil)
+}
+
+// Playback
+// Playback returns the playback of the id.
+func (a *AMIAri) Playback(ctx context.Context, playbackId string) (*AMIAriPlayback, error) {
+	var playback AMIAriPlayback
+	if err := a.Do(ctx, http.MethodGet, ariPath("/playbacks/%s", playbackId), nil, nil, &playback); err != nil {
+		return nil, err
+	}
+	return &playback, nil
+}
+
+// ControlPlayback
+// ControlPlayback controls the playback of the id: restart, pause, unpause, reverse or forward.
+func (a *AMIAri) ControlPlayback(ctx context.Context, playbackId, operation string) error {
+	return a.Do(ctx, http.MethodPost, ariPath("/playbacks/%s/control", playbackId), map[string]string{"operation": operation}, nil, nil)
+}
+
+// StopPlayback
+// StopPlayback stops the playback of the id.
+func (a *AMIAri) StopPlayback(ctx context.Context, playbackId string) error {
+	return a.Do(ctx, http.MethodDelete, ariPath("/playbacks/%s", playbackId), nil, nil, nil)
+}
+
+// LiveRecording
+// LiveRecording returns the live recording of the name.
+func (a *AMIAri) LiveRecording(ctx context.Context, name string) (*AMIAriLiveRecording, error) {
+	var recording AMIAriLiveRecording
+	if err := a.Do(ctx, http.MethodGet, ariPath("/recordings/live/%s", name), nil, nil, &recording); err != nil {
+		return nil, err
+	}
+	return &recording, nil
+}
+
+// StopRecording
+// StopRecording stops the live recording and stores it.
+func (a *AMIAri) StopRecording(ctx context.Context, name string) error {
+	return a.Do(ctx, http.MethodPost, ariPath("/recordings/live/%s/stop", name), nil, nil, nil)
+}
+
+// CancelRecording
+// CancelRecording stops the live recording and discards it.
+func (a *AMIAri) CancelRecording(ctx context.Context, name string) error {
+	return a.Do(ctx, http.MethodDelete, ariPath("/recordings/live/%s", name), nil, nil, nil)
+}
+
+// PauseRecording
+// PauseRecording pauses the live recording, or unpauses it if false.
+func (a *AMIAri) PauseRecording(ctx context.Context, name string, pause bool) error {
+	method := http.MethodPost
+	if !pause {
+		method = http.MethodDelete
+	}
+	return a.Do(ctx, method, ariPath("/recordings/live/%s/pause", name), nil, nil, nil)
+}
+
+// MuteRecording
+// MuteRecording mutes the live recording (silence is recorded), or unmutes it if false.
+func (a *AMIAri) MuteRecording(ctx context.Context, name string, mute bool) error {
+	method := http.MethodPost
+	if !mute {
+		method = http.MethodDelete
+	}
+	return a.Do(ctx, method, ariPath("/recordings/live/%s/mute", name), nil, nil, nil)
+}
+
+// StoredRecordings
+// StoredRecordings returns the stored recordings.
+func (a *AMIAri) StoredRecordings(ctx context.Context) ([]AMIAriStoredRecording, error) {
+	var recordings []AMIAriStoredRecording
+	err := a.Do(ctx, http.MethodGet, "/recordings/stored", nil, nil, &recordings)
+	return recordings, err
+}
+
+// DeleteStoredRecording
+// DeleteStoredRecording deletes the stored recording.
+func (a *AMIAri) DeleteStoredRecording(ctx context.Context, name string) error {
+	return a.Do(ctx, http.MethodDelete, ariPath("/recordings/stored/%s", name), nil, nil, nil)
+}
+
+// Endpoints
+// Endpoints returns the endpoints, of the technology if not empty (i.e: PJSIP).
+func (a *AMIAri) Endpoints(ctx context.Context, technology string) ([]AMIAriEndpoint, error) {
+	path := "/endpoints"
+	if !IsStringEmpty(technology) {
+		path = ariPath("/endpoints/%s", technology)
+	}
+	var endpoints []AMIAriEndpoint
+	err := a.Do(ctx, http.MethodGet, path, nil, nil, &endpoints)
+	return endpoints, err
+}
+
+// Endpoint
+// Endpoint returns the endpoint of the technology and the resource, i.e: PJSIP and 1001
+func (a *AMIAri) Endpoint(ctx context.Context, technology, resource string) (*AMIAriEndpoint, error) {
+	var endpoint AMIAriEndpoint
+	if err := a.Do(ctx, http.MethodGet, ariPath("/endpoints/%s/%s", technology, resource), nil, nil, &endpoint); err != nil {
+		return nil, err
+	}
+	return &endpoint, nil
+}
+
+// DeviceStates
+// DeviceStates returns the device states controlled by ARI (Stasis:<name>).
+func (a *AMIAri) DeviceStates(ctx context.Context) ([]AMIAriDeviceState, error) {
+	var states []AMIAriDeviceState
+	err := a.Do(ctx, http.MethodGet, "/deviceStates", nil, nil, &states)
+	return states, err
+}
+
+// DeviceState
+// DeviceState returns the device state of the name.
+func (a *AMIAri) DeviceState(ctx context.Context, name string) (*AMIAriDeviceState, error) {
+	var state AMIAriDeviceState
+	if err := a.Do(ctx, http.MethodGet, ariPath("/deviceStates/%s", name), nil, nil, &state); err != nil {
+		return nil, err
+	}
+	return &state, nil
+}
+
+// SetDeviceState
+// SetDeviceState sets the device state of the name, i.e: NOT_INUSE, INUSE, BUSY, RINGING, ONHOLD
+func (a *AMIAri) SetDeviceState(ctx context.Context, name, state string) error {
+	return a.Do(ctx, http.MethodPut, ariPath("/deviceStates/%s", name), map[string]string{"deviceState": state}, nil, nil)
+}
+
+// DeleteDeviceState
+// DeleteDeviceState destroys the device state of the name.
+func (a *AMIAri) DeleteDeviceState(ctx context.Context, name string) error {
+	return a.Do(ctx, http.MethodDelete, ariPath("/deviceStates/%s", name), nil, nil, nil)
+}
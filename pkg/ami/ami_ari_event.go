@@ -0,0 +1,212 @@
+package ami
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/pnguyen215/voipkit/pkg/ami/config"
+)
+
+// ParseAriEvent
+// ParseAriEvent parses the JSON event of the ARI WebSocket, the JSON is kept as Raw.
+func ParseAriEvent(data []byte) (*AMIAriEvent, error) {
+	var e AMIAriEvent
+	if err := json.Unmarshal(data, &e); err != nil {
+		return nil, err
+	}
+	e.Raw = append(json.RawMessage(nil), data...)
+	return &e, nil
+}
+
+func (e *AMIAriEvent) Json() string {
+	return JsonString(e)
+}
+
+// ChannelId
+// ChannelId returns the id of the channel of the event, or of the target channel of the playback or the recording.
+func (e *AMIAriEvent) ChannelId() string {
+	if e.Channel != nil {
+		return e.Channel.Id
+	}
+	target := ""
+	if e.Playback != nil {
+		target = e.Playback.TargetUri
+	}
+	if e.Recording != nil {
+		target = e.Recording.TargetUri
+	}
+	if strings.HasPrefix(target, "channel:") {
+		return strings.TrimPrefix(target, "channel:")
+	}
+	return ""
+}
+
+// NewAMIAriEventStream
+// NewAMIAriEventStream creates the event stream of the applications,
+// by default it reconnects after 1 second, doubled up to 30 seconds.
+func NewAMIAriEventStream(ari *AMIAri, applications ...string) *AMIAriEventStream {
+	s := &AMIAriEventStream{ari: ari, Applications: applications}
+	if len(s.Applications) == 0 && !IsStringEmpty(ari.Application) {
+		s.Applications = []string{ari.Application}
+	}
+	s.SetReconnectDelay(time.Second)
+	s.SetMaxReconnectDelay(30 * time.Second)
+	return s
+}
+
+// SetSubscribeAll
+// SetSubscribeAll subscribes the applications to all the events of Asterisk, not only the ones of their channels.
+func (s *AMIAriEventStream) SetSubscribeAll(value bool) *AMIAriEventStream {
+	s.SubscribeAll = value
+	return s
+}
+
+func (s *AMIAriEventStream) SetReconnectDelay(value time.Duration) *AMIAriEventStream {
+	if value > 0 {
+		s.ReconnectDelay = value
+	}
+	return s
+}
+
+func (s *AMIAriEventStream) SetMaxReconnectDelay(value time.Duration) *AMIAriEventStream {
+	if value > 0 {
+		s.MaxReconnectDelay = value
+	}
+	return s
+}
+
+// OnEvent
+// OnEvent registers the callback raised on every event, in the order of the stream.
+func (s *AMIAriEventStream) OnEvent(callback func(AMIAriEvent)) *AMIAriEventStream {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.onEvent = append(s.onEvent, callback)
+	return s
+}
+
+// OnConnect
+// OnConnect registers the callback raised on every (re)connection of the WebSocket.
+func (s *AMIAriEventStream) OnConnect(callback func()) *AMIAriEventStream {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.onConnect = append(s.onConnect, callback)
+	return s
+}
+
+// IsConnected
+// IsConnected returns true if the WebSocket is connected.
+func (s *AMIAriEventStream) IsConnected() bool {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	return s.connected
+}
+
+// URL
+// URL returns the WebSocket URL of the events, i.e: ws://127.0.0.1:8088/ari/events?app=ivr&api_key=user:secret
+func (s *AMIAriEventStream) URL() string {
+	base := s.ari.BaseURL
+	if strings.HasPrefix(base, "https://") {
+		base = "wss://" + strings.TrimPrefix(base, "https://")
+	} else if strings.HasPrefix(base, "http://") {
+		base = "ws://" + strings.TrimPrefix(base, "http://")
+	}
+	query := url.Values{}
+	query.Set("app", strings.Join(s.Applications, ","))
+	query.Set("api_key", s.ari.username+":"+s.ari.password)
+	if s.SubscribeAll {
+		query.Set("subscribeAll", "true")
+	}
+	return base + "/events?" + query.Encode()
+}
+
+// Open
+// Open reads the events until the context is done, the WebSocket is reconnected on error with backoff.
+func (s *AMIAriEventStream) Open(ctx context.Context) {
+	delay := s.ReconnectDelay
+	for ctx.Err() == nil {
+		received, err := s.stream(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+		if received {
+			delay = s.ReconnectDelay
+		}
+		componentLog(config.AmiLogComponentAri).Error("events stream failed, reconnecting", "applications", s.Applications, "delay", delay, config.AmiLogFieldError, err)
+		t := time.NewTimer(delay)
+		select {
+		case <-t.C:
+		case <-ctx.Done():
+			t.Stop()
+			return
+		}
+		if delay *= 2; delay > s.MaxReconnectDelay {
+			delay = s.MaxReconnectDelay
+		}
+	}
+}
+
+func (s *AMIAriEventStream) OpenAsyncFunc(ctx context.Context) {
+	go func() {
+		s.Open(ctx)
+	}()
+}
+
+// stream connects the WebSocket and dispatches its events, it returns true if the connection was established
+func (s *AMIAriEventStream) stream(ctx context.Context) (bool, error) {
+	dial, cancel := context.WithTimeout(ctx, 10*time.Second)
+	ws, err := DialWebSocket(dial, s.URL(), http.Header{})
+	cancel()
+	if err != nil {
+		return false, err
+	}
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			ws.Close()
+		case <-done:
+		}
+	}()
+	defer ws.Close()
+	s.mutex.Lock()
+	s.connected = true
+	s.connections++
+	if s.connections > 1 {
+		Metrics().ObserveReconnect(config.AmiMetricClientAri)
+	}
+	connects := append([]func(){}, s.onConnect...)
+	s.mutex.Unlock()
+	defer func() {
+		s.mutex.Lock()
+		s.connected = false
+		s.mutex.Unlock()
+	}()
+	for _, callback := range connects {
+		callback()
+	}
+	for {
+		opcode, data, err := ws.ReadMessage()
+		if err != nil {
+			return true, err
+		}
+		if opcode != config.AmiWebSocketText {
+			continue
+		}
+		e, err := ParseAriEvent(data)
+		if err != nil {
+			componentLog(config.AmiLogComponentAri).Error("parsing event failed", config.AmiLogFieldError, err)
+			continue
+		}
+		s.mutex.RLock()
+		callbacks := append([]func(AMIAriEvent){}, s.onEvent...)
+		s.mutex.RUnlock()
+		for _, callback := range callbacks {
+			callback(*e)
+		}
+	}
+}
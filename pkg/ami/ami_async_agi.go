@@ -0,0 +1,222 @@
+package ami
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/pnguyen215/voipkit/pkg/ami/config"
+)
+
+// amiAsyncAgiTransport is the transport of the channel running Async AGI,
+// the command is sent by the AGI action and its reply comes back in the AsyncAGIExec event of the command id.
+type amiAsyncAgiTransport struct {
+	agi     *AMIAsyncAgi
+	channel string
+	session *AMIAgiSession
+}
+
+// NewAMIAsyncAgi
+// NewAMIAsyncAgi creates the Async AGI service, the core is the one of the AMI opened if nil.
+func NewAMIAsyncAgi(core *AMICore) *AMIAsyncAgi {
+	a := &AMIAsyncAgi{core: core}
+	a.ctx = context.Background()
+	a.sessions = make(map[string]*AMIAgiSession)
+	a.pending = make(map[string]chan string)
+	return a
+}
+
+// SetHandler
+// SetHandler sets the handler of the sessions, the session returns to the dialplan (ASYNCAGI BREAK) once it is handled.
+func (a *AMIAsyncAgi) SetHandler(handler AmiAgiHandlerFunc) *AMIAsyncAgi {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	a.handler = handler
+	return a
+}
+
+// SetCommandFunc
+// SetCommandFunc sets the function sending the AGI action, AMICore.AGI is used by default.
+func (a *AMIAsyncAgi) SetCommandFunc(value func(ctx context.Context, channel, command, commandId string) (AmiReply, error)) *AMIAsyncAgi {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	a.send = value
+	return a
+}
+
+// Session
+// Session returns the running session of the channel.
+func (a *AMIAsyncAgi) Session(channel string) (*AMIAgiSession, bool) {
+	a.mutex.RLock()
+	defer a.mutex.RUnlock()
+	s, ok := a.sessions[channel]
+	return s, ok
+}
+
+// Len
+// Len returns the number of running sessions.
+func (a *AMIAsyncAgi) Len() int {
+	a.mutex.RLock()
+	defer a.mutex.RUnlock()
+	return len(a.sessions)
+}
+
+// Apply
+// Apply starts the session on AsyncAGIStart, delivers the reply of the command on AsyncAGIExec
+// and ends the session on AsyncAGIEnd.
+func (a *AMIAsyncAgi) Apply(e *AMIMessage) {
+	if e == nil {
+		return
+	}
+	event := e.Field(config.AmiEventKey)
+	channel := e.Field(config.AmiFieldChannel)
+	switch {
+	case strings.EqualFold(event, config.AmiListenerEventAsyncAGIStart):
+		a.start(channel, e.Field(config.AmiFieldEnv))
+	case strings.EqualFold(event, config.AmiListenerEventAsyncAGIExec):
+		id := e.Field(config.AmiFieldCommandID)
+		result, err := url.PathUnescape(e.Field(config.AmiFieldResult))
+		if err != nil {
+			result = e.Field(config.AmiFieldResult)
+		}
+		a.mutex.Lock()
+		reply, ok := a.pending[id]
+		delete(a.pending, id)
+		a.mutex.Unlock()
+		if ok {
+			reply <- result
+		}
+	case strings.EqualFold(event, config.AmiListenerEventAsyncAGIEnd):
+		a.mutex.Lock()
+		s, ok := a.sessions[channel]
+		delete(a.sessions, channel)
+		a.mutex.Unlock()
+		if ok {
+			s.hangup()
+		}
+	}
+}
+
+// Open
+// Open runs the sessions of the Async AGI events until the AMI context is done.
+func (a *AMIAsyncAgi) Open(c *AMI) {
+	events := []string{config.AmiListenerEventAsyncAGIStart, config.AmiListenerEventAsyncAGIExec, config.AmiListenerEventAsyncAGIEnd}
+	event := c.OnEvents(events...)
+	defer c.Unsubscribes(event, events...)
+	ctx := c.Context()
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	a.mutex.Lock()
+	a.ctx = ctx
+	if a.core == nil {
+		a.core = c.Core()
+	}
+	a.mutex.Unlock()
+	for {
+		select {
+		case message, ok := <-event:
+			if !ok {
+				return
+			}
+			a.Apply(message)
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (a *AMIAsyncAgi) OpenAsyncFunc(c *AMI) {
+	go func() {
+		a.Open(c)
+	}()
+}
+
+// start creates the session of the channel from the URL-encoded environment, then runs the handler
+func (a *AMIAsyncAgi) start(channel, encoded string) {
+	decoded, err := url.PathUnescape(encoded)
+	if err != nil {
+		decoded = encoded
+	}
+	env, err := ReadAgiEnv(bufio.NewReader(strings.NewReader(decoded)))
+	if err != nil && len(env) == 0 {
+		componentLog(config.AmiLogComponentAgi).Error("reading AGI environment failed", config.AmiLogFieldChannel, channel, config.AmiLogFieldError, err)
+		return
+	}
+	if IsStringEmpty(channel) {
+		channel = env[config.AmiAgiChannelKey]
+	}
+	transport := &amiAsyncAgiTransport{agi: a, channel: channel}
+	a.mutex.Lock()
+	s := NewAMIAgiSession(a.ctx, env, transport)
+	transport.session = s
+	previous, ok := a.sessions[channel]
+	a.sessions[channel] = s
+	handler := a.handler
+	a.mutex.Unlock()
+	if ok {
+		previous.cancel()
+	}
+	if handler == nil {
+		return
+	}
+	go func() {
+		defer func() {
+			if r := recover(); r != nil {
+				componentLog(config.AmiLogComponentAgi).Error("handler panicked", config.AmiLogFieldChannel, channel, "panic", r)
+			}
+		}()
+		if err := handler(s.Context(), s); err != nil && s.Context().Err() == nil {
+			componentLog(config.AmiLogComponentAgi).Error("handler failed", config.AmiLogFieldChannel, channel, config.AmiLogFieldError, err)
+		}
+		if s.Context().Err() == nil {
+			if err := s.AsyncAgiBreak(s.Context()); err != nil {
+				componentLog(config.AmiLogComponentAgi).Error("breaking channel failed", config.AmiLogFieldChannel, channel, config.AmiLogFieldError, err)
+			}
+		}
+	}()
+}
+
+// Command sends the command by the AGI action with a new command id, and waits for its AsyncAGIExec
+func (t *amiAsyncAgiTransport) Command(ctx context.Context, command string) (*AMIAgiReply, error) {
+	a := t.agi
+	id := GenUUIDShorten()
+	reply := make(chan string, 1)
+	a.mutex.Lock()
+	a.pending[id] = reply
+	send, core := a.send, a.core
+	a.mutex.Unlock()
+	forget := func() {
+		a.mutex.Lock()
+		delete(a.pending, id)
+		a.mutex.Unlock()
+	}
+	if send == nil {
+		if core == nil {
+			forget()
+			return nil, fmt.Errorf(config.AmiErrorCoreRequired, config.AmiActionAgi)
+		}
+		send = core.AGI
+	}
+	response, err := send(ctx, t.channel, command, id)
+	if err != nil {
+		forget()
+		return nil, err
+	}
+	if IsFailure(response) {
+		forget()
+		return nil, fmt.Errorf(config.AmiErrorActionFailed, config.AmiActionAgi, JsonString(response))
+	}
+	select {
+	case line := <-reply:
+		return ParseAgiReply(line)
+	case <-ctx.Done():
+		forget()
+		return nil, ctx.Err()
+	case <-t.session.Context().Done():
+		forget()
+		return nil, fmt.Errorf(config.AmiErrorAgiSessionClosed)
+	}
+}
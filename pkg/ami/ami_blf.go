@@ -0,0 +1,412 @@
+package ami
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pnguyen215/voipkit/pkg/ami/config"
+)
+
+func NewAMIPresenceService() *AMIPresenceService {
+	p := &AMIPresenceService{}
+	p.extensions = make(map[string]*AMIExtensionPresence)
+	p.devices = make(map[string]string)
+	p.presences = make(map[string]amiPresenceState)
+	p.SetDndDevicePrefix("Custom:DND")
+	return p
+}
+
+// SetDndDevicePrefix
+// SetDndDevicePrefix sets the prefix of custom devices toggled by the do-not-disturb feature code,
+// i.e: FreePBX sets Custom:DND1010 to BUSY while extension 1010 is on DND.
+// Those devices are read as DND flag instead of call state. An empty value disables it.
+func (p *AMIPresenceService) SetDndDevicePrefix(value string) *AMIPresenceService {
+	p.DndDevicePrefix = TrimStringSpaces(value)
+	return p
+}
+
+// SetContexts
+// SetContexts restricts the service to the hints of the given dialplan contexts.
+func (p *AMIPresenceService) SetContexts(values []string) *AMIPresenceService {
+	p.Contexts = values
+	return p
+}
+
+func (p *AMIPresenceService) AppendContexts(values ...string) *AMIPresenceService {
+	p.Contexts = append(p.Contexts, values...)
+	return p
+}
+
+// Subscribe
+// Subscribe registers a callback which is invoked only when the effective status
+// (or the presence message) of an extension changes.
+func (p *AMIPresenceService) Subscribe(callback func(AMIPresenceChange)) *AMIPresenceService {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.subscribers = append(p.subscribers, callback)
+	return p
+}
+
+func (p *AMIPresenceService) Json() string {
+	return JsonString(p.Snapshot())
+}
+
+// Seed
+// Seed initializes extensions from a one-shot hint listing, i.e: ExtensionStatesMap.
+// Seeding does not notify subscribers.
+func (p *AMIPresenceService) Seed(extensions []AMIExtensionStatus) *AMIPresenceService {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	for _, v := range extensions {
+		p.applyExtensionState(v.Extension, v.Context, v.Hint, v.Status, v.StatusText, time.Now())
+	}
+	return p
+}
+
+// SeedPresences
+// SeedPresences initializes presence states from a one-shot listing, i.e: PresenceStateList.
+// Seeding does not notify subscribers.
+func (p *AMIPresenceService) SeedPresences(presences []AmiReply) *AMIPresenceService {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	for _, v := range presences {
+		p.applyPresenceState(
+			v.GetOrFallback(config.AmiJsonFieldPresentity, config.AmiFieldPresentity),
+			v.GetOrFallback(config.AmiJsonFieldStatus, config.AmiFieldStatus),
+			v.GetOrFallback(config.AmiJsonFieldSubtype, config.AmiFieldSubtype),
+			v.GetOrFallback(config.AmiJsonFieldMessage, config.AmiFieldMessage),
+			time.Now())
+	}
+	return p
+}
+
+// SeedWith
+// SeedWith loads hints and presence states once from the Asterisk server.
+// The presence states are optional, since res_presencestate might not be loaded.
+func (p *AMIPresenceService) SeedWith(ctx context.Context, c *AMICore, guard *AMIExtensionGuard) error {
+	if guard == nil {
+		guard = NewAMIExtensionGuard()
+	}
+	if len(guard.Context) == 0 && len(p.Contexts) > 0 {
+		guard.Context = p.Contexts
+	}
+	extensions, err := c.ExtensionStatesMap(ctx, guard)
+	if err != nil {
+		return err
+	}
+	p.Seed(extensions)
+	presences, err := c.PresenceStateList(ctx)
+	if err != nil {
+		componentLog(config.AmiLogComponentPresence).Warn("presence states unavailable", config.AmiLogFieldError, err)
+		return nil
+	}
+	p.SeedPresences(presences)
+	return nil
+}
+
+// Apply
+// Apply feeds an ExtensionStatus, DeviceStateChange or PresenceStateChange event into the service.
+// It returns the changes of effective status which have been notified to subscribers.
+func (p *AMIPresenceService) Apply(e *AMIMessage) []AMIPresenceChange {
+	if e == nil {
+		return nil
+	}
+	event := e.Field(config.AmiEventKey)
+	now := time.Now()
+	var changes []AMIPresenceChange
+	p.mutex.Lock()
+	switch {
+	case strings.EqualFold(event, config.AmiListenerEventExtensionStatus):
+		status, err := strconv.Atoi(e.Field(config.AmiFieldStatus))
+		if err != nil {
+			status = config.AmiExtensionNotInUse
+		}
+		changes = p.applyExtensionState(
+			e.Field(config.AmiFieldExtension),
+			e.Field(config.AmiFieldContext),
+			e.Field(config.AmiFieldHint),
+			status,
+			e.Field(config.AmiFieldStatusText),
+			now)
+	case strings.EqualFold(event, config.AmiListenerEventDeviceStateChange):
+		changes = p.applyDeviceState(e.Field(config.AmiFieldDevice), e.Field(config.AmiFieldState), now)
+	case strings.EqualFold(event, config.AmiListenerEventPresenceStateChange):
+		changes = p.applyPresenceState(
+			e.Field(config.AmiFieldPresentity),
+			e.Field(config.AmiFieldStatus),
+			e.Field(config.AmiFieldSubtype),
+			e.Field(config.AmiFieldMessage),
+			now)
+	}
+	subscribers := p.subscribers
+	p.mutex.Unlock()
+	for _, change := range changes {
+		for _, fn := range subscribers {
+			fn(change)
+		}
+	}
+	return changes
+}
+
+// Status
+// Status returns the merged presence of the extension in the given context.
+func (p *AMIPresenceService) Status(exten, context string) (AMIExtensionPresence, bool) {
+	p.mutex.RLock()
+	defer p.mutex.RUnlock()
+	v, ok := p.extensions[p.key(exten, context)]
+	if !ok {
+		return AMIExtensionPresence{}, false
+	}
+	return v.clone(), true
+}
+
+// Snapshot
+// Snapshot returns the merged presence of all extensions, ordered by context and extension.
+func (p *AMIPresenceService) Snapshot() []AMIExtensionPresence {
+	p.mutex.RLock()
+	defer p.mutex.RUnlock()
+	response := make([]AMIExtensionPresence, 0, len(p.extensions))
+	for _, v := range p.extensions {
+		response = append(response, v.clone())
+	}
+	sort.Slice(response, func(i, j int) bool {
+		if response[i].Context != response[j].Context {
+			return response[i].Context < response[j].Context
+		}
+		return response[i].Extension < response[j].Extension
+	})
+	return response
+}
+
+// Open
+// Open consumes ExtensionStatus, DeviceStateChange and PresenceStateChange events until the AMI connection is closed.
+func (p *AMIPresenceService) Open(c *AMI) {
+	events := []string{config.AmiListenerEventExtensionStatus, config.AmiListenerEventDeviceStateChange, config.AmiListenerEventPresenceStateChange}
+	event := c.OnEvents(events...)
+	defer c.Unsubscribes(event, events...)
+	ctx := c.Context()
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	for {
+		select {
+		case message, ok := <-event:
+			if !ok {
+				return
+			}
+			p.Apply(message)
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (p *AMIPresenceService) OpenAsyncFunc(c *AMI) {
+	go func() {
+		p.Open(c)
+	}()
+}
+
+func (p *AMIPresenceService) key(exten, context string) string {
+	return fmt.Sprintf("%s@%s", TrimStringSpaces(exten), TrimStringSpaces(context))
+}
+
+func (p *AMIPresenceService) isDndDevice(device string) bool {
+	return !IsStringEmpty(p.DndDevicePrefix) && strings.HasPrefix(strings.ToLower(device), strings.ToLower(p.DndDevicePrefix))
+}
+
+func (p *AMIPresenceService) applyExtensionState(exten, context, hint string, status int, text string, at time.Time) []AMIPresenceChange {
+	if IsStringEmpty(exten) {
+		return nil
+	}
+	if len(p.Contexts) > 0 && !Contains(p.Contexts, context) {
+		return nil
+	}
+	key := p.key(exten, context)
+	v, ok := p.extensions[key]
+	if !ok {
+		v = &AMIExtensionPresence{Extension: exten, Context: context, DeviceStates: make(map[string]string)}
+		p.extensions[key] = v
+	}
+	if !IsStringEmpty(hint) {
+		v.Hint = hint
+	}
+	v.ExtensionState = status
+	v.ExtensionStateText = text
+	return p.merge(v, at)
+}
+
+func (p *AMIPresenceService) applyDeviceState(device, state string, at time.Time) []AMIPresenceChange {
+	if IsStringEmpty(device) {
+		return nil
+	}
+	p.devices[strings.ToLower(device)] = strings.ToUpper(TrimStringSpaces(state))
+	var changes []AMIPresenceChange
+	for _, v := range p.extensions {
+		devices, _ := splitHint(v.Hint)
+		if !containsFold(devices, device) {
+			continue
+		}
+		changes = append(changes, p.merge(v, at)...)
+	}
+	return changes
+}
+
+func (p *AMIPresenceService) applyPresenceState(presentity, state, subtype, message string, at time.Time) []AMIPresenceChange {
+	if IsStringEmpty(presentity) {
+		return nil
+	}
+	p.presences[strings.ToLower(presentity)] = amiPresenceState{
+		state:   strings.ToLower(TrimStringSpaces(state)),
+		subtype: subtype,
+		message: message,
+	}
+	var changes []AMIPresenceChange
+	for _, v := range p.extensions {
+		_, presentities := splitHint(v.Hint)
+		if !containsFold(presentities, presentity) {
+			continue
+		}
+		changes = append(changes, p.merge(v, at)...)
+	}
+	return changes
+}
+
+// merge recomputes the effective status of the extension from its extension state,
+// the device states of its hint and the presence state, and reports whether it changed.
+func (p *AMIPresenceService) merge(v *AMIExtensionPresence, at time.Time) []AMIPresenceChange {
+	previous := v.clone()
+	devices, presentities := splitHint(v.Hint)
+
+	v.Dnd = false
+	v.DeviceStates = make(map[string]string)
+	state, known := config.AmiExtensionNotInUse, 0
+	unavailable := 0
+	conf := NewAMIConf()
+	for _, device := range devices {
+		s, ok := p.devices[strings.ToLower(device)]
+		if !ok {
+			continue
+		}
+		v.DeviceStates[device] = s
+		if p.isDndDevice(device) {
+			v.Dnd = v.Dnd || s == config.AmiDeviceStateBusyString || s == config.AmiDeviceStateInUseString
+			continue
+		}
+		ext := conf.ConvDeviceStateToExtensionState(config.AmiDeviceStatesCode[s])
+		if ext == config.AmiExtensionUnavailable {
+			unavailable++
+		} else {
+			state |= ext
+		}
+		known++
+	}
+	callDevices := 0
+	for _, device := range devices {
+		if !p.isDndDevice(device) {
+			callDevices++
+		}
+	}
+	// fall back to the aggregated hint state reported by Asterisk unless every device of the hint is known
+	if callDevices == 0 || known < callDevices {
+		state = v.ExtensionState
+		// the dnd device is part of the hint, so Asterisk reports the hint busy while nobody is on the phone
+		if v.Dnd && state == config.AmiExtensionBusy {
+			state = config.AmiExtensionNotInUse
+		}
+	} else if unavailable == known {
+		state = config.AmiExtensionUnavailable
+	}
+
+	v.Presence, v.PresenceSubtype, v.Message = "", "", ""
+	for _, presentity := range presentities {
+		ps, ok := p.presences[strings.ToLower(presentity)]
+		if !ok {
+			continue
+		}
+		v.Presence, v.PresenceSubtype, v.Message = ps.state, ps.subtype, ps.message
+		if ps.state == config.AmiPresenceStateDnd {
+			v.Dnd = true
+		}
+		break
+	}
+
+	switch {
+	case state < 0:
+		v.Status = config.AmiBlfStatusUnavailable
+	case state&config.AmiExtensionRinging != 0:
+		v.Status = config.AmiBlfStatusRinging
+	case state&config.AmiExtensionOnHold != 0:
+		v.Status = config.AmiBlfStatusHold
+	case state&(config.AmiExtensionInUse|config.AmiExtensionBusy) != 0:
+		v.Status = config.AmiBlfStatusInUse
+	case state&config.AmiExtensionUnavailable != 0:
+		v.Status = config.AmiBlfStatusUnavailable
+	case v.Dnd:
+		v.Status = config.AmiBlfStatusDnd
+	case v.Presence == config.AmiPresenceStateAway ||
+		v.Presence == config.AmiPresenceStateXa ||
+		v.Presence == config.AmiPresenceStateUnavailable:
+		v.Status = config.AmiBlfStatusAway
+	default:
+		v.Status = config.AmiBlfStatusIdle
+	}
+
+	if previous.Status == v.Status && previous.Message == v.Message {
+		return nil
+	}
+	v.UpdatedAt = at
+	return []AMIPresenceChange{{Previous: previous, Current: v.clone()}}
+}
+
+func (v *AMIExtensionPresence) Json() string {
+	return JsonString(v)
+}
+
+func (v *AMIExtensionPresence) clone() AMIExtensionPresence {
+	c := *v
+	c.DeviceStates = make(map[string]string, len(v.DeviceStates))
+	for k, s := range v.DeviceStates {
+		c.DeviceStates[k] = s
+	}
+	return c
+}
+
+func (c *AMIPresenceChange) Json() string {
+	return JsonString(c)
+}
+
+// splitHint splits a hint, i.e: SIP/1010&Custom:DND1010,CustomPresence:1010
+// into its devices and presence providers.
+func splitHint(hint string) (devices []string, presentities []string) {
+	if IsStringEmpty(hint) {
+		return nil, nil
+	}
+	parts := strings.SplitN(hint, ",", 2)
+	for _, d := range strings.Split(parts[0], "&") {
+		if d = TrimStringSpaces(d); !IsStringEmpty(d) {
+			devices = append(devices, d)
+		}
+	}
+	if len(parts) > 1 {
+		for _, s := range strings.Split(parts[1], "&") {
+			if s = TrimStringSpaces(s); !IsStringEmpty(s) {
+				presentities = append(presentities, s)
+			}
+		}
+	}
+	return devices, presentities
+}
+
+func containsFold(values []string, value string) bool {
+	for _, v := range values {
+		if strings.EqualFold(v, value) {
+			return true
+		}
+	}
+	return false
+}
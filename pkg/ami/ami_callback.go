@@ -96,13 +96,13 @@ func (h *AMICallbackHandler) Send() (AmiReply, error) {
 		}
 		if len(response) > 0 && err == nil {
 			if h.socket.DebugMode {
-				D().Info("Send(). callback return for the %v time(s) and waste time: %v", i, _end)
+				h.socket.Logger(config.AmiLogComponentCommand).Debug("callback returned", config.AmiLogFieldAction, h.command.Action, config.AmiLogFieldActionId, h.command.ID, "attempt", i, "elapsed", _end)
 			}
 			break
 		}
 	}
 	if h.socket.DebugMode {
-		D().Info("Send(). callback total waste time: %v", total)
+		h.socket.Logger(config.AmiLogComponentCommand).Debug("callback completed", config.AmiLogFieldAction, h.command.Action, config.AmiLogFieldActionId, h.command.ID, "elapsed", total)
 	}
 	return response, err
 }
@@ -130,13 +130,13 @@ func (h *AMICallbackHandler) SendLevel() (AmiReplies, error) {
 		}
 		if len(response) > 0 && err == nil {
 			if h.socket.DebugMode {
-				D().Info("SendLevel(). callback return for the %v time(s) and waste time: %v", i, _end)
+				h.socket.Logger(config.AmiLogComponentCommand).Debug("callback returned", config.AmiLogFieldAction, h.command.Action, config.AmiLogFieldActionId, h.command.ID, "attempt", i, "elapsed", _end)
 			}
 			break
 		}
 	}
 	if h.socket.DebugMode {
-		D().Info("SendLevel(). callback total waste time: %v", total)
+		h.socket.Logger(config.AmiLogComponentCommand).Debug("callback completed", config.AmiLogFieldAction, h.command.Action, config.AmiLogFieldActionId, h.command.ID, "elapsed", total)
 	}
 	return response, err
 }
@@ -164,13 +164,13 @@ func (h *AMICallbackHandler) SendSuperLevel() ([]AmiReply, error) {
 		}
 		if len(response) > 0 && err == nil {
 			if h.socket.DebugMode {
-				D().Info("SendSuperLevel(). callback return for the %v time(s) and waste time: %v", i, _end)
+				h.socket.Logger(config.AmiLogComponentCommand).Debug("callback returned", config.AmiLogFieldAction, h.command.Action, config.AmiLogFieldActionId, h.command.ID, "attempt", i, "elapsed", _end)
 			}
 			break
 		}
 	}
 	if h.socket.DebugMode {
-		D().Info("SendSuperLevel(). callback total waste time: %v", total)
+		h.socket.Logger(config.AmiLogComponentCommand).Debug("callback completed", config.AmiLogFieldAction, h.command.Action, config.AmiLogFieldActionId, h.command.ID, "elapsed", total)
 	}
 	return response, err
 }
@@ -174,6 +174,11 @@ func (r *AMICdr) SetUniqueId(value string) *AMICdr {
 	return r
 }
 
+func (r *AMICdr) SetLinkedId(value string) *AMICdr {
+	r.LinkedId = value
+	return r
+}
+
 func (r *AMICdr) SetUserField(value string) *AMICdr {
 	r.UserField = value
 	return r
@@ -232,10 +237,114 @@ func (r *AMICdr) SetMediaLink(value string) *AMICdr {
 	return r
 }
 
+func (r *AMICdr) SetCost(value float64) *AMICdr {
+	r.Cost = value
+	return r
+}
+
+func (r *AMICdr) SetCurrency(value string) *AMICdr {
+	r.Currency = TrimStringSpaces(value)
+	return r
+}
+
 func (r *AMICdr) Json() string {
 	return JsonString(r)
 }
 
+// Value
+// Value returns the typed value of the CDR field by its json key (i.e: start_time, duration, channel).
+// The zero times are returned as nil.
+func (r *AMICdr) Value(key string) (interface{}, bool) {
+	at := func(t time.Time) interface{} {
+		if t.IsZero() {
+			return nil
+		}
+		return t
+	}
+	switch strings.ToLower(key) {
+	case config.AmiCdrFieldEvent:
+		return r.Event, true
+	case config.AmiCdrFieldAccountCode:
+		return r.AccountCode, true
+	case config.AmiCdrFieldSource:
+		return r.Source, true
+	case config.AmiCdrFieldDestination:
+		return r.Destination, true
+	case config.AmiCdrFieldDestinationContext:
+		return r.DestinationContext, true
+	case config.AmiCdrFieldCallerId:
+		return r.CallerId, true
+	case config.AmiCdrFieldChannel:
+		return r.Channel, true
+	case config.AmiCdrFieldDestinationChannel:
+		return r.DestinationChannel, true
+	case config.AmiCdrFieldLastApplication:
+		return r.LastApplication, true
+	case config.AmiCdrFieldLastData:
+		return r.LastData, true
+	case config.AmiCdrFieldStartTime:
+		return at(r.StartTime), true
+	case config.AmiCdrFieldAnswerTime:
+		return at(r.AnswerTime), true
+	case config.AmiCdrFieldEndTime:
+		return at(r.EndTime), true
+	case config.AmiCdrFieldDuration:
+		return r.Duration, true
+	case config.AmiCdrFieldBillableSeconds:
+		return r.BillableSeconds, true
+	case config.AmiCdrFieldDisposition:
+		return r.Disposition, true
+	case config.AmiCdrFieldAmaFlags:
+		return r.AmaFlags, true
+	case config.AmiCdrFieldUniqueId:
+		return r.UniqueId, true
+	case config.AmiCdrFieldLinkedId:
+		return r.LinkedId, true
+	case config.AmiCdrFieldUserField:
+		return r.UserField, true
+	case config.AmiCdrFieldDateReceivedAt:
+		return at(r.DateReceivedAt), true
+	case config.AmiCdrFieldPrivilege:
+		return r.Privilege, true
+	case config.AmiCdrFieldDirection:
+		return r.Direction, true
+	case config.AmiCdrFieldDesc:
+		return r.Desc, true
+	case config.AmiCdrFieldType:
+		return r.Type, true
+	case config.AmiCdrFieldExtension:
+		return r.Extension, true
+	case config.AmiCdrFieldNumber:
+		return r.Number, true
+	case config.AmiCdrFieldMediaLink:
+		return r.MediaLink, true
+	case config.AmiCdrFieldCost:
+		return r.Cost, true
+	case config.AmiCdrFieldCurrency:
+		return r.Currency, true
+	}
+	return nil, false
+}
+
+// Text
+// Text returns the value of the CDR field as text, times are formatted as 2006-01-02 15:04:05.
+func (r *AMICdr) Text(key string) string {
+	v, _ := r.Value(key)
+	switch value := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return value
+	case int:
+		return strconv.Itoa(value)
+	case time.Time:
+		return value.Format(config.DateTimeFormat20060102150405)
+	case float64:
+		return strconv.FormatFloat(value, 'f', -1, 64)
+	}
+	return fmt.Sprintf("%v", v)
+}
+
 func (r *AMICdr) IsCdrNoAnswer() bool {
 	_, ok := config.AmiCdrDispositionText[r.Disposition]
 	if !ok {
@@ -309,10 +418,30 @@ func (r *AMICdr) IsCdrOutboundNormal() bool {
 }
 
 func (r *AMICdr) IsCdrOutboundChanSpy() bool {
-	return strings.EqualFold(r.Type, config.AmiLastApplicationChanSpy)
+	return strings.EqualFold(r.Type, config.AmiTypeChanSpyDirection)
+}
+
+func (r *AMICdr) IsCdrInternal() bool {
+	return strings.EqualFold(r.Direction, config.AmiInternalDirection)
+}
+
+func (r *AMICdr) IsCdrTransfer() bool {
+	return strings.EqualFold(r.Direction, config.AmiTransferDirection)
+}
+
+func (r *AMICdr) IsCdrSpy() bool {
+	return strings.EqualFold(r.Direction, config.AmiSpyDirection)
 }
 
 func ParseCdr(e *AMIMessage, d *AMIDictionary) *AMICdr {
+	return ParseCdrWith(e, d, nil)
+}
+
+// ParseCdrWith
+// ParseCdrWith parses the CDR event and classifies it by the classifier.
+// If the classifier is nil, the legacy rules are used. If the classifier has no regions (phone prefix),
+// the region (phone prefix) of the message is used.
+func ParseCdrWith(e *AMIMessage, d *AMIDictionary, c *AMICdrClassifier) *AMICdr {
 	if d == nil {
 		d = NewDictionary()
 	}
@@ -339,53 +468,22 @@ func ParseCdr(e *AMIMessage, d *AMIDictionary) *AMICdr {
 		SetSource(e.FieldDictionaryOrRefer(d, config.AmiJsonFieldSource, "Source")).
 		SetStartTimeWith(e.FieldDictionaryOrRefer(d, config.AmiJsonFieldStartTime, "StartTime")).
 		SetUniqueId(e.FieldDictionaryOrRefer(d, config.AmiJsonFieldUniqueId, "UniqueID")).
+		SetLinkedId(e.FieldDictionaryOrRefer(d, config.AmiJsonFieldLinkedId, "LinkedID")).
 		SetUserField(e.FieldDictionaryOrRefer(d, config.AmiJsonFieldUserField, "UserField"))
 
-	// detect outbound, inbound
-	// if the field destination is phone number, so mark this cdr belong to outbound, otherwise mark as inbound
-	form := "CDR.call_from_'%v'_to_'%v'"
-	phone := RemoveStringPrefix(r.Destination, e.PhonePrefix...)
-	if VerifyPhoneNo(phone, e.Region) {
-		flow := fmt.Sprintf(form, r.Channel, phone)
-		r.SetDesc(flow)
-		r.SetDirection(config.AmiOutboundDirection)
-		r.SetType(config.AmiTypeOutboundNormalDirection)
-		r.SetExtension(strings.Split(r.Channel, r.symbol)[0])
-		r.SetNumber(phone)
-	} else {
-		var inCase bool = false
-		// from outbound chan-spy
-		if strings.EqualFold(r.LastApplication, config.AmiLastApplicationChanSpy) {
-			inCase = true
-			flow := fmt.Sprintf(form, r.Channel, r.LastData)
-			r.SetDesc(flow)
-			r.SetType(config.AmiTypeChanSpyDirection)
-			r.SetDirection(config.AmiOutboundDirection)
-			r.SetExtension(strings.Split(r.Channel, r.symbol)[0])
-		}
-		// from inbound dial
-		if strings.EqualFold(r.LastApplication, config.AmiLastApplicationDial) {
-			inCase = true
-			flow := fmt.Sprintf(form, r.Source, r.DestinationChannel)
-			r.SetDesc(flow)
-			r.SetDirection(config.AmiInboundDirection)
-			r.SetType(config.AmiTypeInboundDialDirection)
-			r.SetExtension(strings.Split(r.DestinationChannel, r.symbol)[0])
-			r.SetNumber(r.Source)
-		}
-		// from inbound queue
-		if strings.EqualFold(r.LastApplication, config.AmiLastApplicationQueue) {
-			inCase = true
-			flow := fmt.Sprintf(form, r.Source, r.Channel)
-			r.SetDesc(flow)
-			r.SetDirection(config.AmiInboundDirection)
-			r.SetType(config.AmiTypeInboundQueueDirection)
-			r.SetExtension(strings.Split(r.Channel, r.symbol)[0])
-			r.SetNumber(r.Source)
-		}
-		if !inCase {
-			D().Error("ParseCdr, CDR got an error exception case:: %v", JsonString(r))
-		}
+	// detect the direction and the type by the rules, the first matching rule wins
+	if c == nil {
+		c = defaultCdrClassifier
+	}
+	regions, prefixes := c.Regions, c.PhonePrefix
+	if len(regions) == 0 && !IsStringEmpty(e.Region) {
+		regions = []string{e.Region}
+	}
+	if len(prefixes) == 0 {
+		prefixes = e.PhonePrefix
+	}
+	if !c.classify(r, regions, prefixes) {
+		componentLog(config.AmiLogComponentCdr).Warn("cdr not classified", config.AmiLogFieldUniqueId, r.UniqueId, "cdr", r)
 	}
 	return r
 }
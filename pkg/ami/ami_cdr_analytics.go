@@ -0,0 +1,363 @@
+package ami
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pnguyen215/voipkit/pkg/ami/config"
+)
+
+// cdrGroups are the groups the statistics are aggregated by
+var cdrGroups = []string{
+	config.AmiCdrGroupAll,
+	config.AmiCdrGroupExtension,
+	config.AmiCdrGroupTrunk,
+	config.AmiCdrGroupDirection,
+	config.AmiCdrGroupDID,
+}
+
+// NewAMICdrAnalytics
+// NewAMICdrAnalytics creates the aggregator, it implements AmiCdrSink so that it can be fed by AMICdrWriter.
+func NewAMICdrAnalytics() *AMICdrAnalytics {
+	a := &AMICdrAnalytics{}
+	a.SetRetention(31 * 24 * time.Hour)
+	a.SetSymbol("-")
+	return a
+}
+
+func (a *AMICdrAnalytics) SetRetention(value time.Duration) *AMICdrAnalytics {
+	a.Retention = value
+	return a
+}
+
+func (a *AMICdrAnalytics) SetSymbol(value string) *AMICdrAnalytics {
+	a.Symbol = TrimStringSpaces(value)
+	return a
+}
+
+// SetTrunkFunc
+// SetTrunkFunc sets the resolver of the trunk of the record, it applies to the records added afterwards.
+// By default, the trunk is the endpoint of party A for the inbound calls, and of party B for the outbound calls.
+func (a *AMICdrAnalytics) SetTrunkFunc(value func(r *AMICdr) string) *AMICdrAnalytics {
+	a.trunkFunc = value
+	return a
+}
+
+// SetDidFunc
+// SetDidFunc sets the resolver of the DID of the record, it applies to the records added afterwards.
+// By default, the DID is the destination of the inbound calls.
+func (a *AMICdrAnalytics) SetDidFunc(value func(r *AMICdr) string) *AMICdrAnalytics {
+	a.didFunc = value
+	return a
+}
+
+// Add
+// Add aggregates the records into the running statistics of the minute they started, by every group,
+// and evicts the minutes out of the retention. The records themselves are not kept.
+func (a *AMICdrAnalytics) Add(records ...AMICdr) *AMICdrAnalytics {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	if a.minutes == nil {
+		a.minutes = make(map[int64]*amiCdrMinute)
+	}
+	for i := range records {
+		r := &records[i]
+		m := a.minute(r.StartTime)
+		m.calls++
+		for _, groupBy := range cdrGroups {
+			key := a.key(r, groupBy)
+			id := groupBy + "|" + key
+			s, ok := m.stats[id]
+			if !ok {
+				s = &AMICdrStats{GroupBy: groupBy, Key: key, Dispositions: make(map[string]int)}
+				m.stats[id] = s
+			}
+			s.add(r)
+		}
+		a.count++
+	}
+	if a.Retention > 0 {
+		deadline := time.Now().Add(-a.Retention)
+		n := 0
+		// the minutes are sorted, the ones ended before the deadline are at the front
+		for n < len(a.order) && !time.Unix(a.order[n], 0).Add(time.Minute).After(deadline) {
+			a.count -= a.minutes[a.order[n]].calls
+			delete(a.minutes, a.order[n])
+			n++
+		}
+		a.order = a.order[n:]
+	}
+	return a
+}
+
+// minute returns the running statistics of the minute of the time, the lock must be held.
+// The records without start time are kept apart, they are never evicted.
+func (a *AMICdrAnalytics) minute(at time.Time) *amiCdrMinute {
+	id := int64(math.MinInt64)
+	if !at.IsZero() {
+		at = at.Truncate(time.Minute)
+		id = at.Unix()
+	}
+	if m, ok := a.minutes[id]; ok {
+		return m
+	}
+	m := &amiCdrMinute{at: at, stats: make(map[string]*AMICdrStats)}
+	a.minutes[id] = m
+	if !at.IsZero() {
+		i := sort.Search(len(a.order), func(i int) bool { return a.order[i] >= id })
+		a.order = append(a.order, 0)
+		copy(a.order[i+1:], a.order[i:])
+		a.order[i] = id
+	}
+	return m
+}
+
+// Len
+// Len returns the number of records aggregated within the retention.
+func (a *AMICdrAnalytics) Len() int {
+	a.mutex.RLock()
+	defer a.mutex.RUnlock()
+	return a.count
+}
+
+// Write
+// Write adds the records, it implements AmiCdrSink.
+func (a *AMICdrAnalytics) Write(ctx context.Context, records []AMICdr) error {
+	a.Add(records...)
+	return nil
+}
+
+// Close
+// Close does nothing, the statistics are kept for the reports.
+func (a *AMICdrAnalytics) Close() error {
+	return nil
+}
+
+// Report
+// Report computes the statistics of the records started within the window of the query,
+// sorted by the group key then by the bucket. The window applies to the minute the records started.
+func (a *AMICdrAnalytics) Report(q AMICdrQuery) []AMICdrStats {
+	groupBy := strings.ToLower(q.GroupBy)
+	if !Contains(cdrGroups, groupBy) {
+		groupBy = config.AmiCdrGroupAll
+	}
+	location := q.Location
+	if location == nil {
+		location = time.UTC
+	}
+	groups := make(map[string]*AMICdrStats)
+	merge := func(m *amiCdrMinute) {
+		bucket := cdrBucket(m.at, q.Bucket, location)
+		for _, v := range m.stats {
+			if v.GroupBy != groupBy {
+				continue
+			}
+			id := fmt.Sprintf("%v|%v", v.Key, bucket.UnixNano())
+			s, ok := groups[id]
+			if !ok {
+				s = &AMICdrStats{GroupBy: groupBy, Key: v.Key, BucketAt: bucket, Dispositions: make(map[string]int)}
+				groups[id] = s
+			}
+			s.merge(v)
+		}
+	}
+	a.mutex.RLock()
+	from, to := 0, len(a.order)
+	if !q.From.IsZero() {
+		from = sort.Search(len(a.order), func(i int) bool { return a.order[i] >= q.From.Truncate(time.Minute).Unix() })
+	}
+	if !q.To.IsZero() {
+		to = sort.Search(len(a.order), func(i int) bool { return a.order[i] >= q.To.Unix() })
+	}
+	for i := from; i < to; i++ {
+		merge(a.minutes[a.order[i]])
+	}
+	if m, ok := a.minutes[math.MinInt64]; ok && q.From.IsZero() {
+		merge(m)
+	}
+	a.mutex.RUnlock()
+
+	stats := make([]AMICdrStats, 0, len(groups))
+	for _, s := range groups {
+		s.compute()
+		stats = append(stats, *s)
+	}
+	sort.Slice(stats, func(i, j int) bool {
+		if stats[i].Key != stats[j].Key {
+			return stats[i].Key < stats[j].Key
+		}
+		return stats[i].BucketAt.Before(stats[j].BucketAt)
+	})
+	return stats
+}
+
+// Summary
+// Summary computes the statistics of all records started within [from, to).
+func (a *AMICdrAnalytics) Summary(from, to time.Time) AMICdrStats {
+	stats := a.Report(AMICdrQuery{From: from, To: to, GroupBy: config.AmiCdrGroupAll})
+	if len(stats) == 0 {
+		return AMICdrStats{GroupBy: config.AmiCdrGroupAll, Key: config.AmiCdrGroupAll, Dispositions: make(map[string]int)}
+	}
+	return stats[0]
+}
+
+// Trunk
+// Trunk returns the trunk of the record.
+func (a *AMICdrAnalytics) Trunk(r *AMICdr) string {
+	if a.trunkFunc != nil {
+		return a.trunkFunc(r)
+	}
+	return CdrTrunk(r, a.Symbol)
+}
+
+// CdrTrunk
+// CdrTrunk returns the endpoint of party A for the inbound calls, and of party B for the outbound calls.
+func CdrTrunk(r *AMICdr, symbol string) string {
+	if r.IsCdrOutbound() {
+		return ChannelEndpoint(r.DestinationChannel, symbol)
+	}
+	if r.IsCdrInbound() {
+		return ChannelEndpoint(r.Channel, symbol)
+	}
+	return ""
+}
+
+// Did
+// Did returns the DID of the record.
+func (a *AMICdrAnalytics) Did(r *AMICdr) string {
+	if a.didFunc != nil {
+		return a.didFunc(r)
+	}
+	if r.IsCdrInbound() {
+		return r.Destination
+	}
+	return ""
+}
+
+func (a *AMICdrAnalytics) key(r *AMICdr, groupBy string) string {
+	switch groupBy {
+	case config.AmiCdrGroupExtension:
+		return r.Extension
+	case config.AmiCdrGroupTrunk:
+		return a.Trunk(r)
+	case config.AmiCdrGroupDirection:
+		return r.Direction
+	case config.AmiCdrGroupDID:
+		return a.Did(r)
+	}
+	return config.AmiCdrGroupAll
+}
+
+func cdrBucket(at time.Time, bucket string, location *time.Location) time.Time {
+	if at.IsZero() {
+		return at
+	}
+	at = at.In(location)
+	switch strings.ToLower(bucket) {
+	case config.AmiCdrBucketHour:
+		return time.Date(at.Year(), at.Month(), at.Day(), at.Hour(), 0, 0, 0, location)
+	case config.AmiCdrBucketDay:
+		return time.Date(at.Year(), at.Month(), at.Day(), 0, 0, 0, 0, location)
+	}
+	return time.Time{}
+}
+
+func (s *AMICdrStats) add(r *AMICdr) {
+	s.Calls++
+	s.Dispositions[r.Disposition]++
+	s.Duration += r.Duration
+	switch {
+	case r.IsCdrAnswered():
+		s.Answered++
+		s.BillableSeconds += r.BillableSeconds
+		s.BillableMinutesRounded += (r.BillableSeconds + 59) / 60
+		if r.Duration >= r.BillableSeconds {
+			s.ringing += r.Duration - r.BillableSeconds
+		}
+	case r.IsCdrBusy():
+		s.Busy++
+	case r.IsCdrNoAnswer():
+		s.NoAnswer++
+	case r.IsCdrFailed():
+		s.Failed++
+	case r.IsCdrCongestion():
+		s.Congestion++
+	}
+}
+
+func (s *AMICdrStats) merge(o *AMICdrStats) {
+	s.Calls += o.Calls
+	s.Answered += o.Answered
+	s.Busy += o.Busy
+	s.NoAnswer += o.NoAnswer
+	s.Failed += o.Failed
+	s.Congestion += o.Congestion
+	for disposition, n := range o.Dispositions {
+		s.Dispositions[disposition] += n
+	}
+	s.Duration += o.Duration
+	s.BillableSeconds += o.BillableSeconds
+	s.BillableMinutesRounded += o.BillableMinutesRounded
+	s.ringing += o.ringing
+}
+
+func (s *AMICdrStats) compute() {
+	if s.Calls > 0 {
+		s.ASR = round2(float64(s.Answered) * 100 / float64(s.Calls))
+	}
+	if s.Answered > 0 {
+		s.ACD = round2(float64(s.BillableSeconds) / float64(s.Answered))
+		s.AvgRingTime = round2(float64(s.ringing) / float64(s.Answered))
+	}
+	s.BillableMinutes = round2(float64(s.BillableSeconds) / 60)
+}
+
+func (s *AMICdrStats) Json() string {
+	return JsonString(s)
+}
+
+func round2(v float64) float64 {
+	return math.Round(v*100) / 100
+}
+
+// ExportCdrStatsJson
+// ExportCdrStatsJson writes the statistics as a JSON array.
+func ExportCdrStatsJson(w io.Writer, stats []AMICdrStats) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(stats)
+}
+
+// ExportCdrStatsCsv
+// ExportCdrStatsCsv writes the statistics as CSV rows with a header.
+func ExportCdrStatsCsv(w io.Writer, stats []AMICdrStats) error {
+	writer := csv.NewWriter(w)
+	writer.Write([]string{
+		"group_by", "key", "bucket_at", "calls", "answered", "busy", "no_answer", "failed", "congestion",
+		"asr", "acd", "avg_ring_time", "duration", "billable_seconds", "billable_minutes", "billable_minutes_rounded",
+	})
+	for _, s := range stats {
+		bucket := ""
+		if !s.BucketAt.IsZero() {
+			bucket = s.BucketAt.Format(time.RFC3339)
+		}
+		writer.Write([]string{
+			s.GroupBy, s.Key, bucket,
+			strconv.Itoa(s.Calls), strconv.Itoa(s.Answered), strconv.Itoa(s.Busy), strconv.Itoa(s.NoAnswer),
+			strconv.Itoa(s.Failed), strconv.Itoa(s.Congestion),
+			strconv.FormatFloat(s.ASR, 'f', 2, 64), strconv.FormatFloat(s.ACD, 'f', 2, 64), strconv.FormatFloat(s.AvgRingTime, 'f', 2, 64),
+			strconv.Itoa(s.Duration), strconv.Itoa(s.BillableSeconds),
+			strconv.FormatFloat(s.BillableMinutes, 'f', 2, 64), strconv.Itoa(s.BillableMinutesRounded),
+		})
+	}
+	writer.Flush()
+	return writer.Error()
+}
@@ -0,0 +1,342 @@
+package ami
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/pnguyen215/voipkit/pkg/ami/config"
+)
+
+// defaultCdrClassifier keeps the legacy classification of ParseCdr
+var defaultCdrClassifier = NewDefaultCdrClassifier()
+
+func NewAMICdrClassifier() *AMICdrClassifier {
+	c := &AMICdrClassifier{}
+	c.SetSymbol("-")
+	return c
+}
+
+// NewDefaultCdrClassifier
+// NewDefaultCdrClassifier returns the classifier of the legacy rules:
+// a phone number destination is outbound, ChanSpy is an outbound spy, Dial and Queue are inbound.
+func NewDefaultCdrClassifier() *AMICdrClassifier {
+	phone := true
+	c := NewAMICdrClassifier()
+	c.AddRule(
+		AMICdrRule{
+			Name:        "outbound_phone",
+			Phone:       &phone,
+			Direction:   config.AmiOutboundDirection,
+			Type:        config.AmiTypeOutboundNormalDirection,
+			ExtensionBy: config.AmiCdrFieldChannel,
+			NumberBy:    config.AmiCdrFieldPhone,
+			DescFrom:    config.AmiCdrFieldChannel,
+			DescTo:      config.AmiCdrFieldPhone,
+		},
+		AMICdrRule{
+			Name:         "outbound_chan_spy",
+			Applications: []string{config.AmiLastApplicationChanSpy},
+			Direction:    config.AmiOutboundDirection,
+			Type:         config.AmiTypeChanSpyDirection,
+			ExtensionBy:  config.AmiCdrFieldChannel,
+			DescFrom:     config.AmiCdrFieldChannel,
+			DescTo:       config.AmiCdrFieldLastData,
+		},
+		AMICdrRule{
+			Name:         "inbound_dial",
+			Applications: []string{config.AmiLastApplicationDial},
+			Direction:    config.AmiInboundDirection,
+			Type:         config.AmiTypeInboundDialDirection,
+			ExtensionBy:  config.AmiCdrFieldDestinationChannel,
+			NumberBy:     config.AmiCdrFieldSource,
+			DescFrom:     config.AmiCdrFieldSource,
+			DescTo:       config.AmiCdrFieldDestinationChannel,
+		},
+		AMICdrRule{
+			Name:         "inbound_queue",
+			Applications: []string{config.AmiLastApplicationQueue},
+			Direction:    config.AmiInboundDirection,
+			Type:         config.AmiTypeInboundQueueDirection,
+			ExtensionBy:  config.AmiCdrFieldChannel,
+			NumberBy:     config.AmiCdrFieldSource,
+			DescFrom:     config.AmiCdrFieldSource,
+			DescTo:       config.AmiCdrFieldChannel,
+		},
+	)
+	return c
+}
+
+// LoadCdrClassifier
+// LoadCdrClassifier parses the classifier (regions, phone prefix and ordered rules) from JSON and validates the rules.
+// Example:
+//
+//	{
+//		"regions": ["VN", "SG"],
+//		"phone_prefix": ["9"],
+//		"rules": [
+//			{"name": "spy", "applications": ["ChanSpy"], "direction": "spy", "type": "chan_spy", "desc_to": "last_data"},
+//			{"name": "trunk_in", "technologies": ["PJSIP"], "trunks": ["trunk_*"], "direction": "inbound", "extension_by": "destination_channel", "number_by": "source"}
+//		]
+//	}
+func LoadCdrClassifier(data []byte) (*AMICdrClassifier, error) {
+	c := NewAMICdrClassifier()
+	if err := json.Unmarshal(data, c); err != nil {
+		return nil, err
+	}
+	if IsStringEmpty(c.Symbol) {
+		c.SetSymbol("-")
+	}
+	if err := c.Validate(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// LoadCdrClassifierFile
+// LoadCdrClassifierFile reads the JSON classifier from the file.
+func LoadCdrClassifierFile(filename string) (*AMICdrClassifier, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	return LoadCdrClassifier(data)
+}
+
+func (c *AMICdrClassifier) SetRegions(values ...string) *AMICdrClassifier {
+	c.Regions = values
+	return c
+}
+
+func (c *AMICdrClassifier) SetPhonePrefix(values ...string) *AMICdrClassifier {
+	c.PhonePrefix = values
+	return c
+}
+
+func (c *AMICdrClassifier) SetSymbol(value string) *AMICdrClassifier {
+	c.Symbol = TrimStringSpaces(value)
+	return c
+}
+
+func (c *AMICdrClassifier) SetRules(values []AMICdrRule) *AMICdrClassifier {
+	c.Rules = values
+	return c
+}
+
+func (c *AMICdrClassifier) AddRule(values ...AMICdrRule) *AMICdrClassifier {
+	c.Rules = append(c.Rules, values...)
+	return c
+}
+
+func (c *AMICdrClassifier) Json() string {
+	return JsonString(c)
+}
+
+// Validate
+// Validate checks the direction, the patterns and the DID ranges of every rule.
+func (c *AMICdrClassifier) Validate() error {
+	for i, rule := range c.Rules {
+		name := rule.Name
+		if IsStringEmpty(name) {
+			name = fmt.Sprintf("#%v", i)
+		}
+		if !config.AmiCallDirection[strings.ToLower(rule.Direction)] {
+			return fmt.Errorf(config.AmiErrorCdrRuleInvalid, name, fmt.Sprintf("unsupported direction '%v'", rule.Direction))
+		}
+		patterns := append(append(append([]string{}, rule.Contexts...), rule.Trunks...), rule.AccountCodes...)
+		for _, p := range patterns {
+			if _, err := path.Match(p, ""); err != nil {
+				return fmt.Errorf(config.AmiErrorCdrRuleInvalid, name, fmt.Sprintf("bad pattern '%v'", p))
+			}
+		}
+		for _, did := range rule.DIDs {
+			if IsStringEmpty(did.Prefix) && (IsStringEmpty(did.From) || len(did.From) != len(did.To) || did.From > did.To) {
+				return fmt.Errorf(config.AmiErrorCdrRuleInvalid, name, fmt.Sprintf("bad DID range %v", JsonString(did)))
+			}
+		}
+	}
+	return nil
+}
+
+// Match
+// Match returns the first rule matching the CDR.
+func (c *AMICdrClassifier) Match(r *AMICdr) (*AMICdrRule, bool) {
+	return c.match(r, c.Regions, c.PhonePrefix)
+}
+
+// Classify
+// Classify sets the direction, the type, the extension, the number and the description
+// of the CDR from the first matching rule. It returns false if no rule matches.
+func (c *AMICdrClassifier) Classify(r *AMICdr) bool {
+	return c.classify(r, c.Regions, c.PhonePrefix)
+}
+
+func (c *AMICdrClassifier) classify(r *AMICdr, regions, prefixes []string) bool {
+	if r == nil {
+		return false
+	}
+	if !IsStringEmpty(c.Symbol) {
+		r.SetSymbol(c.Symbol)
+	}
+	rule, ok := c.match(r, regions, prefixes)
+	if !ok {
+		return false
+	}
+	phone := RemoveStringPrefix(r.Destination, prefixes...)
+	r.SetDirection(strings.ToLower(rule.Direction))
+	r.SetType(rule.Type)
+	extensionBy := rule.ExtensionBy
+	if IsStringEmpty(extensionBy) {
+		extensionBy = config.AmiCdrFieldChannel
+	}
+	extension := r.field(extensionBy, phone)
+	if extensionBy == config.AmiCdrFieldChannel || extensionBy == config.AmiCdrFieldDestinationChannel {
+		extension = ChannelPeer(extension, r.symbol)
+	}
+	if rule.StripTech {
+		if i := strings.Index(extension, "/"); i >= 0 {
+			extension = extension[i+1:]
+		}
+	}
+	r.SetExtension(extension)
+	if !IsStringEmpty(rule.NumberBy) {
+		r.SetNumber(r.field(rule.NumberBy, phone))
+	}
+	from, to := rule.DescFrom, rule.DescTo
+	if IsStringEmpty(from) {
+		from = config.AmiCdrFieldChannel
+	}
+	if IsStringEmpty(to) {
+		to = config.AmiCdrFieldDestination
+	}
+	r.SetDesc(fmt.Sprintf("CDR.call_from_'%v'_to_'%v'", r.field(from, phone), r.field(to, phone)))
+	return true
+}
+
+func (c *AMICdrClassifier) match(r *AMICdr, regions, prefixes []string) (*AMICdrRule, bool) {
+	if r == nil {
+		return nil, false
+	}
+	symbol := r.symbol
+	if !IsStringEmpty(c.Symbol) {
+		symbol = c.Symbol
+	}
+	for i := range c.Rules {
+		rule := &c.Rules[i]
+		if !matchPatterns(rule.Contexts, r.DestinationContext) {
+			continue
+		}
+		if !matchPatterns(rule.AccountCodes, r.AccountCode) {
+			continue
+		}
+		if len(rule.Applications) > 0 && !containsFold(rule.Applications, r.LastApplication) {
+			continue
+		}
+		if len(rule.Technologies) > 0 &&
+			!containsFold(rule.Technologies, ChannelTechnology(r.Channel)) &&
+			!containsFold(rule.Technologies, ChannelTechnology(r.DestinationChannel)) {
+			continue
+		}
+		if len(rule.Trunks) > 0 &&
+			!matchPatterns(rule.Trunks, ChannelEndpoint(r.Channel, symbol)) &&
+			!matchPatterns(rule.Trunks, ChannelEndpoint(r.DestinationChannel, symbol)) {
+			continue
+		}
+		if len(rule.DIDs) > 0 && !matchDids(rule.DIDs, r.Destination) {
+			continue
+		}
+		if rule.Phone != nil {
+			in := regions
+			if len(rule.Regions) > 0 {
+				in = rule.Regions
+			}
+			if isPhoneIn(RemoveStringPrefix(r.Destination, prefixes...), in) != *rule.Phone {
+				continue
+			}
+		}
+		return rule, true
+	}
+	return nil, false
+}
+
+// field returns the text of the CDR field by the classification field key
+func (r *AMICdr) field(key string, phone string) string {
+	if strings.EqualFold(key, config.AmiCdrFieldPhone) {
+		return phone
+	}
+	return r.Text(key)
+}
+
+// ChannelTechnology
+// ChannelTechnology returns the technology of the channel, i.e: PJSIP/trunk_vn-0000001a returns PJSIP
+func ChannelTechnology(channel string) string {
+	i := strings.Index(channel, "/")
+	if i < 0 {
+		return ""
+	}
+	return channel[:i]
+}
+
+// ChannelPeer
+// ChannelPeer returns the channel without its unique suffix, i.e: SIP/1000-00098fec returns SIP/1000.
+// Only the last symbol is considered, so trunk names holding the symbol are kept.
+func ChannelPeer(channel string, symbol string) string {
+	if IsStringEmpty(symbol) {
+		return channel
+	}
+	i := strings.LastIndex(channel, symbol)
+	if i <= strings.Index(channel, "/") {
+		return channel
+	}
+	return channel[:i]
+}
+
+// ChannelEndpoint
+// ChannelEndpoint returns the endpoint (peer, trunk) name of the channel, i.e: PJSIP/trunk-vn-0000001a returns trunk-vn
+func ChannelEndpoint(channel string, symbol string) string {
+	peer := ChannelPeer(channel, symbol)
+	if i := strings.Index(peer, "/"); i >= 0 {
+		return peer[i+1:]
+	}
+	return peer
+}
+
+func matchPatterns(patterns []string, value string) bool {
+	if len(patterns) == 0 {
+		return true
+	}
+	value = strings.ToLower(value)
+	for _, p := range patterns {
+		if ok, _ := path.Match(strings.ToLower(p), value); ok {
+			return true
+		}
+	}
+	return false
+}
+
+func matchDids(ranges []AMICdrDidRange, destination string) bool {
+	number := strings.TrimPrefix(TrimStringSpaces(destination), "+")
+	if IsStringEmpty(number) {
+		return false
+	}
+	for _, did := range ranges {
+		if !IsStringEmpty(did.Prefix) && strings.HasPrefix(number, strings.TrimPrefix(did.Prefix, "+")) {
+			return true
+		}
+		if !IsStringEmpty(did.From) && len(number) == len(did.From) && number >= did.From && number <= did.To {
+			return true
+		}
+	}
+	return false
+}
+
+func isPhoneIn(phone string, regions []string) bool {
+	for _, region := range regions {
+		if VerifyPhoneNo(phone, region) {
+			return true
+		}
+	}
+	return false
+}
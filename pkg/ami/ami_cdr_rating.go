@@ -0,0 +1,368 @@
+package ami
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pnguyen215/voipkit/pkg/ami/config"
+)
+
+func NewAMIRateTable(name string) *AMIRateTable {
+	t := &AMIRateTable{Name: TrimStringSpaces(name)}
+	t.Rates = make(map[string][]AMIRate)
+	return t
+}
+
+// AddRate
+// AddRate adds the rates, the increments default to 60/60.
+func (t *AMIRateTable) AddRate(rates ...AMIRate) *AMIRateTable {
+	for _, rate := range rates {
+		if rate.InitialIncrement <= 0 {
+			rate.InitialIncrement = 60
+		}
+		if rate.Increment <= 0 {
+			rate.Increment = rate.InitialIncrement
+		}
+		t.Rates[rate.Prefix] = append(t.Rates[rate.Prefix], rate)
+	}
+	return t
+}
+
+// Len
+// Len returns the number of prefixes of the table.
+func (t *AMIRateTable) Len() int {
+	return len(t.Rates)
+}
+
+// Lookup
+// Lookup returns the rate of the longest prefix of the number, valid at the time of day.
+// Among the rates of the prefix, a time-of-day tariff wins over an all-day one.
+func (t *AMIRateTable) Lookup(number string, at time.Time) (*AMIRate, bool) {
+	minutes := at.Hour()*60 + at.Minute()
+	for i := len(number); i >= 0; i-- {
+		rates, ok := t.Rates[number[:i]]
+		if !ok {
+			continue
+		}
+		var fallback *AMIRate
+		for j := range rates {
+			rate := &rates[j]
+			if IsStringEmpty(rate.TimeFrom) && IsStringEmpty(rate.TimeTo) {
+				if fallback == nil {
+					fallback = rate
+				}
+				continue
+			}
+			if rate.within(minutes) {
+				v := *rate
+				return &v, true
+			}
+		}
+		if fallback != nil {
+			v := *fallback
+			return &v, true
+		}
+	}
+	return nil, false
+}
+
+// Bill
+// Bill returns the billed seconds and the cost of the billable seconds.
+// The unanswered calls (no billable seconds) are free, the connection fee included.
+func (rate *AMIRate) Bill(billable int) (int, float64) {
+	if billable <= 0 {
+		return 0, 0
+	}
+	if billable < rate.MinimumDuration {
+		billable = rate.MinimumDuration
+	}
+	billed := rate.InitialIncrement
+	if billable > rate.InitialIncrement {
+		next := billable - rate.InitialIncrement
+		billed += int(math.Ceil(float64(next)/float64(rate.Increment))) * rate.Increment
+	}
+	cost := rate.ConnectionFee + float64(billed)*rate.PerMinute/60
+	return billed, math.Round(cost*1e6) / 1e6
+}
+
+// within checks the minutes of the day are within [TimeFrom, TimeTo), the window may wrap over midnight
+func (rate *AMIRate) within(minutes int) bool {
+	from, okFrom := parseClock(rate.TimeFrom)
+	to, okTo := parseClock(rate.TimeTo)
+	if !okFrom {
+		from = 0
+	}
+	if !okTo {
+		to = 24 * 60
+	}
+	if from <= to {
+		return minutes >= from && minutes < to
+	}
+	return minutes >= from || minutes < to
+}
+
+func parseClock(value string) (int, bool) {
+	t, err := time.Parse("15:04", TrimStringSpaces(value))
+	if err != nil {
+		return 0, false
+	}
+	return t.Hour()*60 + t.Minute(), true
+}
+
+// LoadRateTableCsv
+// LoadRateTableCsv reads the rate table from CSV with a header, the columns are:
+// prefix, description, rate (per minute), connection_fee, increment (i.e: 60/60, 30/6, 1/1),
+// minimum_duration, time_from, time_to and currency. Only prefix and rate are required.
+func LoadRateTableCsv(name string, reader io.Reader) (*AMIRateTable, error) {
+	r := csv.NewReader(reader)
+	r.TrimLeadingSpace = true
+	r.FieldsPerRecord = -1
+	header, err := r.Read()
+	if err != nil {
+		return nil, fmt.Errorf(config.AmiErrorRateTableInvalid, name, 1, err)
+	}
+	columns := make(map[string]int)
+	for i, column := range header {
+		columns[strings.ToLower(TrimStringSpaces(column))] = i
+	}
+	if _, ok := columns["prefix"]; !ok {
+		return nil, fmt.Errorf(config.AmiErrorRateTableInvalid, name, 1, "missing column prefix")
+	}
+	if _, ok := columns["rate"]; !ok {
+		return nil, fmt.Errorf(config.AmiErrorRateTableInvalid, name, 1, "missing column rate")
+	}
+	t := NewAMIRateTable(name)
+	for line := 2; ; line++ {
+		record, err := r.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf(config.AmiErrorRateTableInvalid, name, line, err)
+		}
+		get := func(column string) string {
+			i, ok := columns[column]
+			if !ok || i >= len(record) {
+				return ""
+			}
+			return TrimStringSpaces(record[i])
+		}
+		rate := AMIRate{
+			Prefix:      strings.TrimPrefix(get("prefix"), "+"),
+			Description: get("description"),
+			TimeFrom:    get("time_from"),
+			TimeTo:      get("time_to"),
+			Currency:    get("currency"),
+		}
+		if rate.PerMinute, err = strconv.ParseFloat(get("rate"), 64); err != nil {
+			return nil, fmt.Errorf(config.AmiErrorRateTableInvalid, name, line, err)
+		}
+		if v := get("connection_fee"); !IsStringEmpty(v) {
+			if rate.ConnectionFee, err = strconv.ParseFloat(v, 64); err != nil {
+				return nil, fmt.Errorf(config.AmiErrorRateTableInvalid, name, line, err)
+			}
+		}
+		if v := get("minimum_duration"); !IsStringEmpty(v) {
+			if rate.MinimumDuration, err = strconv.Atoi(v); err != nil {
+				return nil, fmt.Errorf(config.AmiErrorRateTableInvalid, name, line, err)
+			}
+		}
+		if v := get("increment"); !IsStringEmpty(v) {
+			if rate.InitialIncrement, rate.Increment, err = ParseBillingIncrement(v); err != nil {
+				return nil, fmt.Errorf(config.AmiErrorRateTableInvalid, name, line, err)
+			}
+		}
+		for _, clock := range []string{rate.TimeFrom, rate.TimeTo} {
+			if _, ok := parseClock(clock); !IsStringEmpty(clock) && !ok {
+				return nil, fmt.Errorf(config.AmiErrorRateTableInvalid, name, line, fmt.Sprintf("bad time '%v'", clock))
+			}
+		}
+		t.AddRate(rate)
+	}
+	return t, nil
+}
+
+// LoadRateTableCsvFile
+// LoadRateTableCsvFile reads the rate table from the CSV file.
+func LoadRateTableCsvFile(name string, filename string) (*AMIRateTable, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return LoadRateTableCsv(name, file)
+}
+
+// ParseBillingIncrement
+// ParseBillingIncrement parses the billing increments, i.e: 30/6 returns 30 and 6, 60 returns 60 and 60.
+func ParseBillingIncrement(value string) (int, int, error) {
+	parts := strings.SplitN(TrimStringSpaces(value), "/", 2)
+	initial, err := strconv.Atoi(TrimStringSpaces(parts[0]))
+	if err != nil || initial <= 0 {
+		return 0, 0, fmt.Errorf("bad billing increment '%v'", value)
+	}
+	next := initial
+	if len(parts) == 2 {
+		next, err = strconv.Atoi(TrimStringSpaces(parts[1]))
+		if err != nil || next <= 0 {
+			return 0, 0, fmt.Errorf("bad billing increment '%v'", value)
+		}
+	}
+	return initial, next, nil
+}
+
+// NewAMIRatingEngine
+// NewAMIRatingEngine creates the engine rating the outbound records only, see SetDirections.
+// The region has no default, set it to normalize the national numbers (i.e: SetRegion("VN")).
+func NewAMIRatingEngine() *AMIRatingEngine {
+	e := &AMIRatingEngine{}
+	e.tables = make(map[string]*AMIRateTable)
+	e.SetDirections(config.AmiOutboundDirection)
+	e.SetSymbol("-")
+	e.SetLocation(time.UTC)
+	return e
+}
+
+// SetRegion
+// SetRegion sets the region of the national numbers (ISO 3166-1 alpha-2), without region the numbers
+// are only stripped to their digits.
+func (e *AMIRatingEngine) SetRegion(value string) *AMIRatingEngine {
+	e.Region = TrimStringSpaces(value)
+	return e
+}
+
+// SetDirections
+// SetDirections sets the directions of the records which are rated, i.e: outbound, inbound (the toll-free numbers).
+func (e *AMIRatingEngine) SetDirections(values ...string) *AMIRatingEngine {
+	e.Directions = values
+	return e
+}
+
+// Eligible
+// Eligible returns true if the direction of the record is rated.
+func (e *AMIRatingEngine) Eligible(r *AMICdr) bool {
+	for _, direction := range e.Directions {
+		if strings.EqualFold(r.Direction, direction) {
+			return true
+		}
+	}
+	return false
+}
+
+func (e *AMIRatingEngine) SetPhonePrefix(values ...string) *AMIRatingEngine {
+	e.PhonePrefix = values
+	return e
+}
+
+func (e *AMIRatingEngine) SetSymbol(value string) *AMIRatingEngine {
+	e.Symbol = TrimStringSpaces(value)
+	return e
+}
+
+func (e *AMIRatingEngine) SetLocation(value *time.Location) *AMIRatingEngine {
+	if value != nil {
+		e.Location = value
+	}
+	return e
+}
+
+// SetTrunkFunc
+// SetTrunkFunc sets the resolver of the trunk of the record, CdrTrunk is used by default.
+func (e *AMIRatingEngine) SetTrunkFunc(value func(r *AMICdr) string) *AMIRatingEngine {
+	e.trunkFunc = value
+	return e
+}
+
+// SetTable
+// SetTable sets the rate table of the trunk.
+func (e *AMIRatingEngine) SetTable(trunk string, table *AMIRateTable) *AMIRatingEngine {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	e.tables[trunk] = table
+	return e
+}
+
+// SetDefaultTable
+// SetDefaultTable sets the rate table of the trunks without their own table.
+func (e *AMIRatingEngine) SetDefaultTable(table *AMIRateTable) *AMIRatingEngine {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	e.fallback = table
+	return e
+}
+
+// Number
+// Number returns the normalized destination number of the record, from Number or else from Destination.
+func (e *AMIRatingEngine) Number(r *AMICdr) string {
+	number := r.Number
+	if IsStringEmpty(number) {
+		number = RemoveStringPrefix(r.Destination, e.PhonePrefix...)
+	}
+	return NormalizePhoneNo(number, e.Region)
+}
+
+// Rate
+// Rate rates the record by its billable seconds, at the time of day of the answer (or the start).
+// The records of the other directions than SetDirections are not rated.
+func (e *AMIRatingEngine) Rate(r *AMICdr) (*AMICdrRating, error) {
+	if !e.Eligible(r) {
+		return nil, fmt.Errorf(config.AmiErrorRateDirectionIneligible, r.Direction)
+	}
+	trunk := ""
+	if e.trunkFunc != nil {
+		trunk = e.trunkFunc(r)
+	} else {
+		trunk = CdrTrunk(r, e.Symbol)
+	}
+	e.mutex.RLock()
+	table, ok := e.tables[trunk]
+	if !ok {
+		table = e.fallback
+	}
+	e.mutex.RUnlock()
+	number := e.Number(r)
+	if table == nil {
+		return nil, fmt.Errorf(config.AmiErrorRateNotFound, number, trunk)
+	}
+	at := r.AnswerTime
+	if at.IsZero() {
+		at = r.StartTime
+	}
+	rate, ok := table.Lookup(number, at.In(e.Location))
+	if !ok {
+		return nil, fmt.Errorf(config.AmiErrorRateNotFound, number, trunk)
+	}
+	billed, cost := rate.Bill(r.BillableSeconds)
+	rating := &AMICdrRating{
+		Number:        number,
+		Trunk:         trunk,
+		Table:         table.Name,
+		Rate:          rate,
+		BilledSeconds: billed,
+		Cost:          cost,
+		Currency:      rate.Currency,
+	}
+	return rating, nil
+}
+
+// Apply
+// Apply rates the record and sets its cost and currency.
+func (e *AMIRatingEngine) Apply(r *AMICdr) (*AMICdrRating, error) {
+	rating, err := e.Rate(r)
+	if err != nil {
+		return nil, err
+	}
+	r.SetCost(rating.Cost)
+	r.SetCurrency(rating.Currency)
+	return rating, nil
+}
+
+func (r *AMICdrRating) Json() string {
+	return JsonString(r)
+}
@@ -0,0 +1,350 @@
+package ami
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pnguyen215/voipkit/pkg/ami/config"
+)
+
+// AmiCdrSink
+// AmiCdrSink is the destination of the CDR records (i.e: CSV file, JSON Lines file, database).
+// Write receives a batch of records and should be all-or-nothing, so that a failed batch can be retried.
+// The file sinks write each batch at once, but a failed write might have written a part of it,
+// so that the retried batch is delivered at least once (the records might be duplicated, never lost).
+type AmiCdrSink interface {
+	Write(ctx context.Context, records []AMICdr) error
+	Close() error
+}
+
+var sqlIdentifierRegexp = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*(\.[A-Za-z_][A-Za-z0-9_]*)?$`)
+
+// cdrBackupSuffixRegexp matches the timestamp suffix of the rotated JSON Lines files, see config.AmiCdrBackupTimeFormat
+var cdrBackupSuffixRegexp = regexp.MustCompile(`^\.[0-9]{14}\.[0-9]{9}$`)
+
+// ValidateCdrColumns
+// ValidateCdrColumns checks that every column is a json key of the CDR.
+func ValidateCdrColumns(columns []string) error {
+	r := &AMICdr{}
+	for _, column := range columns {
+		if _, ok := r.Value(column); !ok {
+			return fmt.Errorf(config.AmiErrorCdrColumnUnknown, column)
+		}
+	}
+	return nil
+}
+
+// NewAMICdrCsvSink
+// NewAMICdrCsvSink creates the CSV sink writing into the writer, the header is written before the first row.
+func NewAMICdrCsvSink(w io.Writer) *AMICdrCsvSink {
+	s := &AMICdrCsvSink{writer: w}
+	s.SetColumns(config.AmiCdrColumns...)
+	s.SetHeader(true)
+	return s
+}
+
+// NewAMICdrCsvFileSink
+// NewAMICdrCsvFileSink creates the CSV sink appending into the file,
+// the header is only written if the file is new or empty.
+func NewAMICdrCsvFileSink(filename string) (*AMICdrCsvSink, error) {
+	file, err := os.OpenFile(filename, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	s := NewAMICdrCsvSink(file)
+	s.closer = file
+	s.wroteHeader = info.Size() > 0
+	return s, nil
+}
+
+func (s *AMICdrCsvSink) SetColumns(values ...string) *AMICdrCsvSink {
+	s.Columns = values
+	return s
+}
+
+func (s *AMICdrCsvSink) SetHeader(value bool) *AMICdrCsvSink {
+	s.Header = value
+	return s
+}
+
+func (s *AMICdrCsvSink) Write(ctx context.Context, records []AMICdr) error {
+	if err := ValidateCdrColumns(s.Columns); err != nil {
+		return err
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	// the batch is encoded before writing, so that it is written at once (see AmiCdrSink)
+	var rows [][]string
+	if s.Header && !s.wroteHeader {
+		rows = append(rows, s.Columns)
+	}
+	for i := range records {
+		row := make([]string, len(s.Columns))
+		for j, column := range s.Columns {
+			row[j] = records[i].Text(column)
+		}
+		rows = append(rows, row)
+	}
+	var buf bytes.Buffer
+	if err := csv.NewWriter(&buf).WriteAll(rows); err != nil {
+		return err
+	}
+	if _, err := s.writer.Write(buf.Bytes()); err != nil {
+		return err
+	}
+	s.wroteHeader = true
+	return nil
+}
+
+func (s *AMICdrCsvSink) Close() error {
+	if s.closer == nil {
+		return nil
+	}
+	return s.closer.Close()
+}
+
+// NewAMICdrJsonLinesSink
+// NewAMICdrJsonLinesSink creates the JSON Lines sink appending into the file.
+// Once the file reaches MaxSize, it is renamed to <filename>.<timestamp> and a new file is started.
+func NewAMICdrJsonLinesSink(filename string) (*AMICdrJsonLinesSink, error) {
+	s := &AMICdrJsonLinesSink{Filename: filename}
+	if err := s.open(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *AMICdrJsonLinesSink) SetMaxSize(value int64) *AMICdrJsonLinesSink {
+	s.MaxSize = value
+	return s
+}
+
+func (s *AMICdrJsonLinesSink) SetMaxBackups(value int) *AMICdrJsonLinesSink {
+	s.MaxBackups = value
+	return s
+}
+
+func (s *AMICdrJsonLinesSink) Write(ctx context.Context, records []AMICdr) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.file == nil {
+		return fmt.Errorf(config.AmiErrorCdrWriterClosed)
+	}
+	var buf []byte
+	for i := range records {
+		line, err := json.Marshal(records[i])
+		if err != nil {
+			return err
+		}
+		buf = append(buf, append(line, '\n')...)
+	}
+	if len(buf) == 0 {
+		return nil
+	}
+	if s.MaxSize > 0 && s.size > 0 && s.size+int64(len(buf)) > s.MaxSize {
+		if err := s.rotate(); err != nil {
+			return err
+		}
+	}
+	n, err := s.file.Write(buf)
+	s.size += int64(n)
+	return err
+}
+
+func (s *AMICdrJsonLinesSink) Close() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.file == nil {
+		return nil
+	}
+	err := s.file.Close()
+	s.file = nil
+	return err
+}
+
+func (s *AMICdrJsonLinesSink) open() error {
+	file, err := os.OpenFile(s.Filename, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	s.file = file
+	s.size = info.Size()
+	return nil
+}
+
+func (s *AMICdrJsonLinesSink) rotate() error {
+	if err := s.file.Close(); err != nil {
+		return err
+	}
+	s.file = nil
+	backup := fmt.Sprintf("%v.%v", s.Filename, time.Now().Format(config.AmiCdrBackupTimeFormat))
+	if err := os.Rename(s.Filename, backup); err != nil {
+		return err
+	}
+	if err := s.open(); err != nil {
+		return err
+	}
+	if s.MaxBackups <= 0 {
+		return nil
+	}
+	backups, err := s.backups()
+	if err != nil {
+		return err
+	}
+	for len(backups) > s.MaxBackups {
+		if err := os.Remove(backups[0]); err != nil {
+			componentLog(config.AmiLogComponentCdr).Error("removing backup failed", "file", backups[0], config.AmiLogFieldError, err)
+		}
+		backups = backups[1:]
+	}
+	return nil
+}
+
+// backups returns the files rotated from the file, the oldest first.
+// The other files named after the file (i.e: <filename>.bak) are not backups.
+func (s *AMICdrJsonLinesSink) backups() ([]string, error) {
+	entries, err := os.ReadDir(filepath.Dir(s.Filename))
+	if err != nil {
+		return nil, err
+	}
+	base := filepath.Base(s.Filename)
+	var backups []string
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasPrefix(name, base) || !cdrBackupSuffixRegexp.MatchString(name[len(base):]) {
+			continue
+		}
+		backups = append(backups, filepath.Join(filepath.Dir(s.Filename), name))
+	}
+	sort.Strings(backups)
+	return backups, nil
+}
+
+// NewAMICdrSqlSink
+// NewAMICdrSqlSink creates the database sink inserting into the table, the columns of the table
+// are named after the json keys of the CDR (i.e: unique_id, start_time, duration).
+// The placeholder is ? by default, use SetPlaceholder for the other drivers, i.e: PostgreSQL
+//
+//	sink.SetPlaceholder(func(index int) string { return fmt.Sprintf("$%d", index) })
+func NewAMICdrSqlSink(db *sql.DB, table string) *AMICdrSqlSink {
+	s := &AMICdrSqlSink{db: db}
+	s.SetTable(table)
+	s.SetColumns(config.AmiCdrColumns...)
+	s.SetMaxRows(500)
+	s.SetPlaceholder(func(index int) string { return "?" })
+	return s
+}
+
+func (s *AMICdrSqlSink) SetTable(value string) *AMICdrSqlSink {
+	s.Table = TrimStringSpaces(value)
+	return s
+}
+
+func (s *AMICdrSqlSink) SetColumns(values ...string) *AMICdrSqlSink {
+	s.Columns = values
+	return s
+}
+
+func (s *AMICdrSqlSink) SetMaxRows(value int) *AMICdrSqlSink {
+	if value > 0 {
+		s.MaxRows = value
+	}
+	return s
+}
+
+// SetPlaceholder
+// SetPlaceholder sets the bind parameter of the driver, the index starts from 1.
+func (s *AMICdrSqlSink) SetPlaceholder(value func(index int) string) *AMICdrSqlSink {
+	if value != nil {
+		s.placeholder = value
+	}
+	return s
+}
+
+// Write
+// Write inserts the records within a transaction, so that the batch is committed all at once.
+func (s *AMICdrSqlSink) Write(ctx context.Context, records []AMICdr) error {
+	if len(records) == 0 {
+		return nil
+	}
+	if err := s.validate(); err != nil {
+		return err
+	}
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	for start := 0; start < len(records); start += s.MaxRows {
+		end := start + s.MaxRows
+		if end > len(records) {
+			end = len(records)
+		}
+		query, args := s.insert(records[start:end])
+		if _, err := tx.ExecContext(ctx, query, args...); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// Close
+// Close does nothing, the database is owned by the caller.
+func (s *AMICdrSqlSink) Close() error {
+	return nil
+}
+
+func (s *AMICdrSqlSink) validate() error {
+	if !sqlIdentifierRegexp.MatchString(s.Table) {
+		return fmt.Errorf(config.AmiErrorCdrSqlIdentifierInvalid, s.Table)
+	}
+	for _, column := range s.Columns {
+		if !sqlIdentifierRegexp.MatchString(column) {
+			return fmt.Errorf(config.AmiErrorCdrSqlIdentifierInvalid, column)
+		}
+	}
+	return ValidateCdrColumns(s.Columns)
+}
+
+func (s *AMICdrSqlSink) insert(records []AMICdr) (string, []interface{}) {
+	var builder strings.Builder
+	args := make([]interface{}, 0, len(records)*len(s.Columns))
+	builder.WriteString(fmt.Sprintf("INSERT INTO %v (%v) VALUES ", s.Table, strings.Join(s.Columns, ", ")))
+	for i := range records {
+		if i > 0 {
+			builder.WriteString(", ")
+		}
+		builder.WriteString("(")
+		for j, column := range s.Columns {
+			if j > 0 {
+				builder.WriteString(", ")
+			}
+			v, _ := records[i].Value(column)
+			args = append(args, v)
+			builder.WriteString(s.placeholder(len(args)))
+		}
+		builder.WriteString(")")
+	}
+	return builder.String(), args
+}
@@ -0,0 +1,379 @@
+package ami
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/pnguyen215/voipkit/pkg/ami/config"
+)
+
+// NewAMICdrWriter
+// NewAMICdrWriter creates the buffered writer of the sink,
+// by default it flushes every 100 records or every 5 seconds and retries 3 times.
+func NewAMICdrWriter(sink AmiCdrSink) *AMICdrWriter {
+	w := &AMICdrWriter{sink: sink}
+	w.notify = make(chan struct{}, 1)
+	w.stop = make(chan struct{})
+	w.done = make(chan struct{})
+	w.SetBatchSize(100)
+	w.SetFlushInterval(5 * time.Second)
+	w.SetRetries(3)
+	w.SetRetryBackoff(time.Second)
+	w.SetWriteTimeout(30 * time.Second)
+	return w
+}
+
+func (w *AMICdrWriter) SetBatchSize(value int) *AMICdrWriter {
+	if value > 0 {
+		w.BatchSize = value
+	}
+	return w
+}
+
+func (w *AMICdrWriter) SetFlushInterval(value time.Duration) *AMICdrWriter {
+	if value > 0 {
+		w.FlushInterval = value
+	}
+	return w
+}
+
+func (w *AMICdrWriter) SetRetries(value int) *AMICdrWriter {
+	if value >= 0 {
+		w.Retries = value
+	}
+	return w
+}
+
+func (w *AMICdrWriter) SetRetryBackoff(value time.Duration) *AMICdrWriter {
+	w.RetryBackoff = value
+	return w
+}
+
+func (w *AMICdrWriter) SetWriteTimeout(value time.Duration) *AMICdrWriter {
+	w.WriteTimeout = value
+	return w
+}
+
+// SetSpoolDir
+// SetSpoolDir sets the directory keeping the batches the sink failed to write,
+// they are replayed in order once the sink recovers. Without spool, the failed batches stay in memory.
+func (w *AMICdrWriter) SetSpoolDir(value string) *AMICdrWriter {
+	w.SpoolDir = TrimStringSpaces(value)
+	return w
+}
+
+// SetDictionary
+// SetDictionary sets the dictionary used to parse the CDR events.
+func (w *AMICdrWriter) SetDictionary(value *AMIDictionary) *AMICdrWriter {
+	w.dictionary = value
+	return w
+}
+
+// SetClassifier
+// SetClassifier sets the classifier of the CDR events, the legacy rules are used by default.
+func (w *AMICdrWriter) SetClassifier(value *AMICdrClassifier) *AMICdrWriter {
+	w.classifier = value
+	return w
+}
+
+// SetRatingEngine
+// SetRatingEngine sets the engine rating the answered CDR events of its directions before they are pushed.
+func (w *AMICdrWriter) SetRatingEngine(value *AMIRatingEngine) *AMICdrWriter {
+	w.rating = value
+	return w
+}
+
+// SetRecordingManager
+// SetRecordingManager sets the manager resolving the media link of the answered CDR events.
+func (w *AMICdrWriter) SetRecordingManager(value *AMIRecordingManager) *AMICdrWriter {
+	w.recordings = value
+	return w
+}
+
+// Start
+// Start runs the background flush on interval and on size.
+func (w *AMICdrWriter) Start() *AMICdrWriter {
+	w.mutex.Lock()
+	if w.started || w.closed {
+		w.mutex.Unlock()
+		return w
+	}
+	w.started = true
+	w.mutex.Unlock()
+	go w.run()
+	return w
+}
+
+// Push
+// Push buffers the record, it is written into the sink by the next flush.
+func (w *AMICdrWriter) Push(r *AMICdr) error {
+	if r == nil {
+		return nil
+	}
+	w.mutex.Lock()
+	if w.closed {
+		w.mutex.Unlock()
+		return fmt.Errorf(config.AmiErrorCdrWriterClosed)
+	}
+	w.buffer = append(w.buffer, *r)
+	full := len(w.buffer) >= w.BatchSize
+	w.mutex.Unlock()
+	if full {
+		select {
+		case w.notify <- struct{}{}:
+		default:
+		}
+	}
+	return nil
+}
+
+// Pending
+// Pending returns the number of buffered records.
+func (w *AMICdrWriter) Pending() int {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	return len(w.buffer)
+}
+
+// Flush
+// Flush replays the spool, then writes the buffered records into the sink.
+// If the sink still fails after the retries, the records are spooled (or kept in memory) and the error is returned.
+func (w *AMICdrWriter) Flush() error {
+	w.flushing.Lock()
+	defer w.flushing.Unlock()
+	w.mutex.Lock()
+	batch := w.buffer
+	w.buffer = nil
+	w.mutex.Unlock()
+
+	if err := w.replay(); err != nil {
+		return w.fallback(batch, err)
+	}
+	if len(batch) == 0 {
+		return nil
+	}
+	if err := w.write(batch, true); err != nil {
+		return w.fallback(batch, err)
+	}
+	return nil
+}
+
+// Close
+// Close stops the background flush, flushes the buffered records and closes the sink.
+func (w *AMICdrWriter) Close() error {
+	var err error
+	w.once.Do(func() {
+		w.mutex.Lock()
+		w.closed = true
+		started := w.started
+		w.mutex.Unlock()
+		close(w.stop)
+		if started {
+			<-w.done
+		}
+		err = w.Flush()
+		if e := w.sink.Close(); e != nil && err == nil {
+			err = e
+		}
+	})
+	return err
+}
+
+// Open
+// Open parses every CDR event and pushes it into the writer, until the AMI context is done.
+// The writer is closed gracefully on exit.
+func (w *AMICdrWriter) Open(c *AMI) {
+	events := []string{config.AmiListenerEventCdr}
+	event := c.OnEvents(events...)
+	defer c.Unsubscribes(event, events...)
+	ctx := c.Context()
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	w.Start()
+	defer w.Close()
+	for {
+		select {
+		case message, ok := <-event:
+			if !ok {
+				return
+			}
+			r := ParseCdrWith(message, w.dictionary, w.classifier)
+			if w.rating != nil && r.BillableSeconds > 0 && w.rating.Eligible(r) {
+				if _, err := w.rating.Apply(r); err != nil {
+					componentLog(config.AmiLogComponentCdr).Error("rating cdr failed", config.AmiLogFieldUniqueId, r.UniqueId, config.AmiLogFieldError, err)
+				}
+			}
+			if w.recordings != nil && r.IsCdrAnswered() {
+				w.recordings.AttachMediaLink(r)
+			}
+			w.Push(r)
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (w *AMICdrWriter) OpenAsyncFunc(c *AMI) {
+	go func() {
+		w.Open(c)
+	}()
+}
+
+func (w *AMICdrWriter) run() {
+	defer close(w.done)
+	ticker := time.NewTicker(w.FlushInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-w.notify:
+		case <-w.stop:
+			return
+		}
+		if err := w.Flush(); err != nil {
+			componentLog(config.AmiLogComponentCdr).Error("flushing cdr failed", config.AmiLogFieldError, err)
+		}
+	}
+}
+
+// write writes the batch into the sink, with retries if allowed
+func (w *AMICdrWriter) write(batch []AMICdr, retry bool) error {
+	attempts := 1
+	if retry {
+		attempts += w.Retries
+	}
+	var err error
+	for i := 0; i < attempts; i++ {
+		if i > 0 && !w.sleep(time.Duration(i)*w.RetryBackoff) {
+			break
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), w.WriteTimeout)
+		err = w.sink.Write(ctx, batch)
+		cancel()
+		if err == nil {
+			return nil
+		}
+	}
+	return err
+}
+
+// sleep waits for the backoff, it returns false if the writer is stopping
+func (w *AMICdrWriter) sleep(d time.Duration) bool {
+	if d <= 0 {
+		return true
+	}
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return true
+	case <-w.stop:
+		return false
+	}
+}
+
+// fallback keeps the failed batch in the spool, or back in memory if the spool is not set or unavailable
+func (w *AMICdrWriter) fallback(batch []AMICdr, cause error) error {
+	if len(batch) == 0 {
+		return cause
+	}
+	if !IsStringEmpty(w.SpoolDir) {
+		err := w.spool(batch)
+		if err == nil {
+			return cause
+		}
+		componentLog(config.AmiLogComponentCdr).Error("spooling cdr failed", "count", len(batch), config.AmiLogFieldError, err)
+	}
+	w.mutex.Lock()
+	w.buffer = append(batch, w.buffer...)
+	w.mutex.Unlock()
+	return cause
+}
+
+func (w *AMICdrWriter) spool(batch []AMICdr) error {
+	if err := os.MkdirAll(w.SpoolDir, 0o755); err != nil {
+		return err
+	}
+	filename := filepath.Join(w.SpoolDir, fmt.Sprintf("cdr-%020d.jsonl", time.Now().UnixNano()))
+	file, err := os.OpenFile(filename, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o644)
+	if err != nil {
+		return err
+	}
+	encoder := json.NewEncoder(file)
+	for i := range batch {
+		if err := encoder.Encode(batch[i]); err != nil {
+			file.Close()
+			os.Remove(filename)
+			return err
+		}
+	}
+	if err := file.Sync(); err != nil {
+		file.Close()
+		os.Remove(filename)
+		return err
+	}
+	if err := file.Close(); err != nil {
+		os.Remove(filename)
+		return err
+	}
+	return nil
+}
+
+// replay writes the spooled batches in order, each one is removed once written.
+// The spool files which cannot be loaded are renamed to *.bad, so that they are not loaded again.
+func (w *AMICdrWriter) replay() error {
+	if IsStringEmpty(w.SpoolDir) {
+		return nil
+	}
+	files, err := filepath.Glob(filepath.Join(w.SpoolDir, "cdr-*.jsonl"))
+	if err != nil {
+		return err
+	}
+	sort.Strings(files)
+	for _, filename := range files {
+		batch, err := w.load(filename)
+		if err != nil {
+			componentLog(config.AmiLogComponentCdr).Error("loading spool failed", "file", filename, config.AmiLogFieldError, err)
+			if err := os.Rename(filename, filename+".bad"); err != nil {
+				componentLog(config.AmiLogComponentCdr).Error("renaming spool failed", "file", filename, config.AmiLogFieldError, err)
+			}
+			continue
+		}
+		if err := w.write(batch, false); err != nil {
+			return err
+		}
+		if err := os.Remove(filename); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (w *AMICdrWriter) load(filename string) ([]AMICdr, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	var batch []AMICdr
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		var r AMICdr
+		if err := json.Unmarshal(scanner.Bytes(), &r); err != nil {
+			return nil, err
+		}
+		batch = append(batch, r)
+	}
+	return batch, scanner.Err()
+}
@@ -0,0 +1,374 @@
+package ami
+
+import (
+	"context"
+	"encoding/json"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pnguyen215/voipkit/pkg/ami/config"
+)
+
+// amiCelTimeLayouts are the layouts of the CEL EventTime (dateformat of cel.conf) and of the queue_log time
+var amiCelTimeLayouts = []string{
+	"2006-01-02 15:04:05.000000",
+	config.DateTimeFormat20060102150405,
+	time.RFC3339Nano,
+}
+
+func NewAMICel() *AMICel {
+	r := &AMICel{}
+	r.SetEvent(config.AmiListenerEventCel)
+	return r
+}
+
+func (r *AMICel) SetEvent(value string) *AMICel {
+	r.Event = TrimStringSpaces(value)
+	return r
+}
+
+func (r *AMICel) SetEventName(value config.AmiCelEventKind) *AMICel {
+	r.EventName = config.AmiCelEventKind(strings.ToUpper(TrimStringSpaces(string(value))))
+	return r
+}
+
+func (r *AMICel) SetEventTime(value time.Time) *AMICel {
+	r.EventTime = value
+	return r
+}
+
+// SetEventTimeWith
+// SetEventTimeWith parses the event time, either as the unix time with microseconds (i.e: 1700000000.123456)
+// or as the date time with optional microseconds (i.e: 2023-11-14 22:13:20.123456).
+func (r *AMICel) SetEventTimeWith(value string) *AMICel {
+	if t, ok := parseEventTime(value); ok {
+		r.SetEventTime(t)
+	}
+	return r
+}
+
+// parseEventTime parses the time of the logs (CEL, queue_log), either as the unix time
+// with optional microseconds or as the date time with optional microseconds
+func parseEventTime(value string) (time.Time, bool) {
+	value = TrimStringSpaces(value)
+	if IsStringEmpty(value) {
+		return time.Time{}, false
+	}
+	if v, err := strconv.ParseFloat(value, 64); err == nil {
+		seconds, fraction := math.Modf(v)
+		return time.Unix(int64(seconds), int64(math.Round(fraction*1e6))*1e3), true
+	}
+	for _, layout := range amiCelTimeLayouts {
+		if t, err := time.Parse(layout, value); err == nil {
+			return t, true
+		}
+	}
+	return time.Time{}, false
+}
+
+func (r *AMICel) Json() string {
+	return JsonString(r)
+}
+
+// ExtraMap
+// ExtraMap returns the extra field decoded as a JSON object, or an empty map.
+func (r *AMICel) ExtraMap() map[string]interface{} {
+	values := make(map[string]interface{})
+	if IsStringEmpty(r.Extra) {
+		return values
+	}
+	json.Unmarshal([]byte(r.Extra), &values)
+	return values
+}
+
+// ExtraString
+// ExtraString returns the extra value of the key as text.
+func (r *AMICel) ExtraString(key string) string {
+	v, ok := r.ExtraMap()[key]
+	if !ok || v == nil {
+		return ""
+	}
+	switch value := v.(type) {
+	case string:
+		return value
+	case float64:
+		return strconv.FormatFloat(value, 'f', -1, 64)
+	}
+	return JsonString(v)
+}
+
+// HangupCause
+// HangupCause returns the Q.850 hangup cause of the HANGUP event, or 0.
+func (r *AMICel) HangupCause() int {
+	v, _ := strconv.Atoi(r.ExtraString(config.AmiCelExtraHangupCause))
+	return v
+}
+
+func (r *AMICel) Is(kind config.AmiCelEventKind) bool {
+	return strings.EqualFold(string(r.EventName), string(kind))
+}
+
+func (r *AMICel) IsCelTransfer() bool {
+	return r.Is(config.AmiCelBlindTransfer) || r.Is(config.AmiCelAttendedTransfer)
+}
+
+// ParseCel
+// ParseCel parses the CEL event, the keys are translated by the dictionary if any.
+func ParseCel(e *AMIMessage, d *AMIDictionary) *AMICel {
+	if d == nil {
+		d = NewDictionary()
+	}
+	if !d.EnabledForceTranslate {
+		d.SetEnabledForceTranslate(true)
+	}
+	r := NewAMICel().
+		SetEventName(config.AmiCelEventKind(e.FieldDictionaryOrRefer(d, config.AmiJsonFieldEventName, "EventName"))).
+		SetEventTimeWith(e.FieldDictionaryOrRefer(d, config.AmiJsonFieldEventTime, "EventTime"))
+	r.AccountCode = e.FieldDictionaryOrRefer(d, config.AmiJsonFieldAccountCode, "AccountCode")
+	r.CallerIdNumber = e.FieldDictionaryOrRefer(d, config.AmiJsonFieldCallerIdNumber, "CallerIDnum")
+	r.CallerIdName = e.FieldDictionaryOrRefer(d, config.AmiJsonFieldCallerIdName, "CallerIDname")
+	r.CallerIdAni = e.FieldDictionaryOrRefer(d, config.AmiJsonFieldCallerIdAni, "CallerIDani")
+	r.CallerIdRdnis = e.FieldDictionaryOrRefer(d, config.AmiJsonFieldCallerIdRdnis, "CallerIDrdnis")
+	r.CallerIdDnid = e.FieldDictionaryOrRefer(d, config.AmiJsonFieldCallerIdDnid, "CallerIDdnid")
+	r.Exten = e.FieldDictionaryOrRefer(d, config.AmiJsonFieldExten, "Exten")
+	r.Context = e.FieldDictionaryOrRefer(d, config.AmiJsonFieldContext, "Context")
+	r.Channel = e.FieldDictionaryOrRefer(d, config.AmiJsonFieldChannel, "Channel")
+	r.Application = e.FieldDictionaryOrRefer(d, config.AmiJsonFieldApplication, "Application")
+	r.AppData = e.FieldDictionaryOrRefer(d, config.AmiJsonFieldAppData, "AppData")
+	r.AmaFlags = e.FieldDictionaryOrRefer(d, config.AmiJsonFieldAmaFlags, "AMAFlags")
+	r.UniqueId = e.FieldDictionaryOrRefer(d, config.AmiJsonFieldUniqueId, "UniqueID")
+	r.LinkedId = e.FieldDictionaryOrRefer(d, config.AmiJsonFieldLinkedId, "LinkedID")
+	r.UserField = e.FieldDictionaryOrRefer(d, config.AmiJsonFieldUserField, "UserField")
+	r.Peer = e.FieldDictionaryOrRefer(d, config.AmiJsonFieldPeer, "Peer")
+	r.PeerAccount = e.FieldDictionaryOrRefer(d, config.AmiJsonFieldPeerAccount, "PeerAccount")
+	r.Extra = e.FieldDictionaryOrRefer(d, config.AmiJsonFieldExtra, "Extra")
+	r.Privilege = e.FieldDictionaryOrRefer(d, config.AmiJsonFieldPrivilege, "Privilege")
+	if IsStringEmpty(r.LinkedId) {
+		r.LinkedId = r.UniqueId
+	}
+	return r
+}
+
+func NewAMICelAggregator() *AMICelAggregator {
+	a := &AMICelAggregator{}
+	a.calls = make(map[string]*AMICelCall)
+	a.SetMaxAge(6 * time.Hour)
+	return a
+}
+
+func (a *AMICelAggregator) SetMaxAge(value time.Duration) *AMICelAggregator {
+	a.MaxAge = value
+	return a
+}
+
+func (a *AMICelAggregator) SetDictionary(value *AMIDictionary) *AMICelAggregator {
+	a.dictionary = value
+	return a
+}
+
+// OnCall
+// OnCall registers the callback of the rebuilt calls, raised on LINKEDID_END
+// or when an incomplete call is evicted (Completed is false).
+func (a *AMICelAggregator) OnCall(callback func(AMICelCall)) *AMICelAggregator {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	a.callbacks = append(a.callbacks, callback)
+	return a
+}
+
+// Apply
+// Apply parses the CEL event and adds it to its call.
+func (a *AMICelAggregator) Apply(e *AMIMessage) (*AMICelCall, bool) {
+	if e == nil || !strings.EqualFold(e.Field(config.AmiEventKey), config.AmiListenerEventCel) {
+		return nil, false
+	}
+	return a.Add(ParseCel(e, a.dictionary))
+}
+
+// Add
+// Add adds the CEL record to its call. It returns the snapshot of the call,
+// and true if the call is completed by this record.
+func (a *AMICelAggregator) Add(r *AMICel) (*AMICelCall, bool) {
+	if r == nil || IsStringEmpty(r.LinkedId) {
+		return nil, false
+	}
+	a.mutex.Lock()
+	call, ok := a.calls[r.LinkedId]
+	if !ok {
+		call = &AMICelCall{LinkedId: r.LinkedId, StartedAt: r.EventTime, Channels: make(map[string]AMICelChannel)}
+		a.calls[r.LinkedId] = call
+	}
+	call.apply(r)
+	snapshot := call.clone()
+	var callbacks []func(AMICelCall)
+	if call.Completed {
+		delete(a.calls, r.LinkedId)
+		callbacks = a.callbacks
+	}
+	a.mutex.Unlock()
+	for _, callback := range callbacks {
+		callback(*snapshot)
+	}
+	return snapshot, snapshot.Completed
+}
+
+// Call
+// Call returns the snapshot of the call in progress.
+func (a *AMICelAggregator) Call(linkedId string) (*AMICelCall, bool) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	call, ok := a.calls[linkedId]
+	if !ok {
+		return nil, false
+	}
+	return call.clone(), true
+}
+
+// Pending
+// Pending returns the number of calls in progress.
+func (a *AMICelAggregator) Pending() int {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	return len(a.calls)
+}
+
+// Evict
+// Evict removes the calls without event since MaxAge, i.e: the LINKEDID_END was lost.
+func (a *AMICelAggregator) Evict(at time.Time) []AMICelCall {
+	if a.MaxAge <= 0 {
+		return nil
+	}
+	var evicted []AMICelCall
+	a.mutex.Lock()
+	for id, call := range a.calls {
+		last := call.StartedAt
+		if n := len(call.Events); n > 0 {
+			last = call.Events[n-1].EventTime
+		}
+		if at.Sub(last) >= a.MaxAge {
+			call.finish(last)
+			evicted = append(evicted, *call.clone())
+			delete(a.calls, id)
+		}
+	}
+	callbacks := a.callbacks
+	a.mutex.Unlock()
+	for _, call := range evicted {
+		for _, callback := range callbacks {
+			callback(call)
+		}
+	}
+	return evicted
+}
+
+func (a *AMICelAggregator) Open(c *AMI) {
+	events := []string{config.AmiListenerEventCel}
+	event := c.OnEvents(events...)
+	defer c.Unsubscribes(event, events...)
+	ctx := c.Context()
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+	for {
+		select {
+		case message, ok := <-event:
+			if !ok {
+				return
+			}
+			a.Apply(message)
+		case at := <-ticker.C:
+			a.Evict(at)
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (a *AMICelAggregator) OpenAsyncFunc(c *AMI) {
+	go func() {
+		a.Open(c)
+	}()
+}
+
+func (call *AMICelCall) apply(r *AMICel) {
+	call.Events = append(call.Events, *r)
+	if call.StartedAt.IsZero() || (!r.EventTime.IsZero() && r.EventTime.Before(call.StartedAt)) {
+		call.StartedAt = r.EventTime
+	}
+	ch, ok := call.Channels[r.UniqueId]
+	if !ok && !IsStringEmpty(r.UniqueId) {
+		ch = AMICelChannel{UniqueId: r.UniqueId, Channel: r.Channel, StartedAt: r.EventTime}
+	}
+	switch {
+	case r.Is(config.AmiCelChanStart):
+		ch.StartedAt = r.EventTime
+		ch.CallerId = r.CallerIdNumber
+		ch.Exten = r.Exten
+		ch.Context = r.Context
+		// the originating channel holds the caller of the whole call
+		if r.UniqueId == r.LinkedId {
+			call.Caller = r.CallerIdNumber
+			call.Dnid = r.CallerIdDnid
+			if IsStringEmpty(call.Dnid) {
+				call.Dnid = r.Exten
+			}
+			call.AccountCode = r.AccountCode
+		}
+	case r.Is(config.AmiCelAnswer):
+		ch.AnsweredAt = r.EventTime
+	case r.Is(config.AmiCelBridgeEnter):
+		// the call is answered once two parties are bridged, not when an IVR answers
+		if !call.Answered {
+			call.Answered = true
+			call.AnsweredAt = r.EventTime
+		}
+	case r.Is(config.AmiCelHangup):
+		ch.EndedAt = r.EventTime
+		ch.HangupCause = r.HangupCause()
+		ch.DialStatus = r.ExtraString(config.AmiCelExtraDialStatus)
+	case r.Is(config.AmiCelChanEnd):
+		if ch.EndedAt.IsZero() {
+			ch.EndedAt = r.EventTime
+		}
+	case r.IsCelTransfer():
+		call.Transfers++
+	case r.Is(config.AmiCelParkStart):
+		call.Parked = true
+	case r.Is(config.AmiCelPickup):
+		call.PickedUp = true
+	case r.Is(config.AmiCelLinkedIdEnd):
+		call.Completed = true
+		call.finish(r.EventTime)
+	}
+	if !IsStringEmpty(ch.UniqueId) {
+		call.Channels[ch.UniqueId] = ch
+	}
+}
+
+func (call *AMICelCall) finish(at time.Time) {
+	call.EndedAt = at
+	if !call.StartedAt.IsZero() && at.After(call.StartedAt) {
+		call.Duration = int(at.Sub(call.StartedAt).Seconds())
+	}
+	if call.Answered && at.After(call.AnsweredAt) {
+		call.BillableSeconds = int(at.Sub(call.AnsweredAt).Seconds())
+	}
+}
+
+func (call *AMICelCall) clone() *AMICelCall {
+	c := *call
+	c.Channels = make(map[string]AMICelChannel, len(call.Channels))
+	for k, v := range call.Channels {
+		c.Channels[k] = v
+	}
+	c.Events = append([]AMICel(nil), call.Events...)
+	return &c
+}
+
+func (call *AMICelCall) Json() string {
+	return JsonString(call)
+}
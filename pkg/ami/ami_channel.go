@@ -36,16 +36,16 @@ func (c *AMIChannel) SetExtension(extension string) *AMIChannel {
 
 func (c *AMIChannel) Verify(regex string, extension string) bool {
 	if strings.EqualFold(regex, "") {
-		log.Printf(config.AmiErrorFieldRequired, "Regex")
+		componentLog(config.AmiLogComponentCall).Debug("channel not verified, regex is required")
 		return false
 	}
 	if strings.EqualFold(extension, "") {
-		log.Printf(config.AmiErrorFieldRequired, "Extension")
+		componentLog(config.AmiLogComponentCall).Debug("channel not verified, extension is required")
 		return false
 	}
 	_regexp, err := regexp.Compile(regex)
 	if err != nil {
-		log.Printf("regex '%v' has an error compile occurred: %v", regex, err.Error())
+		componentLog(config.AmiLogComponentCall).Warn("channel not verified, regex is invalid", "regex", regex, config.AmiLogFieldError, err)
 		return false
 	}
 	match := _regexp.MatchString(extension)
@@ -124,7 +124,7 @@ func (c *AMIChannel) JoinChannelWith(protocol, extension string) string {
 	c.SetChannelProtocol(protocol)
 
 	if strings.EqualFold(extension, "") {
-		log.Printf(config.AmiErrorFieldRequired, "Extension")
+		componentLog(config.AmiLogComponentCall).Debug("channel not joined, extension is required")
 		return extension
 	}
 
@@ -173,6 +173,15 @@ func Originate(ctx context.Context, s AMISocket, originate AMIOriginate) (AmiRep
 	return callback.Send()
 }
 
+// OriginateWithId originates a call using the given ActionID, so that the OriginateResponse event
+// of an asynchronous originate can be correlated to it.
+func OriginateWithId(ctx context.Context, s AMISocket, id string, originate AMIOriginate) (AmiReply, error) {
+	c := NewCommand().SetId(id).SetAction(config.AmiActionOriginate)
+	c.SetVCmd(originate)
+	callback := NewAmiCallbackService(ctx, s, c, []string{}, []string{})
+	return callback.Send()
+}
+
 // ParkedCalls list parked calls.
 func ParkedCalls(ctx context.Context, s AMISocket) ([]AmiReply, error) {
 	c := NewCommand().SetId(s.UUID).SetAction(config.AmiActionParkedCalls)
@@ -182,14 +191,29 @@ func ParkedCalls(ctx context.Context, s AMISocket) ([]AmiReply, error) {
 }
 
 // Park parks a channel.
+// channel2 is the timeout channel, which is dialed back if the parked channel times out (field TimeoutChannel).
+// The timeout is specified in milliseconds.
 func Park(ctx context.Context, s AMISocket, channel1, channel2 string, timeout int, parkinglot string) (AmiReply, error) {
-	c := NewCommand().SetId(s.UUID).SetAction(config.AmiActionHangup)
-	c.SetV(map[string]interface{}{
-		config.AmiFieldChannel:    channel1,
-		config.AmiFieldChannel2:   channel2,
-		config.AmiFieldTimeout:    timeout,
-		config.AmiFieldParkinglot: parkinglot,
-	})
+	return ParkWith(ctx, s, channel1, channel2, "", timeout, parkinglot)
+}
+
+// ParkWith parks a channel, announcing the parking space to announceChannel when it is bridged.
+func ParkWith(ctx context.Context, s AMISocket, channel, timeoutChannel, announceChannel string, timeout int, parkinglot string) (AmiReply, error) {
+	params := map[string]interface{}{
+		config.AmiFieldChannel: channel,
+		config.AmiFieldTimeout: timeout,
+	}
+	if len(timeoutChannel) > 0 {
+		params[config.AmiFieldTimeoutChannel] = timeoutChannel
+	}
+	if len(announceChannel) > 0 {
+		params[config.AmiFieldAnnounceChannel] = announceChannel
+	}
+	if len(parkinglot) > 0 {
+		params[config.AmiFieldParkinglot] = parkinglot
+	}
+	c := NewCommand().SetId(s.UUID).SetAction(config.AmiActionPark)
+	c.SetV(params)
 	callback := NewAmiCallbackService(ctx, s, c, []string{}, []string{})
 	return callback.Send()
 }
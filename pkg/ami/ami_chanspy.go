@@ -67,8 +67,7 @@ func (s *AMIChanspy) CommandChanspy(c_extension string) string {
 func Chanspy(ctx context.Context, s AMISocket, ch AMIChanspy) (AmiReplies, error) {
 	ok := config.AmiChanspy[ch.Join]
 	if !ok {
-		msg := fmt.Sprintf(config.AmiErrorChanspyMessage, strings.Join(GetKeys(config.AmiChanspy), ","))
-		D().Warn(msg)
+		s.Logger(config.AmiLogComponentCall).Warn("chanspy join is invalid", "join", ch.Join, "allowed", strings.Join(GetKeys(config.AmiChanspy), ","))
 		return nil, fmt.Errorf(config.AmiErrorInvalidChanspy)
 	}
 	if IsStringEmpty(ch.ExtensionConnected) {
@@ -97,10 +96,8 @@ func Chanspy(ctx context.Context, s AMISocket, ch AMIChanspy) (AmiReplies, error
 	channelId := ch.CommandChanspy(extensionConnected)
 	cmd := fmt.Sprintf("channel originate %s application ChanSpy %s", extensionJoined, channelId)
 	if ch.DebugMode {
-		D().Debug("Chanspy command: %v", cmd)
-		D().Debug("Chanspy channel_id: %v", channelId)
-		D().Debug("Chanspy extension.connected: %v", extensionConnected)
-		D().Debug("Chanspy extension.joined: %v", extensionJoined)
+		s.Logger(config.AmiLogComponentCall).Debug("chanspy", "command", cmd, config.AmiLogFieldChannel, channelId,
+			"extension_connected", extensionConnected, "extension_joined", extensionJoined)
 	}
 	return Command(ctx, s, cmd)
 }
@@ -74,6 +74,27 @@ func (a *AmiClient) Timeout() time.Duration {
 	return a.timeout
 }
 
+// SetLogger
+// SetLogger sets the logger of the client, its sockets and its core, instead of the logger of the library (see SetLogger).
+func (a *AmiClient) SetLogger(value AmiLogger) *AmiClient {
+	a.logger = value
+	return a
+}
+
+// SetTap
+// SetTap sets the tap of the frames read and written on the connections of the client (see NewAMITap).
+func (a *AmiClient) SetTap(value *AMITap) *AmiClient {
+	a.tap = value
+	return a
+}
+
+// SetTracer
+// SetTracer sets the tracer of the actions and the events of the client, instead of the tracer of the library (see SetTracer).
+func (a *AmiClient) SetTracer(value AmiTracer) *AmiClient {
+	a.tracer = value
+	return a
+}
+
 func (a *AmiClient) String() string {
 	var builder strings.Builder
 	builder.WriteString(fmt.Sprintf("host=%v;", a.host))
@@ -4,7 +4,6 @@ import (
 	"bytes"
 	"context"
 	"fmt"
-	"log"
 	"strings"
 	"time"
 
@@ -48,13 +47,31 @@ func (a *AMICommand) TransformCommand(c *AMICommand) ([]byte, error) {
 	}
 	if len(c.ID) <= 0 {
 		c.ID, _ = GenUUID()
-		log.Printf("TransformCommand for Action = %v has been generated ID = %v", c.Action, c.ID)
+		componentLog(config.AmiLogComponentCommand).Debug("action id generated", config.AmiLogFieldAction, c.Action, config.AmiLogFieldActionId, c.ID)
 	}
 	return Marshal(c)
 }
 
 // Send
+// Send sends the command and reads its reply, once the action in flight on the socket is done.
 func (a *AMICommand) Send(ctx context.Context, socket AMISocket, c *AMICommand) (AmiReply, error) {
+	unlock, err := socket.lock(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+	return a.send(ctx, socket, c)
+}
+
+// send sends the command and reads its reply, the socket must be locked
+func (a *AMICommand) send(ctx context.Context, socket AMISocket, c *AMICommand) (reply AmiReply, err error) {
+	defer func(start time.Time) {
+		Metrics().ObserveAction(c.Action, metricOutcome(err, reply.Get(strings.ToLower(config.AmiResponseKey))), time.Since(start))
+	}(time.Now())
+	ctx, trace := startTrace(ctx, socket, c)
+	defer func() {
+		trace.end(ctx, reply.GetFold(config.AmiResponseKey), reply.GetFold(config.AmiFieldUniqueId), err)
+	}()
 	b, err := a.TransformCommand(c)
 	if err != nil {
 		return nil, err
@@ -66,7 +83,20 @@ func (a *AMICommand) Send(ctx context.Context, socket AMISocket, c *AMICommand)
 }
 
 // SendLevel
-func (a *AMICommand) SendLevel(ctx context.Context, socket AMISocket, c *AMICommand) (AmiReplies, error) {
+// SendLevel sends the command and reads its replies, see Send.
+func (a *AMICommand) SendLevel(ctx context.Context, socket AMISocket, c *AMICommand) (replies AmiReplies, err error) {
+	unlock, err := socket.lock(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+	defer func(start time.Time) {
+		Metrics().ObserveAction(c.Action, metricOutcome(err, replies.Get(strings.ToLower(config.AmiResponseKey))), time.Since(start))
+	}(time.Now())
+	ctx, trace := startTrace(ctx, socket, c)
+	defer func() {
+		trace.end(ctx, replies.Get(strings.ToLower(config.AmiResponseKey)), replies.Get(strings.ToLower(config.AmiFieldUniqueId)), err)
+	}()
 	b, err := a.TransformCommand(c)
 	if err != nil {
 		return nil, err
@@ -84,7 +114,21 @@ func (a *AMICommand) SendLevel(ctx context.Context, socket AMISocket, c *AMIComm
 // 2. AMICommand - to build command cli will be sent to server
 // 3. acceptedEvents - select event will captured as response
 // 4. ignoreEvents - the event will been stopped fetching command
-func (a *AMICommand) DoGetResult(ctx context.Context, s AMISocket, c *AMICommand, acceptedEvents []string, ignoreEvents []string) ([]AmiReply, error) {
+// The socket is held until the result is read, see Send.
+func (a *AMICommand) DoGetResult(ctx context.Context, s AMISocket, c *AMICommand, acceptedEvents []string, ignoreEvents []string) (response []AmiReply, err error) {
+	unlock, err := s.lock(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+	status, reply := "", ""
+	defer func(start time.Time) {
+		Metrics().ObserveAction(c.Action, metricOutcome(err, status), time.Since(start))
+	}(time.Now())
+	ctx, trace := startTrace(ctx, s, c)
+	defer func() {
+		trace.end(ctx, reply, "", err)
+	}()
 	bytes, err := c.TransformCommand(c)
 
 	if err != nil {
@@ -95,7 +139,7 @@ func (a *AMICommand) DoGetResult(ctx context.Context, s AMISocket, c *AMICommand
 		return nil, err
 	}
 
-	response := make([]AmiReply, 0)
+	response = make([]AmiReply, 0)
 
 	for {
 		raw, err := c.Read(ctx, s)
@@ -104,18 +148,28 @@ func (a *AMICommand) DoGetResult(ctx context.Context, s AMISocket, c *AMICommand
 		}
 		_event := raw.Get(strings.ToLower(config.AmiEventKey))
 		_response := raw.Get(strings.ToLower(config.AmiResponseKey))
+		if _event != "" {
+			trace.event(ctx, raw)
+		}
+		if reply == "" {
+			reply = _response
+			status = _response
+		}
 
 		if len(acceptedEvents) == 0 {
 			if s.DebugMode {
-				log.Printf(config.AmiErrorMissingSocketEvent, _event, _response)
+				s.Logger(config.AmiLogComponentCommand).Debug("no accepted events, stop reading the result",
+					config.AmiLogFieldAction, c.Action, config.AmiLogFieldActionId, c.ID, config.AmiLogFieldEvent, _event, "response", _response)
 			}
 			break
 		}
 
 		if len(ignoreEvents) > 0 {
 			if slices.Contains(ignoreEvents, _event) || (_response != "" && !strings.EqualFold(_response, config.AmiStatusSuccessKey)) {
+				status = _response
 				if s.DebugMode {
-					log.Printf(config.AmiErrorBreakSocketIgnoredEvent, _event)
+					s.Logger(config.AmiLogComponentCommand).Debug("ignored event or failure, stop reading the result",
+						config.AmiLogFieldAction, c.Action, config.AmiLogFieldActionId, c.ID, config.AmiLogFieldEvent, _event, "response", _response)
 				}
 				break
 			}
@@ -146,8 +200,8 @@ func (a *AMICommand) Read(ctx context.Context, socket AMISocket) (AmiReply, erro
 		if socket.MaxConcurrencyMillis > 0 {
 			if concurrency >= socket.MaxConcurrencyMillis {
 				if socket.DebugMode {
-					D().Warn("Read(). max over concurrency: %v (ms) and the concurrency allowed: %v (ms)",
-						concurrency, socket.MaxConcurrencyMillis)
+					socket.Logger(config.AmiLogComponentCommand).Warn("reading took over the max concurrency",
+						"concurrency_millis", concurrency, "max_concurrency_millis", socket.MaxConcurrencyMillis)
 				}
 				break
 			}
@@ -177,8 +231,8 @@ func (a *AMICommand) ReadLevel(ctx context.Context, socket AMISocket) (AmiReplie
 		if socket.MaxConcurrencyMillis > 0 {
 			if concurrency >= socket.MaxConcurrencyMillis {
 				if socket.DebugMode {
-					D().Warn("ReadLevel(). max over concurrency: %v (ms) and the concurrency allowed: %v (ms)",
-						concurrency, socket.MaxConcurrencyMillis)
+					socket.Logger(config.AmiLogComponentCommand).Warn("reading took over the max concurrency",
+						"concurrency_millis", concurrency, "max_concurrency_millis", socket.MaxConcurrencyMillis)
 				}
 				break
 			}
@@ -0,0 +1,452 @@
+package ami
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pnguyen215/voipkit/pkg/ami/config"
+)
+
+// confBridgeEvents contains the events which update the state of conference rooms.
+var confBridgeEvents = []string{
+	config.AmiListenerEventConfbridgeStart,
+	config.AmiListenerEventConfbridgeEnd,
+	config.AmiListenerEventConfbridgeJoin,
+	config.AmiListenerEventConfbridgeLeave,
+	config.AmiListenerEventConfbridgeTalking,
+	config.AmiListenerEventConfbridgeMute,
+	config.AmiListenerEventConfbridgeUnMute,
+	config.AmiListenerEventConfbridgeRecord,
+	config.AmiListenerEventConfbridgeStopRecord,
+}
+
+// NewAMIConfBridgeManager
+// NewAMIConfBridgeManager creates a ConfBridge room manager. The core is used to perform room operations,
+// it might be nil when the manager only tracks rooms.
+func NewAMIConfBridgeManager(core *AMICore) *AMIConfBridgeManager {
+	m := &AMIConfBridgeManager{}
+	m.core = core
+	m.rooms = make(map[string]*amiConfRoomState)
+	return m
+}
+
+// SetAutoRecordParticipants
+// SetAutoRecordParticipants starts recording a room as soon as the given number of participants joined,
+// i.e: 2 to start recording when the second participant joins. Zero disables it.
+func (m *AMIConfBridgeManager) SetAutoRecordParticipants(value int) *AMIConfBridgeManager {
+	m.AutoRecordParticipants = value
+	return m
+}
+
+// SetRecordFileFunc
+// SetRecordFileFunc sets the function producing the record file of automatic recordings.
+// If not set, Asterisk uses its default file name.
+func (m *AMIConfBridgeManager) SetRecordFileFunc(value func(conference string) string) *AMIConfBridgeManager {
+	m.RecordFileFunc = value
+	return m
+}
+
+// OnChange
+// OnChange registers a callback which is invoked with the ConfBridge event name and the room after each update.
+func (m *AMIConfBridgeManager) OnChange(callback func(event string, room AMIConfRoom)) *AMIConfBridgeManager {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.onChange = append(m.onChange, callback)
+	return m
+}
+
+func (m *AMIConfBridgeManager) Json() string {
+	return JsonString(m.Rooms())
+}
+
+// Seed
+// Seed loads active rooms and their participants from ConfbridgeListRooms and ConfbridgeList.
+func (m *AMIConfBridgeManager) Seed(ctx context.Context) error {
+	if m.core == nil {
+		return fmt.Errorf(config.AmiErrorCoreRequired, config.AmiActionConfbridgeListRooms)
+	}
+	rooms, err := m.core.ConfbridgeListRooms(ctx)
+	if err != nil {
+		return err
+	}
+	for _, room := range rooms {
+		conference := room.GetFold(config.AmiFieldConference)
+		if IsStringEmpty(conference) {
+			continue
+		}
+		participants, err := m.core.ConfbridgeList(ctx, conference)
+		if err != nil {
+			return err
+		}
+		m.SeedWith(room, participants)
+	}
+	return nil
+}
+
+// SeedWith
+// SeedWith initializes a room from a ConfbridgeListRooms item and its ConfbridgeList items.
+func (m *AMIConfBridgeManager) SeedWith(room AmiReply, participants []AmiReply) *AMIConfBridgeManager {
+	conference := room.GetFold(config.AmiFieldConference)
+	if IsStringEmpty(conference) {
+		return m
+	}
+	now := time.Now()
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	r := m.room(conference, now)
+	r.locked = isConfYes(room.GetFold("Locked"))
+	for _, v := range participants {
+		channel := v.GetFold(config.AmiFieldChannel)
+		if IsStringEmpty(channel) {
+			continue
+		}
+		p := &AMIConfParticipant{
+			Conference:     conference,
+			Channel:        channel,
+			UniqueId:       v.GetFold("Uniqueid"),
+			CallerIdNumber: v.GetFold("CallerIDNum"),
+			CallerIdName:   v.GetFold("CallerIDName"),
+			Admin:          isConfYes(v.GetFold("Admin")),
+			Marked:         isConfYes(v.GetFold("MarkedUser")),
+			Muted:          isConfYes(v.GetFold("Muted")),
+			Talking:        isConfYes(v.GetFold("Talking")),
+			JoinedAt:       now,
+		}
+		// AnsweredTime is the number of seconds the participant has been in the conference
+		if seconds, err := strconv.Atoi(v.GetFold("AnsweredTime")); err == nil {
+			p.JoinedAt = now.Add(-time.Duration(seconds) * time.Second)
+		}
+		r.participants[channel] = p
+		if p.JoinedAt.Before(r.startedAt) {
+			r.startedAt = p.JoinedAt
+		}
+	}
+	return m
+}
+
+// Apply
+// Apply feeds a ConfBridge event into the manager. When the automatic recording condition is reached,
+// it starts the recording through the core.
+func (m *AMIConfBridgeManager) Apply(e *AMIMessage) *AMIConfRoom {
+	return m.ApplyContext(context.Background(), e)
+}
+
+// ApplyContext
+// ApplyContext is Apply with the context used for the automatic recording action.
+// The events of an unknown room, other than ConfbridgeStart and ConfbridgeJoin, are ignored and nil is returned.
+func (m *AMIConfBridgeManager) ApplyContext(ctx context.Context, e *AMIMessage) *AMIConfRoom {
+	if e == nil {
+		return nil
+	}
+	event := e.Field(config.AmiEventKey)
+	conference := e.Field(config.AmiFieldConference)
+	if IsStringEmpty(conference) || !Contains(confBridgeEvents, event) {
+		return nil
+	}
+	channel := e.Field(config.AmiFieldChannel)
+	now := time.Now()
+	record := false
+
+	m.mutex.Lock()
+	// the rooms are created by Start and Join only, so that a late event after End does not recreate the room
+	r, ok := m.rooms[conference]
+	if !ok {
+		if !strings.EqualFold(event, config.AmiListenerEventConfbridgeStart) && !strings.EqualFold(event, config.AmiListenerEventConfbridgeJoin) {
+			m.mutex.Unlock()
+			return nil
+		}
+		r = m.room(conference, now)
+	}
+	switch {
+	case strings.EqualFold(event, config.AmiListenerEventConfbridgeStart):
+		r.startedAt = now
+	case strings.EqualFold(event, config.AmiListenerEventConfbridgeJoin):
+		r.participants[channel] = &AMIConfParticipant{
+			Conference:     conference,
+			Channel:        channel,
+			UniqueId:       e.Field("Uniqueid"),
+			CallerIdNumber: e.Field("CallerIDNum"),
+			CallerIdName:   e.Field("CallerIDName"),
+			Admin:          isConfYes(e.Field("Admin")),
+			Muted:          isConfYes(e.Field("Muted")),
+			JoinedAt:       now,
+		}
+		if m.AutoRecordParticipants > 0 && !r.recording && len(r.participants) >= m.AutoRecordParticipants {
+			// mark it right away, so a burst of joins does not start several recordings
+			r.recording = true
+			record = m.core != nil
+		}
+	case strings.EqualFold(event, config.AmiListenerEventConfbridgeLeave):
+		delete(r.participants, channel)
+	case strings.EqualFold(event, config.AmiListenerEventConfbridgeTalking):
+		if p, ok := r.participants[channel]; ok {
+			p.Talking = strings.EqualFold(e.Field("TalkingStatus"), "on")
+		}
+	case strings.EqualFold(event, config.AmiListenerEventConfbridgeMute):
+		if p, ok := r.participants[channel]; ok {
+			p.Muted = true
+		}
+	case strings.EqualFold(event, config.AmiListenerEventConfbridgeUnMute):
+		if p, ok := r.participants[channel]; ok {
+			p.Muted = false
+		}
+	case strings.EqualFold(event, config.AmiListenerEventConfbridgeRecord):
+		r.recording = true
+	case strings.EqualFold(event, config.AmiListenerEventConfbridgeStopRecord):
+		r.recording = false
+	case strings.EqualFold(event, config.AmiListenerEventConfbridgeEnd):
+		delete(m.rooms, conference)
+	}
+	room := r.snapshot()
+	callbacks := m.onChange
+	m.mutex.Unlock()
+
+	if record {
+		if _, err := m.StartRecording(ctx, conference); err != nil {
+			componentLog(config.AmiLogComponentConference).Error("auto recording failed", "conference", conference, config.AmiLogFieldError, err)
+			m.mutex.Lock()
+			if r, ok := m.rooms[conference]; ok {
+				r.recording = false
+			}
+			m.mutex.Unlock()
+		}
+	}
+	for _, fn := range callbacks {
+		fn(event, room)
+	}
+	return &room
+}
+
+// Room
+// Room returns the state of the conference room.
+func (m *AMIConfBridgeManager) Room(conference string) (AMIConfRoom, bool) {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+	r, ok := m.rooms[conference]
+	if !ok {
+		return AMIConfRoom{}, false
+	}
+	return r.snapshot(), true
+}
+
+// Rooms
+// Rooms returns the state of all active conference rooms, ordered by conference.
+func (m *AMIConfBridgeManager) Rooms() []AMIConfRoom {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+	response := make([]AMIConfRoom, 0, len(m.rooms))
+	for _, r := range m.rooms {
+		response = append(response, r.snapshot())
+	}
+	sort.Slice(response, func(i, j int) bool {
+		return response[i].Conference < response[j].Conference
+	})
+	return response
+}
+
+// MuteAllExceptAdmins
+// MuteAllExceptAdmins mutes every participant of the room which is not an admin.
+func (m *AMIConfBridgeManager) MuteAllExceptAdmins(ctx context.Context, conference string) error {
+	return m.each(ctx, conference, config.AmiActionConfbridgeMute, func(p AMIConfParticipant) bool {
+		return !p.Admin && !p.Muted
+	}, m.core.ConfbridgeMute)
+}
+
+// UnmuteAll
+// UnmuteAll unmutes every muted participant of the room.
+func (m *AMIConfBridgeManager) UnmuteAll(ctx context.Context, conference string) error {
+	return m.each(ctx, conference, config.AmiActionConfbridgeUnmute, func(p AMIConfParticipant) bool {
+		return p.Muted
+	}, m.core.ConfbridgeUnmute)
+}
+
+// KickAll
+// KickAll removes every participant from the room, which ends the conference.
+func (m *AMIConfBridgeManager) KickAll(ctx context.Context, conference string) error {
+	return m.each(ctx, conference, config.AmiActionConfbridgeKick, func(p AMIConfParticipant) bool {
+		return true
+	}, m.core.ConfbridgeKick)
+}
+
+// Lock
+// Lock locks the room, so that no more participants can join.
+// Asterisk sends no event once locked, so the room is marked locked on the success reply.
+func (m *AMIConfBridgeManager) Lock(ctx context.Context, conference string) (AmiReply, error) {
+	if m.core == nil {
+		return nil, fmt.Errorf(config.AmiErrorCoreRequired, config.AmiActionConfbridgeLock)
+	}
+	reply, err := m.core.ConfbridgeLock(ctx, conference, "")
+	m.setLocked(conference, reply, err, true)
+	return reply, err
+}
+
+// Unlock
+// Unlock unlocks the room, see Lock.
+func (m *AMIConfBridgeManager) Unlock(ctx context.Context, conference string) (AmiReply, error) {
+	if m.core == nil {
+		return nil, fmt.Errorf(config.AmiErrorCoreRequired, config.AmiActionConfbridgeUnlock)
+	}
+	reply, err := m.core.ConfbridgeUnlock(ctx, conference, "")
+	m.setLocked(conference, reply, err, false)
+	return reply, err
+}
+
+// StartRecording
+// StartRecording starts recording the room, using RecordFileFunc to name the file when set.
+func (m *AMIConfBridgeManager) StartRecording(ctx context.Context, conference string) (AmiReply, error) {
+	if m.core == nil {
+		return nil, fmt.Errorf(config.AmiErrorCoreRequired, config.AmiActionConfbridgeStartRecord)
+	}
+	file := ""
+	if m.RecordFileFunc != nil {
+		file = m.RecordFileFunc(conference)
+	}
+	reply, err := m.core.ConfbridgeStartRecord(ctx, conference, file)
+	if err != nil {
+		return reply, err
+	}
+	if IsFailure(reply) {
+		return reply, fmt.Errorf(config.AmiErrorActionFailed, config.AmiActionConfbridgeStartRecord, reply.Get(config.AmiJsonFieldMessage))
+	}
+	return reply, nil
+}
+
+// StopRecording
+// StopRecording stops recording the room.
+func (m *AMIConfBridgeManager) StopRecording(ctx context.Context, conference string) (AmiReply, error) {
+	if m.core == nil {
+		return nil, fmt.Errorf(config.AmiErrorCoreRequired, config.AmiActionConfbridgeStopRecord)
+	}
+	return m.core.ConfbridgeStopRecord(ctx, conference)
+}
+
+// Open
+// Open consumes ConfBridge events until the AMI connection is closed.
+func (m *AMIConfBridgeManager) Open(c *AMI) {
+	event := c.OnEvents(confBridgeEvents...)
+	defer c.Unsubscribes(event, confBridgeEvents...)
+	ctx := c.Context()
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	for {
+		select {
+		case message, ok := <-event:
+			if !ok {
+				return
+			}
+			m.ApplyContext(ctx, message)
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (m *AMIConfBridgeManager) OpenAsyncFunc(c *AMI) {
+	go func() {
+		m.Open(c)
+	}()
+}
+
+// room returns the state of the conference, creating it when missing. The caller must hold the lock.
+func (m *AMIConfBridgeManager) room(conference string, at time.Time) *amiConfRoomState {
+	r, ok := m.rooms[conference]
+	if !ok {
+		r = &amiConfRoomState{conference: conference, startedAt: at, participants: make(map[string]*AMIConfParticipant)}
+		m.rooms[conference] = r
+	}
+	return r
+}
+
+// setLocked updates the lock of the room on the success reply of ConfbridgeLock or ConfbridgeUnlock.
+func (m *AMIConfBridgeManager) setLocked(conference string, reply AmiReply, err error, locked bool) {
+	if err != nil || IsFailure(reply) {
+		return
+	}
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if r, ok := m.rooms[conference]; ok {
+		r.locked = locked
+	}
+}
+
+func (m *AMIConfBridgeManager) each(ctx context.Context, conference string, action string,
+	filter func(AMIConfParticipant) bool, fn func(context.Context, string, string) (AmiReply, error)) error {
+	if m.core == nil {
+		return fmt.Errorf(config.AmiErrorCoreRequired, action)
+	}
+	room, ok := m.Room(conference)
+	if !ok {
+		return fmt.Errorf(config.AmiErrorConferenceNotFound, conference)
+	}
+	var errs []string
+	for _, p := range room.Participants {
+		if !filter(p) {
+			continue
+		}
+		reply, err := fn(ctx, conference, p.Channel)
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("%s: %v", p.Channel, err))
+			continue
+		}
+		if IsFailure(reply) {
+			errs = append(errs, fmt.Sprintf("%s: %v", p.Channel, reply.Get(config.AmiJsonFieldMessage)))
+		}
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf(config.AmiErrorActionFailed, action, strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+func (r *amiConfRoomState) snapshot() AMIConfRoom {
+	room := AMIConfRoom{
+		Conference:   r.conference,
+		Locked:       r.locked,
+		Recording:    r.recording,
+		StartedAt:    r.startedAt,
+		Participants: make([]AMIConfParticipant, 0, len(r.participants)),
+	}
+	for _, p := range r.participants {
+		room.Participants = append(room.Participants, *p)
+	}
+	sort.Slice(room.Participants, func(i, j int) bool {
+		return room.Participants[i].JoinedAt.Before(room.Participants[j].JoinedAt)
+	})
+	return room
+}
+
+func (r *AMIConfRoom) Json() string {
+	return JsonString(r)
+}
+
+// Admins returns the admin participants of the room.
+func (r *AMIConfRoom) Admins() []AMIConfParticipant {
+	var response []AMIConfParticipant
+	for _, p := range r.Participants {
+		if p.Admin {
+			response = append(response, p)
+		}
+	}
+	return response
+}
+
+// Talkers returns the participants who are currently talking.
+func (r *AMIConfRoom) Talkers() []AMIConfParticipant {
+	var response []AMIConfParticipant
+	for _, p := range r.Participants {
+		if p.Talking {
+			response = append(response, p)
+		}
+	}
+	return response
+}
+
+func isConfYes(value string) bool {
+	value = strings.ToLower(TrimStringSpaces(value))
+	return value == "yes" || value == "true" || value == "1"
+}
@@ -3,7 +3,6 @@ package ami
 import (
 	"context"
 	"fmt"
-	"log"
 
 	"github.com/pnguyen215/voipkit/pkg/ami/config"
 )
@@ -83,7 +82,7 @@ func (c *AMICore) run(ctx context.Context) {
 		default:
 			event, err := Events(ctx, *c.socket)
 			if err != nil {
-				log.Printf(config.AmiErrorConsumeEvent, err)
+				c.socket.Logger(config.AmiLogComponentEvent).Error("consuming events stopped", config.AmiLogFieldError, err)
 				return
 			}
 			c.event <- event
@@ -380,6 +379,13 @@ func (c *AMICore) Command(ctx context.Context, cmd string) (AmiReplies, error) {
 	return Command(ctx, *c.socket, cmd)
 }
 
+// SendAction
+// SendAction sends any action with the fields, i.e: SendAction(ctx, "PJSIPShowEndpoint", map[string]string{"Endpoint": "1001"}).
+// The response is returned first, followed by the events of the list if any.
+func (c *AMICore) SendAction(ctx context.Context, action string, fields map[string]string) ([]AmiReply, error) {
+	return SendAction(ctx, *c.socket, action, fields)
+}
+
 // CoreSettings shows PBX core settings (version etc).
 func (c *AMICore) GetCoreSettings(ctx context.Context) (AmiReply, error) {
 	return CoreSettings(ctx, *c.socket)
@@ -705,6 +711,12 @@ func (c *AMICore) Originate(ctx context.Context, originate AMIOriginate) (AmiRep
 	return Originate(ctx, *c.socket, originate)
 }
 
+// OriginateWithId
+// OriginateWithId originates a call using the given ActionID.
+func (c *AMICore) OriginateWithId(ctx context.Context, id string, originate AMIOriginate) (AmiReply, error) {
+	return OriginateWithId(ctx, *c.socket, id, originate)
+}
+
 func (c *AMICore) MakeCall(ctx context.Context, originate AMIOriginate) (AmiReply, error) {
 	return c.Originate(ctx, originate)
 }
@@ -715,11 +727,17 @@ func (c *AMICore) ParkedCalls(ctx context.Context) ([]AmiReply, error) {
 }
 
 // Park
-// Park parks a channel.
+// Park parks a channel by the Park action, channel2 is its TimeoutChannel (see Park).
 func (c *AMICore) Park(ctx context.Context, channel1, channel2 string, timeout int, parkinglot string) (AmiReply, error) {
 	return Park(ctx, *c.socket, channel1, channel2, timeout, parkinglot)
 }
 
+// ParkWith
+// ParkWith parks a channel, announcing the parking space to announceChannel when it is bridged.
+func (c *AMICore) ParkWith(ctx context.Context, channel, timeoutChannel, announceChannel string, timeout int, parkinglot string) (AmiReply, error) {
+	return ParkWith(ctx, *c.socket, channel, timeoutChannel, announceChannel, timeout, parkinglot)
+}
+
 // Parkinglots
 func (c *AMICore) Parkinglots(ctx context.Context) ([]AmiReply, error) {
 	return Parkinglots(ctx, *c.socket)
@@ -1323,7 +1341,7 @@ func (c *AMICore) ConfbridgeLock(ctx context.Context, conference string, channel
 // ConfbridgeUnlock
 // ConfbridgeUnlock unlocks a specified conference.
 func (c *AMICore) ConfbridgeUnlock(ctx context.Context, conference string, channel string) (AmiReply, error) {
-	return ConfbridgeUnlock(ctx, *c.socket, config.AmiErrorLoginFailed, channel)
+	return ConfbridgeUnlock(ctx, *c.socket, conference, channel)
 }
 
 // ConfbridgeSetSingleVideoSrc
@@ -2,7 +2,6 @@ package ami
 
 import (
 	"errors"
-	"log"
 	"strings"
 
 	"github.com/pnguyen215/voipkit/pkg/ami/config"
@@ -183,6 +182,21 @@ func (d *AMIDictionary) GetDictionaries() *[]AMIEventDictionary {
 		},
 	})
 
+	dictionaries = append(dictionaries, AMIEventDictionary{
+		EventKey: config.AmiListenerEventCel,
+		Dictionaries: map[string]string{
+			"Eventname":     "event_name",
+			"Eventtime":     "event_time",
+			"Calleridani":   "caller_id_ani",
+			"Calleridrdnis": "caller_id_rdnis",
+			"Calleriddnid":  "caller_id_dnid",
+			"Application":   "application",
+			"Appdata":       "app_data",
+			"Peeraccount":   "peer_account",
+			"Extra":         "extra",
+		},
+	})
+
 	dictionaries = append(dictionaries, AMIEventDictionary{
 		EventKey: config.AmiListenerEventBridgeEnter,
 		Dictionaries: map[string]string{
@@ -228,7 +242,7 @@ func (d *AMIDictionary) GetDictionaries() *[]AMIEventDictionary {
 
 	overlapDictionaries = &dictionaries
 	dictionary, _ := d.FindDictionaryByKey(config.AmiListenerEventCommon)
-	D().Info("Dictionaries (common) was initialized, len: %d", len(dictionary.Dictionaries))
+	componentLog(config.AmiLogComponentDictionary).Debug("dictionaries initialized", "common", len(dictionary.Dictionaries))
 	return overlapDictionaries
 }
 
@@ -342,7 +356,7 @@ func (d *AMIDictionary) Json() string {
 func (d *AMIDictionary) LenTranslatorCommon() int {
 	dictionary, _ := d.FindDictionaryByKey(config.AmiListenerEventCommon)
 	_len := len(dictionary.Dictionaries)
-	log.Printf("Translator dictionaries (common) was added, len = %d", _len)
+	componentLog(config.AmiLogComponentDictionary).Debug("translator dictionaries added", "common", _len)
 	return _len
 }
 
@@ -1,9 +1,6 @@
 package ami
 
 import (
-	"log"
-	"strings"
-
 	"github.com/pnguyen215/voipkit/pkg/ami/config"
 )
 
@@ -141,13 +138,13 @@ func (m *AMIEvent) Reconnect(ins *AMI, err error) {
 				// reconnecting for network error
 				if ErrorAsteriskNetwork.S == e.S {
 					if m.Attempt.DebugMode {
-						D().Error("Ami wrap error occurred: %v", e.Error())
+						ins.Logger(config.AmiLogComponentClient).Error("network error, reconnecting", config.AmiLogFieldError, e)
 					}
 					addr := ins.Conn().RemoteAddr().String()
 					current_socket, err := WithSocket(ins.Context(), addr)
 					if err != nil {
 						if m.Attempt.DebugMode {
-							D().Error("Ami wrap error while reconnecting socket connection: %v", err.Error())
+							ins.Logger(config.AmiLogComponentClient).Error("reconnecting socket failed", config.AmiLogFieldError, err)
 						}
 						break
 					}
@@ -155,20 +152,21 @@ func (m *AMIEvent) Reconnect(ins *AMI, err error) {
 					conn, err := WithCore(ins.Context(), ins.Socket(), ins.Auth())
 					if err != nil {
 						if m.Attempt.DebugMode {
-							D().Error("Ami wrap error while creating server reconnection: %v", err.Error())
+							ins.Logger(config.AmiLogComponentClient).Error("reconnecting core failed", config.AmiLogFieldError, err)
 						}
 						break
 					}
 					// updating stateless
 					ins.SetCore(conn)
 					ins.release(ins.Context())
+					Metrics().ObserveReconnect(config.AmiMetricClientAmi)
 					if m.Attempt.DebugMode {
-						D().Info("Ami socket reconnected successfully")
+						ins.Logger(config.AmiLogComponentClient).Info("socket reconnected")
 					}
 				}
 			}
 		default:
-			D().Error("Ami unknown error occurred: %v", err)
+			ins.Logger(config.AmiLogComponentClient).Error("unknown error", config.AmiLogFieldError, err)
 			m.SetPost(NewAmiPost().SetErr(err))
 			break
 		}
@@ -182,7 +180,7 @@ func (e *AMIEvent) OpenFullEvents(c *AMI) {
 		select {
 		case message := <-all:
 			message.apply(e)
-			log.Printf("ami event: '%s' received: %s", message.Field(strings.ToLower(config.AmiEventKey)), message.Json())
+			c.Logger(config.AmiLogComponentEvent).Debug("event received", config.AmiLogFieldEvent, message.Field(config.AmiEventKey), config.AmiLogFieldUniqueId, message.Field(config.AmiFieldUniqueId), "message", message.Json())
 		case err := <-c.Error():
 			c.Close()
 			e.Reconnect(c, err)
@@ -198,7 +196,7 @@ func (e *AMIEvent) OpenFullEventsTranslator(c *AMI, d *AMIDictionary) {
 		select {
 		case message := <-all:
 			message.apply(e)
-			log.Printf("ami event: '%s' received: %s", message.Field(strings.ToLower(config.AmiEventKey)), message.JsonTranslator(d))
+			c.Logger(config.AmiLogComponentEvent).Debug("event received", config.AmiLogFieldEvent, message.Field(config.AmiEventKey), config.AmiLogFieldUniqueId, message.Field(config.AmiFieldUniqueId), "message", message.JsonTranslator(d))
 		case err := <-c.Error():
 			c.Close()
 			e.Reconnect(c, err)
@@ -231,7 +229,7 @@ func (e *AMIEvent) OpenEvent(c *AMI, name string) {
 		select {
 		case message := <-event:
 			message.apply(e)
-			log.Printf("ami event: '%s' received: %s", name, message.Json())
+			c.Logger(config.AmiLogComponentEvent).Debug("event received", config.AmiLogFieldEvent, name, config.AmiLogFieldUniqueId, message.Field(config.AmiFieldUniqueId), "message", message.Json())
 		case err := <-c.Error():
 			c.Close()
 			e.Reconnect(c, err)
@@ -247,7 +245,7 @@ func (e *AMIEvent) OpenEventTranslator(c *AMI, d *AMIDictionary, name string) {
 		select {
 		case message := <-event:
 			message.apply(e)
-			log.Printf("ami event: '%s' received: %s", name, message.JsonTranslator(d))
+			c.Logger(config.AmiLogComponentEvent).Debug("event received", config.AmiLogFieldEvent, name, config.AmiLogFieldUniqueId, message.Field(config.AmiFieldUniqueId), "message", message.JsonTranslator(d))
 		case err := <-c.Error():
 			c.Close()
 			e.Reconnect(c, err)
@@ -280,7 +278,7 @@ func (e *AMIEvent) OpenEvents(c *AMI, keys ...string) {
 		select {
 		case message := <-event:
 			message.apply(e)
-			log.Printf("ami event(s): '%s' received: %s", keys, message.Json())
+			c.Logger(config.AmiLogComponentEvent).Debug("event received", config.AmiLogFieldEvent, message.Field(config.AmiEventKey), config.AmiLogFieldUniqueId, message.Field(config.AmiFieldUniqueId), "message", message.Json())
 		case err := <-c.Error():
 			c.Close()
 			e.Reconnect(c, err)
@@ -296,7 +294,7 @@ func (e *AMIEvent) OpenEventsTranslator(c *AMI, d *AMIDictionary, keys ...string
 		select {
 		case message := <-event:
 			message.apply(e)
-			log.Printf("ami event(s): '%s' received: %s", keys, message.JsonTranslator(d))
+			c.Logger(config.AmiLogComponentEvent).Debug("event received", config.AmiLogFieldEvent, message.Field(config.AmiEventKey), config.AmiLogFieldUniqueId, message.Field(config.AmiFieldUniqueId), "message", message.JsonTranslator(d))
 		case err := <-c.Error():
 			c.Close()
 			e.Reconnect(c, err)
@@ -0,0 +1,357 @@
+package ami
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pnguyen215/voipkit/pkg/ami/config"
+)
+
+// NewAMIEventStream
+// NewAMIEventStream creates the event stream endpoint, by default it sends a heartbeat every 15 seconds,
+// buffers 256 events per client and keeps the last 1000 events to resume from Last-Event-ID.
+//
+// The clients select the events and the format by the query parameters,
+// i.e: /events?events=Hangup,Queue*&format=translated
+// The request upgrading to WebSocket receives the events as JSON messages {"id", "event", "data"},
+// the other requests receive them as Server-Sent Events.
+func NewAMIEventStream() *AMIEventStream {
+	s := &AMIEventStream{}
+	s.clients = make(map[*amiEventStreamClient]bool)
+	s.SetDictionary(NewDictionary())
+	s.SetHeartbeatInterval(15 * time.Second)
+	s.SetBufferSize(256)
+	s.SetHistorySize(1000)
+	return s
+}
+
+// SetDictionary
+// SetDictionary sets the dictionary of the translated format.
+func (s *AMIEventStream) SetDictionary(dictionary *AMIDictionary) *AMIEventStream {
+	if dictionary != nil {
+		s.dictionary = dictionary
+	}
+	return s
+}
+
+func (s *AMIEventStream) SetHeartbeatInterval(value time.Duration) *AMIEventStream {
+	if value > 0 {
+		s.HeartbeatInterval = value
+	}
+	return s
+}
+
+// SetBufferSize
+// SetBufferSize sets the number of events buffered per client,
+// the client not reading them is disconnected and should resume from its Last-Event-ID.
+func (s *AMIEventStream) SetBufferSize(value int) *AMIEventStream {
+	if value > 0 {
+		s.BufferSize = value
+	}
+	return s
+}
+
+// SetHistorySize
+// SetHistorySize sets the number of the last events kept to resume from Last-Event-ID.
+func (s *AMIEventStream) SetHistorySize(value int) *AMIEventStream {
+	if value >= 0 {
+		s.mutex.Lock()
+		defer s.mutex.Unlock()
+		s.HistorySize = value
+		s.trim()
+	}
+	return s
+}
+
+// SetAuthorizer
+// SetAuthorizer sets the hook authorizing the clients, i.e: checking the bearer token or the session cookie,
+// the client is rejected with 401 on error.
+func (s *AMIEventStream) SetAuthorizer(authorizer func(r *http.Request) error) *AMIEventStream {
+	s.authorizer = authorizer
+	return s
+}
+
+// SetFilter
+// SetFilter sets the hook selecting the events the client of the request is allowed to receive,
+// i.e: the events of the agent only. It is called on every event and must not block.
+func (s *AMIEventStream) SetFilter(filter func(r *http.Request, message *AMIMessage) bool) *AMIEventStream {
+	s.filter = filter
+	return s
+}
+
+// Len
+// Len returns the number of the connected clients.
+func (s *AMIEventStream) Len() int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return len(s.clients)
+}
+
+// Apply
+// Apply numbers the message, keeps it in the history and sends it to the clients matching it.
+func (s *AMIEventStream) Apply(message *AMIMessage) uint64 {
+	if message == nil {
+		return 0
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.sequence++
+	item := AMIEventStreamItem{Id: s.sequence, Event: message.Field(config.AmiEventKey), Message: message}
+	if s.HistorySize > 0 {
+		s.history = append(s.history, item)
+		s.trim()
+	}
+	for client := range s.clients {
+		if !s.match(client, item) {
+			continue
+		}
+		select {
+		case client.events <- item:
+		default:
+			componentLog(config.AmiLogComponentStream).Warn("client too slow, disconnecting", "addr", client.request.RemoteAddr, "id", item.Id)
+			delete(s.clients, client)
+			close(client.events)
+			Metrics().ObserveDroppedEvent(config.AmiMetricSourceStream)
+		}
+	}
+	return item.Id
+}
+
+// Open
+// Open streams the events of the pub-sub until the context is done.
+func (s *AMIEventStream) Open(ctx context.Context, pub *AMIPubSubQueue) {
+	event := pub.Subscribe(config.AmiPubSubKeyRef)
+	if event == nil {
+		return
+	}
+	defer pub.Unsubscribe(config.AmiPubSubKeyRef, event)
+	for {
+		select {
+		case message, ok := <-event:
+			if !ok {
+				return
+			}
+			s.Apply(message)
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (s *AMIEventStream) OpenAsyncFunc(ctx context.Context, pub *AMIPubSubQueue) {
+	go func() {
+		s.Open(ctx, pub)
+	}()
+}
+
+// ServeHTTP
+// ServeHTTP authorizes the client, replays the events after its Last-Event-ID and streams the next ones.
+func (s *AMIEventStream) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if s.authorizer != nil {
+		if err := s.authorizer(r); err != nil {
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+			return
+		}
+	}
+	query := r.URL.Query()
+	format := strings.ToLower(TrimStringSpaces(query.Get(config.AmiEventStreamQueryFormat)))
+	switch format {
+	case "":
+		format = config.AmiEventStreamFormatRaw
+	case config.AmiEventStreamFormatRaw, config.AmiEventStreamFormatTranslated, config.AmiEventStreamFormatPure:
+	default:
+		http.Error(w, fmt.Sprintf("format '%v' is not supported", format), http.StatusBadRequest)
+		return
+	}
+	var patterns []string
+	for _, pattern := range strings.Split(query.Get(config.AmiEventStreamQueryEvents), ",") {
+		pattern = strings.ToLower(TrimStringSpaces(pattern))
+		if IsStringEmpty(pattern) {
+			continue
+		}
+		if _, err := path.Match(pattern, ""); err != nil {
+			http.Error(w, fmt.Sprintf("event pattern '%v' is invalid", pattern), http.StatusBadRequest)
+			return
+		}
+		patterns = append(patterns, pattern)
+	}
+	last := r.Header.Get("Last-Event-ID")
+	if IsStringEmpty(last) {
+		last = query.Get(config.AmiEventStreamQueryLastEventId)
+	}
+	lastId, _ := strconv.ParseUint(TrimStringSpaces(last), 10, 64)
+	if strings.EqualFold(r.Header.Get("Upgrade"), "websocket") {
+		ws, err := UpgradeWebSocket(w, r)
+		if err != nil {
+			componentLog(config.AmiLogComponentStream).Error("upgrading client failed", "addr", r.RemoteAddr, config.AmiLogFieldError, err)
+			return
+		}
+		defer ws.Close()
+		client, replay := s.subscribe(r, patterns, lastId)
+		defer s.unsubscribe(client)
+		s.serveWebSocket(ws, client, replay, format)
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming is not supported", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+	client, replay := s.subscribe(r, patterns, lastId)
+	defer s.unsubscribe(client)
+	s.serveSse(w, flusher, client, replay, format)
+}
+
+func (s *AMIEventStream) serveSse(w http.ResponseWriter, flusher http.Flusher, client *amiEventStreamClient, replay []AMIEventStreamItem, format string) {
+	write := func(item AMIEventStreamItem) error {
+		data, err := json.Marshal(s.encode(item.Message, format))
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", item.Id, strings.NewReplacer("\r", "", "\n", "").Replace(item.Event), data)
+		return err
+	}
+	for _, item := range replay {
+		if err := write(item); err != nil {
+			return
+		}
+	}
+	flusher.Flush()
+	heartbeat := time.NewTicker(s.HeartbeatInterval)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case item, ok := <-client.events:
+			if !ok {
+				return
+			}
+			if err := write(item); err != nil {
+				return
+			}
+			flusher.Flush()
+		case <-heartbeat.C:
+			if _, err := fmt.Fprint(w, ": heartbeat\n\n"); err != nil {
+				return
+			}
+			flusher.Flush()
+		case <-client.request.Context().Done():
+			return
+		}
+	}
+}
+
+func (s *AMIEventStream) serveWebSocket(ws *AMIWebSocket, client *amiEventStreamClient, replay []AMIEventStreamItem, format string) {
+	// the messages of the client are discarded, reading them answers the pings and detects the close
+	closed := make(chan struct{})
+	go func() {
+		defer close(closed)
+		for {
+			if _, _, err := ws.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+	write := func(item AMIEventStreamItem) error {
+		item.Data = s.encode(item.Message, format)
+		return ws.WriteJson(item)
+	}
+	for _, item := range replay {
+		if err := write(item); err != nil {
+			return
+		}
+	}
+	heartbeat := time.NewTicker(s.HeartbeatInterval)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case item, ok := <-client.events:
+			if !ok {
+				return
+			}
+			if err := write(item); err != nil {
+				return
+			}
+		case <-heartbeat.C:
+			if err := ws.Ping(); err != nil {
+				return
+			}
+		case <-closed:
+			return
+		}
+	}
+}
+
+// subscribe registers the client and returns the events of the history after the last id,
+// both under the lock so that no event is lost or duplicated between the replay and the live events
+func (s *AMIEventStream) subscribe(r *http.Request, patterns []string, lastId uint64) (*amiEventStreamClient, []AMIEventStreamItem) {
+	client := &amiEventStreamClient{request: r, patterns: patterns, events: make(chan AMIEventStreamItem, s.BufferSize)}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	var replay []AMIEventStreamItem
+	if lastId > 0 {
+		for _, item := range s.history {
+			if item.Id > lastId && s.match(client, item) {
+				replay = append(replay, item)
+			}
+		}
+	}
+	s.clients[client] = true
+	return client, replay
+}
+
+func (s *AMIEventStream) unsubscribe(client *amiEventStreamClient) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.clients[client] {
+		delete(s.clients, client)
+		close(client.events)
+	}
+}
+
+// match returns true if the event matches one of the patterns of the client (all events if none) and the filter
+func (s *AMIEventStream) match(client *amiEventStreamClient, item AMIEventStreamItem) bool {
+	if len(client.patterns) > 0 {
+		name := strings.ToLower(item.Event)
+		matched := false
+		for _, pattern := range client.patterns {
+			if ok, _ := path.Match(pattern, name); ok {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return false
+		}
+	}
+	return s.filter == nil || s.filter(client.request, item.Message)
+}
+
+func (s *AMIEventStream) encode(message *AMIMessage, format string) interface{} {
+	switch format {
+	case config.AmiEventStreamFormatTranslated:
+		return message.ProduceMessageTranslator(s.dictionary)
+	case config.AmiEventStreamFormatPure:
+		return message.ProduceMessagePure()
+	default:
+		return message.ProduceMessage()
+	}
+}
+
+// trim drops the oldest events over the history size, the lock must be held
+func (s *AMIEventStream) trim() {
+	if over := len(s.history) - s.HistorySize; over > 0 {
+		s.history = s.history[over:]
+	}
+}
@@ -0,0 +1,298 @@
+package ami
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pnguyen215/voipkit/pkg/ami/config"
+)
+
+// NewAMIMetricsExporter
+// NewAMIMetricsExporter creates the /metrics handler of the core, in the Prometheus text exposition format.
+// The gauges of Asterisk are polled once per scrape, in sequence on the connection of the core, within 10 seconds by default.
+// The registration tracker, the conference manager and the parking service, if set, are read instead of polling
+// (they are kept up to date by the events once opened).
+// The health counters of the library (see Metrics) are always exported, the core might be nil to export them only.
+func NewAMIMetricsExporter(core *AMICore) *AMIMetricsExporter {
+	x := &AMIMetricsExporter{core: core, Sip: true, Pjsip: true}
+	x.SetTimeout(10 * time.Second)
+	return x
+}
+
+func (x *AMIMetricsExporter) SetTimeout(value time.Duration) *AMIMetricsExporter {
+	if value > 0 {
+		x.Timeout = value
+	}
+	return x
+}
+
+// SetMinInterval
+// SetMinInterval sets the interval the gauges of the last scrape are reused within, i.e: for many Prometheus replicas.
+func (x *AMIMetricsExporter) SetMinInterval(value time.Duration) *AMIMetricsExporter {
+	if value >= 0 {
+		x.MinInterval = value
+	}
+	return x
+}
+
+// SetSip
+// SetSip enables the peers and the registrations of chan_sip.
+func (x *AMIMetricsExporter) SetSip(value bool) *AMIMetricsExporter {
+	x.Sip = value
+	return x
+}
+
+// SetPjsip
+// SetPjsip enables the endpoints and the outbound registrations of chan_pjsip.
+func (x *AMIMetricsExporter) SetPjsip(value bool) *AMIMetricsExporter {
+	x.Pjsip = value
+	return x
+}
+
+// SetRegistrationTracker
+// SetRegistrationTracker sets the tracker the peers by status are read from.
+func (x *AMIMetricsExporter) SetRegistrationTracker(value *AMIRegistrationTracker) *AMIMetricsExporter {
+	x.registration = value
+	return x
+}
+
+// SetConfBridgeManager
+// SetConfBridgeManager sets the manager the conference rooms are read from.
+func (x *AMIMetricsExporter) SetConfBridgeManager(value *AMIConfBridgeManager) *AMIMetricsExporter {
+	x.conference = value
+	return x
+}
+
+// SetParkingService
+// SetParkingService sets the service the parked calls are read from.
+func (x *AMIMetricsExporter) SetParkingService(value *AMIParkingService) *AMIMetricsExporter {
+	x.parking = value
+	return x
+}
+
+// ServeHTTP
+// ServeHTTP writes the gauges of Asterisk and the health counters of the library.
+func (x *AMIMetricsExporter) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), x.Timeout)
+	defer cancel()
+	families := x.scrape(ctx)
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	if _, err := writeMetricFamilies(w, families); err != nil {
+		componentLog(config.AmiLogComponentMetrics).Error("writing metrics failed", config.AmiLogFieldError, err)
+		return
+	}
+	if _, err := Metrics().WriteTo(w); err != nil {
+		componentLog(config.AmiLogComponentMetrics).Error("writing metrics failed", config.AmiLogFieldError, err)
+	}
+}
+
+// scrape polls the gauges of Asterisk, the concurrent scrapes wait for the running one and reuse it within the min interval
+func (x *AMIMetricsExporter) scrape(ctx context.Context) []amiMetricFamily {
+	x.mutex.Lock()
+	defer x.mutex.Unlock()
+	if x.families != nil && time.Since(x.collectedAt) < x.MinInterval {
+		return x.families
+	}
+	x.families = x.collect(ctx)
+	x.collectedAt = time.Now()
+	return x.families
+}
+
+func (x *AMIMetricsExporter) collect(ctx context.Context) []amiMetricFamily {
+	up := amiMetricFamily{name: "asterisk_up", help: "1 if Asterisk answered the CoreStatus of the scrape.", kind: "gauge"}
+	collectors := amiMetricFamily{name: "voipkit_collector_success", help: "1 if the collector of the scrape succeeded.", kind: "gauge"}
+	calls := amiMetricFamily{name: "asterisk_active_calls", help: "Active calls.", kind: "gauge"}
+	channels := amiMetricFamily{name: "asterisk_active_channels", help: "Active channels.", kind: "gauge"}
+	peers := amiMetricFamily{name: "asterisk_peers", help: "Peers by technology and status.", kind: "gauge"}
+	callers := amiMetricFamily{name: "asterisk_queue_callers", help: "Callers waiting in the queue.", kind: "gauge"}
+	loggedIn := amiMetricFamily{name: "asterisk_queue_members_logged_in", help: "Members logged in the queue.", kind: "gauge"}
+	available := amiMetricFamily{name: "asterisk_queue_members_available", help: "Members available in the queue.", kind: "gauge"}
+	longest := amiMetricFamily{name: "asterisk_queue_longest_wait_seconds", help: "Longest wait of the callers in the queue.", kind: "gauge"}
+	rooms := amiMetricFamily{name: "asterisk_conference_rooms", help: "Active conference rooms.", kind: "gauge"}
+	parties := amiMetricFamily{name: "asterisk_conference_participants", help: "Participants of the conference rooms.", kind: "gauge"}
+	parked := amiMetricFamily{name: "asterisk_parked_calls", help: "Parked calls by parking lot.", kind: "gauge"}
+	trunks := amiMetricFamily{name: "asterisk_trunk_registered", help: "1 if the outbound registration of the trunk is registered.", kind: "gauge"}
+	if x.core == nil {
+		up.samples = append(up.samples, amiMetricSample{value: 0})
+		return []amiMetricFamily{up}
+	}
+	run := func(name string, collector func() error) {
+		value := 1.0
+		if err := collector(); err != nil {
+			componentLog(config.AmiLogComponentMetrics).Error("collector failed", "collector", name, config.AmiLogFieldError, err)
+			value = 0
+		}
+		collectors.samples = append(collectors.samples, amiMetricSample{labels: [][2]string{{"collector", name}}, value: value})
+	}
+	run("core", func() error {
+		reply, err := x.core.GetCoreStatus(ctx)
+		if err == nil && IsFailure(reply) {
+			err = fmt.Errorf(config.AmiErrorActionFailed, config.AmiActionCoreStatus, JsonString(reply))
+		}
+		if err != nil {
+			up.samples = append(up.samples, amiMetricSample{value: 0})
+			return err
+		}
+		up.samples = append(up.samples, amiMetricSample{value: 1})
+		calls.samples = append(calls.samples, amiMetricSample{value: metricNumber(reply.GetFold("CoreCurrentCalls"))})
+		return nil
+	})
+	run("channels", func() error {
+		replies, err := x.core.CoreShowChannels(ctx)
+		if err != nil {
+			return err
+		}
+		channels.samples = append(channels.samples, amiMetricSample{value: float64(len(replies))})
+		return nil
+	})
+	counts := make(map[[2]string]int)
+	if x.registration != nil {
+		run("peers", func() error {
+			for _, r := range x.registration.Snapshot() {
+				counts[[2]string{strings.ToLower(r.Technology), r.Status}]++
+			}
+			return nil
+		})
+	} else {
+		if x.Sip {
+			run("peers_sip", func() error {
+				replies, err := x.core.GetSIPPeersStatus(ctx)
+				if err != nil && err.Error() != config.AmiErrorNoExtensionConfigured {
+					return err
+				}
+				for _, reply := range replies {
+					counts[[2]string{"sip", reply.GetFold("PeerStatus")}]++
+				}
+				return nil
+			})
+		}
+		if x.Pjsip {
+			run("peers_pjsip", func() error {
+				replies, err := x.core.PJSIPShowEndpoints(ctx)
+				if err != nil {
+					return err
+				}
+				for _, reply := range replies {
+					counts[[2]string{"pjsip", reply.GetFold("DeviceState")}]++
+				}
+				return nil
+			})
+		}
+	}
+	for key, count := range counts {
+		peers.samples = append(peers.samples, amiMetricSample{labels: [][2]string{{"tech", key[0]}, {"status", key[1]}}, value: float64(count)})
+	}
+	run("queues", func() error {
+		replies, err := x.core.GetQueueSummary(ctx, "")
+		if err != nil {
+			return err
+		}
+		for _, reply := range replies {
+			labels := [][2]string{{"queue", reply.GetFold("Queue")}}
+			callers.samples = append(callers.samples, amiMetricSample{labels: labels, value: metricNumber(reply.GetFold("Callers"))})
+			loggedIn.samples = append(loggedIn.samples, amiMetricSample{labels: labels, value: metricNumber(reply.GetFold("LoggedIn"))})
+			available.samples = append(available.samples, amiMetricSample{labels: labels, value: metricNumber(reply.GetFold("Available"))})
+			longest.samples = append(longest.samples, amiMetricSample{labels: labels, value: metricNumber(reply.GetFold("LongestHoldTime"))})
+		}
+		return nil
+	})
+	run("conferences", func() error {
+		total, participants := 0, 0
+		if x.conference != nil {
+			for _, room := range x.conference.Rooms() {
+				total++
+				participants += len(room.Participants)
+			}
+		} else {
+			replies, err := x.core.ConfbridgeListRooms(ctx)
+			if err != nil {
+				return err
+			}
+			for _, reply := range replies {
+				total++
+				participants += int(metricNumber(reply.GetFold("Parties")))
+			}
+		}
+		rooms.samples = append(rooms.samples, amiMetricSample{value: float64(total)})
+		parties.samples = append(parties.samples, amiMetricSample{value: float64(participants)})
+		return nil
+	})
+	run("parking", func() error {
+		lots := make(map[string]int)
+		if x.parking != nil {
+			for _, lot := range x.parking.Lots() {
+				lots[lot.Name] += len(lot.Calls)
+			}
+		} else {
+			replies, err := x.core.ParkedCalls(ctx)
+			if err != nil {
+				return err
+			}
+			for _, reply := range replies {
+				lot := reply.GetFold("Parkinglot")
+				if IsStringEmpty(lot) {
+					lot = "default"
+				}
+				lots[lot]++
+			}
+		}
+		for lot, count := range lots {
+			parked.samples = append(parked.samples, amiMetricSample{labels: [][2]string{{"lot", lot}}, value: float64(count)})
+		}
+		return nil
+	})
+	if x.Sip {
+		run("trunks_sip", func() error {
+			replies, err := x.core.GetSIPShowRegistry(ctx)
+			if err != nil {
+				return err
+			}
+			for _, reply := range replies {
+				trunk := reply.GetFold("Host")
+				if username := reply.GetFold("Username"); !IsStringEmpty(username) {
+					trunk = username + "@" + trunk
+				}
+				trunks.samples = append(trunks.samples, amiMetricSample{labels: [][2]string{{"tech", "sip"}, {"trunk", trunk}},
+					value: metricBool(strings.EqualFold(reply.GetFold("State"), "Registered"))})
+			}
+			return nil
+		})
+	}
+	if x.Pjsip {
+		run("trunks_pjsip", func() error {
+			replies, err := x.core.PJSIPShowRegistrationsOutbound(ctx)
+			if err != nil {
+				return err
+			}
+			for _, reply := range replies {
+				trunks.samples = append(trunks.samples, amiMetricSample{labels: [][2]string{{"tech", "pjsip"}, {"trunk", reply.GetFold("ObjectName")}},
+					value: metricBool(strings.EqualFold(reply.GetFold("Status"), "Registered"))})
+			}
+			return nil
+		})
+	}
+	families := []amiMetricFamily{up, calls, channels, peers, callers, loggedIn, available, longest, rooms, parties, parked, trunks, collectors}
+	for i := range families {
+		families[i] = sortMetricFamily(families[i])
+	}
+	return families
+}
+
+func metricNumber(value string) float64 {
+	v, err := strconv.ParseFloat(TrimStringSpaces(value), 64)
+	if err != nil {
+		return 0
+	}
+	return v
+}
+
+func metricBool(value bool) float64 {
+	if value {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,274 @@
+package ami
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pnguyen215/voipkit/pkg/ami/config"
+)
+
+// amiFastAgiTransport is the transport of the FastAGI connection,
+// the replies are read in background so that HANGUP is received while a command is running.
+type amiFastAgiTransport struct {
+	conn      net.Conn
+	reader    *bufio.Reader
+	mutex     sync.Mutex
+	replies   chan string
+	done      chan struct{} // closed once the session has ended, the next replies are dropped
+	abandoned int           // the replies of the commands cancelled while waiting, they are discarded
+}
+
+// NewAMIAgiServer
+// NewAMIAgiServer creates the FastAGI server listening on the address, i.e: :4573
+func NewAMIAgiServer(addr string) *AMIAgiServer {
+	s := &AMIAgiServer{}
+	s.handlers = make(map[string]AmiAgiHandlerFunc)
+	s.SetAddr(addr)
+	s.SetHeaderTimeout(10 * time.Second)
+	return s
+}
+
+func (s *AMIAgiServer) SetAddr(value string) *AMIAgiServer {
+	s.Addr = TrimStringSpaces(value)
+	if IsStringEmpty(s.Addr) {
+		s.Addr = fmt.Sprintf(":%v", config.AmiAgiDefaultFastPort)
+	}
+	return s
+}
+
+func (s *AMIAgiServer) SetHeaderTimeout(value time.Duration) *AMIAgiServer {
+	if value > 0 {
+		s.HeaderTimeout = value
+	}
+	return s
+}
+
+// Handle
+// Handle registers the handler of the script path, i.e: agi://host/ivr/main is handled by ivr/main
+func (s *AMIAgiServer) Handle(script string, handler AmiAgiHandlerFunc) *AMIAgiServer {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.handlers[agiScriptPath(script)] = handler
+	return s
+}
+
+// HandleDefault
+// HandleDefault registers the handler of the script paths without their own handler.
+func (s *AMIAgiServer) HandleDefault(handler AmiAgiHandlerFunc) *AMIAgiServer {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.fallback = handler
+	return s
+}
+
+// Handler
+// Handler returns the handler of the script path.
+func (s *AMIAgiServer) Handler(script string) (AmiAgiHandlerFunc, bool) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	if handler, ok := s.handlers[agiScriptPath(script)]; ok {
+		return handler, true
+	}
+	return s.fallback, s.fallback != nil
+}
+
+// ListenAndServe
+// ListenAndServe listens on the address and serves the sessions until the context is done or the server is closed.
+func (s *AMIAgiServer) ListenAndServe(ctx context.Context) error {
+	listener, err := net.Listen(config.AmiNetworkTcpKey, s.Addr)
+	if err != nil {
+		return err
+	}
+	return s.Serve(ctx, listener)
+}
+
+// Serve
+// Serve serves the sessions of the listener until the context is done or the server is closed,
+// then it waits for the running sessions to end.
+func (s *AMIAgiServer) Serve(ctx context.Context, listener net.Listener) error {
+	s.mutex.Lock()
+	if s.closed {
+		s.mutex.Unlock()
+		listener.Close()
+		return fmt.Errorf(config.AmiErrorAgiSessionClosed)
+	}
+	s.listener = listener
+	s.mutex.Unlock()
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() {
+		select {
+		case <-ctx.Done():
+			s.Close()
+		case <-stop:
+		}
+	}()
+	defer s.sessions.Wait()
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			s.mutex.RLock()
+			closed := s.closed
+			s.mutex.RUnlock()
+			if closed {
+				return nil
+			}
+			if ne, ok := err.(net.Error); ok && ne.Timeout() {
+				continue
+			}
+			return err
+		}
+		s.sessions.Add(1)
+		go func() {
+			defer s.sessions.Done()
+			s.serve(ctx, conn)
+		}()
+	}
+}
+
+// Close
+// Close stops accepting the sessions, the running sessions are not interrupted.
+func (s *AMIAgiServer) Close() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.closed {
+		return nil
+	}
+	s.closed = true
+	if s.listener != nil {
+		return s.listener.Close()
+	}
+	return nil
+}
+
+// ListenAddr
+// ListenAddr returns the address the server is listening on, nil if not serving.
+func (s *AMIAgiServer) ListenAddr() net.Addr {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	if s.listener == nil {
+		return nil
+	}
+	return s.listener.Addr()
+}
+
+// serve reads the agi_* environment, then runs the handler of the script path
+func (s *AMIAgiServer) serve(ctx context.Context, conn net.Conn) {
+	defer conn.Close()
+	reader := bufio.NewReader(conn)
+	conn.SetReadDeadline(time.Now().Add(s.HeaderTimeout))
+	env, err := ReadAgiEnv(reader)
+	if err != nil {
+		componentLog(config.AmiLogComponentAgi).Error("reading AGI environment failed", "addr", conn.RemoteAddr().String(), config.AmiLogFieldError, err)
+		return
+	}
+	conn.SetReadDeadline(time.Time{})
+	transport := &amiFastAgiTransport{conn: conn, reader: reader, replies: make(chan string), done: make(chan struct{})}
+	session := NewAMIAgiSession(ctx, env, transport)
+	defer session.cancel()
+	defer close(transport.done)
+	go transport.read(session)
+
+	handler, ok := s.Handler(session.Script())
+	if !ok {
+		componentLog(config.AmiLogComponentAgi).Warn("no handler of script", "script", session.Script(), "request", session.Request())
+		session.Verbose(session.Context(), fmt.Sprintf("No AGI handler of script '%v'", session.Script()), 1)
+		return
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			componentLog(config.AmiLogComponentAgi).Error("handler panicked", "script", session.Script(), "panic", r)
+		}
+	}()
+	if err := handler(session.Context(), session); err != nil && session.Context().Err() == nil {
+		componentLog(config.AmiLogComponentAgi).Error("handler failed", "script", session.Script(), config.AmiLogFieldError, err)
+	}
+}
+
+// ReadAgiEnv
+// ReadAgiEnv reads the agi_* environment block, the lines key: value until the blank line.
+func ReadAgiEnv(reader *bufio.Reader) (map[string]string, error) {
+	env := make(map[string]string)
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			if err == io.EOF && len(env) > 0 && IsStringEmpty(line) {
+				return env, nil
+			}
+			return env, err
+		}
+		line = strings.TrimRight(line, "\r\n")
+		if IsStringEmpty(line) {
+			return env, nil
+		}
+		key, value, ok := strings.Cut(line, ":")
+		if !ok {
+			return env, fmt.Errorf(config.AmiErrorAgiReplyInvalid, line)
+		}
+		env[TrimStringSpaces(key)] = TrimStringSpaces(value)
+	}
+}
+
+// read reads the replies until the connection is closed or the session has ended, HANGUP cancels the session
+func (t *amiFastAgiTransport) read(session *AMIAgiSession) {
+	defer close(t.replies)
+	defer session.cancel()
+	var usage []string
+	for {
+		line, err := t.reader.ReadString('\n')
+		if err != nil {
+			return
+		}
+		line = strings.TrimRight(line, "\r\n")
+		if line == config.AmiAgiHangupKey {
+			session.hangup()
+			continue
+		}
+		// the 520 reply carries the usage on the 520- lines up to the 520 line
+		if strings.HasPrefix(line, fmt.Sprintf("%v-", config.AmiAgiCodeUsage)) || len(usage) > 0 {
+			usage = append(usage, line)
+			if !strings.HasPrefix(line, fmt.Sprintf("%v ", config.AmiAgiCodeUsage)) {
+				continue
+			}
+			line = strings.Join(usage, "\n")
+			usage = nil
+		}
+		// the reply of the command interrupted by HANGUP might arrive once nobody is waiting anymore
+		select {
+		case t.replies <- line:
+		case <-t.done:
+			return
+		}
+	}
+}
+
+// Command writes the command line and waits for its reply
+func (t *amiFastAgiTransport) Command(ctx context.Context, command string) (*AMIAgiReply, error) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	if _, err := io.WriteString(t.conn, command+"\n"); err != nil {
+		return nil, err
+	}
+	t.abandoned++ // this reply, until it is received
+	for t.abandoned > 0 {
+		select {
+		case line, ok := <-t.replies:
+			if !ok {
+				return nil, fmt.Errorf(config.AmiErrorAgiSessionClosed)
+			}
+			t.abandoned--
+			if t.abandoned == 0 {
+				return ParseAgiReply(line)
+			}
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+	return nil, fmt.Errorf(config.AmiErrorAgiSessionClosed)
+}
@@ -0,0 +1,519 @@
+package ami
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pnguyen215/voipkit/pkg/ami/config"
+)
+
+// NewAMIGateway
+// NewAMIGateway creates the HTTP gateway of the core, the responses are translated by the dictionary of the core.
+// By default the actions time out after 10 seconds and the bodies are limited to 1 MiB.
+//
+// Routes (permission):
+//
+//	POST   /calls                          (calls:write)    originate the call, asynchronously
+//	GET    /channels                       (channels:read)  list the active channels
+//	DELETE /channels/{id}                  (channels:write) hang up the channel, ?cause=16
+//	POST   /channels/{id}/redirect         (channels:write) redirect the channel
+//	GET    /queues/{q}                     (queues:read)    list the members and the callers of the queue
+//	PUT    /queues/{q}/members/{m}         (queues:write)   add the member (interface) to the queue
+//	DELETE /queues/{q}/members/{m}         (queues:write)   remove the member from the queue
+//	POST   /queues/{q}/members/{m}/pause   (queues:write)   pause the member
+//	DELETE /queues/{q}/members/{m}/pause   (queues:write)   unpause the member
+//	GET    /peers                          (peers:read)     list the SIP peers, ?tech=pjsip for the PJSIP endpoints
+//	GET    /peers/{p}                      (peers:read)     show the SIP peer, ?tech=pjsip for the PJSIP endpoint
+//	GET    /ping                           (any token)      ping the server
+//
+// The path parameters are escaped, i.e: /queues/8001/members/SIP%2F1001/pause
+func NewAMIGateway(core *AMICore) *AMIGateway {
+	g := &AMIGateway{core: core}
+	g.tokens = make(map[string]map[string]bool)
+	g.SetTimeout(10 * time.Second)
+	g.SetMaxBodyBytes(1 << 20)
+	if core != nil {
+		g.SetDictionary(core.Dictionary)
+	}
+	g.route(http.MethodPost, "/calls", config.AmiGatewayPermissionCallsWrite, http.StatusAccepted, g.originate)
+	g.route(http.MethodGet, "/channels", config.AmiGatewayPermissionChannelsRead, http.StatusOK, g.channels)
+	g.route(http.MethodDelete, "/channels/{}", config.AmiGatewayPermissionChannelsWrite, http.StatusOK, g.hangup)
+	g.route(http.MethodPost, "/channels/{}/redirect", config.AmiGatewayPermissionChannelsWrite, http.StatusOK, g.redirect)
+	g.route(http.MethodGet, "/queues/{}", config.AmiGatewayPermissionQueuesRead, http.StatusOK, g.queue)
+	g.route(http.MethodPut, "/queues/{}/members/{}", config.AmiGatewayPermissionQueuesWrite, http.StatusOK, g.queueAdd)
+	g.route(http.MethodDelete, "/queues/{}/members/{}", config.AmiGatewayPermissionQueuesWrite, http.StatusOK, g.queueRemove)
+	g.route(http.MethodPost, "/queues/{}/members/{}/pause", config.AmiGatewayPermissionQueuesWrite, http.StatusOK, g.queuePause(true))
+	g.route(http.MethodDelete, "/queues/{}/members/{}/pause", config.AmiGatewayPermissionQueuesWrite, http.StatusOK, g.queuePause(false))
+	g.route(http.MethodGet, "/peers", config.AmiGatewayPermissionPeersRead, http.StatusOK, g.peers)
+	g.route(http.MethodGet, "/peers/{}", config.AmiGatewayPermissionPeersRead, http.StatusOK, g.peer)
+	g.route(http.MethodGet, "/ping", "", http.StatusOK, g.ping)
+	return g
+}
+
+// SetDictionary
+// SetDictionary sets the dictionary translating the fields of the responses, nil to keep the fields of Asterisk.
+func (g *AMIGateway) SetDictionary(dictionary *AMIDictionary) *AMIGateway {
+	g.dictionary = dictionary
+	return g
+}
+
+func (g *AMIGateway) SetTimeout(value time.Duration) *AMIGateway {
+	if value > 0 {
+		g.Timeout = value
+	}
+	return g
+}
+
+func (g *AMIGateway) SetMaxBodyBytes(value int64) *AMIGateway {
+	if value > 0 {
+		g.MaxBodyBytes = value
+	}
+	return g
+}
+
+// AddToken
+// AddToken grants the permissions to the bearer token, i.e: calls:write, queues:read or * for all.
+func (g *AMIGateway) AddToken(token string, permissions ...string) *AMIGateway {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+	if IsStringEmpty(token) {
+		return g
+	}
+	granted, ok := g.tokens[token]
+	if !ok {
+		granted = make(map[string]bool)
+		g.tokens[token] = granted
+	}
+	for _, permission := range permissions {
+		granted[TrimStringSpaces(permission)] = true
+	}
+	return g
+}
+
+// RemoveToken
+// RemoveToken revokes the bearer token.
+func (g *AMIGateway) RemoveToken(token string) *AMIGateway {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+	delete(g.tokens, token)
+	return g
+}
+
+func (g *AMIGateway) route(method, pattern, permission string, status int, handle func(ctx context.Context, r *http.Request, params []string) (interface{}, error)) {
+	g.routes = append(g.routes, amiGatewayRoute{
+		method:     method,
+		pattern:    strings.Split(strings.Trim(pattern, "/"), "/"),
+		permission: permission,
+		status:     status,
+		handle:     handle,
+	})
+}
+
+// ServeHTTP
+// ServeHTTP authorizes the request, runs the action of the route and writes its JSON response.
+func (g *AMIGateway) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	route, params, err := g.match(r)
+	if err != nil {
+		g.writeError(w, err)
+		return
+	}
+	if err := g.authorize(r, route.permission); err != nil {
+		g.writeError(w, err)
+		return
+	}
+	if g.core == nil {
+		g.writeError(w, &AMIGatewayError{Status: http.StatusServiceUnavailable, Code: config.AmiGatewayErrorUnavailable,
+			Message: fmt.Sprintf(config.AmiErrorCoreRequired, r.Method+" "+r.URL.Path)})
+		return
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), g.Timeout)
+	defer cancel()
+	result, err := route.handle(ctx, r, params)
+	if err != nil {
+		g.writeError(w, err)
+		return
+	}
+	g.writeJson(w, route.status, result)
+}
+
+// match returns the route of the request and its unescaped path parameters
+func (g *AMIGateway) match(r *http.Request) (*amiGatewayRoute, []string, error) {
+	segments := strings.Split(strings.Trim(r.URL.EscapedPath(), "/"), "/")
+	var allowed []string
+	for i := range g.routes {
+		route := &g.routes[i]
+		params, ok := matchGatewayPattern(route.pattern, segments)
+		if !ok {
+			continue
+		}
+		if route.method != r.Method {
+			allowed = append(allowed, route.method)
+			continue
+		}
+		return route, params, nil
+	}
+	if len(allowed) > 0 {
+		return nil, nil, &AMIGatewayError{Status: http.StatusMethodNotAllowed, Code: config.AmiGatewayErrorMethodNotAllowed,
+			Message: fmt.Sprintf("%v is not allowed, allowed: %v", r.Method, strings.Join(allowed, ", "))}
+	}
+	return nil, nil, &AMIGatewayError{Status: http.StatusNotFound, Code: config.AmiGatewayErrorNotFound,
+		Message: fmt.Sprintf("%v is not found", r.URL.Path)}
+}
+
+func matchGatewayPattern(pattern, segments []string) ([]string, bool) {
+	if len(pattern) != len(segments) {
+		return nil, false
+	}
+	var params []string
+	for i, p := range pattern {
+		if p != "{}" {
+			if p != segments[i] {
+				return nil, false
+			}
+			continue
+		}
+		value, err := url.PathUnescape(segments[i])
+		if err != nil || IsStringEmpty(value) {
+			return nil, false
+		}
+		params = append(params, value)
+	}
+	return params, true
+}
+
+// authorize checks the bearer token of the request grants the permission, any token is enough for an empty permission
+func (g *AMIGateway) authorize(r *http.Request, permission string) error {
+	header := r.Header.Get("Authorization")
+	token := ""
+	if len(header) > 7 && strings.EqualFold(header[:7], "Bearer ") {
+		token = strings.TrimSpace(header[7:])
+	}
+	g.mutex.RLock()
+	defer g.mutex.RUnlock()
+	var granted map[string]bool
+	for k, v := range g.tokens {
+		if subtle.ConstantTimeCompare([]byte(k), []byte(token)) == 1 {
+			granted = v
+		}
+	}
+	if IsStringEmpty(token) || granted == nil {
+		return &AMIGatewayError{Status: http.StatusUnauthorized, Code: config.AmiGatewayErrorUnauthorized,
+			Message: "bearer token is missing or invalid"}
+	}
+	if IsStringEmpty(permission) || granted[config.AmiGatewayPermissionAll] || granted[permission] {
+		return nil
+	}
+	return &AMIGatewayError{Status: http.StatusForbidden, Code: config.AmiGatewayErrorForbidden,
+		Message: fmt.Sprintf("permission '%v' is required", permission)}
+}
+
+// decode reads the JSON body of the request into the value, an empty body is allowed if optional
+func (g *AMIGateway) decode(r *http.Request, value interface{}, optional bool) error {
+	decoder := json.NewDecoder(io.LimitReader(r.Body, g.MaxBodyBytes))
+	decoder.DisallowUnknownFields()
+	err := decoder.Decode(value)
+	if err == io.EOF && optional {
+		return nil
+	}
+	if err != nil {
+		return invalidGatewayRequest(fmt.Sprintf(config.AmiErrorGatewayBodyInvalid, err))
+	}
+	return nil
+}
+
+func invalidGatewayRequest(message string) *AMIGatewayError {
+	return &AMIGatewayError{Status: http.StatusBadRequest, Code: config.AmiGatewayErrorInvalidRequest, Message: message}
+}
+
+// validateGatewayField rejects the empty required values and the control characters,
+// they would break the framing of the action sent to Asterisk
+func validateGatewayField(name, value string, required bool) error {
+	if required && IsStringEmpty(value) {
+		return invalidGatewayRequest(fmt.Sprintf(config.AmiErrorFieldRequired, name))
+	}
+	if strings.IndexFunc(value, func(r rune) bool { return r < 0x20 || r == 0x7F }) >= 0 {
+		return invalidGatewayRequest(fmt.Sprintf(config.AmiErrorGatewayFieldInvalid, name, "control characters are not allowed"))
+	}
+	return nil
+}
+
+// reply maps the reply of the action to its translated response or to the structured error
+func (g *AMIGateway) reply(action string, reply AmiReply, err error) (interface{}, error) {
+	if err != nil {
+		return nil, g.actionError(action, err)
+	}
+	if IsFailure(reply) {
+		return nil, &AMIGatewayError{Status: http.StatusUnprocessableEntity, Code: config.AmiGatewayErrorActionFailed,
+			Message: fmt.Sprintf(config.AmiErrorActionFailed, action, reply.Get("message"))}
+	}
+	return g.translate(reply), nil
+}
+
+func (g *AMIGateway) replies(action string, replies []AmiReply, err error) (interface{}, error) {
+	if err != nil {
+		return nil, g.actionError(action, err)
+	}
+	response := make([]map[string]string, 0, len(replies))
+	for _, reply := range replies {
+		response = append(response, g.translate(reply))
+	}
+	return response, nil
+}
+
+func (g *AMIGateway) actionError(action string, err error) error {
+	if errors.Is(err, context.DeadlineExceeded) {
+		return &AMIGatewayError{Status: http.StatusGatewayTimeout, Code: config.AmiGatewayErrorTimeout,
+			Message: fmt.Sprintf("action '%v' timed out", action)}
+	}
+	return &AMIGatewayError{Status: http.StatusBadGateway, Code: config.AmiGatewayErrorUnavailable,
+		Message: fmt.Sprintf("action '%v' got an error: %v", action, err)}
+}
+
+func (g *AMIGateway) translate(reply AmiReply) map[string]string {
+	response := make(map[string]string, len(reply))
+	for k, v := range reply {
+		if g.dictionary != nil {
+			k = g.dictionary.TranslateField(k)
+		}
+		response[k] = v
+	}
+	return response
+}
+
+func (g *AMIGateway) writeJson(w http.ResponseWriter, status int, value interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(value); err != nil {
+		componentLog(config.AmiLogComponentGateway).Error("writing response failed", config.AmiLogFieldError, err)
+	}
+}
+
+func (g *AMIGateway) writeError(w http.ResponseWriter, err error) {
+	var e *AMIGatewayError
+	if !errors.As(err, &e) {
+		e = &AMIGatewayError{Status: http.StatusInternalServerError, Code: config.AmiGatewayErrorUnavailable, Message: err.Error()}
+	}
+	if e.Status == http.StatusUnauthorized {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="voipkit"`)
+	}
+	g.writeJson(w, e.Status, map[string]interface{}{"error": e})
+}
+
+func (e *AMIGatewayError) Error() string {
+	return fmt.Sprintf("%v (%v): %v", e.Code, e.Status, e.Message)
+}
+
+func (g *AMIGateway) originate(ctx context.Context, r *http.Request, params []string) (interface{}, error) {
+	var call AMIGatewayCall
+	if err := g.decode(r, &call, false); err != nil {
+		return nil, err
+	}
+	if err := validateGatewayField("channel", call.Channel, true); err != nil {
+		return nil, err
+	}
+	if !strings.Contains(call.Channel, "/") {
+		return nil, invalidGatewayRequest(fmt.Sprintf(config.AmiErrorGatewayFieldInvalid, "channel", "expected Tech/Resource"))
+	}
+	if IsStringEmpty(call.Application) == IsStringEmpty(call.Exten) {
+		return nil, invalidGatewayRequest("either exten (with context) or application is required")
+	}
+	if !IsStringEmpty(call.Exten) && IsStringEmpty(call.Context) {
+		return nil, invalidGatewayRequest(fmt.Sprintf(config.AmiErrorFieldRequired, "context"))
+	}
+	if call.TimeoutMs != 0 && (call.TimeoutMs < config.AmiMinTimeoutInMsForCall || call.TimeoutMs > config.AmiMaxTimeoutInMsForCall) {
+		return nil, invalidGatewayRequest(fmt.Sprintf(config.AmiErrorGatewayFieldInvalid, "timeout_ms",
+			fmt.Sprintf("expected between %v and %v", config.AmiMinTimeoutInMsForCall, config.AmiMaxTimeoutInMsForCall)))
+	}
+	fields := [][]string{
+		{"exten", call.Exten}, {"context", call.Context}, {"application", call.Application},
+		{"data", call.Data}, {"caller_id", call.CallerId}, {"account", call.Account},
+	}
+	for _, field := range fields {
+		if err := validateGatewayField(field[0], field[1], false); err != nil {
+			return nil, err
+		}
+	}
+	variables := make([]string, 0, len(call.Variables))
+	for k, v := range call.Variables {
+		if IsStringEmpty(k) || strings.ContainsAny(k, "=&") {
+			return nil, invalidGatewayRequest(fmt.Sprintf(config.AmiErrorGatewayFieldInvalid, "variables", fmt.Sprintf("bad name '%v'", k)))
+		}
+		if err := validateGatewayField("variables."+k, v, false); err != nil {
+			return nil, err
+		}
+		variables = append(variables, k+"="+v)
+	}
+	sort.Strings(variables)
+	o := NewAmiOriginate().
+		SetChannel(call.Channel).
+		SetCallerId(call.CallerId).
+		SetAccount(call.Account).
+		SetAsync(true)
+	if !IsStringEmpty(call.Exten) {
+		if call.Priority <= 0 {
+			call.Priority = 1
+		}
+		o.SetExtension(call.Exten).SetContext(call.Context).SetPriority(call.Priority)
+	} else {
+		o.SetApplication(call.Application).SetData(call.Data)
+	}
+	if call.TimeoutMs > 0 {
+		o.SetTimeout(call.TimeoutMs)
+	}
+	if len(variables) > 0 {
+		o.SetMultipleVariables(variables...)
+	}
+	reply, err := g.core.Originate(ctx, *o)
+	return g.reply(config.AmiActionOriginate, reply, err)
+}
+
+func (g *AMIGateway) channels(ctx context.Context, r *http.Request, params []string) (interface{}, error) {
+	replies, err := g.core.CoreShowChannels(ctx)
+	return g.replies(config.AmiActionCoreShowChannels, replies, err)
+}
+
+func (g *AMIGateway) hangup(ctx context.Context, r *http.Request, params []string) (interface{}, error) {
+	cause := r.URL.Query().Get("cause")
+	if err := validateGatewayField("channel", params[0], true); err != nil {
+		return nil, err
+	}
+	if err := validateGatewayField("cause", cause, false); err != nil {
+		return nil, err
+	}
+	reply, err := g.core.Hangup(ctx, params[0], cause)
+	return g.reply(config.AmiActionHangup, reply, err)
+}
+
+func (g *AMIGateway) redirect(ctx context.Context, r *http.Request, params []string) (interface{}, error) {
+	var redirect AMIGatewayRedirect
+	if err := g.decode(r, &redirect, false); err != nil {
+		return nil, err
+	}
+	fields := [][]string{{"channel", params[0]}, {"exten", redirect.Exten}, {"context", redirect.Context}}
+	for _, field := range fields {
+		if err := validateGatewayField(field[0], field[1], true); err != nil {
+			return nil, err
+		}
+	}
+	if redirect.Priority <= 0 {
+		redirect.Priority = 1
+	}
+	call := AMIPayloadCall{
+		Channel:  params[0],
+		Exten:    redirect.Exten,
+		Context:  redirect.Context,
+		Priority: fmt.Sprintf("%v", redirect.Priority),
+	}
+	reply, err := g.core.Redirect(ctx, call)
+	return g.reply(config.AmiActionRedirect, reply, err)
+}
+
+func (g *AMIGateway) queue(ctx context.Context, r *http.Request, params []string) (interface{}, error) {
+	if err := validateGatewayField("queue", params[0], true); err != nil {
+		return nil, err
+	}
+	replies, err := g.core.GetQueueStatuses(ctx, params[0])
+	return g.replies(config.AmiActionQueueStatus, replies, err)
+}
+
+func (g *AMIGateway) queueMember(r *http.Request, params []string) (*AMIGatewayQueueMember, error) {
+	var member AMIGatewayQueueMember
+	if err := g.decode(r, &member, true); err != nil {
+		return nil, err
+	}
+	fields := [][]string{
+		{"queue", params[0]}, {"member", params[1]}, {"member_name", member.MemberName},
+		{"state_interface", member.StateInterface}, {"reason", member.Reason},
+	}
+	for i, field := range fields {
+		if err := validateGatewayField(field[0], field[1], i < 2); err != nil {
+			return nil, err
+		}
+	}
+	if member.Penalty < 0 {
+		return nil, invalidGatewayRequest(fmt.Sprintf(config.AmiErrorGatewayFieldInvalid, "penalty", "expected a positive value"))
+	}
+	return &member, nil
+}
+
+func (g *AMIGateway) queueAdd(ctx context.Context, r *http.Request, params []string) (interface{}, error) {
+	member, err := g.queueMember(r, params)
+	if err != nil {
+		return nil, err
+	}
+	queue := AMIPayloadQueue{
+		Queue:          params[0],
+		Interface:      params[1],
+		Penalty:        fmt.Sprintf("%v", member.Penalty),
+		Paused:         fmt.Sprintf("%v", member.Paused),
+		MemberName:     member.MemberName,
+		StateInterface: member.StateInterface,
+		Reason:         member.Reason,
+	}
+	reply, err := g.core.QueueAdd(ctx, queue)
+	return g.reply(config.AmiActionQueueAdd, reply, err)
+}
+
+func (g *AMIGateway) queueRemove(ctx context.Context, r *http.Request, params []string) (interface{}, error) {
+	for i, name := range []string{"queue", "member"} {
+		if err := validateGatewayField(name, params[i], true); err != nil {
+			return nil, err
+		}
+	}
+	reply, err := g.core.QueueRemove(ctx, AMIPayloadQueue{Queue: params[0], Interface: params[1]})
+	return g.reply(config.AmiActionQueueRemove, reply, err)
+}
+
+func (g *AMIGateway) queuePause(paused bool) func(ctx context.Context, r *http.Request, params []string) (interface{}, error) {
+	return func(ctx context.Context, r *http.Request, params []string) (interface{}, error) {
+		member, err := g.queueMember(r, params)
+		if err != nil {
+			return nil, err
+		}
+		queue := AMIPayloadQueue{
+			Queue:     params[0],
+			Interface: params[1],
+			Paused:    fmt.Sprintf("%v", paused),
+			Reason:    member.Reason,
+		}
+		reply, err := g.core.QueuePause(ctx, queue)
+		return g.reply(config.AmiActionQueuePause, reply, err)
+	}
+}
+
+func (g *AMIGateway) peers(ctx context.Context, r *http.Request, params []string) (interface{}, error) {
+	if strings.EqualFold(r.URL.Query().Get("tech"), "pjsip") {
+		replies, err := g.core.PJSIPShowEndpoints(ctx)
+		return g.replies(config.AmiActionPJSIPShowEndpoints, replies, err)
+	}
+	replies, err := g.core.GetSIPPeers(ctx)
+	return g.replies(config.AmiActionSIPPeers, replies, err)
+}
+
+func (g *AMIGateway) peer(ctx context.Context, r *http.Request, params []string) (interface{}, error) {
+	if err := validateGatewayField("peer", params[0], true); err != nil {
+		return nil, err
+	}
+	if strings.EqualFold(r.URL.Query().Get("tech"), "pjsip") {
+		replies, err := g.core.PJSIPShowEndpoint(ctx, params[0])
+		return g.replies(config.AmiActionPJSIPShowEndpoint, replies, err)
+	}
+	reply, err := g.core.GetSIPPeer(ctx, params[0])
+	return g.reply(config.AmiActionSIPShowPeer, reply, err)
+}
+
+func (g *AMIGateway) ping(ctx context.Context, r *http.Request, params []string) (interface{}, error) {
+	if err := g.core.Ping(ctx); err != nil {
+		return nil, g.actionError(config.AmiActionPing, err)
+	}
+	return map[string]string{"response": config.AmiStatusSuccessKey}, nil
+}
@@ -6,7 +6,6 @@ import (
 	"encoding/base64"
 	"encoding/json"
 	"fmt"
-	"log"
 	"net"
 	"net/http"
 	"net/url"
@@ -673,7 +672,7 @@ func VerifyPhoneNo(phone string, region string) bool {
 
 	p, err := phonenumbers.Parse(phone, region)
 	if err != nil {
-		log.Printf(err.Error())
+		componentLog(config.AmiLogComponentCall).Debug("phone number not parsed", "phone", phone, "region", region, config.AmiLogFieldError, err)
 		return false
 	}
 	v := phonenumbers.IsValidNumber(p)
@@ -681,6 +680,35 @@ func VerifyPhoneNo(phone string, region string) bool {
 	return v && l && VerifyPhoneNoCustomize(phone)
 }
 
+// NormalizePhoneNo converts a given phone number into its international form without the plus sign,
+// based on the specified region using the Google's libphonenumber library.
+//
+// Parameters:
+//   - phone:  The phone number string to be normalized.
+//   - region: The ISO 3166-1 alpha-2 country code representing the region associated with the phone number.
+//
+// Returns:
+//   - The normalized phone number if it is valid for the region, otherwise the digits of the phone number.
+//
+// Example:
+//
+//	result := NormalizePhoneNo("090 123 4567", "VN")
+//	// result is "84901234567".
+func NormalizePhoneNo(phone string, region string) string {
+	if VerifyPhoneNo(phone, region) {
+		if p, err := phonenumbers.Parse(phone, region); err == nil {
+			return strings.TrimPrefix(phonenumbers.Format(p, phonenumbers.E164), "+")
+		}
+	}
+	var digits strings.Builder
+	for _, c := range phone {
+		if c >= '0' && c <= '9' {
+			digits.WriteRune(c)
+		}
+	}
+	return digits.String()
+}
+
 // RemoveStringPrefix removes specified prefixes from the beginning of a given string.
 //
 // Parameters:
@@ -817,12 +845,12 @@ func UsableRConnection(ip string, port int) (bool, error) {
 	timeout := time.Second
 	conn, err := net.DialTimeout(config.AmiNetworkTcpKey, net.JoinHostPort(ip, strconv.Itoa(port)), timeout)
 	if err != nil {
-		D().Error("Connecting error: %v", err)
+		componentLog(config.AmiLogComponentNetwork).Debug("connection not usable", "addr", net.JoinHostPort(ip, strconv.Itoa(port)), config.AmiLogFieldError, err)
 		return false, err
 	}
 	if conn != nil {
 		defer conn.Close()
-		D().Info("Opened on: %s", net.JoinHostPort(ip, strconv.Itoa(port)))
+		componentLog(config.AmiLogComponentNetwork).Debug("connection usable", "addr", net.JoinHostPort(ip, strconv.Itoa(port)))
 		return true, nil
 	}
 	return false, nil
@@ -1141,7 +1169,7 @@ func JsonString(data interface{}) string {
 	}
 	result, err := json.Marshal(data)
 	if err != nil {
-		D().Error(err.Error())
+		Log().Error("marshaling json failed", config.AmiLogFieldError, err)
 		return ""
 	}
 	return string(result)
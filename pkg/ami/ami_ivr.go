@@ -0,0 +1,417 @@
+package ami
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/pnguyen215/voipkit/pkg/ami/config"
+)
+
+var amiIvrPlaceholder = regexp.MustCompile(`\{([A-Za-z0-9_.\-]+)\}`)
+
+// NewAMIIvrFlow
+// NewAMIIvrFlow creates the IVR flow starting at the node.
+func NewAMIIvrFlow(name, start string) *AMIIvrFlow {
+	f := &AMIIvrFlow{Name: TrimStringSpaces(name), Start: TrimStringSpaces(start)}
+	f.SetMaxSteps(100)
+	return f
+}
+
+// LoadIvrFlow
+// LoadIvrFlow loads the IVR flow from JSON, the flow is validated.
+func LoadIvrFlow(data []byte) (*AMIIvrFlow, error) {
+	f := NewAMIIvrFlow("", "")
+	if err := json.Unmarshal(data, f); err != nil {
+		return nil, fmt.Errorf(config.AmiErrorIvrFlowInvalid, f.Name, err)
+	}
+	if f.MaxSteps <= 0 {
+		f.SetMaxSteps(100)
+	}
+	if err := f.Validate(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// LoadIvrFlowFile
+// LoadIvrFlowFile loads the IVR flow from the JSON file.
+func LoadIvrFlowFile(filename string) (*AMIIvrFlow, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	return LoadIvrFlow(data)
+}
+
+func (f *AMIIvrFlow) SetMaxSteps(value int) *AMIIvrFlow {
+	if value > 0 {
+		f.MaxSteps = value
+	}
+	return f
+}
+
+// SetRestify
+// SetRestify sets the HTTP client of the http nodes.
+func (f *AMIIvrFlow) SetRestify(value *AmiRestify) *AMIIvrFlow {
+	f.restify = value
+	return f
+}
+
+// AddNode
+// AddNode adds the nodes to the flow.
+func (f *AMIIvrFlow) AddNode(nodes ...AMIIvrNode) *AMIIvrFlow {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.Nodes = append(f.Nodes, nodes...)
+	return f
+}
+
+// OnNode
+// OnNode registers the callback raised with the timing of every node run.
+func (f *AMIIvrFlow) OnNode(callback func(AMIIvrNodeTiming)) *AMIIvrFlow {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.onNode = append(f.onNode, callback)
+	return f
+}
+
+// Node
+// Node returns the node of the id.
+func (f *AMIIvrFlow) Node(id string) (*AMIIvrNode, bool) {
+	f.mutex.RLock()
+	defer f.mutex.RUnlock()
+	for i := range f.Nodes {
+		if f.Nodes[i].Id == id {
+			node := f.Nodes[i]
+			return &node, true
+		}
+	}
+	return nil, false
+}
+
+// Validate
+// Validate checks the start node exists, the nodes are unique with a known kind and their next nodes exist.
+func (f *AMIIvrFlow) Validate() error {
+	f.mutex.RLock()
+	defer f.mutex.RUnlock()
+	ids := make(map[string]bool)
+	for _, node := range f.Nodes {
+		if IsStringEmpty(node.Id) {
+			return fmt.Errorf(config.AmiErrorIvrFlowInvalid, f.Name, "node id is required")
+		}
+		if ids[node.Id] {
+			return fmt.Errorf(config.AmiErrorIvrFlowInvalid, f.Name, fmt.Sprintf("node '%v' is duplicated", node.Id))
+		}
+		if !config.AmiIvrNodeKinds[node.Kind] {
+			return fmt.Errorf(config.AmiErrorIvrFlowInvalid, f.Name, fmt.Sprintf("node '%v' has unknown kind '%v'", node.Id, node.Kind))
+		}
+		ids[node.Id] = true
+	}
+	if !ids[f.Start] {
+		return fmt.Errorf(config.AmiErrorIvrFlowInvalid, f.Name, fmt.Sprintf("start node '%v' not found", f.Start))
+	}
+	for _, node := range f.Nodes {
+		next := []string{node.Next}
+		for _, v := range node.Branches {
+			next = append(next, v)
+		}
+		for _, id := range next {
+			if !IsStringEmpty(id) && !ids[id] {
+				return fmt.Errorf(config.AmiErrorIvrFlowInvalid, f.Name, fmt.Sprintf("node '%v' refers unknown node '%v'", node.Id, id))
+			}
+		}
+		switch node.Kind {
+		case config.AmiIvrNodeBranch, config.AmiIvrNodeSet:
+			if IsStringEmpty(node.Variable) {
+				return fmt.Errorf(config.AmiErrorIvrFlowInvalid, f.Name, fmt.Sprintf("node '%v' requires variable", node.Id))
+			}
+		case config.AmiIvrNodeHttp:
+			if IsStringEmpty(node.Path) {
+				return fmt.Errorf(config.AmiErrorIvrFlowInvalid, f.Name, fmt.Sprintf("node '%v' requires path", node.Id))
+			}
+		case config.AmiIvrNodeExec:
+			if IsStringEmpty(node.Application) {
+				return fmt.Errorf(config.AmiErrorIvrFlowInvalid, f.Name, fmt.Sprintf("node '%v' requires application", node.Id))
+			}
+		case config.AmiIvrNodeQueue:
+			if IsStringEmpty(node.Queue) {
+				return fmt.Errorf(config.AmiErrorIvrFlowInvalid, f.Name, fmt.Sprintf("node '%v' requires queue", node.Id))
+			}
+		case config.AmiIvrNodeVoicemail:
+			if IsStringEmpty(node.Mailbox) {
+				return fmt.Errorf(config.AmiErrorIvrFlowInvalid, f.Name, fmt.Sprintf("node '%v' requires mailbox", node.Id))
+			}
+		case config.AmiIvrNodeTransfer:
+			if IsStringEmpty(node.Extension) {
+				return fmt.Errorf(config.AmiErrorIvrFlowInvalid, f.Name, fmt.Sprintf("node '%v' requires extension", node.Id))
+			}
+		}
+	}
+	return nil
+}
+
+// Handler
+// Handler returns the AGI handler running the flow, it is registered into the FastAGI server or the Async AGI.
+func (f *AMIIvrFlow) Handler() AmiAgiHandlerFunc {
+	return func(ctx context.Context, s *AMIAgiSession) error {
+		_, err := f.Run(ctx, s)
+		return err
+	}
+}
+
+// Run
+// Run runs the flow on the session from the start node, the variables are seeded with the AGI arguments
+// (arg1, arg2...), the query of the request and callerid, extension, uniqueid, channel.
+func (f *AMIIvrFlow) Run(ctx context.Context, s *AMIAgiSession) (*AMIIvrRun, error) {
+	run := &AMIIvrRun{Flow: f.Name, Channel: s.Channel(), UniqueId: s.UniqueId()}
+	run.Variables = map[string]string{
+		"callerid":  s.CallerId(),
+		"extension": s.Extension(),
+		"uniqueid":  s.UniqueId(),
+		"channel":   s.Channel(),
+	}
+	for key, values := range s.Query() {
+		if len(values) > 0 {
+			run.Variables[key] = values[0]
+		}
+	}
+	for i, arg := range s.Arguments {
+		run.Variables[fmt.Sprintf("arg%v", i+1)] = arg
+	}
+	started := time.Now()
+	defer func() {
+		run.Elapsed = time.Since(started)
+	}()
+	id := f.Start
+	for step := 0; !IsStringEmpty(id); step++ {
+		if step >= f.MaxSteps {
+			err := fmt.Errorf(config.AmiErrorIvrNodeFailed, id, fmt.Sprintf("max steps %v reached", f.MaxSteps))
+			run.Error = err.Error()
+			return run, err
+		}
+		node, ok := f.Node(id)
+		if !ok {
+			err := fmt.Errorf(config.AmiErrorIvrNodeFailed, id, "node not found")
+			run.Error = err.Error()
+			return run, err
+		}
+		timing := AMIIvrNodeTiming{Node: node.Id, Kind: node.Kind, StartedAt: time.Now()}
+		input, next, err := f.step(ctx, s, node, run.Variables)
+		timing.Elapsed = time.Since(timing.StartedAt)
+		timing.Input = input
+		timing.Next = next
+		if err != nil {
+			timing.Error = err.Error()
+		}
+		run.Timings = append(run.Timings, timing)
+		componentLog(config.AmiLogComponentIvr).Debug("ivr node completed", "flow", f.Name, config.AmiLogFieldChannel, run.Channel,
+			"node", node.Id, "kind", node.Kind, "elapsed", timing.Elapsed, "input", input, "next", next)
+		f.notify(timing)
+		if err != nil {
+			err = fmt.Errorf(config.AmiErrorIvrNodeFailed, node.Id, err)
+			run.Error = err.Error()
+			return run, err
+		}
+		id = next
+	}
+	return run, nil
+}
+
+func (r *AMIIvrRun) Json() string {
+	return JsonString(r)
+}
+
+func (f *AMIIvrFlow) notify(timing AMIIvrNodeTiming) {
+	f.mutex.RLock()
+	callbacks := append([]func(AMIIvrNodeTiming){}, f.onNode...)
+	f.mutex.RUnlock()
+	for _, callback := range callbacks {
+		callback(timing)
+	}
+}
+
+// step runs the node, it returns the input of the caller (if any) and the next node
+func (f *AMIIvrFlow) step(ctx context.Context, s *AMIAgiSession, node *AMIIvrNode, vars map[string]string) (string, string, error) {
+	render := func(value string) string {
+		return amiIvrPlaceholder.ReplaceAllStringFunc(value, func(m string) string {
+			return vars[m[1:len(m)-1]]
+		})
+	}
+	switch node.Kind {
+	case config.AmiIvrNodePlay:
+		digit := ""
+		for _, prompt := range strings.Split(render(node.Prompt), "&") {
+			reply, err := s.StreamFile(ctx, prompt, node.EscapeDigits, 0)
+			if err != nil {
+				return "", "", err
+			}
+			if digit = reply.Digit(); !IsStringEmpty(digit) {
+				break
+			}
+		}
+		if !IsStringEmpty(node.Variable) {
+			vars[node.Variable] = digit
+		}
+		if next, ok := node.Branches[digit]; ok && !IsStringEmpty(digit) {
+			return digit, next, nil
+		}
+		return digit, node.Next, nil
+	case config.AmiIvrNodeCollect:
+		return f.collect(ctx, s, node, vars, render)
+	case config.AmiIvrNodeBranch:
+		value := vars[node.Variable]
+		if next, ok := node.Branches[value]; ok {
+			return value, next, nil
+		}
+		if next, ok := node.Branches[config.AmiIvrBranchDefault]; ok {
+			return value, next, nil
+		}
+		return value, node.Next, nil
+	case config.AmiIvrNodeHttp:
+		if err := f.lookup(ctx, node, vars, render); err != nil {
+			if next, ok := node.Branches[config.AmiIvrBranchError]; ok {
+				componentLog(config.AmiLogComponentIvr).Error("ivr lookup failed", "flow", f.Name, "node", node.Id, config.AmiLogFieldError, err)
+				return "", next, nil
+			}
+			return "", "", err
+		}
+		return "", node.Next, nil
+	case config.AmiIvrNodeSet:
+		value := render(node.Value)
+		vars[node.Variable] = value
+		return "", node.Next, s.SetVariable(ctx, node.Variable, value)
+	case config.AmiIvrNodeExec:
+		_, err := s.Exec(ctx, node.Application, render(node.Options))
+		return "", node.Next, err
+	case config.AmiIvrNodeTransfer:
+		if !IsStringEmpty(node.Context) {
+			if err := s.SetContext(ctx, render(node.Context)); err != nil {
+				return "", "", err
+			}
+		}
+		if err := s.SetExtension(ctx, render(node.Extension)); err != nil {
+			return "", "", err
+		}
+		priority := node.Priority
+		if IsStringEmpty(priority) {
+			priority = "1"
+		}
+		return "", "", s.SetPriority(ctx, priority)
+	case config.AmiIvrNodeQueue:
+		options := []string{render(node.Queue)}
+		if !IsStringEmpty(node.Options) {
+			options = append(options, render(node.Options))
+		}
+		_, err := s.Exec(ctx, "Queue", options...)
+		return "", node.Next, err
+	case config.AmiIvrNodeVoicemail:
+		options := []string{render(node.Mailbox)}
+		if !IsStringEmpty(node.Options) {
+			options = append(options, render(node.Options))
+		}
+		_, err := s.Exec(ctx, "VoiceMail", options...)
+		return "", node.Next, err
+	case config.AmiIvrNodeHangup:
+		return "", "", s.Hangup(ctx, "")
+	}
+	return "", "", fmt.Errorf(config.AmiErrorIvrNodeKindUnknown, node.Kind)
+}
+
+// collect reads the digits up to the retries, the invalid prompt is played between the attempts
+func (f *AMIIvrFlow) collect(ctx context.Context, s *AMIAgiSession, node *AMIIvrNode, vars map[string]string, render func(string) string) (string, string, error) {
+	minDigits, maxDigits := node.MinDigits, node.MaxDigits
+	if minDigits <= 0 {
+		minDigits = 1
+	}
+	if maxDigits < minDigits {
+		maxDigits = minDigits
+	}
+	timeout := time.Duration(node.TimeoutMs) * time.Millisecond
+	if timeout <= 0 {
+		timeout = 5 * time.Second
+	}
+	reason := config.AmiIvrBranchTimeout
+	digits := ""
+	for attempt := 0; attempt <= node.Retries; attempt++ {
+		if attempt > 0 && !IsStringEmpty(node.InvalidPrompt) {
+			if _, err := s.StreamFile(ctx, render(node.InvalidPrompt), "", 0); err != nil {
+				return digits, "", err
+			}
+		}
+		value, _, err := s.GetData(ctx, render(node.Prompt), timeout, maxDigits)
+		if err != nil {
+			return digits, "", err
+		}
+		digits = value
+		if IsStringEmpty(digits) {
+			reason = config.AmiIvrBranchTimeout
+			continue
+		}
+		next, ok := node.Branches[digits]
+		if !ok {
+			next, ok = node.Branches[config.AmiIvrBranchDefault]
+		}
+		if !ok {
+			next, ok = node.Next, len(node.Branches) == 0 || !IsStringEmpty(node.Next)
+		}
+		if len(digits) < minDigits || !ok {
+			reason = config.AmiIvrBranchInvalid
+			continue
+		}
+		variable := node.Variable
+		if IsStringEmpty(variable) {
+			variable = node.Id
+		}
+		vars[variable] = digits
+		return digits, next, nil
+	}
+	if next, ok := node.Branches[reason]; ok {
+		return digits, next, nil
+	}
+	return digits, "", nil
+}
+
+// lookup calls the HTTP service of the node, the fields of the JSON response are assigned to the variables.
+// The call is cancelled with the session, i.e: when the caller hangs up.
+func (f *AMIIvrFlow) lookup(ctx context.Context, node *AMIIvrNode, vars map[string]string, render func(string) string) error {
+	if f.restify == nil {
+		return fmt.Errorf(config.AmiErrorIvrRestifyRequired, f.Name, node.Id)
+	}
+	query := make(map[string]string)
+	for key, value := range node.Query {
+		query[key] = render(value)
+	}
+	var response map[string]interface{}
+	var err error
+	if strings.EqualFold(node.Method, http.MethodPost) {
+		err = f.restify.Do(ctx, http.MethodPost, render(node.Path), nil, query, &response)
+	} else {
+		err = f.restify.Do(ctx, http.MethodGet, render(node.Path), query, nil, &response)
+	}
+	if err != nil {
+		return err
+	}
+	for variable, field := range node.Assign {
+		var value interface{} = response
+		for _, key := range strings.Split(field, ".") {
+			m, ok := value.(map[string]interface{})
+			if !ok {
+				value = nil
+				break
+			}
+			value = m[key]
+		}
+		if value == nil {
+			vars[variable] = ""
+			continue
+		}
+		vars[variable] = fmt.Sprintf("%v", value)
+	}
+	return nil
+}
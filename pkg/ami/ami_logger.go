@@ -3,9 +3,13 @@ package ami
 import (
 	"encoding/json"
 	"fmt"
+	"io"
 	"log"
 	"os"
+	"sync"
 	"time"
+
+	"github.com/pnguyen215/voipkit/pkg/ami/config"
 )
 
 // LogLevel represents different log levels.
@@ -28,9 +32,43 @@ type Logger struct {
 
 // LoggerEntry represents a log entry.
 type LoggerEntry struct {
-	Timestamp time.Time `json:"timestamp,omitempty"`
-	Level     LogLevel  `json:"level,omitempty"`
-	Message   string    `json:"message,omitempty"`
+	Timestamp time.Time              `json:"timestamp,omitempty"`
+	Level     LogLevel               `json:"level,omitempty"`
+	Message   string                 `json:"message,omitempty"`
+	Fields    map[string]interface{} `json:"fields,omitempty"`
+}
+
+// AmiLogger
+// AmiLogger is the logger of the library. The fields are key-value pairs, i.e: "action", "Originate", "action_id", id,
+// so that *slog.Logger implements it (see NewAmiSlogLogger).
+type AmiLogger interface {
+	Debug(msg string, fields ...interface{})
+	Info(msg string, fields ...interface{})
+	Warn(msg string, fields ...interface{})
+	Error(msg string, fields ...interface{})
+}
+
+// AMILevelLogger filters the logs of a logger by their level, and by the level of their component if set.
+type AMILevelLogger struct {
+	base       AmiLogger
+	mutex      sync.RWMutex
+	level      LogLevel
+	components map[string]LogLevel
+}
+
+// AMIJsonLogger writes the logs as JSON lines, i.e: to stdout.
+type AMIJsonLogger struct {
+	mutex sync.Mutex
+	w     io.Writer
+	level LogLevel
+}
+
+type amiNopLogger struct{}
+
+// amiFieldLogger adds its fields to the logs of the base logger
+type amiFieldLogger struct {
+	base   AmiLogger
+	fields []interface{}
 }
 
 // NewLogger creates a new logger with the specified log level.
@@ -44,6 +82,220 @@ var e *Logger = nil
 var d *Logger = nil
 var f *Logger = nil
 
+var logger AmiLogger = NewAmiNopLogger()
+var loggerMutex sync.RWMutex
+
+// SetLogger
+// SetLogger sets the logger of the library, used by the components without their own logger
+// (see AmiClient.SetLogger and AMISocket.SetLogger). The default logger discards the logs.
+func SetLogger(value AmiLogger) {
+	if value == nil {
+		value = NewAmiNopLogger()
+	}
+	loggerMutex.Lock()
+	defer loggerMutex.Unlock()
+	logger = value
+}
+
+// Log
+// Log returns the logger of the library.
+func Log() AmiLogger {
+	loggerMutex.RLock()
+	defer loggerMutex.RUnlock()
+	return logger
+}
+
+// NewAmiNopLogger
+// NewAmiNopLogger creates the logger discarding the logs.
+func NewAmiNopLogger() AmiLogger {
+	return amiNopLogger{}
+}
+
+func (amiNopLogger) Debug(msg string, fields ...interface{}) {}
+func (amiNopLogger) Info(msg string, fields ...interface{})  {}
+func (amiNopLogger) Warn(msg string, fields ...interface{})  {}
+func (amiNopLogger) Error(msg string, fields ...interface{}) {}
+
+// WithLogFields
+// WithLogFields returns the logger adding the fields to its logs, i.e: WithLogFields(l, "component", "gateway").
+func WithLogFields(l AmiLogger, fields ...interface{}) AmiLogger {
+	if l == nil {
+		l = Log()
+	}
+	if _, ok := l.(amiNopLogger); ok || len(fields) == 0 {
+		return l
+	}
+	if f, ok := l.(*amiFieldLogger); ok {
+		return &amiFieldLogger{base: f.base, fields: append(append([]interface{}{}, f.fields...), fields...)}
+	}
+	return &amiFieldLogger{base: l, fields: fields}
+}
+
+// componentLog returns the logger of the library for the component
+func componentLog(component string) AmiLogger {
+	return WithLogFields(Log(), config.AmiLogFieldComponent, component)
+}
+
+func (l *amiFieldLogger) with(fields []interface{}) []interface{} {
+	return append(append(make([]interface{}, 0, len(l.fields)+len(fields)), l.fields...), fields...)
+}
+
+func (l *amiFieldLogger) Debug(msg string, fields ...interface{}) {
+	l.base.Debug(msg, l.with(fields)...)
+}
+
+func (l *amiFieldLogger) Info(msg string, fields ...interface{}) {
+	l.base.Info(msg, l.with(fields)...)
+}
+
+func (l *amiFieldLogger) Warn(msg string, fields ...interface{}) {
+	l.base.Warn(msg, l.with(fields)...)
+}
+
+func (l *amiFieldLogger) Error(msg string, fields ...interface{}) {
+	l.base.Error(msg, l.with(fields)...)
+}
+
+// NewAmiLevelLogger
+// NewAmiLevelLogger creates the logger writing the logs of the level and above to the base logger.
+func NewAmiLevelLogger(base AmiLogger, level LogLevel) *AMILevelLogger {
+	if base == nil {
+		base = NewAmiNopLogger()
+	}
+	l := &AMILevelLogger{base: base, level: level}
+	l.components = make(map[string]LogLevel)
+	return l
+}
+
+// SetLevel
+// SetLevel sets the level of the components without their own level.
+func (l *AMILevelLogger) SetLevel(level LogLevel) *AMILevelLogger {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	l.level = level
+	return l
+}
+
+// SetComponentLevel
+// SetComponentLevel sets the level of the component, i.e: SetComponentLevel("command", ami.Warn).
+// The component is the "component" field of the logs, see config.AmiLogComponent*.
+func (l *AMILevelLogger) SetComponentLevel(component string, level LogLevel) *AMILevelLogger {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	l.components[component] = level
+	return l
+}
+
+// Enabled
+// Enabled returns true if the logs of the level and the component are written.
+func (l *AMILevelLogger) Enabled(level LogLevel, component string) bool {
+	l.mutex.RLock()
+	defer l.mutex.RUnlock()
+	threshold, ok := l.components[component]
+	if !ok {
+		threshold = l.level
+	}
+	return level.rank() >= threshold.rank()
+}
+
+func (l *AMILevelLogger) Debug(msg string, fields ...interface{}) {
+	if l.Enabled(Debug, logComponent(fields)) {
+		l.base.Debug(msg, fields...)
+	}
+}
+
+func (l *AMILevelLogger) Info(msg string, fields ...interface{}) {
+	if l.Enabled(Info, logComponent(fields)) {
+		l.base.Info(msg, fields...)
+	}
+}
+
+func (l *AMILevelLogger) Warn(msg string, fields ...interface{}) {
+	if l.Enabled(Warn, logComponent(fields)) {
+		l.base.Warn(msg, fields...)
+	}
+}
+
+func (l *AMILevelLogger) Error(msg string, fields ...interface{}) {
+	if l.Enabled(Error, logComponent(fields)) {
+		l.base.Error(msg, fields...)
+	}
+}
+
+// logComponent returns the value of the component field
+func logComponent(fields []interface{}) string {
+	for i := 0; i+1 < len(fields); i += 2 {
+		if fields[i] == config.AmiLogFieldComponent {
+			return fmt.Sprint(fields[i+1])
+		}
+	}
+	return ""
+}
+
+// NewAmiJsonLogger
+// NewAmiJsonLogger creates the logger writing the logs of the level and above as JSON lines to w (stdout if nil).
+func NewAmiJsonLogger(w io.Writer, level LogLevel) *AMIJsonLogger {
+	if w == nil {
+		w = os.Stdout
+	}
+	return &AMIJsonLogger{w: w, level: level}
+}
+
+func (l *AMIJsonLogger) Debug(msg string, fields ...interface{}) { l.write(Debug, msg, fields) }
+func (l *AMIJsonLogger) Info(msg string, fields ...interface{})  { l.write(Info, msg, fields) }
+func (l *AMIJsonLogger) Warn(msg string, fields ...interface{})  { l.write(Warn, msg, fields) }
+func (l *AMIJsonLogger) Error(msg string, fields ...interface{}) { l.write(Error, msg, fields) }
+
+func (l *AMIJsonLogger) write(level LogLevel, msg string, fields []interface{}) {
+	if level.rank() < l.level.rank() {
+		return
+	}
+	entry := LoggerEntry{Timestamp: time.Now(), Level: level, Message: msg}
+	if len(fields) > 0 {
+		entry.Fields = make(map[string]interface{}, len(fields)/2)
+		for i := 0; i < len(fields); i += 2 {
+			key := fmt.Sprint(fields[i])
+			if i+1 == len(fields) {
+				entry.Fields["!BADKEY"] = fields[i]
+				break
+			}
+			value := fields[i+1]
+			if err, ok := value.(error); ok {
+				value = err.Error()
+			}
+			entry.Fields[key] = value
+		}
+	}
+	v, err := json.Marshal(entry)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error marshaling log entry: %v\n", err)
+		return
+	}
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	fmt.Fprintln(l.w, string(v))
+}
+
+// rank returns the order of the level, the unknown levels are ordered as Info
+func (l LogLevel) rank() int {
+	switch l {
+	case Debug:
+		return -4
+	case Warn:
+		return 4
+	case Error:
+		return 8
+	case Fatal:
+		return 12
+	default:
+		return 0
+	}
+}
+
+// D
+// D returns the logger printing JSON logs of the debug level and above to stdout.
+//
+// Deprecated: the library logs to the AmiLogger of SetLogger, D remains for the applications.
 func D() *Logger {
 	if d != nil {
 		return d
@@ -52,6 +304,10 @@ func D() *Logger {
 	return d
 }
 
+// I
+// I returns the logger printing JSON logs of the info level and above to stdout.
+//
+// Deprecated: see D.
 func I() *Logger {
 	if i != nil {
 		return i
@@ -60,6 +316,10 @@ func I() *Logger {
 	return i
 }
 
+// W
+// W returns the logger printing JSON logs of the warn level and above to stdout.
+//
+// Deprecated: see D.
 func W() *Logger {
 	if w != nil {
 		return w
@@ -68,6 +328,10 @@ func W() *Logger {
 	return w
 }
 
+// E
+// E returns the logger printing JSON logs of the error level and above to stdout.
+//
+// Deprecated: see D.
 func E() *Logger {
 	if e != nil {
 		return e
@@ -76,6 +340,10 @@ func E() *Logger {
 	return e
 }
 
+// F
+// F returns the logger printing JSON logs of the fatal level and above to stdout.
+//
+// Deprecated: see D.
 func F() *Logger {
 	if f != nil {
 		return f
@@ -102,7 +370,7 @@ func (l *Logger) log(level LogLevel, format string, args ...interface{}) {
 	if !l.IsEnabled {
 		return
 	}
-	if l.Level <= level {
+	if l.Level.rank() <= level.rank() {
 		entry := LoggerEntry{
 			Timestamp: time.Now(),
 			Level:     level,
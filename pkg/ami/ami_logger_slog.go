@@ -0,0 +1,53 @@
+//go:build go1.21
+
+package ami
+
+import (
+	"context"
+	"log/slog"
+)
+
+// AMISlogLogger adapts *slog.Logger to AmiLogger.
+type AMISlogLogger struct {
+	l *slog.Logger
+}
+
+// NewAmiSlogLogger
+// NewAmiSlogLogger creates the logger writing the logs to the slog logger, slog.Default() if nil.
+// The fields become the attributes of the records, so the handler decides the format and the levels, i.e:
+//
+//	ami.SetLogger(ami.NewAmiSlogLogger(slog.New(slog.NewJSONHandler(os.Stderr, nil))))
+func NewAmiSlogLogger(l *slog.Logger) *AMISlogLogger {
+	if l == nil {
+		l = slog.Default()
+	}
+	return &AMISlogLogger{l: l}
+}
+
+// Slog
+// Slog returns the slog logger.
+func (s *AMISlogLogger) Slog() *slog.Logger {
+	return s.l
+}
+
+func (s *AMISlogLogger) Debug(msg string, fields ...interface{}) {
+	s.l.Log(context.Background(), slog.LevelDebug, msg, fields...)
+}
+
+func (s *AMISlogLogger) Info(msg string, fields ...interface{}) {
+	s.l.Log(context.Background(), slog.LevelInfo, msg, fields...)
+}
+
+func (s *AMISlogLogger) Warn(msg string, fields ...interface{}) {
+	s.l.Log(context.Background(), slog.LevelWarn, msg, fields...)
+}
+
+func (s *AMISlogLogger) Error(msg string, fields ...interface{}) {
+	s.l.Log(context.Background(), slog.LevelError, msg, fields...)
+}
+
+// SlogLevel
+// SlogLevel returns the slog level of the level, i.e: to configure the handler as the level logger.
+func SlogLevel(level LogLevel) slog.Level {
+	return slog.Level(level.rank())
+}
@@ -3,7 +3,6 @@ package ami
 import (
 	"context"
 	"fmt"
-	"log"
 	"strings"
 
 	"github.com/pnguyen215/voipkit/pkg/ami/config"
@@ -106,7 +105,7 @@ func Logoff(ctx context.Context, s AMISocket) error {
 	if err != nil {
 		return err
 	}
-	log.Printf("Logoff, response = %v", JsonString(response))
+	s.Logger(config.AmiLogComponentCommand).Debug("logged off", config.AmiLogFieldAction, c.Action, config.AmiLogFieldActionId, c.ID, "response", response)
 	return err
 }
 
@@ -131,7 +130,7 @@ func Ping(ctx context.Context, s AMISocket) error {
 		return err
 	}
 
-	log.Printf("Ping, response = %v", JsonString(response))
+	s.Logger(config.AmiLogComponentCommand).Debug("pong", config.AmiLogFieldAction, c.Action, config.AmiLogFieldActionId, c.ID, "response", response)
 	return err
 }
 
@@ -145,6 +144,39 @@ func Command(ctx context.Context, s AMISocket, cmd string) (AmiReplies, error) {
 	return callback.SendLevel()
 }
 
+// SendAction sends any action with the fields and returns its response,
+// followed by the events of its list (until EventList: Complete) if the response starts one.
+func SendAction(ctx context.Context, s AMISocket, action string, fields map[string]string) ([]AmiReply, error) {
+	c := NewCommand().SetId(s.UUID).SetAction(action)
+	if len(fields) > 0 {
+		c.SetV(fields)
+	}
+	unlock, err := s.lock(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+	reply, err := c.send(ctx, s, c)
+	if err != nil {
+		return nil, err
+	}
+	replies := []AmiReply{reply}
+	if !strings.EqualFold(reply.GetFold("EventList"), "start") {
+		return replies, nil
+	}
+	for {
+		event, err := c.Read(ctx, s)
+		if err != nil {
+			return replies, err
+		}
+		replies = append(replies, event)
+		s.Tracer().OnEvent(WithActionId(ctx, c.ID), event)
+		if strings.EqualFold(event.GetFold("EventList"), "Complete") {
+			return replies, nil
+		}
+	}
+}
+
 // CoreSettings shows PBX core settings (version etc).
 func CoreSettings(ctx context.Context, s AMISocket) (AmiReply, error) {
 	c := NewCommand().SetId(s.UUID).SetAction(config.AmiActionCoreSettings)
@@ -6,7 +6,6 @@ import (
 	"crypto/rand"
 	"encoding/json"
 	"fmt"
-	"log"
 	"net/textproto"
 	"reflect"
 	"strings"
@@ -64,7 +63,7 @@ func ofMessageWithDictionary(d *AMIDictionary, header textproto.MIMEHeader) *AMI
 		p := make(textproto.MIMEHeader)
 		for k, v := range header {
 			p.Add(d.TranslateField(k), header.Get(k))
-			log.Printf("(AMI). header renew with key = %v and value = %v", k, JsonString(v))
+			componentLog(config.AmiLogComponentDictionary).Debug("header translated", "key", k, "value", v)
 		}
 		m.header = p
 	} else {
@@ -295,6 +294,19 @@ func (k *AMIMessage) Bytes() []byte {
 	return _buffer.Bytes()
 }
 
+// Reply returns the first value of each field of the AMI message
+func (k *AMIMessage) Reply() AmiReply {
+	k.mutex.RLock()
+	defer k.mutex.RUnlock()
+	reply := make(AmiReply, len(k.header))
+	for key, values := range k.header {
+		if len(values) > 0 {
+			reply[key] = values[0]
+		}
+	}
+	return reply
+}
+
 // Return true if the AMI message is event type
 func (k *AMIMessage) IsEvent() bool {
 	return k.Field(config.AmiEventKey) != ""
@@ -440,7 +452,6 @@ func FromJson(jsonString string) (*AMIMessage, error) {
 	err := json.Unmarshal([]byte(jsonString), &builder)
 
 	if err != nil {
-		log.Printf(err.Error())
 		return message, err
 	}
 
@@ -0,0 +1,209 @@
+package ami
+
+import (
+	"bufio"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pnguyen215/voipkit/pkg/ami/config"
+)
+
+var metrics *AMIMetrics = NewAMIMetrics()
+
+// Metrics
+// Metrics returns the health counters of the library, they are recorded by the actions of the core,
+// the event loop of the client, the reconnections and the event streams.
+func Metrics() *AMIMetrics {
+	return metrics
+}
+
+func NewAMIMetrics() *AMIMetrics {
+	m := &AMIMetrics{}
+	m.Reset()
+	return m
+}
+
+// Reset
+// Reset clears the counters.
+func (m *AMIMetrics) Reset() {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.actions = make(map[[2]string]uint64)
+	m.latencies = make(map[string]*amiMetricHistogram)
+	m.events = make(map[string]uint64)
+	m.reconnects = make(map[string]uint64)
+	m.dropped = make(map[string]uint64)
+}
+
+// ObserveAction
+// ObserveAction counts the action sent with its outcome (success, failure or error) and its latency.
+func (m *AMIMetrics) ObserveAction(action, outcome string, duration time.Duration) {
+	if IsStringEmpty(action) {
+		return
+	}
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.actions[[2]string{action, outcome}]++
+	h, ok := m.latencies[action]
+	if !ok {
+		h = &amiMetricHistogram{buckets: make([]uint64, len(config.AmiMetricLatencyBuckets))}
+		m.latencies[action] = h
+	}
+	seconds := duration.Seconds()
+	for i, bound := range config.AmiMetricLatencyBuckets {
+		if seconds <= bound {
+			h.buckets[i]++
+		}
+	}
+	h.count++
+	h.sum += seconds
+}
+
+// ObserveEvent
+// ObserveEvent counts the event received by its name.
+func (m *AMIMetrics) ObserveEvent(event string) {
+	if IsStringEmpty(event) {
+		return
+	}
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.events[event]++
+}
+
+// ObserveReconnect
+// ObserveReconnect counts the reconnection of the client, i.e: ami or ari.
+func (m *AMIMetrics) ObserveReconnect(client string) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.reconnects[client]++
+}
+
+// ObserveDroppedEvent
+// ObserveDroppedEvent counts the event dropped by the source, i.e: the slow client of the event stream.
+func (m *AMIMetrics) ObserveDroppedEvent(source string) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.dropped[source]++
+}
+
+// WriteTo
+// WriteTo writes the counters in the Prometheus text exposition format.
+func (m *AMIMetrics) WriteTo(w io.Writer) (int64, error) {
+	return writeMetricFamilies(w, m.families())
+}
+
+func (m *AMIMetrics) families() []amiMetricFamily {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	actions := amiMetricFamily{name: "voipkit_ami_actions_total", help: "Actions sent to Asterisk by name and outcome.", kind: "counter"}
+	for key, count := range m.actions {
+		actions.samples = append(actions.samples, amiMetricSample{labels: [][2]string{{"action", key[0]}, {"outcome", key[1]}}, value: float64(count)})
+	}
+	latencies := amiMetricFamily{name: "voipkit_ami_action_duration_seconds", help: "Latency of the actions sent to Asterisk.", kind: "histogram"}
+	for _, action := range sortedMetricKeys(m.latencies) {
+		h := m.latencies[action]
+		for i, bound := range config.AmiMetricLatencyBuckets {
+			latencies.samples = append(latencies.samples, amiMetricSample{suffix: "_bucket",
+				labels: [][2]string{{"action", action}, {"le", formatMetricValue(bound)}}, value: float64(h.buckets[i])})
+		}
+		latencies.samples = append(latencies.samples,
+			amiMetricSample{suffix: "_bucket", labels: [][2]string{{"action", action}, {"le", "+Inf"}}, value: float64(h.count)},
+			amiMetricSample{suffix: "_sum", labels: [][2]string{{"action", action}}, value: h.sum},
+			amiMetricSample{suffix: "_count", labels: [][2]string{{"action", action}}, value: float64(h.count)})
+	}
+	return []amiMetricFamily{
+		sortMetricFamily(actions),
+		latencies,
+		countersMetricFamily("voipkit_ami_events_total", "Events received from Asterisk by name.", "event", m.events),
+		countersMetricFamily("voipkit_reconnects_total", "Reconnections of the clients.", "client", m.reconnects),
+		countersMetricFamily("voipkit_dropped_events_total", "Events dropped by the source, i.e: the slow consumers.", "source", m.dropped),
+	}
+}
+
+func countersMetricFamily(name, help, label string, counters map[string]uint64) amiMetricFamily {
+	f := amiMetricFamily{name: name, help: help, kind: "counter"}
+	for key, count := range counters {
+		f.samples = append(f.samples, amiMetricSample{labels: [][2]string{{label, key}}, value: float64(count)})
+	}
+	return sortMetricFamily(f)
+}
+
+// sortMetricFamily sorts the samples by their labels, so that the exposition is stable between the scrapes
+func sortMetricFamily(f amiMetricFamily) amiMetricFamily {
+	sort.SliceStable(f.samples, func(i, j int) bool {
+		return formatMetricLabels(f.samples[i].labels) < formatMetricLabels(f.samples[j].labels)
+	})
+	return f
+}
+
+func sortedMetricKeys(values map[string]*amiMetricHistogram) []string {
+	keys := make([]string, 0, len(values))
+	for k := range values {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func writeMetricFamilies(w io.Writer, families []amiMetricFamily) (int64, error) {
+	var written int64
+	buffer := bufio.NewWriter(w)
+	write := func(s string) {
+		n, _ := buffer.WriteString(s)
+		written += int64(n)
+	}
+	for _, f := range families {
+		write("# HELP " + f.name + " " + f.help + "\n")
+		write("# TYPE " + f.name + " " + f.kind + "\n")
+		for _, s := range f.samples {
+			write(f.name + s.suffix + formatMetricLabels(s.labels) + " " + formatMetricValue(s.value) + "\n")
+		}
+	}
+	return written, buffer.Flush()
+}
+
+var metricLabelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func formatMetricLabels(labels [][2]string) string {
+	if len(labels) == 0 {
+		return ""
+	}
+	var b strings.Builder
+	b.WriteString("{")
+	for i, label := range labels {
+		if i > 0 {
+			b.WriteString(",")
+		}
+		b.WriteString(label[0] + `="` + metricLabelEscaper.Replace(label[1]) + `"`)
+	}
+	b.WriteString("}")
+	return b.String()
+}
+
+func formatMetricValue(value float64) string {
+	switch {
+	case math.IsInf(value, 1):
+		return "+Inf"
+	case math.IsInf(value, -1):
+		return "-Inf"
+	case math.IsNaN(value):
+		return "NaN"
+	}
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
+
+// metricOutcome returns the outcome of the action by the error and the response of Asterisk
+func metricOutcome(err error, response string) string {
+	switch {
+	case err != nil:
+		return config.AmiMetricOutcomeError
+	case IsStringEmpty(response) || strings.EqualFold(response, config.AmiStatusSuccessKey):
+		return config.AmiMetricOutcomeSuccess
+	default:
+		return config.AmiMetricOutcomeFailure
+	}
+}
@@ -3,14 +3,21 @@ package ami
 import (
 	"bufio"
 	"context"
+	"database/sql"
+	"encoding/json"
+	"io"
 	"net"
+	"net/http"
 	"net/textproto"
+	"os"
 	"sync"
 	"time"
+
+	"github.com/pnguyen215/voipkit/pkg/ami/config"
 )
 
 type PubChannel chan *AMIMessage
-type MessageChannel map[string]PubChannel
+type MessageChannel map[string][]PubChannel
 type tcpAmiFactory struct{}
 type udpAmiFactory struct{}
 type AmiReply map[string]string
@@ -24,6 +31,9 @@ type AmiClient struct {
 	password  string
 	privilege string
 	timeout   time.Duration
+	logger    AmiLogger
+	tap       *AMITap
+	tracer    AmiTracer
 }
 
 type AMI struct {
@@ -39,12 +49,17 @@ type AMI struct {
 	socket  *AMISocket
 	c       *AMICore
 	a       *AMIAuth
+	ari     *AMIAri
+	logger  AmiLogger
+	tracer  AmiTracer
 }
 
 type AMIPubSubQueue struct {
 	message MessageChannel
+	queues  map[PubChannel]*amiPubQueue // the queues of the lossless subscribers, by their channel
 	mutex   sync.RWMutex
 	Off     bool `json:"off"`
+	dropped uint64
 }
 
 type AMIMessage struct {
@@ -106,6 +121,7 @@ type AMIChannel struct {
 type AMISocket struct {
 	conn                 net.Conn
 	incoming             chan string
+	actions              chan struct{} // held by the action in flight, shared by the copies of the socket
 	shutdown             chan struct{}
 	errors               chan error
 	Dictionary           *AMIDictionary `json:"dictionary,omitempty"`
@@ -115,6 +131,9 @@ type AMISocket struct {
 	MaxRetries           int            `json:"max_retries"`
 	DebugMode            bool           `json:"debug_mode"`
 	MaxConcurrencyMillis int64          `json:"max_concurrency_millis"`
+	logger               AmiLogger
+	tracer               AmiTracer
+	tap                  *AMITap // the tap of the connection, its sensitive headers are redacted from the logs
 }
 
 // AMICommand
@@ -453,6 +472,8 @@ type AMICdr struct {
 	AmaFlags string `json:"ama_flags,omitempty"`
 	// A unique identifier for the Party A channel.
 	UniqueId string `json:"unique_id"`
+	// The unique identifier of the call, shared by its channels. It is sent if mapped in cdr_manager.conf, i.e: linkedid => LinkedID
+	LinkedId string `json:"linked_id,omitempty"`
 	// A user defined field set on the channels. If set on both the Party A and Party B channel, the user-fields of both are concatenated and separated by a ;.
 	UserField      string    `json:"user_field,omitempty"`
 	DateReceivedAt time.Time `json:"date_received_at"`
@@ -463,6 +484,8 @@ type AMICdr struct {
 	Extension      string    `json:"extension,omitempty"`
 	Number         string    `json:"number,omitempty"`
 	MediaLink      string    `json:"media_link,omitempty"` // the only cdr has status answered
+	Cost           float64   `json:"cost,omitempty"`       // the cost rated by the rating engine
+	Currency       string    `json:"currency,omitempty"`   // the currency of the cost
 	symbol         string    // default extension splitter symbol: -, example: SIP/1000-00098fec then split by -
 }
 
@@ -473,3 +496,1097 @@ type AMIChanspy struct {
 	ChannelProtocol    string `json:"channel_protocol" binding:"required"` // protocols include: SIP, H323, IAX...
 	DebugMode          bool   `json:"debug_mode"`                          // allow to trace log
 }
+
+type AMIRegistrationSample struct {
+	Endpoint       string    `json:"endpoint"`
+	Technology     string    `json:"technology"`
+	Event          string    `json:"event"`
+	Status         string    `json:"status"`
+	PreviousStatus string    `json:"previous_status,omitempty"`
+	Reachable      bool      `json:"reachable"`
+	Address        string    `json:"address,omitempty"`
+	UserAgent      string    `json:"user_agent,omitempty"`
+	RoundTripMs    float64   `json:"round_trip_ms,omitempty"`
+	Cause          string    `json:"cause,omitempty"`
+	At             time.Time `json:"at"`
+}
+
+type AMIEndpointRegistration struct {
+	Endpoint         string                  `json:"endpoint"`
+	Technology       string                  `json:"technology"`
+	Status           string                  `json:"status"`
+	Reachable        bool                    `json:"reachable"`
+	Address          string                  `json:"address,omitempty"`
+	UserAgent        string                  `json:"user_agent,omitempty"`
+	RoundTripMs      float64                 `json:"round_trip_ms,omitempty"`
+	BaselineRttMs    float64                 `json:"baseline_rtt_ms,omitempty"`
+	Flapping         bool                    `json:"flapping"`
+	LatencyRegressed bool                    `json:"latency_regressed"`
+	LastSeenAt       time.Time               `json:"last_seen_at"`
+	LastChangedAt    time.Time               `json:"last_changed_at"`
+	UnreachableSince time.Time               `json:"unreachable_since,omitempty"`
+	History          []AMIRegistrationSample `json:"history,omitempty"`
+	Transitions      []AMIRegistrationSample `json:"transitions,omitempty"`
+	rttSamples       int                     // number of qualify samples contributing to the baseline
+	flips            []time.Time             // moments the reachability flipped, within the flap window
+	unreachableAlert bool                    // the unreachable-too-long alert has been raised for the current outage
+}
+
+type AMIRegistrationAlert struct {
+	Kind     string                  `json:"kind"`
+	Endpoint string                  `json:"endpoint"`
+	Message  string                  `json:"message"`
+	State    AMIEndpointRegistration `json:"state"`
+	At       time.Time               `json:"at"`
+}
+
+type AMIRegistrationTracker struct {
+	HistorySize      int           `json:"history_size"`
+	FlapThreshold    int           `json:"flap_threshold"`
+	FlapWindow       time.Duration `json:"flap_window"`
+	UnreachableAfter time.Duration `json:"unreachable_after"`
+	LatencyFactor    float64       `json:"latency_factor"`
+	LatencyMinMs     float64       `json:"latency_min_ms"`
+	LatencyWarmup    int           `json:"latency_warmup"`
+	InspectInterval  time.Duration `json:"inspect_interval"`
+	mutex            sync.RWMutex
+	endpoints        map[string]*AMIEndpointRegistration
+	onAlert          []func(AMIRegistrationAlert)
+	onTransition     []func(AMIRegistrationSample)
+}
+
+type AMIExtensionPresence struct {
+	Extension          string            `json:"extension"`
+	Context            string            `json:"context"`
+	Hint               string            `json:"hint,omitempty"`
+	ExtensionState     int               `json:"extension_state"`
+	ExtensionStateText string            `json:"extension_state_text,omitempty"`
+	DeviceStates       map[string]string `json:"device_states,omitempty"`
+	Presence           string            `json:"presence,omitempty"`
+	PresenceSubtype    string            `json:"presence_subtype,omitempty"`
+	Dnd                bool              `json:"dnd"`
+	Status             string            `json:"status"`
+	Message            string            `json:"message,omitempty"`
+	UpdatedAt          time.Time         `json:"updated_at"`
+}
+
+type AMIPresenceChange struct {
+	Previous AMIExtensionPresence `json:"previous"`
+	Current  AMIExtensionPresence `json:"current"`
+}
+
+type AMIPresenceService struct {
+	DndDevicePrefix string   `json:"dnd_device_prefix"`
+	Contexts        []string `json:"contexts"`
+	mutex           sync.RWMutex
+	extensions      map[string]*AMIExtensionPresence
+	devices         map[string]string
+	presences       map[string]amiPresenceState
+	subscribers     []func(AMIPresenceChange)
+}
+
+type amiPresenceState struct {
+	state   string
+	subtype string
+	message string
+}
+
+type AMIConfParticipant struct {
+	Conference     string    `json:"conference"`
+	Channel        string    `json:"channel"`
+	UniqueId       string    `json:"unique_id,omitempty"`
+	CallerIdNumber string    `json:"caller_id_number,omitempty"`
+	CallerIdName   string    `json:"caller_id_name,omitempty"`
+	Admin          bool      `json:"admin"`
+	Marked         bool      `json:"marked"`
+	Muted          bool      `json:"muted"`
+	Talking        bool      `json:"talking"`
+	JoinedAt       time.Time `json:"joined_at"`
+}
+
+type AMIConfRoom struct {
+	Conference   string               `json:"conference"`
+	Locked       bool                 `json:"locked"`
+	Recording    bool                 `json:"recording"`
+	StartedAt    time.Time            `json:"started_at"`
+	Participants []AMIConfParticipant `json:"participants"`
+}
+
+type AMIConfBridgeManager struct {
+	AutoRecordParticipants int                            `json:"auto_record_participants"`
+	RecordFileFunc         func(conference string) string `json:"-"`
+	core                   *AMICore
+	mutex                  sync.RWMutex
+	rooms                  map[string]*amiConfRoomState
+	onChange               []func(event string, room AMIConfRoom)
+}
+
+type amiConfRoomState struct {
+	conference   string
+	locked       bool
+	recording    bool
+	startedAt    time.Time
+	participants map[string]*AMIConfParticipant
+}
+
+type AMIParkedCall struct {
+	Lot              string        `json:"lot"`
+	Space            string        `json:"space"`
+	Channel          string        `json:"channel"`
+	UniqueId         string        `json:"unique_id,omitempty"`
+	CallerIdNumber   string        `json:"caller_id_number,omitempty"`
+	CallerIdName     string        `json:"caller_id_name,omitempty"`
+	ParkerDialString string        `json:"parker_dial_string,omitempty"`
+	Timeout          time.Duration `json:"timeout"`
+	ParkedAt         time.Time     `json:"parked_at"`
+}
+
+type AMIParkingLot struct {
+	Name       string          `json:"name"`
+	StartSpace int             `json:"start_space,omitempty"`
+	StopSpace  int             `json:"stop_space,omitempty"`
+	Timeout    time.Duration   `json:"timeout,omitempty"`
+	Calls      []AMIParkedCall `json:"calls"`
+}
+
+type AMIParkingService struct {
+	DefaultContext string        `json:"default_context"`
+	WaitTimeout    time.Duration `json:"wait_timeout"`
+	core           *AMICore
+	mutex          sync.RWMutex
+	lots           map[string]*AMIParkingLot
+	contexts       map[string]string
+	waiters        map[string][]chan AMIParkedCall
+	onChange       []func(event string, call AMIParkedCall)
+	opened         int32 // the number of Open consuming the events
+}
+
+type AMICallResult struct {
+	ActionId string    `json:"action_id"`
+	Outcome  string    `json:"outcome"`
+	Reason   int       `json:"reason"`
+	Response string    `json:"response,omitempty"`
+	Channel  string    `json:"channel,omitempty"`
+	UniqueId string    `json:"unique_id,omitempty"`
+	Expired  bool      `json:"expired,omitempty"` // the OriginateResponse event never arrived
+	At       time.Time `json:"at"`
+}
+
+type AMICallHandle struct {
+	ActionId    string `json:"action_id"`
+	originator  *AMIOriginator
+	mutex       sync.RWMutex
+	channel     string
+	uniqueId    string
+	result      *AMICallResult
+	hangupCause string
+	known       chan struct{}
+	done        chan struct{}
+	ended       chan struct{}
+	trackers    []func(*AMIMessage)
+	expiry      *time.Timer
+}
+
+type AMIOriginator struct {
+	AssignChannelId bool          `json:"assign_channel_id"`
+	ResponseGrace   time.Duration `json:"response_grace"`
+	core            *AMICore
+	mutex           sync.RWMutex
+	pending         map[string]*AMICallHandle
+	legs            map[string]*AMICallHandle
+}
+
+// AMICdrDidRange
+// AMICdrDidRange describes a block of DID numbers, either by a prefix (i.e: 8428730) or
+// an inclusive numeric range of the same length (i.e: from 842873000 to 842873099).
+type AMICdrDidRange struct {
+	Prefix string `json:"prefix,omitempty"`
+	From   string `json:"from,omitempty"`
+	To     string `json:"to,omitempty"`
+}
+
+// AMICdrRule
+// AMICdrRule is a single classification rule. Every non-empty condition must match (AND),
+// and any value of a condition list may match (OR). Patterns support the wildcards of path.Match (i.e: from-trunk-*).
+type AMICdrRule struct {
+	Name         string           `json:"name"`
+	Contexts     []string         `json:"contexts,omitempty"`      // patterns of the destination context
+	Technologies []string         `json:"technologies,omitempty"`  // channel technologies of party A (i.e: SIP, PJSIP, IAX2, Local)
+	Trunks       []string         `json:"trunks,omitempty"`        // patterns of the endpoint name of party A or party B (i.e: trunk_vn_*)
+	DIDs         []AMICdrDidRange `json:"dids,omitempty"`          // DID ranges of the destination
+	Applications []string         `json:"applications,omitempty"`  // last dialplan applications (i.e: Dial, Queue, ChanSpy)
+	AccountCodes []string         `json:"account_codes,omitempty"` // patterns of the account code
+	Phone        *bool            `json:"phone,omitempty"`         // whether the destination must (or must not) be a valid phone number
+	Regions      []string         `json:"regions,omitempty"`       // regions used to verify the phone, overrides the classifier regions
+	Direction    string           `json:"direction"`
+	Type         string           `json:"type,omitempty"`
+	ExtensionBy  string           `json:"extension_by,omitempty"` // the CDR field holding the extension, default: channel
+	NumberBy     string           `json:"number_by,omitempty"`    // the CDR field holding the number
+	DescFrom     string           `json:"desc_from,omitempty"`    // the CDR field of the description caller, default: channel
+	DescTo       string           `json:"desc_to,omitempty"`      // the CDR field of the description callee, default: destination
+	StripTech    bool             `json:"strip_tech,omitempty"`   // strip the channel technology out of the extension (i.e: SIP/1000 to 1000)
+}
+
+// AMICdrClassifier
+// AMICdrClassifier decides the direction and the type of the CDR from an ordered list of rules,
+// the first matching rule wins.
+type AMICdrClassifier struct {
+	Regions     []string     `json:"regions,omitempty"`
+	PhonePrefix []string     `json:"phone_prefix,omitempty"`
+	Symbol      string       `json:"symbol,omitempty"` // the splitter of the channel unique suffix, default: -
+	Rules       []AMICdrRule `json:"rules"`
+}
+
+// AMICdrWriter
+// AMICdrWriter buffers the CDR records and flushes them into the sink on interval or on size,
+// the failed batches are retried and then spooled on disk until the sink recovers.
+type AMICdrWriter struct {
+	BatchSize     int           `json:"batch_size"`
+	FlushInterval time.Duration `json:"flush_interval"`
+	Retries       int           `json:"retries"`
+	RetryBackoff  time.Duration `json:"retry_backoff"`
+	WriteTimeout  time.Duration `json:"write_timeout"`
+	SpoolDir      string        `json:"spool_dir,omitempty"`
+	sink          AmiCdrSink
+	dictionary    *AMIDictionary
+	classifier    *AMICdrClassifier
+	rating        *AMIRatingEngine
+	recordings    *AMIRecordingManager
+	buffer        []AMICdr
+	mutex         sync.Mutex
+	flushing      sync.Mutex
+	notify        chan struct{}
+	stop          chan struct{}
+	done          chan struct{}
+	started       bool
+	closed        bool
+	once          sync.Once
+}
+
+// AMICdrCsvSink
+// AMICdrCsvSink writes the CDR records as CSV rows of the configured columns.
+type AMICdrCsvSink struct {
+	Columns     []string `json:"columns"`
+	Header      bool     `json:"header"`
+	writer      io.Writer
+	closer      io.Closer
+	wroteHeader bool
+	mutex       sync.Mutex
+}
+
+// AMICdrJsonLinesSink
+// AMICdrJsonLinesSink writes the CDR records as JSON Lines into a file rotated by size.
+type AMICdrJsonLinesSink struct {
+	Filename   string `json:"filename"`
+	MaxSize    int64  `json:"max_size"`    // the size in bytes to rotate the file, 0 means never
+	MaxBackups int    `json:"max_backups"` // the number of rotated files kept, 0 means all
+	file       *os.File
+	size       int64
+	mutex      sync.Mutex
+}
+
+// AMICdrSqlSink
+// AMICdrSqlSink batch-inserts the CDR records into a table by database/sql.
+type AMICdrSqlSink struct {
+	Table       string   `json:"table"`
+	Columns     []string `json:"columns"`
+	MaxRows     int      `json:"max_rows"` // the maximum rows per insert statement
+	db          *sql.DB
+	placeholder func(index int) string
+}
+
+// AMICdrAnalytics
+// AMICdrAnalytics keeps the running statistics of the CDR records of the retention window, by minute.
+type AMICdrAnalytics struct {
+	Retention time.Duration           `json:"retention"` // the records older than the retention are evicted, 0 means never
+	Symbol    string                  `json:"symbol"`    // the splitter of the channel unique suffix, default: -
+	minutes   map[int64]*amiCdrMinute // by unix time of the minute
+	order     []int64                 // the minutes sorted, for the eviction and the window of the reports
+	count     int
+	trunkFunc func(r *AMICdr) string
+	didFunc   func(r *AMICdr) string
+	mutex     sync.RWMutex
+}
+
+// amiCdrMinute is the statistics of the records started within a minute, by group and key
+type amiCdrMinute struct {
+	at    time.Time
+	calls int
+	stats map[string]*AMICdrStats
+}
+
+// AMICdrQuery
+// AMICdrQuery selects the records started within [From, To) and groups them by key and time bucket.
+type AMICdrQuery struct {
+	From     time.Time      `json:"from,omitempty"`
+	To       time.Time      `json:"to,omitempty"`
+	GroupBy  string         `json:"group_by,omitempty"` // all, extension, trunk, direction, did
+	Bucket   string         `json:"bucket,omitempty"`   // none, hour, day
+	Location *time.Location `json:"-"`                  // the location of the buckets, default: UTC
+}
+
+// AMICdrStats
+// AMICdrStats is the statistics of a group within a time bucket.
+type AMICdrStats struct {
+	GroupBy                string         `json:"group_by"`
+	Key                    string         `json:"key"`
+	BucketAt               time.Time      `json:"bucket_at,omitempty"`
+	Calls                  int            `json:"calls"`
+	Answered               int            `json:"answered"`
+	Busy                   int            `json:"busy"`
+	NoAnswer               int            `json:"no_answer"`
+	Failed                 int            `json:"failed"`
+	Congestion             int            `json:"congestion"`
+	Dispositions           map[string]int `json:"dispositions"`
+	ASR                    float64        `json:"asr"`              // answer-seizure ratio, in percent
+	ACD                    float64        `json:"acd"`              // average call duration of the answered calls, in seconds
+	AvgRingTime            float64        `json:"avg_ring_time"`    // average ringing time (duration - billable seconds) of the answered calls, in seconds, it includes the post dial delay
+	Duration               int            `json:"duration"`         // total duration, in seconds
+	BillableSeconds        int            `json:"billable_seconds"` // total billable, in seconds
+	BillableMinutes        float64        `json:"billable_minutes"`
+	BillableMinutesRounded int            `json:"billable_minutes_rounded"` // billable minutes rounded up per call
+	ringing                int
+}
+
+// AMICel
+// AMICel is a Channel Event Logging (CEL) record.
+type AMICel struct {
+	Event          string                 `json:"event"`
+	EventName      config.AmiCelEventKind `json:"event_name"`
+	EventTime      time.Time              `json:"event_time"`
+	AccountCode    string                 `json:"account_code,omitempty"`
+	CallerIdNumber string                 `json:"caller_id_number,omitempty"`
+	CallerIdName   string                 `json:"caller_id_name,omitempty"`
+	CallerIdAni    string                 `json:"caller_id_ani,omitempty"`
+	CallerIdRdnis  string                 `json:"caller_id_rdnis,omitempty"`
+	CallerIdDnid   string                 `json:"caller_id_dnid,omitempty"`
+	Exten          string                 `json:"exten,omitempty"`
+	Context        string                 `json:"context,omitempty"`
+	Channel        string                 `json:"channel"`
+	Application    string                 `json:"application,omitempty"`
+	AppData        string                 `json:"app_data,omitempty"`
+	AmaFlags       string                 `json:"ama_flags,omitempty"`
+	UniqueId       string                 `json:"unique_id"`
+	LinkedId       string                 `json:"linked_id"`
+	UserField      string                 `json:"user_field,omitempty"`
+	Peer           string                 `json:"peer,omitempty"`
+	PeerAccount    string                 `json:"peer_account,omitempty"`
+	Extra          string                 `json:"extra,omitempty"` // a JSON object, i.e: {"hangupcause":16,"dialstatus":"ANSWER"}
+	Privilege      string                 `json:"privilege,omitempty"`
+}
+
+// AMICelChannel
+// AMICelChannel is a channel of the call rebuilt from CEL.
+type AMICelChannel struct {
+	UniqueId    string    `json:"unique_id"`
+	Channel     string    `json:"channel"`
+	CallerId    string    `json:"caller_id,omitempty"`
+	Exten       string    `json:"exten,omitempty"`
+	Context     string    `json:"context,omitempty"`
+	StartedAt   time.Time `json:"started_at"`
+	AnsweredAt  time.Time `json:"answered_at,omitempty"`
+	EndedAt     time.Time `json:"ended_at,omitempty"`
+	HangupCause int       `json:"hangup_cause,omitempty"`
+	DialStatus  string    `json:"dial_status,omitempty"`
+}
+
+// AMICelCall
+// AMICelCall is the whole call of the linkedid rebuilt from CEL, across transfers and local channels.
+type AMICelCall struct {
+	LinkedId        string                   `json:"linked_id"`
+	Caller          string                   `json:"caller,omitempty"`
+	Dnid            string                   `json:"dnid,omitempty"`
+	AccountCode     string                   `json:"account_code,omitempty"`
+	StartedAt       time.Time                `json:"started_at"`
+	AnsweredAt      time.Time                `json:"answered_at,omitempty"`
+	EndedAt         time.Time                `json:"ended_at,omitempty"`
+	Duration        int                      `json:"duration"`         // seconds, from the start to the end
+	BillableSeconds int                      `json:"billable_seconds"` // seconds, from the first bridged answer to the end
+	Answered        bool                     `json:"answered"`
+	Completed       bool                     `json:"completed"` // the LINKEDID_END was received
+	Transfers       int                      `json:"transfers"`
+	Parked          bool                     `json:"parked"`
+	PickedUp        bool                     `json:"picked_up"`
+	Channels        map[string]AMICelChannel `json:"channels"`
+	Events          []AMICel                 `json:"events"`
+}
+
+// AMICelAggregator
+// AMICelAggregator rebuilds the calls from the CEL events grouped by linkedid.
+type AMICelAggregator struct {
+	MaxAge     time.Duration `json:"max_age"` // the calls without LINKEDID_END are evicted after, 0 means never
+	dictionary *AMIDictionary
+	calls      map[string]*AMICelCall
+	callbacks  []func(AMICelCall)
+	mutex      sync.Mutex
+}
+
+// AMIRate
+// AMIRate is the tariff of a destination prefix. The billing increments are
+// the initial increment then the next increments, in seconds (i.e: 60/60, 30/6, 1/1).
+type AMIRate struct {
+	Prefix           string  `json:"prefix"`
+	Description      string  `json:"description,omitempty"`
+	PerMinute        float64 `json:"per_minute"`
+	ConnectionFee    float64 `json:"connection_fee,omitempty"`
+	InitialIncrement int     `json:"initial_increment"`
+	Increment        int     `json:"increment"`
+	MinimumDuration  int     `json:"minimum_duration,omitempty"` // seconds
+	TimeFrom         string  `json:"time_from,omitempty"`        // the start of the time-of-day tariff, i.e: 08:00
+	TimeTo           string  `json:"time_to,omitempty"`          // the end (exclusive) of the time-of-day tariff, i.e: 18:00
+	Currency         string  `json:"currency,omitempty"`
+}
+
+// AMIRateTable
+// AMIRateTable is the set of rates of a trunk, looked up by the longest prefix of the number.
+type AMIRateTable struct {
+	Name  string               `json:"name"`
+	Rates map[string][]AMIRate `json:"rates"`
+}
+
+// AMICdrRating
+// AMICdrRating is the result of rating a CDR.
+type AMICdrRating struct {
+	Number        string   `json:"number"`
+	Trunk         string   `json:"trunk,omitempty"`
+	Table         string   `json:"table"`
+	Rate          *AMIRate `json:"rate,omitempty"`
+	BilledSeconds int      `json:"billed_seconds"`
+	Cost          float64  `json:"cost"`
+	Currency      string   `json:"currency,omitempty"`
+}
+
+// AMIRatingEngine
+// AMIRatingEngine rates the CDR from the rate table of its trunk, or from the default table.
+type AMIRatingEngine struct {
+	Region      string         `json:"region"`
+	Directions  []string       `json:"directions"` // the directions rated, default: outbound
+	PhonePrefix []string       `json:"phone_prefix,omitempty"`
+	Symbol      string         `json:"symbol"`
+	Location    *time.Location `json:"-"` // the location of the time-of-day tariffs, default: UTC
+	tables      map[string]*AMIRateTable
+	fallback    *AMIRateTable
+	trunkFunc   func(r *AMICdr) string
+	mutex       sync.RWMutex
+}
+
+// AMIRecordingMute
+// AMIRecordingMute is a mute (pause) or unmute (resume) of a recording.
+type AMIRecordingMute struct {
+	Direction string    `json:"direction"`
+	Muted     bool      `json:"muted"`
+	At        time.Time `json:"at"`
+}
+
+// AMIRecording
+// AMIRecording is a MixMonitor recording of a channel.
+type AMIRecording struct {
+	Channel      string             `json:"channel"`
+	UniqueId     string             `json:"unique_id,omitempty"`
+	LinkedId     string             `json:"linked_id,omitempty"`
+	Extension    string             `json:"extension,omitempty"`
+	MixMonitorId string             `json:"mix_monitor_id,omitempty"`
+	File         string             `json:"file,omitempty"` // the file as recorded by Asterisk
+	Link         string             `json:"link,omitempty"` // the URL (or path) to fetch the recording
+	Status       string             `json:"status"`
+	Muted        bool               `json:"muted"`
+	Mutes        []AMIRecordingMute `json:"mutes,omitempty"`
+	StartedAt    time.Time          `json:"started_at"`
+	StoppedAt    time.Time          `json:"stopped_at,omitempty"`
+}
+
+// AMIRecordingRequest
+// AMIRecordingRequest is the request to start recording a channel.
+type AMIRecordingRequest struct {
+	Channel   string `json:"channel" binding:"required"`
+	UniqueId  string `json:"unique_id,omitempty"`
+	LinkedId  string `json:"linked_id,omitempty"`
+	Extension string `json:"extension,omitempty"`
+	Options   string `json:"options,omitempty"` // the MixMonitor options, default: the options of the manager
+	Command   string `json:"command,omitempty"` // the command executed when the recording is over
+}
+
+// AMIRecordingManager
+// AMIRecordingManager starts the MixMonitor recordings with templated file names, tracks their lifecycle
+// and resolves the media link of the CDR.
+type AMIRecordingManager struct {
+	Directory    string        `json:"directory"`     // the monitor directory of Asterisk, i.e: /var/spool/asterisk/monitor
+	BaseURL      string        `json:"base_url"`      // the URL serving the monitor directory, the link is a path if empty
+	FileTemplate string        `json:"file_template"` // i.e: {date}/{uniqueid}-{extension}.wav
+	Options      string        `json:"options"`
+	Retention    time.Duration `json:"retention"` // the stopped recordings are kept for the CDR lookup
+	core         *AMICore
+	mutex        sync.RWMutex
+	active       map[string]*AMIRecording // by channel
+	stopped      []*AMIRecording
+	onChange     []func(AMIRecording)
+}
+
+// AMIQueueLog
+// AMIQueueLog is a record of the queue_log file. The typed fields are decoded from data1..data5 by the verb,
+// the call id is the uniqueid of the caller channel, so that the record joins the AMI queue events.
+type AMIQueueLog struct {
+	Time         time.Time              `json:"time"`
+	CallId       string                 `json:"call_id"`
+	Queue        string                 `json:"queue"`
+	Agent        string                 `json:"agent"`
+	Event        config.AmiQueueLogVerb `json:"event"`
+	Data         []string               `json:"data,omitempty"`
+	CallerId     string                 `json:"caller_id,omitempty"`
+	Position     int                    `json:"position,omitempty"`
+	OrigPosition int                    `json:"orig_position,omitempty"`
+	WaitTime     int                    `json:"wait_time,omitempty"` // seconds waited in the queue before leaving
+	HoldTime     int                    `json:"hold_time,omitempty"` // seconds waited in the queue before connected
+	CallTime     int                    `json:"call_time,omitempty"` // seconds talked with the agent
+	RingTime     int                    `json:"ring_time,omitempty"` // seconds for CONNECT, milliseconds for RINGNOANSWER and RINGCANCELED
+	Reason       string                 `json:"reason,omitempty"`
+	Key          string                 `json:"key,omitempty"`
+	Extension    string                 `json:"extension,omitempty"`
+	Context      string                 `json:"context,omitempty"`
+	Channel      string                 `json:"channel,omitempty"` // the agent channel, or the bridged channel uniqueid on CONNECT
+	Did          string                 `json:"did,omitempty"`
+	Raw          string                 `json:"raw,omitempty"`
+}
+
+// AMIQueueLogTail
+// AMIQueueLogTail follows a growing queue_log file, like tail -F, across the rotations.
+type AMIQueueLogTail struct {
+	Filename  string        `json:"filename"`
+	Interval  time.Duration `json:"interval"`   // the polling interval
+	FromStart bool          `json:"from_start"` // read the existing records first, otherwise only the new ones
+}
+
+// AMIAgiReply
+// AMIAgiReply is the parsed reply of an AGI command, i.e: 200 result=1 (test variable) endpos=1234
+type AMIAgiReply struct {
+	Code   int               `json:"code"`
+	Result int               `json:"result"`
+	Value  string            `json:"value,omitempty"`  // the raw result, i.e: the digits of GET DATA
+	Data   string            `json:"data,omitempty"`   // the value in parentheses
+	EndPos int64             `json:"endpos,omitempty"` // the position where the playback stopped
+	Extra  map[string]string `json:"extra,omitempty"`  // the other key=value pairs
+	Usage  string            `json:"usage,omitempty"`  // the usage of the 520 reply
+	Raw    string            `json:"raw,omitempty"`
+}
+
+// AMIAgiSession
+// AMIAgiSession is the AGI session of a channel, its context is cancelled on HANGUP.
+type AMIAgiSession struct {
+	Env       map[string]string `json:"env"` // the agi_* environment
+	Arguments []string          `json:"arguments,omitempty"`
+	transport AmiAgiTransport
+	ctx       context.Context
+	cancel    context.CancelFunc
+	mutex     sync.RWMutex
+	hungup    bool
+}
+
+// AMIAgiServer
+// AMIAgiServer is the FastAGI server, the sessions are dispatched to the handler of their script path.
+type AMIAgiServer struct {
+	Addr          string        `json:"addr"`
+	HeaderTimeout time.Duration `json:"header_timeout"` // the timeout to read the agi_* environment
+	handlers      map[string]AmiAgiHandlerFunc
+	fallback      AmiAgiHandlerFunc
+	mutex         sync.RWMutex
+	listener      net.Listener
+	sessions      sync.WaitGroup
+	closed        bool
+}
+
+// AMIAsyncAgi
+// AMIAsyncAgi runs the AGI sessions over AMI, the channels enter it by the dialplan AGI(agi:async).
+type AMIAsyncAgi struct {
+	core     *AMICore
+	ctx      context.Context
+	handler  AmiAgiHandlerFunc
+	send     func(ctx context.Context, channel, command, commandId string) (AmiReply, error)
+	mutex    sync.RWMutex
+	sessions map[string]*AMIAgiSession // by channel
+	pending  map[string]chan string    // the replies of the running commands, by command id
+}
+
+// AMIIvrNode
+// AMIIvrNode is a node of the IVR flow, the fields used depend on its kind.
+// The text fields may refer the variables of the run as {name}, i.e: /customers/{account}
+type AMIIvrNode struct {
+	Id            string                `json:"id"`
+	Kind          config.AmiIvrNodeKind `json:"kind"`
+	Prompt        string                `json:"prompt,omitempty"`         // the sound files joined by &
+	InvalidPrompt string                `json:"invalid_prompt,omitempty"` // played before a retry of collect
+	EscapeDigits  string                `json:"escape_digits,omitempty"`
+	MinDigits     int                   `json:"min_digits,omitempty"`
+	MaxDigits     int                   `json:"max_digits,omitempty"`
+	TimeoutMs     int                   `json:"timeout_ms,omitempty"`
+	Retries       int                   `json:"retries,omitempty"`
+	Variable      string                `json:"variable,omitempty"` // the variable kept by play, collect, set or tested by branch
+	Value         string                `json:"value,omitempty"`
+	Method        string                `json:"method,omitempty"` // GET or POST
+	Path          string                `json:"path,omitempty"`
+	Query         map[string]string     `json:"query,omitempty"`
+	Assign        map[string]string     `json:"assign,omitempty"` // the variables from the fields of the HTTP response
+	Application   string                `json:"application,omitempty"`
+	Options       string                `json:"options,omitempty"`
+	Context       string                `json:"context,omitempty"`
+	Extension     string                `json:"extension,omitempty"`
+	Priority      string                `json:"priority,omitempty"`
+	Queue         string                `json:"queue,omitempty"`
+	Mailbox       string                `json:"mailbox,omitempty"`
+	Branches      map[string]string     `json:"branches,omitempty"` // the input (or default, timeout, invalid, error) to the next node
+	Next          string                `json:"next,omitempty"`
+}
+
+// AMIIvrFlow
+// AMIIvrFlow is the IVR flow, it runs from the start node until a node has no next.
+type AMIIvrFlow struct {
+	Name     string       `json:"name"`
+	Start    string       `json:"start"`
+	MaxSteps int          `json:"max_steps,omitempty"` // the guard of the loops, 100 by default
+	Nodes    []AMIIvrNode `json:"nodes"`
+	restify  *AmiRestify
+	onNode   []func(AMIIvrNodeTiming)
+	mutex    sync.RWMutex
+}
+
+// AMIIvrNodeTiming
+// AMIIvrNodeTiming is the timing of a node of the run.
+type AMIIvrNodeTiming struct {
+	Node      string                `json:"node"`
+	Kind      config.AmiIvrNodeKind `json:"kind"`
+	StartedAt time.Time             `json:"started_at"`
+	Elapsed   time.Duration         `json:"elapsed"`
+	Input     string                `json:"input,omitempty"`
+	Next      string                `json:"next,omitempty"`
+	Error     string                `json:"error,omitempty"`
+}
+
+// AMIIvrRun
+// AMIIvrRun is the run of the IVR flow on a channel.
+type AMIIvrRun struct {
+	Flow      string             `json:"flow"`
+	Channel   string             `json:"channel"`
+	UniqueId  string             `json:"unique_id"`
+	Variables map[string]string  `json:"variables"`
+	Timings   []AMIIvrNodeTiming `json:"timings"`
+	Elapsed   time.Duration      `json:"elapsed"`
+	Error     string             `json:"error,omitempty"`
+}
+
+// AMIAri
+// AMIAri is the ARI (Asterisk REST Interface) client, it sits next to AMICore so that an application mixes AMI and ARI.
+type AMIAri struct {
+	BaseURL     string `json:"base_url"` // i.e: http://127.0.0.1:8088/ari
+	Application string `json:"application,omitempty"`
+	username    string
+	password    string
+	restify     *AmiRestify
+}
+
+// AMIAriError
+// AMIAriError is the error of an ARI request, it matches the Error vars of its status code by errors.Is.
+type AMIAriError struct {
+	Method     string `json:"method,omitempty"`
+	Path       string `json:"path,omitempty"`
+	StatusCode int    `json:"status_code"`
+	Message    string `json:"message,omitempty"`
+}
+
+type AMIAriCallerId struct {
+	Name   string `json:"name"`
+	Number string `json:"number"`
+}
+
+type AMIAriDialplan struct {
+	Context  string `json:"context"`
+	Exten    string `json:"exten"`
+	Priority int64  `json:"priority"`
+	AppName  string `json:"app_name,omitempty"`
+	AppData  string `json:"app_data,omitempty"`
+}
+
+type AMIAriChannel struct {
+	Id           string            `json:"id"`
+	Name         string            `json:"name"`
+	State        string            `json:"state"`
+	Caller       AMIAriCallerId    `json:"caller"`
+	Connected    AMIAriCallerId    `json:"connected"`
+	AccountCode  string            `json:"accountcode"`
+	Dialplan     AMIAriDialplan    `json:"dialplan"`
+	CreationTime string            `json:"creationtime"`
+	Language     string            `json:"language"`
+	ChannelVars  map[string]string `json:"channelvars,omitempty"`
+}
+
+type AMIAriBridge struct {
+	Id           string   `json:"id"`
+	Technology   string   `json:"technology"`
+	BridgeType   string   `json:"bridge_type"`
+	BridgeClass  string   `json:"bridge_class"`
+	Creator      string   `json:"creator"`
+	Name         string   `json:"name"`
+	Channels     []string `json:"channels"`
+	CreationTime string   `json:"creationtime,omitempty"`
+}
+
+type AMIAriPlayback struct {
+	Id           string `json:"id"`
+	MediaUri     string `json:"media_uri"`
+	NextMediaUri string `json:"next_media_uri,omitempty"`
+	TargetUri    string `json:"target_uri"`
+	Language     string `json:"language,omitempty"`
+	State        string `json:"state"`
+}
+
+type AMIAriLiveRecording struct {
+	Name            string `json:"name"`
+	Format          string `json:"format"`
+	TargetUri       string `json:"target_uri"`
+	State           string `json:"state"`
+	Duration        int    `json:"duration,omitempty"`
+	TalkingDuration int    `json:"talking_duration,omitempty"`
+	SilenceDuration int    `json:"silence_duration,omitempty"`
+	Cause           string `json:"cause,omitempty"`
+}
+
+type AMIAriStoredRecording struct {
+	Name   string `json:"name"`
+	Format string `json:"format"`
+}
+
+type AMIAriEndpoint struct {
+	Technology string   `json:"technology"`
+	Resource   string   `json:"resource"`
+	State      string   `json:"state,omitempty"`
+	ChannelIds []string `json:"channel_ids"`
+}
+
+type AMIAriDeviceState struct {
+	Name  string `json:"name"`
+	State string `json:"state"`
+}
+
+type AMIAriAsteriskInfo struct {
+	Build struct {
+		Os      string `json:"os"`
+		Kernel  string `json:"kernel"`
+		Options string `json:"options"`
+		Machine string `json:"machine"`
+		Date    string `json:"date"`
+		User    string `json:"user"`
+	} `json:"build"`
+	System struct {
+		Version  string `json:"version"`
+		EntityId string `json:"entity_id"`
+	} `json:"system"`
+	Config struct {
+		Name            string  `json:"name"`
+		DefaultLanguage string  `json:"default_language"`
+		MaxChannels     int     `json:"max_channels,omitempty"`
+		MaxOpenFiles    int     `json:"max_open_files,omitempty"`
+		MaxLoad         float64 `json:"max_load,omitempty"`
+	} `json:"config"`
+	Status struct {
+		StartupTime    string `json:"startup_time"`
+		LastReloadTime string `json:"last_reload_time"`
+	} `json:"status"`
+}
+
+// AMIAriOriginate
+// AMIAriOriginate is the request of a new channel, it enters the dialplan (context, extension, priority) or the Stasis app.
+type AMIAriOriginate struct {
+	Endpoint       string            `json:"endpoint"`
+	Extension      string            `json:"extension,omitempty"`
+	Context        string            `json:"context,omitempty"`
+	Priority       int64             `json:"priority,omitempty"`
+	Label          string            `json:"label,omitempty"`
+	App            string            `json:"app,omitempty"`
+	AppArgs        string            `json:"appArgs,omitempty"`
+	CallerId       string            `json:"callerId,omitempty"`
+	Timeout        int               `json:"timeout,omitempty"` // seconds
+	ChannelId      string            `json:"channelId,omitempty"`
+	OtherChannelId string            `json:"otherChannelId,omitempty"`
+	Originator     string            `json:"originator,omitempty"`
+	Formats        string            `json:"formats,omitempty"`
+	Variables      map[string]string `json:"variables,omitempty"`
+}
+
+// AMIAriSnoop
+// AMIAriSnoop is the request of a snoop channel, spy and whisper are none, in, out or both.
+type AMIAriSnoop struct {
+	Spy     string `json:"spy,omitempty"`
+	Whisper string `json:"whisper,omitempty"`
+	App     string `json:"app"`
+	AppArgs string `json:"appArgs,omitempty"`
+	SnoopId string `json:"snoopId,omitempty"`
+}
+
+// AMIAriRecord
+// AMIAriRecord is the request of a live recording of a channel or a bridge.
+type AMIAriRecord struct {
+	Name               string `json:"name"`
+	Format             string `json:"format"`
+	MaxDurationSeconds int    `json:"maxDurationSeconds,omitempty"`
+	MaxSilenceSeconds  int    `json:"maxSilenceSeconds,omitempty"`
+	IfExists           string `json:"ifExists,omitempty"` // fail, overwrite or append
+	Beep               bool   `json:"beep,omitempty"`
+	TerminateOn        string `json:"terminateOn,omitempty"` // none, any, * or #
+}
+
+// AMIWebSocket
+// AMIWebSocket is the WebSocket connection (RFC 6455) of the client or the server side, the frames of the client are masked.
+type AMIWebSocket struct {
+	conn   net.Conn
+	reader *bufio.Reader
+	client bool
+	mutex  sync.Mutex // the writes
+	closed bool
+}
+
+// AMIAriEvent
+// AMIAriEvent is the event of the ARI WebSocket, the fields set depend on its type.
+type AMIAriEvent struct {
+	Type        string               `json:"type"`
+	Application string               `json:"application,omitempty"`
+	Timestamp   string               `json:"timestamp,omitempty"`
+	AsteriskId  string               `json:"asterisk_id,omitempty"`
+	Args        []string             `json:"args,omitempty"`
+	Channel     *AMIAriChannel       `json:"channel,omitempty"`
+	Peer        *AMIAriChannel       `json:"peer,omitempty"`
+	Bridge      *AMIAriBridge        `json:"bridge,omitempty"`
+	Playback    *AMIAriPlayback      `json:"playback,omitempty"`
+	Recording   *AMIAriLiveRecording `json:"recording,omitempty"`
+	Endpoint    *AMIAriEndpoint      `json:"endpoint,omitempty"`
+	DeviceState *AMIAriDeviceState   `json:"device_state,omitempty"`
+	Digit       string               `json:"digit,omitempty"`
+	DurationMs  int                  `json:"duration_ms,omitempty"`
+	Cause       int                  `json:"cause,omitempty"`
+	CauseTxt    string               `json:"cause_txt,omitempty"`
+	Variable    string               `json:"variable,omitempty"`
+	Value       string               `json:"value,omitempty"`
+	Raw         json.RawMessage      `json:"-"`
+}
+
+// AMIAriEventStream
+// AMIAriEventStream is the event stream of the ARI WebSocket of the applications, it reconnects with backoff.
+type AMIAriEventStream struct {
+	Applications      []string      `json:"applications"`
+	SubscribeAll      bool          `json:"subscribe_all"`
+	ReconnectDelay    time.Duration `json:"reconnect_delay"`
+	MaxReconnectDelay time.Duration `json:"max_reconnect_delay"`
+	ari               *AMIAri
+	mutex             sync.RWMutex
+	onEvent           []func(AMIAriEvent)
+	onConnect         []func()
+	connected         bool
+	connections       int
+}
+
+// AMIStasisChannel
+// AMIStasisChannel is the channel in the Stasis application, its context is cancelled on StasisEnd.
+type AMIStasisChannel struct {
+	Channel AMIAriChannel `json:"channel"`
+	Args    []string      `json:"args,omitempty"`
+	ari     *AMIAri
+	events  chan AMIAriEvent
+	ctx     context.Context
+	cancel  context.CancelFunc
+}
+
+// AMIStasisApp
+// AMIStasisApp is the Stasis application, every channel entering it runs the handler.
+type AMIStasisApp struct {
+	Name     string `json:"name"`
+	ari      *AMIAri
+	stream   *AMIAriEventStream
+	handler  AmiStasisHandlerFunc
+	mutex    sync.RWMutex
+	channels map[string]*AMIStasisChannel // by channel id
+}
+
+// AMIGateway
+// AMIGateway is the HTTP handler exposing the operations of the core as JSON endpoints,
+// the requests are authorized by bearer tokens with per-route permissions.
+type AMIGateway struct {
+	Timeout      time.Duration `json:"timeout"`
+	MaxBodyBytes int64         `json:"max_body_bytes"`
+	core         *AMICore
+	dictionary   *AMIDictionary
+	mutex        sync.RWMutex
+	tokens       map[string]map[string]bool // permissions by token
+	routes       []amiGatewayRoute
+}
+
+// amiGatewayRoute is the route of the gateway, the "{}" segments of the pattern are the path parameters
+type amiGatewayRoute struct {
+	method     string
+	pattern    []string
+	permission string
+	status     int
+	handle     func(ctx context.Context, r *http.Request, params []string) (interface{}, error)
+}
+
+// AMIGatewayError
+// AMIGatewayError is the structured error of the gateway.
+type AMIGatewayError struct {
+	Status  int    `json:"-"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// AMIGatewayCall
+// AMIGatewayCall is the body of POST /calls, either the extension or the application is dialed once the channel answers.
+type AMIGatewayCall struct {
+	Channel     string            `json:"channel"`
+	Exten       string            `json:"exten,omitempty"`
+	Context     string            `json:"context,omitempty"`
+	Priority    int               `json:"priority,omitempty"`
+	Application string            `json:"application,omitempty"`
+	Data        string            `json:"data,omitempty"`
+	CallerId    string            `json:"caller_id,omitempty"`
+	Account     string            `json:"account,omitempty"`
+	TimeoutMs   int               `json:"timeout_ms,omitempty"`
+	Variables   map[string]string `json:"variables,omitempty"`
+}
+
+// AMIGatewayRedirect
+// AMIGatewayRedirect is the body of POST /channels/{id}/redirect.
+type AMIGatewayRedirect struct {
+	Exten    string `json:"exten"`
+	Context  string `json:"context"`
+	Priority int    `json:"priority,omitempty"`
+}
+
+// AMIGatewayQueueMember
+// AMIGatewayQueueMember is the body of PUT /queues/{q}/members/{m} and POST /queues/{q}/members/{m}/pause.
+type AMIGatewayQueueMember struct {
+	MemberName     string `json:"member_name,omitempty"`
+	StateInterface string `json:"state_interface,omitempty"`
+	Penalty        int    `json:"penalty,omitempty"`
+	Paused         bool   `json:"paused,omitempty"`
+	Reason         string `json:"reason,omitempty"`
+}
+
+// AMIEventStream
+// AMIEventStream is the HTTP handler streaming the events to the browsers as Server-Sent Events or WebSocket messages.
+type AMIEventStream struct {
+	HeartbeatInterval time.Duration `json:"heartbeat_interval"`
+	BufferSize        int           `json:"buffer_size"`
+	HistorySize       int           `json:"history_size"`
+	dictionary        *AMIDictionary
+	authorizer        func(r *http.Request) error
+	filter            func(r *http.Request, message *AMIMessage) bool
+	mutex             sync.Mutex
+	sequence          uint64
+	history           []AMIEventStreamItem
+	clients           map[*amiEventStreamClient]bool
+}
+
+// AMIEventStreamItem
+// AMIEventStreamItem is the event of the stream, the id is the one resumed by Last-Event-ID.
+type AMIEventStreamItem struct {
+	Id      uint64      `json:"id"`
+	Event   string      `json:"event"`
+	Message *AMIMessage `json:"-"`
+	Data    interface{} `json:"data,omitempty"`
+}
+
+// amiEventStreamClient is the client of the stream with its bounded buffer, it is closed once the buffer overflows
+type amiEventStreamClient struct {
+	request  *http.Request
+	patterns []string
+	events   chan AMIEventStreamItem
+}
+
+// AMIMetrics
+// AMIMetrics holds the health counters of the library, see Metrics.
+type AMIMetrics struct {
+	mutex      sync.Mutex
+	actions    map[[2]string]uint64 // by action and outcome
+	latencies  map[string]*amiMetricHistogram
+	events     map[string]uint64
+	reconnects map[string]uint64
+	dropped    map[string]uint64
+}
+
+type amiMetricHistogram struct {
+	buckets []uint64 // cumulative counts of config.AmiMetricLatencyBuckets
+	count   uint64
+	sum     float64
+}
+
+// amiMetricFamily is the metric of the exposition with its samples
+type amiMetricFamily struct {
+	name    string
+	help    string
+	kind    string
+	samples []amiMetricSample
+}
+
+type amiMetricSample struct {
+	suffix string      // i.e: _bucket, _sum, _count of the histograms
+	labels [][2]string // label name and value, in order
+	value  float64
+}
+
+// AMIMetricsExporter
+// AMIMetricsExporter is the HTTP handler of the Prometheus metrics of Asterisk and of the library.
+type AMIMetricsExporter struct {
+	Timeout      time.Duration `json:"timeout"`
+	MinInterval  time.Duration `json:"min_interval"`
+	Sip          bool          `json:"sip"`
+	Pjsip        bool          `json:"pjsip"`
+	core         *AMICore
+	registration *AMIRegistrationTracker
+	conference   *AMIConfBridgeManager
+	parking      *AMIParkingService
+	mutex        sync.Mutex
+	collectedAt  time.Time
+	families     []amiMetricFamily
+}
+
+// AMITap
+// AMITap is the tap of the frames of the manager connections, both directions, written with their secrets redacted.
+type AMITap struct {
+	Format        string `json:"format"`
+	MaxFrameBytes int    `json:"max_frame_bytes"`
+	Sampling      int    `json:"sampling"`
+	w             io.Writer
+	mutex         sync.Mutex
+	enabled       bool
+	sensitive     map[string]bool
+	events        uint64
+}
+
+// AmiTapFrame
+// AmiTapFrame is a frame written by the tap, the message is redacted and truncated to the size limit.
+type AmiTapFrame struct {
+	Timestamp time.Time `json:"timestamp"`
+	Direction string    `json:"direction"`
+	Remote    string    `json:"remote,omitempty"`
+	Action    string    `json:"action,omitempty"`
+	ActionId  string    `json:"action_id,omitempty"`
+	Response  string    `json:"response,omitempty"`
+	Event     string    `json:"event,omitempty"`
+	Bytes     int       `json:"bytes"`
+	Truncated bool      `json:"truncated,omitempty"`
+	Message   string    `json:"message"`
+}
+
+// AmiTraceAction
+// AmiTraceAction is the action given to the tracer, the response, the uniqueid and the duration are set once it ends.
+type AmiTraceAction struct {
+	Action   string        `json:"action"`
+	ActionId string        `json:"action_id"`
+	Response string        `json:"response,omitempty"`
+	UniqueId string        `json:"uniqueid,omitempty"`
+	Start    time.Time     `json:"start"`
+	Duration time.Duration `json:"duration,omitempty"`
+}
+
+// AMISpanTracer
+// AMISpanTracer is the tracer recording the actions as spans, started by its starter (i.e: bridged to OpenTelemetry).
+type AMISpanTracer struct {
+	start      AmiSpanStarter
+	mutex      sync.RWMutex
+	eventSpans bool
+}
@@ -88,10 +88,10 @@ func NewNetwork(network, ip string, port int) (net.Conn, error) {
 	host, _port, _ := DecodeIp(ip)
 	if len(host) > 0 && len(_port) > 0 {
 		form := net.JoinHostPort(host, _port)
-		D().Info("Ami (IP decoded) dial connection: %v", form)
+		componentLog(config.AmiLogComponentNetwork).Debug("dialing", "addr", form)
 		return net.Dial(network, form)
 	}
 	form := RemoveProtocol(ip, port)
-	D().Info("Ami dial connection: %v", form)
+	componentLog(config.AmiLogComponentNetwork).Debug("dialing", "addr", form)
 	return net.Dial(network, form)
 }
@@ -196,30 +196,12 @@ func DialCall(ctx context.Context, s AMISocket, originate AMIOriginate) (AmiRepl
 // priority: 1
 // timeout: 60000
 func DialOut(ctx context.Context, s AMISocket, d AMIDialCall) (AmiReply, bool, error) {
-	channel := NewChannel().
-		SetChannelProtocol(d.ChannelProtocol)
-	o := NewAmiOriginate().
-		SetPriority(1).
-		SetAsync(true).
-		SetTimeout(d.Timeout).
-		SetContext(config.AmiContextOutbound).
-		SetExtension(strings.TrimSpace(d.Telephone)).
-		SetChannel(channel.JoinChannelWith(channel.ChannelProtocol, fmt.Sprintf("%v", d.Extension)))
-	o.SetMultipleVariables(d.OfVars()...)
-
-	if d.ExtensionExists {
-		peer, err := SIPPeerStatusShort(ctx, s, fmt.Sprintf("%v", d.Extension))
-		if err != nil {
-			return nil, false, err
-		}
-		if peer.Size() == 0 {
-			return nil, false, fmt.Errorf("Peer %v not found", d.Extension)
-		}
-		o.SetChannel(peer.Get(config.AmiJsonFieldPeer))
+	o, err := NewDialOriginate(ctx, s, d, config.AmiContextOutbound)
+	if err != nil {
+		return nil, false, err
 	}
 	if d.DebugMode {
-		D().Info("DialOut, an outgoing call with originate request body: %v", o.Json())
-		D().Info("DialOut, an outgoing call with original request body: %v", d.Json())
+		s.Logger(config.AmiLogComponentCall).Debug("dialing out", "originate", o, "call", d)
 	}
 	response, err := DialCall(ctx, s, *o)
 	return response, IsSuccess(response), err
@@ -235,13 +217,29 @@ func DialOut(ctx context.Context, s AMISocket, d AMIDialCall) (AmiReply, bool, e
 // priority: 1
 // timeout: 60000
 func DialIn(ctx context.Context, s AMISocket, d AMIDialCall) (AmiReply, bool, error) {
+	o, err := NewDialOriginate(ctx, s, d, config.AmiContextFromInternal)
+	if err != nil {
+		return nil, false, err
+	}
+	if d.DebugMode {
+		s.Logger(config.AmiLogComponentCall).Debug("dialing in", "originate", o, "call", d)
+	}
+	response, err := DialCall(ctx, s, *o)
+	return response, IsSuccess(response), err
+}
+
+// NewDialOriginate
+// NewDialOriginate builds the asynchronous originate of a dial call from the extension to the telephone,
+// into the dialplan context (i.e: outbound-allroutes, from-internal).
+// If the extension must exist, the channel is taken from the SIP peer of the extension.
+func NewDialOriginate(ctx context.Context, s AMISocket, d AMIDialCall, context string) (*AMIOriginate, error) {
 	channel := NewChannel().
 		SetChannelProtocol(d.ChannelProtocol)
 	o := NewAmiOriginate().
 		SetPriority(1).
 		SetAsync(true).
 		SetTimeout(d.Timeout).
-		SetContext(config.AmiContextFromInternal).
+		SetContext(context).
 		SetExtension(strings.TrimSpace(d.Telephone)).
 		SetChannel(channel.JoinChannelWith(channel.ChannelProtocol, fmt.Sprintf("%v", d.Extension)))
 	o.SetMultipleVariables(d.OfVars()...)
@@ -249,17 +247,12 @@ func DialIn(ctx context.Context, s AMISocket, d AMIDialCall) (AmiReply, bool, er
 	if d.ExtensionExists {
 		peer, err := SIPPeerStatusShort(ctx, s, fmt.Sprintf("%v", d.Extension))
 		if err != nil {
-			return nil, false, err
+			return nil, err
 		}
 		if peer.Size() == 0 {
-			return nil, false, fmt.Errorf("Peer %v not found", d.Extension)
+			return nil, fmt.Errorf("Peer %v not found", d.Extension)
 		}
 		o.SetChannel(peer.Get(config.AmiJsonFieldPeer))
 	}
-	if d.DebugMode {
-		D().Info("DialIn, an internal call with originate request body: %v", o.Json())
-		D().Info("DialIn, an internal call with original request body: %v", d.Json())
-	}
-	response, err := DialCall(ctx, s, *o)
-	return response, IsSuccess(response), err
+	return o, nil
 }
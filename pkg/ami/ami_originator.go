@@ -0,0 +1,457 @@
+package ami
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pnguyen215/voipkit/pkg/ami/config"
+)
+
+// NewAMIOriginator
+// NewAMIOriginator creates an originator which places asynchronous calls through the core and
+// resolves them from the OriginateResponse events, once it is opened on the AMI event stream.
+func NewAMIOriginator(core *AMICore) *AMIOriginator {
+	o := &AMIOriginator{}
+	o.core = core
+	o.pending = make(map[string]*AMICallHandle)
+	o.legs = make(map[string]*AMICallHandle)
+	o.SetAssignChannelId(true)
+	o.SetResponseGrace(config.AmiOriginateResponseGraceDefault)
+	return o
+}
+
+// SetAssignChannelId
+// SetAssignChannelId assigns a generated unique id (ChannelId) to every originated channel when not provided,
+// so that the leg can be tracked before the OriginateResponse event arrives (Asterisk 12+).
+func (o *AMIOriginator) SetAssignChannelId(value bool) *AMIOriginator {
+	o.AssignChannelId = value
+	return o
+}
+
+// SetResponseGrace
+// SetResponseGrace sets the time waited for the OriginateResponse event past the originate timeout,
+// the call is then given up as failed (see AMICallResult.Expired), i.e: when the event is lost across a reconnect.
+func (o *AMIOriginator) SetResponseGrace(value time.Duration) *AMIOriginator {
+	o.ResponseGrace = value
+	return o
+}
+
+// Originate
+// Originate places the call asynchronously and returns a handle as soon as Asterisk queued it.
+// The outcome of the call is delivered later on the handle, i.e: h.Wait(ctx)
+func (o *AMIOriginator) Originate(ctx context.Context, originate AMIOriginate) (*AMICallHandle, error) {
+	if o.core == nil {
+		return nil, fmt.Errorf(config.AmiErrorCoreRequired, config.AmiActionOriginate)
+	}
+	id, err := GenUUID()
+	if err != nil {
+		return nil, err
+	}
+	originate.SetAsync(true)
+	if o.AssignChannelId && IsStringEmpty(originate.ChannelID) {
+		originate.SetChannelId(GenUUIDShorten())
+	}
+	h := newCallHandle(o, id)
+	o.mutex.Lock()
+	// register the handle before sending, the response event might be faster than the reply
+	o.pending[id] = h
+	if !IsStringEmpty(originate.ChannelID) {
+		h.uniqueId = originate.ChannelID
+		o.legs[originate.ChannelID] = h
+	}
+	o.mutex.Unlock()
+
+	reply, err := o.core.OriginateWithId(ctx, id, originate)
+	if err == nil && IsFailure(reply) {
+		err = fmt.Errorf(config.AmiErrorActionFailed, config.AmiActionOriginate, reply.Get(config.AmiJsonFieldMessage))
+	}
+	if err != nil {
+		o.forget(h)
+		return nil, err
+	}
+	timeout := originate.Timeout
+	if timeout <= 0 {
+		timeout = config.AmiOriginateTimeoutDefault
+	}
+	o.mutex.Lock()
+	if _, ok := o.pending[id]; ok {
+		h.expiry = time.AfterFunc(time.Duration(timeout)*time.Millisecond+o.ResponseGrace, func() {
+			o.expire(h)
+		})
+	}
+	o.mutex.Unlock()
+	return h, nil
+}
+
+// DialOut
+// DialOut places an outgoing call from the extension to the telephone and returns its handle.
+func (o *AMIOriginator) DialOut(ctx context.Context, d AMIDialCall) (*AMICallHandle, error) {
+	return o.dial(ctx, d, config.AmiContextOutbound)
+}
+
+// DialIn
+// DialIn places an internal call from the extension to the telephone and returns its handle.
+func (o *AMIOriginator) DialIn(ctx context.Context, d AMIDialCall) (*AMICallHandle, error) {
+	return o.dial(ctx, d, config.AmiContextFromInternal)
+}
+
+func (o *AMIOriginator) dial(ctx context.Context, d AMIDialCall, context string) (*AMICallHandle, error) {
+	if o.core == nil {
+		return nil, fmt.Errorf(config.AmiErrorCoreRequired, config.AmiActionOriginate)
+	}
+	originate, err := NewDialOriginate(ctx, *o.core.socket, d, context)
+	if err != nil {
+		return nil, err
+	}
+	return o.Originate(ctx, *originate)
+}
+
+// Pending
+// Pending returns the number of calls waiting for their OriginateResponse event.
+func (o *AMIOriginator) Pending() int {
+	o.mutex.RLock()
+	defer o.mutex.RUnlock()
+	return len(o.pending)
+}
+
+// Apply
+// Apply feeds an AMI event into the originator: OriginateResponse events resolve the pending calls,
+// any other event of a tracked leg is forwarded to the trackers of its handle.
+func (o *AMIOriginator) Apply(e *AMIMessage) {
+	if e == nil {
+		return
+	}
+	event := e.Field(config.AmiEventKey)
+	if strings.EqualFold(event, config.AmiListenerEventOriginateResponse) {
+		o.mutex.Lock()
+		h, ok := o.pending[e.Field(config.AmiFieldActionId)]
+		if ok {
+			delete(o.pending, h.ActionId)
+			if h.expiry != nil {
+				h.expiry.Stop()
+			}
+		}
+		o.mutex.Unlock()
+		if ok {
+			o.resolve(h, e)
+		}
+		return
+	}
+	uniqueId := e.Field(config.AmiFieldUniqueId)
+	if IsStringEmpty(uniqueId) {
+		return
+	}
+	o.mutex.RLock()
+	h, ok := o.legs[uniqueId]
+	o.mutex.RUnlock()
+	if !ok {
+		return
+	}
+	h.mutex.Lock()
+	if IsStringEmpty(h.channel) {
+		h.channel = e.Field(config.AmiFieldChannel)
+		h.markKnown()
+	}
+	if strings.EqualFold(event, config.AmiListenerEventHangup) {
+		h.hangupCause = e.FieldOrRefer(config.AmiFieldCauseText, config.AmiFieldCause)
+		closeOnce(h.ended)
+	}
+	trackers := h.trackers
+	h.mutex.Unlock()
+	for _, fn := range trackers {
+		fn(e)
+	}
+	if strings.EqualFold(event, config.AmiListenerEventHangup) {
+		o.mutex.Lock()
+		delete(o.legs, uniqueId)
+		o.mutex.Unlock()
+	}
+}
+
+// Open
+// Open consumes the AMI event stream until the AMI connection is closed.
+func (o *AMIOriginator) Open(c *AMI) {
+	all := c.AllEvents()
+	defer c.Unsubscribe(config.AmiPubSubKeyRef, all)
+	ctx := c.Context()
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	for {
+		select {
+		case message, ok := <-all:
+			if !ok {
+				return
+			}
+			o.Apply(message)
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (o *AMIOriginator) OpenAsyncFunc(c *AMI) {
+	go func() {
+		o.Open(c)
+	}()
+}
+
+func (o *AMIOriginator) resolve(h *AMICallHandle, e *AMIMessage) {
+	reason, _ := strconv.Atoi(e.Field(config.AmiFieldReason))
+	response := e.Field(config.AmiFieldResponse)
+	result := &AMICallResult{
+		ActionId: h.ActionId,
+		Outcome:  OriginateOutcome(response, reason),
+		Reason:   reason,
+		Response: response,
+		Channel:  e.Field(config.AmiFieldChannel),
+		UniqueId: e.Field(config.AmiFieldUniqueId),
+		At:       time.Now(),
+	}
+	// a failed originate reports no channel at all
+	if strings.EqualFold(result.UniqueId, "<null>") {
+		result.UniqueId = ""
+	}
+	h.mutex.Lock()
+	if !IsStringEmpty(result.Channel) && IsStringEmpty(h.channel) {
+		h.channel = result.Channel
+	}
+	if IsStringEmpty(result.UniqueId) {
+		result.UniqueId = h.uniqueId
+	}
+	previous := h.uniqueId
+	if !IsStringEmpty(result.UniqueId) {
+		h.uniqueId = result.UniqueId
+	}
+	if IsStringEmpty(result.Channel) {
+		result.Channel = h.channel
+	}
+	h.result = result
+	h.markKnown()
+	closeOnce(h.done)
+	answered := result.Outcome == config.AmiOriginateOutcomeAnswered
+	if !answered {
+		// the leg never came up, there is nothing left to track
+		closeOnce(h.ended)
+	}
+	h.mutex.Unlock()
+
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+	if previous != result.UniqueId {
+		delete(o.legs, previous)
+	}
+	if answered && !IsStringEmpty(result.UniqueId) {
+		o.legs[result.UniqueId] = h
+		return
+	}
+	delete(o.legs, result.UniqueId)
+}
+
+// expire gives the call up as failed if its OriginateResponse event has not arrived yet.
+func (o *AMIOriginator) expire(h *AMICallHandle) {
+	o.mutex.Lock()
+	if _, ok := o.pending[h.ActionId]; !ok {
+		o.mutex.Unlock()
+		return
+	}
+	delete(o.pending, h.ActionId)
+	o.mutex.Unlock()
+	componentLog(config.AmiLogComponentCall).Warn("originate response not received", config.AmiLogFieldActionId, h.ActionId)
+
+	h.mutex.Lock()
+	h.result = &AMICallResult{
+		ActionId: h.ActionId,
+		Outcome:  config.AmiOriginateOutcomeFailed,
+		Reason:   config.AmiOriginateReasonFailed,
+		Channel:  h.channel,
+		UniqueId: h.uniqueId,
+		Expired:  true,
+		At:       time.Now(),
+	}
+	uniqueId := h.uniqueId
+	closeOnce(h.done)
+	closeOnce(h.ended)
+	h.mutex.Unlock()
+
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+	delete(o.legs, uniqueId)
+}
+
+func (o *AMIOriginator) forget(h *AMICallHandle) {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+	delete(o.pending, h.ActionId)
+	if !IsStringEmpty(h.uniqueId) {
+		delete(o.legs, h.uniqueId)
+	}
+}
+
+// OriginateOutcome
+// OriginateOutcome converts the Response and Reason of an OriginateResponse event into
+// one of the outcomes: answered, busy, no-answer, congestion, failed.
+func OriginateOutcome(response string, reason int) string {
+	if strings.EqualFold(response, config.AmiStatusSuccessKey) || reason == config.AmiOriginateReasonAnswered {
+		return config.AmiOriginateOutcomeAnswered
+	}
+	switch reason {
+	case config.AmiOriginateReasonBusy:
+		return config.AmiOriginateOutcomeBusy
+	case config.AmiOriginateReasonHangup, config.AmiOriginateReasonRinging:
+		return config.AmiOriginateOutcomeNoAnswer
+	case config.AmiOriginateReasonCongestion:
+		return config.AmiOriginateOutcomeCongestion
+	}
+	return config.AmiOriginateOutcomeFailed
+}
+
+func newCallHandle(o *AMIOriginator, id string) *AMICallHandle {
+	h := &AMICallHandle{
+		ActionId:   id,
+		originator: o,
+		known:      make(chan struct{}),
+		done:       make(chan struct{}),
+		ended:      make(chan struct{}),
+	}
+	return h
+}
+
+// Channel returns the name of the originated channel, empty until it is known.
+func (h *AMICallHandle) Channel() string {
+	h.mutex.RLock()
+	defer h.mutex.RUnlock()
+	return h.channel
+}
+
+// UniqueId returns the unique id of the originated channel, empty until it is known.
+func (h *AMICallHandle) UniqueId() string {
+	h.mutex.RLock()
+	defer h.mutex.RUnlock()
+	return h.uniqueId
+}
+
+// HangupCause returns the hangup cause of the leg, once it has ended.
+func (h *AMICallHandle) HangupCause() string {
+	h.mutex.RLock()
+	defer h.mutex.RUnlock()
+	return h.hangupCause
+}
+
+// Known is closed as soon as the channel name of the call is known.
+func (h *AMICallHandle) Known() <-chan struct{} {
+	return h.known
+}
+
+// Done is closed when the outcome of the call is known.
+func (h *AMICallHandle) Done() <-chan struct{} {
+	return h.done
+}
+
+// Ended is closed when the leg has been hung up, or never came up.
+func (h *AMICallHandle) Ended() <-chan struct{} {
+	return h.ended
+}
+
+// Result returns the outcome of the call, if already known.
+func (h *AMICallHandle) Result() (AMICallResult, bool) {
+	h.mutex.RLock()
+	defer h.mutex.RUnlock()
+	if h.result == nil {
+		return AMICallResult{}, false
+	}
+	return *h.result, true
+}
+
+// Wait
+// Wait blocks until the outcome of the call is known or the context is done.
+func (h *AMICallHandle) Wait(ctx context.Context) (AMICallResult, error) {
+	select {
+	case <-h.done:
+		r, _ := h.Result()
+		return r, nil
+	case <-ctx.Done():
+		return AMICallResult{}, ctx.Err()
+	}
+}
+
+// WaitChannel
+// WaitChannel blocks until the channel name of the call is known or the context is done.
+func (h *AMICallHandle) WaitChannel(ctx context.Context) (string, error) {
+	select {
+	case <-h.known:
+		return h.Channel(), nil
+	case <-ctx.Done():
+		return "", ctx.Err()
+	}
+}
+
+// Answered returns true if the call has been answered.
+func (h *AMICallHandle) Answered() bool {
+	r, ok := h.Result()
+	return ok && r.Outcome == config.AmiOriginateOutcomeAnswered
+}
+
+// Track
+// Track registers a callback which receives every AMI event of the leg (by Uniqueid) until it hangs up.
+func (h *AMICallHandle) Track(callback func(*AMIMessage)) *AMICallHandle {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	h.trackers = append(h.trackers, callback)
+	return h
+}
+
+// Hangup
+// Hangup hangs the leg up. It fails while the channel name is not known yet.
+func (h *AMICallHandle) Hangup(ctx context.Context, cause string) (AmiReply, error) {
+	if h.originator.core == nil {
+		return nil, fmt.Errorf(config.AmiErrorCoreRequired, config.AmiActionHangup)
+	}
+	channel := h.Channel()
+	if IsStringEmpty(channel) {
+		return nil, fmt.Errorf(config.AmiErrorFieldRequired, config.AmiFieldChannel)
+	}
+	return h.originator.core.Hangup(ctx, channel, cause)
+}
+
+// Redirect
+// Redirect transfers the leg to the extension, in the dialplan context.
+func (h *AMICallHandle) Redirect(ctx context.Context, exten, context string, priority int) (AmiReply, error) {
+	if h.originator.core == nil {
+		return nil, fmt.Errorf(config.AmiErrorCoreRequired, config.AmiActionRedirect)
+	}
+	channel := h.Channel()
+	if IsStringEmpty(channel) {
+		return nil, fmt.Errorf(config.AmiErrorFieldRequired, config.AmiFieldChannel)
+	}
+	call := AMIPayloadCall{
+		Channel:  channel,
+		Exten:    exten,
+		Context:  context,
+		Priority: strconv.Itoa(priority),
+	}
+	return h.originator.core.Redirect(ctx, call)
+}
+
+func (h *AMICallHandle) Json() string {
+	r, _ := h.Result()
+	return JsonString(r)
+}
+
+// markKnown closes the known channel once the channel name is set. The caller must hold the lock.
+func (h *AMICallHandle) markKnown() {
+	if !IsStringEmpty(h.channel) {
+		closeOnce(h.known)
+	}
+}
+
+func closeOnce(ch chan struct{}) {
+	select {
+	case <-ch:
+	default:
+		close(ch)
+	}
+}
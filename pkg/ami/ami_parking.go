@@ -0,0 +1,454 @@
+package ami
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/pnguyen215/voipkit/pkg/ami/config"
+)
+
+// parkingEvents contains the events which update the occupancy of parking lots.
+var parkingEvents = []string{
+	config.AmiListenerEventParkedCall,
+	config.AmiListenerEventParkedCallSwap,
+	config.AmiListenerEventParkedCallTimeOut,
+	config.AmiListenerEventParkedCallGiveUp,
+	config.AmiListenerEventUnParkedCall,
+}
+
+// NewAMIParkingService
+// NewAMIParkingService creates a parking service. The core is used to park and retrieve calls,
+// it might be nil when the service only tracks parking lots.
+func NewAMIParkingService(core *AMICore) *AMIParkingService {
+	p := &AMIParkingService{}
+	p.core = core
+	p.lots = make(map[string]*AMIParkingLot)
+	p.contexts = make(map[string]string)
+	p.waiters = make(map[string][]chan AMIParkedCall)
+	p.SetDefaultContext("parkedcalls")
+	p.SetWaitTimeout(5 * time.Second)
+	return p
+}
+
+// SetDefaultContext
+// SetDefaultContext sets the dialplan context of parking spaces, used to retrieve parked calls.
+func (p *AMIParkingService) SetDefaultContext(value string) *AMIParkingService {
+	p.DefaultContext = TrimStringSpaces(value)
+	return p
+}
+
+// SetContext
+// SetContext sets the dialplan context of the parking spaces of a specific lot.
+func (p *AMIParkingService) SetContext(lot, context string) *AMIParkingService {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.contexts[lot] = TrimStringSpaces(context)
+	return p
+}
+
+// SetWaitTimeout
+// SetWaitTimeout sets how long Park waits for the ParkedCall event before looking the space up with ParkedCalls.
+func (p *AMIParkingService) SetWaitTimeout(value time.Duration) *AMIParkingService {
+	p.WaitTimeout = value
+	return p
+}
+
+// OnChange
+// OnChange registers a callback which is invoked with the parking event name and the call after each update.
+func (p *AMIParkingService) OnChange(callback func(event string, call AMIParkedCall)) *AMIParkingService {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.onChange = append(p.onChange, callback)
+	return p
+}
+
+func (p *AMIParkingService) Json() string {
+	return JsonString(p.Lots())
+}
+
+// Seed
+// Seed loads the parking lots from Parkinglots and the calls being parked from ParkedCalls.
+func (p *AMIParkingService) Seed(ctx context.Context) error {
+	if p.core == nil {
+		return fmt.Errorf(config.AmiErrorCoreRequired, config.AmiActionParkingLots)
+	}
+	lots, err := p.core.Parkinglots(ctx)
+	if err != nil {
+		return err
+	}
+	calls, err := p.core.ParkedCalls(ctx)
+	if err != nil {
+		return err
+	}
+	p.SeedWith(lots, calls)
+	return nil
+}
+
+// SeedWith
+// SeedWith initializes the parking lots from Parkinglots items and ParkedCalls items.
+func (p *AMIParkingService) SeedWith(lots []AmiReply, calls []AmiReply) *AMIParkingService {
+	now := time.Now()
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	for _, v := range lots {
+		name := v.GetFold("Name")
+		if IsStringEmpty(name) {
+			continue
+		}
+		lot := p.lot(name)
+		lot.StartSpace, _ = strconv.Atoi(v.GetFold("StartSpace"))
+		lot.StopSpace, _ = strconv.Atoi(v.GetFold("StopSpace"))
+		if seconds, err := strconv.Atoi(v.GetFold("Timeout")); err == nil {
+			lot.Timeout = time.Duration(seconds) * time.Second
+		}
+	}
+	for _, v := range calls {
+		call := p.parseParkedCall(v.GetFold, now)
+		if IsStringEmpty(call.Space) {
+			continue
+		}
+		p.park(call)
+	}
+	return p
+}
+
+// Apply
+// Apply feeds a ParkedCall, ParkedCallSwap, ParkedCallTimeOut, ParkedCallGiveUp or UnParkedCall event into the service.
+// It returns the call affected by the event, or nil if the event is not parking related.
+func (p *AMIParkingService) Apply(e *AMIMessage) *AMIParkedCall {
+	if e == nil {
+		return nil
+	}
+	event := e.Field(config.AmiEventKey)
+	if !Contains(parkingEvents, event) {
+		return nil
+	}
+	call := p.parseParkedCall(e.Field, time.Now())
+	if IsStringEmpty(call.Space) {
+		return nil
+	}
+	p.mutex.Lock()
+	var waiters []chan AMIParkedCall
+	switch event {
+	case config.AmiListenerEventParkedCall, config.AmiListenerEventParkedCallSwap:
+		p.park(call)
+		waiters = p.waiters[call.Channel]
+		delete(p.waiters, call.Channel)
+	default:
+		if v, ok := p.unpark(call.Lot, call.Space); ok {
+			call.ParkedAt = v.ParkedAt
+		}
+	}
+	callbacks := p.onChange
+	p.mutex.Unlock()
+
+	for _, ch := range waiters {
+		ch <- call
+	}
+	for _, fn := range callbacks {
+		fn(event, call)
+	}
+	return &call
+}
+
+// Lots
+// Lots returns the occupancy of all parking lots, ordered by name.
+func (p *AMIParkingService) Lots() []AMIParkingLot {
+	p.mutex.RLock()
+	defer p.mutex.RUnlock()
+	response := make([]AMIParkingLot, 0, len(p.lots))
+	for _, lot := range p.lots {
+		response = append(response, lot.clone())
+	}
+	sort.Slice(response, func(i, j int) bool {
+		return response[i].Name < response[j].Name
+	})
+	return response
+}
+
+// Lot
+// Lot returns the occupancy of the parking lot.
+func (p *AMIParkingService) Lot(name string) (AMIParkingLot, bool) {
+	p.mutex.RLock()
+	defer p.mutex.RUnlock()
+	lot, ok := p.lots[name]
+	if !ok {
+		return AMIParkingLot{}, false
+	}
+	return lot.clone(), true
+}
+
+// Call
+// Call returns the call parked in the space of the lot.
+func (p *AMIParkingService) Call(lot, space string) (AMIParkedCall, bool) {
+	p.mutex.RLock()
+	defer p.mutex.RUnlock()
+	v, ok := p.lots[lot]
+	if !ok {
+		return AMIParkedCall{}, false
+	}
+	for _, call := range v.Calls {
+		if call.Space == space {
+			return call, true
+		}
+	}
+	return AMIParkedCall{}, false
+}
+
+// Park
+// Park parks the channel into the lot, announcing the parking space to announceChannel when set,
+// and returns the assigned parking space. A zero timeout keeps the timeout of the lot.
+// The space is taken from the ParkedCall event when the service is open (see Open), waiting up to WaitTimeout
+// before falling back to ParkedCalls. When the service is not open, the space is looked up with ParkedCalls at once.
+func (p *AMIParkingService) Park(ctx context.Context, channel, lot string, timeout time.Duration, announceChannel string) (AMIParkedCall, error) {
+	if p.core == nil {
+		return AMIParkedCall{}, fmt.Errorf(config.AmiErrorCoreRequired, config.AmiActionPark)
+	}
+	if IsStringEmpty(channel) {
+		return AMIParkedCall{}, fmt.Errorf(config.AmiErrorFieldRequired, config.AmiFieldChannel)
+	}
+	ch := make(chan AMIParkedCall, 1)
+	p.mutex.Lock()
+	p.waiters[channel] = append(p.waiters[channel], ch)
+	p.mutex.Unlock()
+	defer p.release(channel, ch)
+
+	reply, err := p.core.ParkWith(ctx, channel, "", announceChannel, int(timeout/time.Millisecond), lot)
+	if err != nil {
+		return AMIParkedCall{}, err
+	}
+	if IsFailure(reply) {
+		return AMIParkedCall{}, fmt.Errorf(config.AmiErrorActionFailed, config.AmiActionPark, reply.Get(config.AmiJsonFieldMessage))
+	}
+	if atomic.LoadInt32(&p.opened) > 0 {
+		wait := time.NewTimer(p.WaitTimeout)
+		defer wait.Stop()
+		select {
+		case call := <-ch:
+			return call, nil
+		case <-ctx.Done():
+			return AMIParkedCall{}, ctx.Err()
+		case <-wait.C:
+		}
+	}
+	calls, err := p.core.ParkedCalls(ctx)
+	if err != nil {
+		return AMIParkedCall{}, err
+	}
+	p.SeedWith(nil, calls)
+	for _, v := range calls {
+		call := p.parseParkedCall(v.GetFold, time.Now())
+		if call.Channel == channel {
+			return call, nil
+		}
+	}
+	return AMIParkedCall{}, fmt.Errorf(config.AmiErrorActionFailed, config.AmiActionPark, "parked call not found")
+}
+
+// Retrieve
+// Retrieve originates a call from the endpoint (i.e: SIP/1000) to the parking space, which picks the parked call up.
+func (p *AMIParkingService) Retrieve(ctx context.Context, lot, space, endpoint string) (AmiReply, error) {
+	if p.core == nil {
+		return nil, fmt.Errorf(config.AmiErrorCoreRequired, config.AmiActionOriginate)
+	}
+	originate := AMIOriginate{
+		Channel:  endpoint,
+		Exten:    space,
+		Context:  p.context(lot),
+		Priority: 1,
+		Timeout:  30000,
+		Async:    "true",
+	}
+	if call, ok := p.Call(lot, space); ok {
+		originate.CallerID = fmt.Sprintf("%s <%s>", call.CallerIdName, call.CallerIdNumber)
+	}
+	return p.core.Originate(ctx, originate)
+}
+
+// RetrieveRedirect
+// RetrieveRedirect redirects an active channel (i.e: the receptionist channel) to the parking space.
+func (p *AMIParkingService) RetrieveRedirect(ctx context.Context, lot, space, channel string) (AmiReply, error) {
+	if p.core == nil {
+		return nil, fmt.Errorf(config.AmiErrorCoreRequired, config.AmiActionRedirect)
+	}
+	call := AMIPayloadCall{
+		Channel:  channel,
+		Exten:    space,
+		Context:  p.context(lot),
+		Priority: "1",
+	}
+	return p.core.Redirect(ctx, call)
+}
+
+// Open
+// Open consumes parking events until the AMI connection is closed.
+func (p *AMIParkingService) Open(c *AMI) {
+	event := c.OnEvents(parkingEvents...)
+	defer c.Unsubscribes(event, parkingEvents...)
+	atomic.AddInt32(&p.opened, 1)
+	defer atomic.AddInt32(&p.opened, -1)
+	ctx := c.Context()
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	for {
+		select {
+		case message, ok := <-event:
+			if !ok {
+				return
+			}
+			p.Apply(message)
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (p *AMIParkingService) OpenAsyncFunc(c *AMI) {
+	go func() {
+		p.Open(c)
+	}()
+}
+
+// parseParkedCall reads a parked call from an event or a list item, through the field getter.
+func (p *AMIParkingService) parseParkedCall(field func(string) string, at time.Time) AMIParkedCall {
+	call := AMIParkedCall{
+		Lot:              field("Parkinglot"),
+		Space:            field("ParkingSpace"),
+		Channel:          field("ParkeeChannel"),
+		UniqueId:         field("ParkeeUniqueid"),
+		CallerIdNumber:   field("ParkeeCallerIDNum"),
+		CallerIdName:     field("ParkeeCallerIDName"),
+		ParkerDialString: field("ParkerDialString"),
+		ParkedAt:         at,
+	}
+	if IsStringEmpty(call.Lot) {
+		call.Lot = "default"
+	}
+	if seconds, err := strconv.Atoi(field("ParkingTimeout")); err == nil {
+		call.Timeout = time.Duration(seconds) * time.Second
+	}
+	// ParkingDuration is the number of seconds the call has been parked
+	if seconds, err := strconv.Atoi(field("ParkingDuration")); err == nil {
+		call.ParkedAt = at.Add(-time.Duration(seconds) * time.Second)
+	}
+	return call
+}
+
+// lot returns the parking lot, creating it when missing. The caller must hold the lock.
+func (p *AMIParkingService) lot(name string) *AMIParkingLot {
+	lot, ok := p.lots[name]
+	if !ok {
+		lot = &AMIParkingLot{Name: name}
+		p.lots[name] = lot
+	}
+	return lot
+}
+
+func (p *AMIParkingService) park(call AMIParkedCall) {
+	p.unpark(call.Lot, call.Space)
+	lot := p.lot(call.Lot)
+	lot.Calls = append(lot.Calls, call)
+}
+
+func (p *AMIParkingService) unpark(name, space string) (AMIParkedCall, bool) {
+	lot, ok := p.lots[name]
+	if !ok {
+		return AMIParkedCall{}, false
+	}
+	for i, call := range lot.Calls {
+		if call.Space == space {
+			lot.Calls = append(lot.Calls[:i], lot.Calls[i+1:]...)
+			return call, true
+		}
+	}
+	return AMIParkedCall{}, false
+}
+
+func (p *AMIParkingService) release(channel string, ch chan AMIParkedCall) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	waiters := p.waiters[channel]
+	for i, v := range waiters {
+		if v == ch {
+			waiters = append(waiters[:i], waiters[i+1:]...)
+			break
+		}
+	}
+	if len(waiters) == 0 {
+		delete(p.waiters, channel)
+		return
+	}
+	p.waiters[channel] = waiters
+}
+
+func (p *AMIParkingService) context(lot string) string {
+	p.mutex.RLock()
+	defer p.mutex.RUnlock()
+	if v, ok := p.contexts[lot]; ok && !IsStringEmpty(v) {
+		return v
+	}
+	return p.DefaultContext
+}
+
+func (l *AMIParkingLot) clone() AMIParkingLot {
+	c := *l
+	c.Calls = append([]AMIParkedCall(nil), l.Calls...)
+	sort.Slice(c.Calls, func(i, j int) bool {
+		a, _ := strconv.Atoi(c.Calls[i].Space)
+		b, _ := strconv.Atoi(c.Calls[j].Space)
+		if a != b {
+			return a < b
+		}
+		return strings.Compare(c.Calls[i].Space, c.Calls[j].Space) < 0
+	})
+	return c
+}
+
+func (l *AMIParkingLot) Json() string {
+	return JsonString(l)
+}
+
+// FreeSpaces returns the spaces of the lot which are not occupied, when the range of the lot is known.
+func (l *AMIParkingLot) FreeSpaces() []string {
+	var response []string
+	for space := l.StartSpace; space > 0 && space <= l.StopSpace; space++ {
+		occupied := false
+		for _, call := range l.Calls {
+			if call.Space == strconv.Itoa(space) {
+				occupied = true
+				break
+			}
+		}
+		if !occupied {
+			response = append(response, strconv.Itoa(space))
+		}
+	}
+	return response
+}
+
+func (c *AMIParkedCall) Json() string {
+	return JsonString(c)
+}
+
+// ParkedFor returns how long the call has been parked at the given moment.
+func (c *AMIParkedCall) ParkedFor(at time.Time) time.Duration {
+	return at.Sub(c.ParkedAt)
+}
+
+// Remaining returns the time left before the parked call times out, zero when the lot has no timeout.
+func (c *AMIParkedCall) Remaining(at time.Time) time.Duration {
+	if c.Timeout <= 0 {
+		return 0
+	}
+	left := c.Timeout - c.ParkedFor(at)
+	if left < 0 {
+		return 0
+	}
+	return left
+}
@@ -20,6 +20,6 @@ func PresenceState(ctx context.Context, s AMISocket, provider string) (AmiReply,
 func PresenceStateList(ctx context.Context, s AMISocket) ([]AmiReply, error) {
 	c := NewCommand().SetId(s.UUID).SetAction(config.AmiActionPresenceStateList)
 	callback := NewAmiCallbackService(ctx, s, c,
-		[]string{config.AmiListenerEventAgents}, []string{config.AmiListenerEventPresenceStateListComplete})
+		[]string{config.AmiListenerEventPresenceStateChange}, []string{config.AmiListenerEventPresenceStateListComplete})
 	return callback.SendSuperLevel()
 }
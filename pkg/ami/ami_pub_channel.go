@@ -1,8 +1,9 @@
 package ami
 
 import (
-	"log"
 	"strings"
+	"sync"
+	"sync/atomic"
 
 	"github.com/pnguyen215/voipkit/pkg/ami/config"
 )
@@ -10,6 +11,7 @@ import (
 func NewPubSubQueue() *AMIPubSubQueue {
 	c := &AMIPubSubQueue{}
 	c.message = make(MessageChannel)
+	c.queues = make(map[PubChannel]*amiPubQueue)
 	return c
 }
 
@@ -30,13 +32,21 @@ func (k *AMIPubSubQueue) Destroy() {
 	defer k.mutex.Unlock()
 
 	if k.Off {
-		log.Println("Destroy pub-sub stopped")
+		componentLog(config.AmiLogComponentPubSub).Debug("pub-sub already destroyed")
 		return
 	}
 
 	k.Off = true
-	for key, ch := range k.message {
-		close(ch)
+	closed := make(map[PubChannel]bool)
+	for key, channels := range k.message {
+		for _, ch := range channels {
+			// a channel might be shared by many keys (see Subscribes), so close it once
+			if closed[ch] {
+				continue
+			}
+			k.close(ch)
+			closed[ch] = true
+		}
 		delete(k.message, key)
 	}
 }
@@ -47,43 +57,120 @@ func (k *AMIPubSubQueue) SizeMessage() int {
 	return len(k.message)
 }
 
+// Subscribe
+// Subscribe registers a channel to the events of the key, its events are queued in the order they are published
+// without blocking the publisher and never dropped. Unsubscribe it once it is not read anymore.
 func (k *AMIPubSubQueue) Subscribe(key string) PubChannel {
-	k.mutex.Lock()
-	defer k.mutex.Unlock()
-
-	if k.Off {
-		return nil
-	}
+	return k.subscribe(0, key)
+}
 
-	key = strings.ToLower(key)
+// Subscribes
+// Subscribes registers a channel to the events of the keys, see Subscribe.
+func (k *AMIPubSubQueue) Subscribes(keys ...string) PubChannel {
+	return k.subscribe(0, keys...)
+}
 
-	ch := make(PubChannel)
+// SubscribeBuffered
+// SubscribeBuffered registers a channel to the events of the key, its events are buffered up to size
+// then dropped (see Dropped), for the subscribers that would rather lose events than pile them up.
+func (k *AMIPubSubQueue) SubscribeBuffered(key string, size int) PubChannel {
+	return k.SubscribesBuffered(size, key)
+}
 
-	if _, ok := k.message[key]; !ok {
-		k.message[key] = ch
+// SubscribesBuffered
+// SubscribesBuffered registers a channel to the events of the keys, see SubscribeBuffered.
+// The size defaults to config.AmiPubSubBufferSize.
+func (k *AMIPubSubQueue) SubscribesBuffered(size int, keys ...string) PubChannel {
+	if size <= 0 {
+		size = config.AmiPubSubBufferSize
 	}
-
-	return ch
+	return k.subscribe(size, keys...)
 }
 
-func (k *AMIPubSubQueue) Subscribes(keys ...string) PubChannel {
+// subscribe registers a channel to the keys, buffered up to size or queued without limit if size is 0
+func (k *AMIPubSubQueue) subscribe(size int, keys ...string) PubChannel {
 	k.mutex.Lock()
 	defer k.mutex.Unlock()
 	if k.Off {
 		return nil
 	}
-	ch := make(PubChannel, len((keys)))
+	ch := make(PubChannel, size)
+	if size == 0 {
+		k.queues[ch] = newAmiPubQueue(ch)
+	}
 	for _, key := range keys {
 		key = strings.ToLower(key)
-		if _, ok := k.message[key]; !ok {
-			k.message[key] = ch
-			// ch <- <-k.Message[key]
+		if Contains(k.message[key], ch) {
+			continue
 		}
+		k.message[key] = append(k.message[key], ch)
 	}
-	// close(ch)
 	return ch
 }
 
+// Unsubscribe
+// Unsubscribe removes the channel from the subscribers of the key,
+// the channel is closed once it is no longer subscribed to any key.
+func (k *AMIPubSubQueue) Unsubscribe(key string, ch <-chan *AMIMessage) {
+	k.mutex.Lock()
+	defer k.mutex.Unlock()
+	k.unsubscribe(strings.ToLower(key), ch)
+}
+
+// Unsubscribes
+// Unsubscribes removes the channel from the subscribers of the keys, see Unsubscribe.
+func (k *AMIPubSubQueue) Unsubscribes(ch <-chan *AMIMessage, keys ...string) {
+	k.mutex.Lock()
+	defer k.mutex.Unlock()
+	for _, key := range keys {
+		k.unsubscribe(strings.ToLower(key), ch)
+	}
+}
+
+// unsubscribe removes the channel from the key, the lock must be held
+func (k *AMIPubSubQueue) unsubscribe(key string, ch <-chan *AMIMessage) {
+	if ch == nil || k.Off {
+		return
+	}
+	var target PubChannel
+	channels := k.message[key]
+	for i, c := range channels {
+		if (<-chan *AMIMessage)(c) == ch {
+			target = c
+			k.message[key] = append(channels[:i:i], channels[i+1:]...)
+			break
+		}
+	}
+	if target == nil {
+		return
+	}
+	if len(k.message[key]) == 0 {
+		delete(k.message, key)
+	}
+	for _, channels := range k.message {
+		if Contains(channels, target) {
+			return
+		}
+	}
+	k.close(target)
+}
+
+// close closes the channel, once its queue is flushed if any, the lock must be held
+func (k *AMIPubSubQueue) close(ch PubChannel) {
+	if q, ok := k.queues[ch]; ok {
+		delete(k.queues, ch)
+		q.stop()
+		return
+	}
+	close(ch)
+}
+
+// Dropped
+// Dropped returns the count of the events dropped since the buffer of their subscriber was full (see SubscribeBuffered).
+func (k *AMIPubSubQueue) Dropped() uint64 {
+	return atomic.LoadUint64(&k.dropped)
+}
+
 // Publish broadcasts the provided AMI message to all subscribers interested in the corresponding event type.
 // It also broadcasts the message to subscribers interested in all events.
 // Returns true if the message is successfully published; otherwise, returns false.
@@ -94,25 +181,93 @@ func (k *AMIPubSubQueue) Subscribes(keys ...string) PubChannel {
 //
 // Note: The AMI Pub-Sub mechanism allows subscribers to receive notifications for specific events or all events.
 // This method ensures that the message is sent to relevant subscribers based on event type and general subscriptions.
+// The messages are queued by subscriber in the order they are published, without blocking the publisher:
+// only the messages of a buffered subscriber whose buffer is full are dropped (see SubscribeBuffered).
 func (k *AMIPubSubQueue) Publish(message *AMIMessage) bool {
 	k.mutex.RLock()
 	defer k.mutex.RUnlock()
 	if k.Off {
 		return false
 	}
-	ch, ok := k.message[config.AmiPubSubKeyRef]
-	if ok {
-		go func(ch PubChannel) {
-			ch <- message
-		}(ch)
-	}
+	delivered := make(map[PubChannel]bool)
+	k.deliver(k.message[config.AmiPubSubKeyRef], message, delivered)
 	name := strings.ToLower(message.Field(strings.ToLower(config.AmiEventKey)))
 	if name != "" {
-		if ch, ok := k.message[name]; ok {
-			go func(ch PubChannel) {
-				ch <- message
-			}(ch)
-		}
+		k.deliver(k.message[name], message, delivered)
 	}
 	return true
 }
+
+// deliver sends the message to the channels not delivered yet, the lock must be held
+func (k *AMIPubSubQueue) deliver(channels []PubChannel, message *AMIMessage, delivered map[PubChannel]bool) {
+	for _, ch := range channels {
+		if delivered[ch] {
+			continue
+		}
+		delivered[ch] = true
+		if q, ok := k.queues[ch]; ok {
+			q.push(message)
+			continue
+		}
+		select {
+		case ch <- message:
+		default:
+			atomic.AddUint64(&k.dropped, 1)
+			Metrics().ObserveDroppedEvent(config.AmiMetricSourcePubSub)
+		}
+	}
+}
+
+// amiPubQueue queues the messages of a lossless subscriber and sends them in order to its channel
+type amiPubQueue struct {
+	ch      PubChannel
+	pending []*AMIMessage
+	mutex   sync.Mutex
+	wake    chan struct{}
+	done    chan struct{}
+}
+
+func newAmiPubQueue(ch PubChannel) *amiPubQueue {
+	q := &amiPubQueue{ch: ch, wake: make(chan struct{}, 1), done: make(chan struct{})}
+	go q.run()
+	return q
+}
+
+// push queues the message without blocking
+func (q *amiPubQueue) push(message *AMIMessage) {
+	q.mutex.Lock()
+	q.pending = append(q.pending, message)
+	q.mutex.Unlock()
+	select {
+	case q.wake <- struct{}{}:
+	default:
+	}
+}
+
+// stop stops the queue, its channel is closed and the messages not read are discarded
+func (q *amiPubQueue) stop() {
+	close(q.done)
+}
+
+// run sends the queued messages to the channel until the queue is stopped
+func (q *amiPubQueue) run() {
+	defer close(q.ch)
+	for {
+		q.mutex.Lock()
+		pending := q.pending
+		q.pending = nil
+		q.mutex.Unlock()
+		for _, message := range pending {
+			select {
+			case q.ch <- message:
+			case <-q.done:
+				return
+			}
+		}
+		select {
+		case <-q.wake:
+		case <-q.done:
+			return
+		}
+	}
+}
@@ -0,0 +1,326 @@
+package ami
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pnguyen215/voipkit/pkg/ami/config"
+)
+
+// ParseQueueLogLine
+// ParseQueueLogLine parses a queue_log line: time|callid|queue|agent|event|data1|data2|data3|data4|data5
+func ParseQueueLogLine(line string) (*AMIQueueLog, error) {
+	line = strings.TrimRight(line, "\r\n")
+	if IsStringEmpty(line) {
+		return nil, fmt.Errorf(config.AmiErrorQueueLogInvalid, "empty line")
+	}
+	fields := strings.Split(line, "|")
+	if len(fields) < 5 {
+		return nil, fmt.Errorf(config.AmiErrorQueueLogInvalid, line)
+	}
+	at, ok := parseEventTime(fields[0])
+	if !ok {
+		return nil, fmt.Errorf(config.AmiErrorQueueLogInvalid, line)
+	}
+	r := &AMIQueueLog{
+		Time:   at,
+		CallId: fields[1],
+		Queue:  fields[2],
+		Agent:  fields[3],
+		Event:  config.AmiQueueLogVerb(strings.ToUpper(TrimStringSpaces(fields[4]))),
+		Data:   fields[5:],
+		Raw:    line,
+	}
+	r.decode()
+	return r, nil
+}
+
+// ReadQueueLog
+// ReadQueueLog reads all the records of the reader, the invalid lines are skipped.
+func ReadQueueLog(reader io.Reader) ([]AMIQueueLog, error) {
+	var records []AMIQueueLog
+	scanner := bufio.NewScanner(reader)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		if IsStringEmpty(scanner.Text()) {
+			continue
+		}
+		r, err := ParseQueueLogLine(scanner.Text())
+		if err != nil {
+			componentLog(config.AmiLogComponentQueue).Warn("skipping queue_log line", config.AmiLogFieldError, err)
+			continue
+		}
+		records = append(records, *r)
+	}
+	return records, scanner.Err()
+}
+
+// ReadQueueLogFile
+// ReadQueueLogFile reads all the records of the queue_log file.
+func ReadQueueLogFile(filename string) ([]AMIQueueLog, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return ReadQueueLog(file)
+}
+
+// decode sets the typed fields from data1..data5 by the verb
+func (r *AMIQueueLog) decode() {
+	switch r.Event {
+	case config.AmiQueueLogEnterQueue:
+		r.CallerId = r.data(1)
+		r.Position = r.number(2)
+	case config.AmiQueueLogConnect:
+		r.HoldTime = r.number(0)
+		r.Channel = r.data(1)
+		r.RingTime = r.number(2)
+	case config.AmiQueueLogCompleteAgent, config.AmiQueueLogCompleteCaller:
+		r.HoldTime = r.number(0)
+		r.CallTime = r.number(1)
+		r.OrigPosition = r.number(2)
+	case config.AmiQueueLogAbandon, config.AmiQueueLogExitEmpty, config.AmiQueueLogExitWithTimeout:
+		r.Position = r.number(0)
+		r.OrigPosition = r.number(1)
+		r.WaitTime = r.number(2)
+	case config.AmiQueueLogExitWithKey:
+		r.Key = r.data(0)
+		r.Position = r.number(1)
+		r.OrigPosition = r.number(2)
+		r.WaitTime = r.number(3)
+	case config.AmiQueueLogRingNoAnswer, config.AmiQueueLogRingCanceled:
+		r.RingTime = r.number(0)
+	case config.AmiQueueLogTransfer, config.AmiQueueLogBlindTransfer:
+		r.Extension = r.data(0)
+		r.Context = r.data(1)
+		r.HoldTime = r.number(2)
+		r.CallTime = r.number(3)
+		r.OrigPosition = r.number(4)
+	case config.AmiQueueLogAttendedTransfer:
+		r.Reason = r.data(0)
+		r.Extension = r.data(1)
+		r.HoldTime = r.number(2)
+		r.CallTime = r.number(3)
+		r.OrigPosition = r.number(4)
+	case config.AmiQueueLogPause, config.AmiQueueLogUnpause, config.AmiQueueLogPauseAll, config.AmiQueueLogUnpauseAll:
+		r.Reason = r.data(0)
+	case config.AmiQueueLogAgentLogin:
+		r.Channel = r.data(0)
+	case config.AmiQueueLogAgentLogoff:
+		r.Channel = r.data(0)
+		r.CallTime = r.number(1)
+	case config.AmiQueueLogDid:
+		r.Did = r.data(0)
+	}
+}
+
+func (r *AMIQueueLog) data(index int) string {
+	if index >= len(r.Data) {
+		return ""
+	}
+	return TrimStringSpaces(r.Data[index])
+}
+
+func (r *AMIQueueLog) number(index int) int {
+	v, _ := strconv.Atoi(r.data(index))
+	return v
+}
+
+func (r *AMIQueueLog) Is(verb config.AmiQueueLogVerb) bool {
+	return r.Event == verb
+}
+
+// IsQueueLogCallerExit
+// IsQueueLogCallerExit returns true if the caller left the queue without being connected.
+func (r *AMIQueueLog) IsQueueLogCallerExit() bool {
+	switch r.Event {
+	case config.AmiQueueLogAbandon, config.AmiQueueLogExitEmpty, config.AmiQueueLogExitWithKey, config.AmiQueueLogExitWithTimeout:
+		return true
+	}
+	return false
+}
+
+func (r *AMIQueueLog) Json() string {
+	return JsonString(r)
+}
+
+// Message
+// Message converts the record into the AMI queue event it stands for (i.e: ENTERQUEUE is QueueCallerJoin),
+// with the call id as Uniqueid, so that the records and the live events are handled the same way.
+// The verbs without AMI equivalent are converted into the QueueLog event. The verb is kept in QueueLogEvent.
+func (r *AMIQueueLog) Message() *AMIMessage {
+	fields := map[string]string{
+		config.AmiFieldQueue:         r.Queue,
+		config.AmiFieldUniqueId:      r.CallId,
+		config.AmiFieldQueueLogEvent: string(r.Event),
+		config.AmiFieldTimestamp:     strconv.FormatFloat(float64(r.Time.UnixNano())/1e9, 'f', 6, 64),
+	}
+	if !IsStringEmpty(r.Agent) && !strings.EqualFold(r.Agent, "NONE") {
+		fields[config.AmiFieldMemberName] = r.Agent
+		fields[config.AmiFieldInterface] = r.Agent
+	}
+	event := "QueueLog"
+	switch r.Event {
+	case config.AmiQueueLogEnterQueue:
+		event = config.AmiListenerEventQueueCallerJoin
+		fields[config.AmiFieldCallerIdNum] = r.CallerId
+		fields[config.AmiFieldPosition] = strconv.Itoa(r.Position)
+	case config.AmiQueueLogAbandon:
+		event = config.AmiListenerEventQueueCallerAbandon
+		fields[config.AmiFieldPosition] = strconv.Itoa(r.Position)
+		fields[config.AmiFieldOriginalPosition] = strconv.Itoa(r.OrigPosition)
+		fields[config.AmiFieldHoldTime] = strconv.Itoa(r.WaitTime)
+	case config.AmiQueueLogExitEmpty, config.AmiQueueLogExitWithKey, config.AmiQueueLogExitWithTimeout:
+		event = config.AmiListenerEventQueueCallerLeave
+		fields[config.AmiFieldPosition] = strconv.Itoa(r.Position)
+	case config.AmiQueueLogConnect:
+		event = config.AmiListenerEventAgentConnect
+		fields[config.AmiFieldHoldTime] = strconv.Itoa(r.HoldTime)
+		fields[config.AmiFieldRingTime] = strconv.Itoa(r.RingTime)
+	case config.AmiQueueLogCompleteAgent, config.AmiQueueLogCompleteCaller:
+		event = config.AmiListenerEventAgentComplete
+		fields[config.AmiFieldHoldTime] = strconv.Itoa(r.HoldTime)
+		fields[config.AmiFieldTalkTime] = strconv.Itoa(r.CallTime)
+		fields[config.AmiFieldReason] = map[bool]string{true: "agent", false: "caller"}[r.Is(config.AmiQueueLogCompleteAgent)]
+	case config.AmiQueueLogRingNoAnswer:
+		event = config.AmiListenerEventAgentRingNoAnswer
+		fields[config.AmiFieldRingTime] = strconv.Itoa(r.RingTime)
+	case config.AmiQueueLogPause, config.AmiQueueLogUnpause:
+		event = config.AmiListenerEventQueueMemberPause
+		fields[config.AmiFieldPaused] = map[bool]string{true: "1", false: "0"}[r.Is(config.AmiQueueLogPause)]
+		fields[config.AmiFieldPausedReason] = r.Reason
+	case config.AmiQueueLogAddMember:
+		event = config.AmiListenerEventQueueMemberAdded
+	case config.AmiQueueLogRemoveMember:
+		event = config.AmiListenerEventQueueMemberRemoved
+	}
+	fields[config.AmiEventKey] = event
+	e := NewMessage()
+	e.AddFields(fields)
+	return e
+}
+
+// ReplayQueueLog
+// ReplayQueueLog publishes the records as AMI queue events into the pub-sub queue.
+// The speed scales the delays between the records, i.e: 1 for real time, 60 for a minute per second, 0 for no delay.
+func ReplayQueueLog(ctx context.Context, records []AMIQueueLog, pub *AMIPubSubQueue, speed float64) error {
+	var last time.Time
+	for i := range records {
+		if speed > 0 && !last.IsZero() && records[i].Time.After(last) {
+			delay := time.Duration(float64(records[i].Time.Sub(last)) / speed)
+			t := time.NewTimer(delay)
+			select {
+			case <-t.C:
+			case <-ctx.Done():
+				t.Stop()
+				return ctx.Err()
+			}
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		last = records[i].Time
+		pub.Publish(records[i].Message())
+	}
+	return nil
+}
+
+func NewAMIQueueLogTail(filename string) *AMIQueueLogTail {
+	t := &AMIQueueLogTail{Filename: filename}
+	t.SetInterval(time.Second)
+	return t
+}
+
+func (t *AMIQueueLogTail) SetInterval(value time.Duration) *AMIQueueLogTail {
+	if value > 0 {
+		t.Interval = value
+	}
+	return t
+}
+
+func (t *AMIQueueLogTail) SetFromStart(value bool) *AMIQueueLogTail {
+	t.FromStart = value
+	return t
+}
+
+// Follow
+// Follow calls the callback on every new record until the context is done.
+// The file is reopened from its start when it is rotated or truncated.
+func (t *AMIQueueLogTail) Follow(ctx context.Context, callback func(*AMIQueueLog)) error {
+	file, err := os.Open(t.Filename)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		file.Close()
+	}()
+	var offset int64
+	if !t.FromStart {
+		if offset, err = file.Seek(0, io.SeekEnd); err != nil {
+			return err
+		}
+	}
+	reader := bufio.NewReader(file)
+	var partial string
+	for {
+		line, err := reader.ReadString('\n')
+		offset += int64(len(line))
+		if err == nil {
+			line = partial + line
+			partial = ""
+			if !IsStringEmpty(line) {
+				if r, e := ParseQueueLogLine(line); e == nil {
+					callback(r)
+				} else {
+					componentLog(config.AmiLogComponentQueue).Warn("skipping queue_log line", config.AmiLogFieldError, e)
+				}
+			}
+			continue
+		}
+		if err != io.EOF {
+			return err
+		}
+		// keep the line being written until its end of line
+		partial += line
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(t.Interval):
+		}
+		rotated, err := t.rotated(file, offset)
+		if err != nil {
+			continue // the file is being rotated, retry on the next interval
+		}
+		if rotated {
+			next, err := os.Open(t.Filename)
+			if err != nil {
+				continue
+			}
+			file.Close()
+			file = next
+			reader = bufio.NewReader(file)
+			offset = 0
+			partial = ""
+		}
+	}
+}
+
+// rotated checks the file was replaced or truncated
+func (t *AMIQueueLogTail) rotated(file *os.File, offset int64) (bool, error) {
+	current, err := os.Stat(t.Filename)
+	if err != nil {
+		return false, err
+	}
+	opened, err := file.Stat()
+	if err != nil {
+		return false, err
+	}
+	return !os.SameFile(current, opened) || current.Size() < offset, nil
+}
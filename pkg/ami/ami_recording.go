@@ -0,0 +1,384 @@
+package ami
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pnguyen215/voipkit/pkg/ami/config"
+)
+
+func NewAMIRecordingManager(core *AMICore) *AMIRecordingManager {
+	m := &AMIRecordingManager{}
+	m.core = core
+	m.active = make(map[string]*AMIRecording)
+	m.SetFileTemplate("{date}/{uniqueid}-{extension}.wav")
+	m.SetRetention(time.Hour)
+	return m
+}
+
+// SetDirectory
+// SetDirectory sets the monitor directory of Asterisk, the files are recorded under it.
+func (m *AMIRecordingManager) SetDirectory(value string) *AMIRecordingManager {
+	m.Directory = TrimStringSpaces(value)
+	return m
+}
+
+// SetBaseURL
+// SetBaseURL sets the URL serving the monitor directory, i.e: https://pbx.local/recordings
+func (m *AMIRecordingManager) SetBaseURL(value string) *AMIRecordingManager {
+	m.BaseURL = strings.TrimRight(TrimStringSpaces(value), "/")
+	return m
+}
+
+// SetFileTemplate
+// SetFileTemplate sets the template of the file name, the placeholders are:
+// {uniqueid}, {linkedid}, {extension}, {channel}, {date} (20060102), {time} (150405),
+// {year}, {month}, {day} and {timestamp} (unix seconds).
+func (m *AMIRecordingManager) SetFileTemplate(value string) *AMIRecordingManager {
+	m.FileTemplate = TrimStringSpaces(value)
+	return m
+}
+
+// SetOptions
+// SetOptions sets the default MixMonitor options, i.e: b to only record the bridged call.
+func (m *AMIRecordingManager) SetOptions(value string) *AMIRecordingManager {
+	m.Options = value
+	return m
+}
+
+func (m *AMIRecordingManager) SetRetention(value time.Duration) *AMIRecordingManager {
+	m.Retention = value
+	return m
+}
+
+// OnChange
+// OnChange registers the callback raised on every change of a recording.
+func (m *AMIRecordingManager) OnChange(callback func(AMIRecording)) *AMIRecordingManager {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.onChange = append(m.onChange, callback)
+	return m
+}
+
+// FileName
+// FileName renders the file template of the request at the given time.
+func (m *AMIRecordingManager) FileName(request AMIRecordingRequest, at time.Time) string {
+	channel := strings.NewReplacer("/", "_", "@", "_", ";", "_").Replace(request.Channel)
+	r := strings.NewReplacer(
+		"{uniqueid}", request.UniqueId,
+		"{linkedid}", request.LinkedId,
+		"{extension}", request.Extension,
+		"{channel}", channel,
+		"{date}", at.Format("20060102"),
+		"{time}", at.Format("150405"),
+		"{year}", at.Format("2006"),
+		"{month}", at.Format("01"),
+		"{day}", at.Format("02"),
+		"{timestamp}", strconv.FormatInt(at.Unix(), 10),
+	)
+	return r.Replace(m.FileTemplate)
+}
+
+// Link
+// Link returns the URL of the file under the base URL, or its path if the base URL is not set.
+func (m *AMIRecordingManager) Link(file string) string {
+	if IsStringEmpty(file) {
+		return ""
+	}
+	relative := file
+	if !IsStringEmpty(m.Directory) {
+		if rel, err := filepath.Rel(m.Directory, file); err == nil && !strings.HasPrefix(rel, "..") {
+			relative = rel
+		}
+	}
+	if IsStringEmpty(m.BaseURL) {
+		if filepath.IsAbs(file) || IsStringEmpty(m.Directory) {
+			return file
+		}
+		return filepath.Join(m.Directory, file)
+	}
+	return m.BaseURL + "/" + filepath.ToSlash(relative)
+}
+
+// Start
+// Start records the channel by MixMonitor into the templated file.
+func (m *AMIRecordingManager) Start(ctx context.Context, request AMIRecordingRequest) (*AMIRecording, error) {
+	if m.core == nil {
+		return nil, fmt.Errorf(config.AmiErrorCoreRequired, config.AmiActionMixMonitor)
+	}
+	now := time.Now()
+	file := m.FileName(request, now)
+	if !IsStringEmpty(m.Directory) && !filepath.IsAbs(file) {
+		file = filepath.Join(m.Directory, file)
+	}
+	options := request.Options
+	if IsStringEmpty(options) {
+		options = m.Options
+	}
+	reply, err := m.core.MixMonitorWith(ctx, request.Channel, file, options, request.Command)
+	if err != nil {
+		return nil, err
+	}
+	if IsFailure(reply) {
+		return nil, fmt.Errorf(config.AmiErrorActionFailed, config.AmiActionMixMonitor, reply.Get(config.AmiJsonFieldMessage))
+	}
+	r := &AMIRecording{
+		Channel:      request.Channel,
+		UniqueId:     request.UniqueId,
+		LinkedId:     request.LinkedId,
+		Extension:    request.Extension,
+		MixMonitorId: reply.GetFold(config.AmiFieldMixMonitorId),
+		File:         file,
+		Link:         m.Link(file),
+		Status:       config.AmiRecordingStatusStarting,
+		StartedAt:    now,
+	}
+	m.mutex.Lock()
+	// the MixMonitorStart event may be received before the response
+	if current, ok := m.active[request.Channel]; ok && strings.EqualFold(current.Status, config.AmiRecordingStatusRecording) {
+		r.Status = current.Status
+		if IsStringEmpty(r.UniqueId) {
+			r.UniqueId = current.UniqueId
+		}
+		if IsStringEmpty(r.LinkedId) {
+			r.LinkedId = current.LinkedId
+		}
+	}
+	m.active[request.Channel] = r
+	snapshot := *r
+	callbacks := m.onChange
+	m.mutex.Unlock()
+	for _, callback := range callbacks {
+		callback(snapshot)
+	}
+	return &snapshot, nil
+}
+
+// Stop
+// Stop stops the recording of the channel and frees its file handle.
+func (m *AMIRecordingManager) Stop(ctx context.Context, channel string) (AmiReply, error) {
+	if m.core == nil {
+		return nil, fmt.Errorf(config.AmiErrorCoreRequired, config.AmiActionStopMixMonitor)
+	}
+	id := ""
+	if r, ok := m.Recording(channel); ok {
+		id = r.MixMonitorId
+	}
+	reply, err := m.core.StopMixMonitor(ctx, channel, id)
+	if err == nil && IsFailure(reply) {
+		err = fmt.Errorf(config.AmiErrorActionFailed, config.AmiActionStopMixMonitor, reply.Get(config.AmiJsonFieldMessage))
+	}
+	return reply, err
+}
+
+// Pause
+// Pause mutes both directions of the recording, i.e: while the caller gives the card details (PCI DSS).
+func (m *AMIRecordingManager) Pause(ctx context.Context, channel string) (AmiReply, error) {
+	return m.mute(ctx, channel, true)
+}
+
+// Resume
+// Resume unmutes both directions of the recording.
+func (m *AMIRecordingManager) Resume(ctx context.Context, channel string) (AmiReply, error) {
+	return m.mute(ctx, channel, false)
+}
+
+func (m *AMIRecordingManager) mute(ctx context.Context, channel string, state bool) (AmiReply, error) {
+	if m.core == nil {
+		return nil, fmt.Errorf(config.AmiErrorCoreRequired, config.AmiActionMixMonitorMute)
+	}
+	reply, err := m.core.MixMonitorMute(ctx, channel, config.AmiMixMonitorDirectionBoth, state)
+	if err == nil && IsFailure(reply) {
+		err = fmt.Errorf(config.AmiErrorActionFailed, config.AmiActionMixMonitorMute, reply.Get(config.AmiJsonFieldMessage))
+	}
+	return reply, err
+}
+
+// Recording
+// Recording returns the snapshot of the active recording of the channel.
+func (m *AMIRecordingManager) Recording(channel string) (*AMIRecording, bool) {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+	r, ok := m.active[channel]
+	if !ok {
+		return nil, false
+	}
+	return r.clone(), true
+}
+
+// Recordings
+// Recordings returns the snapshots of the active recordings.
+func (m *AMIRecordingManager) Recordings() []AMIRecording {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+	recordings := make([]AMIRecording, 0, len(m.active))
+	for _, r := range m.active {
+		recordings = append(recordings, *r.clone())
+	}
+	return recordings
+}
+
+// Find
+// Find returns the recording of the uniqueid, or else the first recording of the linkedid.
+func (m *AMIRecordingManager) Find(uniqueId string) (*AMIRecording, bool) {
+	if IsStringEmpty(uniqueId) {
+		return nil, false
+	}
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+	all := make([]*AMIRecording, 0, len(m.stopped)+len(m.active))
+	all = append(all, m.stopped...)
+	for _, r := range m.active {
+		all = append(all, r)
+	}
+	var linked *AMIRecording
+	for _, r := range all {
+		if r.UniqueId == uniqueId {
+			return r.clone(), true
+		}
+		if linked == nil && r.LinkedId == uniqueId {
+			linked = r
+		}
+	}
+	if linked != nil {
+		return linked.clone(), true
+	}
+	return nil, false
+}
+
+// AttachMediaLink
+// AttachMediaLink sets the media link of the CDR from the recording of its uniqueid, or else of its linkedid,
+// so that the recording made on the other leg of the call is attached too.
+func (m *AMIRecordingManager) AttachMediaLink(r *AMICdr) bool {
+	if r == nil || !IsStringEmpty(r.MediaLink) {
+		return false
+	}
+	for _, id := range []string{r.UniqueId, r.LinkedId} {
+		recording, ok := m.Find(id)
+		if !ok || IsStringEmpty(recording.Link) {
+			continue
+		}
+		r.SetMediaLink(recording.Link)
+		return true
+	}
+	return false
+}
+
+// Apply
+// Apply tracks the lifecycle of the recordings from the MixMonitorStart, MixMonitorStop and MixMonitorMute events.
+func (m *AMIRecordingManager) Apply(e *AMIMessage) {
+	if e == nil {
+		return
+	}
+	event := e.Field(config.AmiEventKey)
+	channel := e.Field(config.AmiFieldChannel)
+	if IsStringEmpty(channel) {
+		return
+	}
+	now := time.Now()
+	m.mutex.Lock()
+	r, ok := m.active[channel]
+	switch {
+	case strings.EqualFold(event, config.AmiListenerEventMixMonitorStart):
+		if !ok {
+			// started by the dialplan, the file is unknown
+			r = &AMIRecording{Channel: channel, StartedAt: now}
+			m.active[channel] = r
+		}
+		r.Status = config.AmiRecordingStatusRecording
+	case strings.EqualFold(event, config.AmiListenerEventMixMonitorMute):
+		if !ok {
+			m.mutex.Unlock()
+			return
+		}
+		muted := isConfYes(e.Field(config.AmiFieldState))
+		direction := e.Field(config.AmiFieldDirection)
+		r.Mutes = append(r.Mutes, AMIRecordingMute{Direction: direction, Muted: muted, At: now})
+		r.Muted = muted
+		if muted && strings.EqualFold(direction, config.AmiMixMonitorDirectionBoth) {
+			r.Status = config.AmiRecordingStatusPaused
+		} else if !muted {
+			r.Status = config.AmiRecordingStatusRecording
+		}
+	case strings.EqualFold(event, config.AmiListenerEventMixMonitorStop):
+		if !ok {
+			m.mutex.Unlock()
+			return
+		}
+		r.Status = config.AmiRecordingStatusStopped
+		r.StoppedAt = now
+		delete(m.active, channel)
+		m.stopped = append(m.stopped, r)
+	default:
+		m.mutex.Unlock()
+		return
+	}
+	if v := e.Field(config.AmiFieldUniqueId); IsStringEmpty(r.UniqueId) && !IsStringEmpty(v) {
+		r.UniqueId = v
+	}
+	if v := e.Field(config.AmiFieldLinkedId); IsStringEmpty(r.LinkedId) && !IsStringEmpty(v) {
+		r.LinkedId = v
+	}
+	m.prune(now)
+	snapshot := *r.clone()
+	callbacks := m.onChange
+	m.mutex.Unlock()
+	for _, callback := range callbacks {
+		callback(snapshot)
+	}
+}
+
+func (m *AMIRecordingManager) Open(c *AMI) {
+	events := []string{config.AmiListenerEventMixMonitorStart, config.AmiListenerEventMixMonitorStop, config.AmiListenerEventMixMonitorMute}
+	event := c.OnEvents(events...)
+	defer c.Unsubscribes(event, events...)
+	ctx := c.Context()
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	for {
+		select {
+		case message, ok := <-event:
+			if !ok {
+				return
+			}
+			m.Apply(message)
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (m *AMIRecordingManager) OpenAsyncFunc(c *AMI) {
+	go func() {
+		m.Open(c)
+	}()
+}
+
+// prune removes the stopped recordings out of the retention, the lock must be held
+func (m *AMIRecordingManager) prune(at time.Time) {
+	if m.Retention <= 0 {
+		return
+	}
+	kept := m.stopped[:0]
+	for _, r := range m.stopped {
+		if at.Sub(r.StoppedAt) < m.Retention {
+			kept = append(kept, r)
+		}
+	}
+	m.stopped = kept
+}
+
+func (r *AMIRecording) clone() *AMIRecording {
+	c := *r
+	c.Mutes = append([]AMIRecordingMute(nil), r.Mutes...)
+	return &c
+}
+
+func (r *AMIRecording) Json() string {
+	return JsonString(r)
+}
@@ -0,0 +1,583 @@
+package ami
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pnguyen215/voipkit/pkg/ami/config"
+)
+
+// registrationRttSmoothing is the weight of the newest qualify sample in the
+// exponentially weighted moving average used as latency baseline.
+const registrationRttSmoothing = 0.2
+
+// registrationReachableStatuses contains every peer, contact or registry status
+// that is considered as the endpoint being reachable.
+var registrationReachableStatuses = map[string]bool{
+	strings.ToLower(config.AmiPeerStatusRegistered):      true,
+	strings.ToLower(config.AmiPeerStatusReachable):       true,
+	strings.ToLower(config.AmiPeerStatusLagged):          true,
+	strings.ToLower(config.AmiContactStatusCreated):      true,
+	strings.ToLower(config.AmiContactStatusUpdated):      true,
+	strings.ToLower(config.AmiContactStatusNonQualified): true,
+}
+
+func NewAMIRegistrationSample() *AMIRegistrationSample {
+	s := &AMIRegistrationSample{}
+	return s
+}
+
+func (s *AMIRegistrationSample) SetEndpoint(value string) *AMIRegistrationSample {
+	s.Endpoint = TrimStringSpaces(value)
+	return s
+}
+
+func (s *AMIRegistrationSample) SetTechnology(value string) *AMIRegistrationSample {
+	s.Technology = strings.ToUpper(TrimStringSpaces(value))
+	return s
+}
+
+func (s *AMIRegistrationSample) SetEvent(value string) *AMIRegistrationSample {
+	s.Event = value
+	return s
+}
+
+func (s *AMIRegistrationSample) SetStatus(value string) *AMIRegistrationSample {
+	s.Status = TrimStringSpaces(value)
+	s.Reachable = IsRegistrationReachable(s.Status)
+	return s
+}
+
+func (s *AMIRegistrationSample) SetAddress(value string) *AMIRegistrationSample {
+	s.Address = value
+	return s
+}
+
+func (s *AMIRegistrationSample) SetUserAgent(value string) *AMIRegistrationSample {
+	s.UserAgent = value
+	return s
+}
+
+func (s *AMIRegistrationSample) SetRoundTripMs(value float64) *AMIRegistrationSample {
+	s.RoundTripMs = value
+	return s
+}
+
+func (s *AMIRegistrationSample) SetCause(value string) *AMIRegistrationSample {
+	s.Cause = value
+	return s
+}
+
+func (s *AMIRegistrationSample) SetAt(value time.Time) *AMIRegistrationSample {
+	s.At = value
+	return s
+}
+
+func (s *AMIRegistrationSample) Json() string {
+	return JsonString(s)
+}
+
+// IsRegistrationReachable
+// IsRegistrationReachable returns true if the peer, contact or registry status means the endpoint can be reached.
+func IsRegistrationReachable(status string) bool {
+	return registrationReachableStatuses[strings.ToLower(TrimStringSpaces(status))]
+}
+
+// ParseRegistrationSample
+// ParseRegistrationSample converts a PeerStatus (SIP, IAX2), ContactStatus (PJSIP) or Registry event
+// into a registration sample. It returns nil when the message is none of those events.
+func ParseRegistrationSample(e *AMIMessage, at time.Time) *AMIRegistrationSample {
+	if e == nil {
+		return nil
+	}
+	event := e.Field(config.AmiEventKey)
+	s := NewAMIRegistrationSample().SetEvent(event).SetAt(at)
+	switch {
+	case strings.EqualFold(event, config.AmiListenerEventPeerStatus):
+		peer := e.Field(config.AmiFieldPeer)
+		if IsStringEmpty(peer) {
+			return nil
+		}
+		s.SetEndpoint(peer).
+			SetTechnology(e.Field(config.AmiFieldChannelType)).
+			SetStatus(e.Field(config.AmiFieldPeerStatus)).
+			SetAddress(e.Field(config.AmiFieldAddress)).
+			SetCause(e.Field(config.AmiFieldCause))
+		if ms, err := strconv.ParseFloat(e.Field(config.AmiFieldTime), 64); err == nil {
+			s.SetRoundTripMs(ms)
+		}
+	case strings.EqualFold(event, config.AmiListenerEventContactStatus):
+		name := e.FieldOrRefer(config.AmiFieldEndpointName, config.AmiFieldAOR)
+		if IsStringEmpty(name) {
+			return nil
+		}
+		s.SetEndpoint(fmt.Sprintf("%s/%s", config.AmiPJSIPChannelProtocol, name)).
+			SetTechnology(config.AmiPJSIPChannelProtocol).
+			SetStatus(e.Field(config.AmiFieldContactStatus)).
+			SetAddress(e.Field(config.AmiFieldUri)).
+			SetUserAgent(e.Field(config.AmiFieldUserAgent))
+		if usec, err := strconv.ParseFloat(e.Field(config.AmiFieldRoundtripUsec), 64); err == nil {
+			s.SetRoundTripMs(usec / 1000)
+		}
+	case strings.EqualFold(event, config.AmiListenerEventRegistry):
+		domain := e.Field(config.AmiFieldDomain)
+		if IsStringEmpty(domain) {
+			return nil
+		}
+		s.SetTechnology(e.Field(config.AmiFieldChannelType))
+		target := domain
+		if username := e.Field(config.AmiFieldUsername); !IsStringEmpty(username) {
+			target = fmt.Sprintf("%s@%s", username, domain)
+		}
+		s.SetEndpoint(fmt.Sprintf("%s/%s", s.Technology, target)).
+			SetStatus(e.Field(config.AmiFieldStatus)).
+			SetAddress(domain).
+			SetCause(e.Field(config.AmiFieldCause))
+	default:
+		return nil
+	}
+	if IsStringEmpty(s.Technology) {
+		if idx := strings.Index(s.Endpoint, "/"); idx > 0 {
+			s.SetTechnology(s.Endpoint[:idx])
+		}
+	}
+	return s
+}
+
+func (r *AMIEndpointRegistration) Json() string {
+	return JsonString(r)
+}
+
+// clone returns a copy of the registration state that is safe to hand over to callers.
+func (r *AMIEndpointRegistration) clone() AMIEndpointRegistration {
+	c := *r
+	c.History = append([]AMIRegistrationSample(nil), r.History...)
+	c.Transitions = append([]AMIRegistrationSample(nil), r.Transitions...)
+	c.flips = nil
+	return c
+}
+
+func (a *AMIRegistrationAlert) Json() string {
+	return JsonString(a)
+}
+
+func NewAMIRegistrationTracker() *AMIRegistrationTracker {
+	t := &AMIRegistrationTracker{}
+	t.endpoints = make(map[string]*AMIEndpointRegistration)
+	t.SetHistorySize(50)
+	t.SetFlapThreshold(4)
+	t.SetFlapWindow(10 * time.Minute)
+	t.SetUnreachableAfter(5 * time.Minute)
+	t.SetLatencyFactor(2)
+	t.SetLatencyMinMs(50)
+	t.SetLatencyWarmup(5)
+	t.SetInspectInterval(30 * time.Second)
+	return t
+}
+
+// SetHistorySize
+// SetHistorySize sets the number of samples and transitions kept per endpoint.
+func (t *AMIRegistrationTracker) SetHistorySize(value int) *AMIRegistrationTracker {
+	t.HistorySize = value
+	return t
+}
+
+// SetFlapThreshold
+// SetFlapThreshold sets the number of reachability flips within the flap window that marks an endpoint as flapping.
+// A value less than or equal to zero disables the flap detection.
+func (t *AMIRegistrationTracker) SetFlapThreshold(value int) *AMIRegistrationTracker {
+	t.FlapThreshold = value
+	return t
+}
+
+func (t *AMIRegistrationTracker) SetFlapWindow(value time.Duration) *AMIRegistrationTracker {
+	t.FlapWindow = value
+	return t
+}
+
+// SetUnreachableAfter
+// SetUnreachableAfter sets how long an endpoint may stay unreachable before an alert is raised.
+// A value less than or equal to zero disables the alert.
+func (t *AMIRegistrationTracker) SetUnreachableAfter(value time.Duration) *AMIRegistrationTracker {
+	t.UnreachableAfter = value
+	return t
+}
+
+// SetLatencyFactor
+// SetLatencyFactor sets the ratio between a qualify round-trip time and the baseline above which
+// the latency is considered regressed. A value less than or equal to zero disables the check.
+func (t *AMIRegistrationTracker) SetLatencyFactor(value float64) *AMIRegistrationTracker {
+	t.LatencyFactor = value
+	return t
+}
+
+// SetLatencyMinMs
+// SetLatencyMinMs sets the minimum increase (in milliseconds) over the baseline for a regression,
+// so that endpoints with a tiny baseline (1ms on LAN) do not alert on every jitter.
+func (t *AMIRegistrationTracker) SetLatencyMinMs(value float64) *AMIRegistrationTracker {
+	t.LatencyMinMs = value
+	return t
+}
+
+// SetLatencyWarmup
+// SetLatencyWarmup sets the number of qualify samples required before the baseline is trusted.
+func (t *AMIRegistrationTracker) SetLatencyWarmup(value int) *AMIRegistrationTracker {
+	t.LatencyWarmup = value
+	return t
+}
+
+// SetInspectInterval
+// SetInspectInterval sets how often Open checks endpoints which stayed unreachable too long.
+func (t *AMIRegistrationTracker) SetInspectInterval(value time.Duration) *AMIRegistrationTracker {
+	t.InspectInterval = value
+	return t
+}
+
+// OnAlert
+// OnAlert registers a callback which is invoked for every flapping, unreachable or latency alert.
+func (t *AMIRegistrationTracker) OnAlert(callback func(AMIRegistrationAlert)) *AMIRegistrationTracker {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	t.onAlert = append(t.onAlert, callback)
+	return t
+}
+
+// OnTransition
+// OnTransition registers a callback which is invoked every time the status of an endpoint changes.
+func (t *AMIRegistrationTracker) OnTransition(callback func(AMIRegistrationSample)) *AMIRegistrationTracker {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	t.onTransition = append(t.onTransition, callback)
+	return t
+}
+
+func (t *AMIRegistrationTracker) Json() string {
+	return JsonString(t.Snapshot())
+}
+
+// Seed
+// Seed initializes the state of endpoints from a one-shot peer listing, i.e: GetSIPPeersStatusMap.
+// Seeding does not invoke callbacks nor record transitions.
+func (t *AMIRegistrationTracker) Seed(peers []AMIPeerStatus) *AMIRegistrationTracker {
+	for _, p := range peers {
+		if IsStringEmpty(p.Peer) {
+			continue
+		}
+		at := p.PublishedAt
+		if at.IsZero() {
+			at = time.Now()
+		}
+		s := NewAMIRegistrationSample().
+			SetEndpoint(p.Peer).
+			SetTechnology(p.ChannelType).
+			SetEvent(p.Event).
+			SetStatus(p.PeerStatus).
+			SetRoundTripMs(float64(p.TimeInMs)).
+			SetAt(at)
+		t.observe(*s, false)
+	}
+	return t
+}
+
+// Apply
+// Apply feeds an AMI event into the tracker, using the current time as the moment of the sample.
+// It returns the sample being recorded, or nil if the event is not registration related.
+func (t *AMIRegistrationTracker) Apply(e *AMIMessage) *AMIRegistrationSample {
+	return t.ApplyAt(e, time.Now())
+}
+
+// ApplyAt
+// ApplyAt feeds an AMI event into the tracker as if it was received at the given moment.
+func (t *AMIRegistrationTracker) ApplyAt(e *AMIMessage, at time.Time) *AMIRegistrationSample {
+	s := ParseRegistrationSample(e, at)
+	if s == nil {
+		return nil
+	}
+	return t.Observe(*s)
+}
+
+// Observe
+// Observe records a registration sample, which might come from another source than AMI events.
+func (t *AMIRegistrationTracker) Observe(s AMIRegistrationSample) *AMIRegistrationSample {
+	if IsStringEmpty(s.Endpoint) {
+		return nil
+	}
+	if s.At.IsZero() {
+		s.At = time.Now()
+	}
+	return t.observe(s, true)
+}
+
+// Inspect
+// Inspect checks every endpoint for the unreachable-too-long and flapping conditions at the given moment,
+// invokes the alert callbacks and returns the raised alerts.
+func (t *AMIRegistrationTracker) Inspect(at time.Time) []AMIRegistrationAlert {
+	var alerts []AMIRegistrationAlert
+	t.mutex.Lock()
+	for _, r := range t.endpoints {
+		if a := t.inspectFlapping(r, at); a != nil {
+			alerts = append(alerts, *a)
+		}
+		if a := t.inspectUnreachable(r, at); a != nil {
+			alerts = append(alerts, *a)
+		}
+	}
+	callbacks := t.onAlert
+	t.mutex.Unlock()
+	for _, a := range alerts {
+		for _, fn := range callbacks {
+			fn(a)
+		}
+	}
+	return alerts
+}
+
+// State
+// State returns the current registration state of the endpoint, i.e: SIP/1000, PJSIP/1000
+func (t *AMIRegistrationTracker) State(endpoint string) (AMIEndpointRegistration, bool) {
+	t.mutex.RLock()
+	defer t.mutex.RUnlock()
+	r, ok := t.endpoints[endpoint]
+	if !ok {
+		return AMIEndpointRegistration{}, false
+	}
+	return r.clone(), true
+}
+
+// Snapshot
+// Snapshot returns the current registration state of all endpoints, ordered by endpoint.
+func (t *AMIRegistrationTracker) Snapshot() []AMIEndpointRegistration {
+	t.mutex.RLock()
+	defer t.mutex.RUnlock()
+	response := make([]AMIEndpointRegistration, 0, len(t.endpoints))
+	for _, r := range t.endpoints {
+		response = append(response, r.clone())
+	}
+	sort.Slice(response, func(i, j int) bool {
+		return response[i].Endpoint < response[j].Endpoint
+	})
+	return response
+}
+
+// Transitions
+// Transitions returns the recent transitions of the endpoint, oldest first.
+func (t *AMIRegistrationTracker) Transitions(endpoint string) []AMIRegistrationSample {
+	t.mutex.RLock()
+	defer t.mutex.RUnlock()
+	r, ok := t.endpoints[endpoint]
+	if !ok {
+		return nil
+	}
+	return append([]AMIRegistrationSample(nil), r.Transitions...)
+}
+
+// RecentTransitions
+// RecentTransitions returns the latest transitions among all endpoints, newest first.
+// A limit less than or equal to zero returns all of them.
+func (t *AMIRegistrationTracker) RecentTransitions(limit int) []AMIRegistrationSample {
+	t.mutex.RLock()
+	var response []AMIRegistrationSample
+	for _, r := range t.endpoints {
+		response = append(response, r.Transitions...)
+	}
+	t.mutex.RUnlock()
+	sort.SliceStable(response, func(i, j int) bool {
+		return response[i].At.After(response[j].At)
+	})
+	if limit > 0 && len(response) > limit {
+		response = response[:limit]
+	}
+	return response
+}
+
+// Open
+// Open consumes PeerStatus, ContactStatus and Registry events until the AMI connection is closed.
+func (t *AMIRegistrationTracker) Open(c *AMI) {
+	events := []string{config.AmiListenerEventPeerStatus, config.AmiListenerEventContactStatus, config.AmiListenerEventRegistry}
+	event := c.OnEventsBuffered(config.AmiPubSubBufferSize, events...)
+	defer c.Unsubscribes(event, events...)
+	ctx := c.Context()
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	interval := t.InspectInterval
+	if interval <= 0 {
+		interval = 30 * time.Second
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case message, ok := <-event:
+			if !ok {
+				return
+			}
+			t.Apply(message)
+		case at := <-ticker.C:
+			t.Inspect(at)
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (t *AMIRegistrationTracker) OpenAsyncFunc(c *AMI) {
+	go func() {
+		t.Open(c)
+	}()
+}
+
+func (t *AMIRegistrationTracker) observe(s AMIRegistrationSample, notify bool) *AMIRegistrationSample {
+	var alerts []AMIRegistrationAlert
+	t.mutex.Lock()
+	r, ok := t.endpoints[s.Endpoint]
+	if !ok {
+		r = &AMIEndpointRegistration{Endpoint: s.Endpoint, Technology: s.Technology}
+		t.endpoints[s.Endpoint] = r
+	}
+	// an updated contact only refreshes its details, the reachability stays as is
+	if ok && strings.EqualFold(s.Status, config.AmiContactStatusUpdated) {
+		s.Status = r.Status
+		s.Reachable = r.Reachable
+	}
+	s.PreviousStatus = r.Status
+	changed := !ok || !strings.EqualFold(r.Status, s.Status)
+	flipped := ok && r.Reachable != s.Reachable
+
+	r.Status = s.Status
+	r.Reachable = s.Reachable
+	r.LastSeenAt = s.At
+	if !IsStringEmpty(s.Technology) {
+		r.Technology = s.Technology
+	}
+	if !IsStringEmpty(s.Address) {
+		r.Address = s.Address
+	}
+	if !IsStringEmpty(s.UserAgent) {
+		r.UserAgent = s.UserAgent
+	}
+	r.History = t.trim(append(r.History, s))
+	if changed {
+		r.LastChangedAt = s.At
+		if notify {
+			r.Transitions = t.trim(append(r.Transitions, s))
+		}
+	}
+	if s.Reachable {
+		r.UnreachableSince = time.Time{}
+		r.unreachableAlert = false
+	} else if r.UnreachableSince.IsZero() {
+		r.UnreachableSince = s.At
+	}
+	if flipped {
+		r.flips = append(r.flips, s.At)
+	}
+	if a := t.inspectFlapping(r, s.At); a != nil {
+		alerts = append(alerts, *a)
+	}
+	if s.RoundTripMs > 0 {
+		r.RoundTripMs = s.RoundTripMs
+		if a := t.inspectLatency(r, s.RoundTripMs, s.At); a != nil {
+			alerts = append(alerts, *a)
+		}
+	}
+	if a := t.inspectUnreachable(r, s.At); a != nil {
+		alerts = append(alerts, *a)
+	}
+	onAlert, onTransition := t.onAlert, t.onTransition
+	t.mutex.Unlock()
+
+	if !notify {
+		return &s
+	}
+	if changed {
+		for _, fn := range onTransition {
+			fn(s)
+		}
+	}
+	for _, a := range alerts {
+		for _, fn := range onAlert {
+			fn(a)
+		}
+	}
+	return &s
+}
+
+func (t *AMIRegistrationTracker) trim(samples []AMIRegistrationSample) []AMIRegistrationSample {
+	if t.HistorySize > 0 && len(samples) > t.HistorySize {
+		return samples[len(samples)-t.HistorySize:]
+	}
+	return samples
+}
+
+func (t *AMIRegistrationTracker) inspectFlapping(r *AMIEndpointRegistration, at time.Time) *AMIRegistrationAlert {
+	if t.FlapThreshold <= 0 {
+		return nil
+	}
+	since := at.Add(-t.FlapWindow)
+	idx := 0
+	for idx < len(r.flips) && r.flips[idx].Before(since) {
+		idx++
+	}
+	r.flips = r.flips[idx:]
+	flapping := len(r.flips) >= t.FlapThreshold
+	if !flapping || r.Flapping {
+		r.Flapping = flapping
+		return nil
+	}
+	r.Flapping = true
+	return t.alert(r, config.AmiRegistrationAlertFlapping, at,
+		fmt.Sprintf("endpoint %s changed reachability %d times within %v", r.Endpoint, len(r.flips), t.FlapWindow))
+}
+
+// inspectLatency compares the round-trip time against the baseline of the endpoint.
+// The baseline is only learned from healthy samples, so a regression does not hide itself.
+func (t *AMIRegistrationTracker) inspectLatency(r *AMIEndpointRegistration, rtt float64, at time.Time) *AMIRegistrationAlert {
+	if t.LatencyFactor > 0 && r.rttSamples >= t.LatencyWarmup && r.BaselineRttMs > 0 {
+		regressed := rtt >= r.BaselineRttMs*t.LatencyFactor && rtt-r.BaselineRttMs >= t.LatencyMinMs
+		if regressed {
+			if r.LatencyRegressed {
+				return nil
+			}
+			r.LatencyRegressed = true
+			return t.alert(r, config.AmiRegistrationAlertLatency, at,
+				fmt.Sprintf("endpoint %s qualify took %.1fms, baseline is %.1fms", r.Endpoint, rtt, r.BaselineRttMs))
+		}
+		r.LatencyRegressed = false
+	}
+	if r.rttSamples == 0 {
+		r.BaselineRttMs = rtt
+	} else {
+		r.BaselineRttMs += registrationRttSmoothing * (rtt - r.BaselineRttMs)
+	}
+	r.rttSamples++
+	return nil
+}
+
+func (t *AMIRegistrationTracker) inspectUnreachable(r *AMIEndpointRegistration, at time.Time) *AMIRegistrationAlert {
+	if t.UnreachableAfter <= 0 || r.Reachable || r.UnreachableSince.IsZero() || r.unreachableAlert {
+		return nil
+	}
+	if at.Sub(r.UnreachableSince) < t.UnreachableAfter {
+		return nil
+	}
+	r.unreachableAlert = true
+	return t.alert(r, config.AmiRegistrationAlertUnreachable, at,
+		fmt.Sprintf("endpoint %s has been unreachable since %s (%s)", r.Endpoint,
+			r.UnreachableSince.Format(config.DateTimeFormat20060102150405), r.Status))
+}
+
+func (t *AMIRegistrationTracker) alert(r *AMIEndpointRegistration, kind string, at time.Time, message string) *AMIRegistrationAlert {
+	a := &AMIRegistrationAlert{
+		Kind:     kind,
+		Endpoint: r.Endpoint,
+		Message:  message,
+		State:    r.clone(),
+		At:       at,
+	}
+	return a
+}
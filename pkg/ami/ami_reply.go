@@ -1,6 +1,10 @@
 package ami
 
-import "github.com/pnguyen215/voipkit/pkg/ami/config"
+import (
+	"strings"
+
+	"github.com/pnguyen215/voipkit/pkg/ami/config"
+)
 
 func NewAmiReply() *AmiReply {
 	s := &AmiReply{}
@@ -54,6 +58,22 @@ func (s AmiReply) GetOrFallbacks(key string, fallback_keys ...string) string {
 	return _v
 }
 
+// GetFold retrieves the value associated with the specified key in the AmiReply map,
+// matching keys regardless of case and underscores, so that both raw (MarkedUser) and
+// translated (marked_user) keys are found.
+func (s AmiReply) GetFold(key string) string {
+	if v := s.Get(key); len(v) > 0 {
+		return v
+	}
+	target := strings.ReplaceAll(strings.ToLower(key), "_", "")
+	for k, v := range s {
+		if strings.ReplaceAll(strings.ToLower(k), "_", "") == target {
+			return v
+		}
+	}
+	return ""
+}
+
 // Values returns a slice containing unique values from the AmiReply map.
 // Values are filtered based on the fields specified in config.AmiJsonIgnoringFieldType.
 func (s AmiReply) Values() []string {
@@ -2,12 +2,15 @@ package ami
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
 	"log"
 	"net/http"
 	"net/url"
+
+	"github.com/pnguyen215/voipkit/pkg/ami/config"
 )
 
 type AmiRestify struct {
@@ -21,6 +24,19 @@ type AmiRestify struct {
 
 type AmiRestifyOption func(*AmiRestify)
 
+// AmiRestifyError
+// AmiRestifyError is the error of a request answered with a status code out of 2xx.
+type AmiRestifyError struct {
+	Method     string `json:"method"`
+	URL        string `json:"url"`
+	StatusCode int    `json:"status_code"`
+	Body       string `json:"body,omitempty"`
+}
+
+func (e *AmiRestifyError) Error() string {
+	return fmt.Sprintf("%s ::: %s request failed with status code %d", e.URL, e.Method, e.StatusCode)
+}
+
 func NewRestify(baseURL string, options ...AmiRestifyOption) *AmiRestify {
 	c := &AmiRestify{}
 	c.
@@ -55,6 +71,14 @@ func (c *AmiRestify) AddHeader(key, value string) *AmiRestify {
 	return c
 }
 
+// SetBasicAuth
+// SetBasicAuth sets the basic authorization header of the requests.
+func (c *AmiRestify) SetBasicAuth(username, password string) *AmiRestify {
+	request := &http.Request{Header: make(http.Header)}
+	request.SetBasicAuth(username, password)
+	return c.SetHeaderWith("Authorization", request.Header.Get("Authorization"))
+}
+
 func (c *AmiRestify) SetBaseUrl(value string) *AmiRestify {
 	if IsStringEmpty(value) {
 		log.Panicf("BaseURL is required")
@@ -81,7 +105,7 @@ func (c *AmiRestify) SetRetryCondition(condition func(*http.Response, error) boo
 	return c
 }
 
-func (c *AmiRestify) request(method, path string, queryParams map[string]string, body interface{}) (*http.Response, error) {
+func (c *AmiRestify) request(ctx context.Context, method, path string, queryParams map[string]string, body interface{}) (*http.Response, error) {
 	u, err := url.Parse(fmt.Sprintf("%s%s", c.baseURL, path))
 	if err != nil {
 		return nil, err
@@ -95,13 +119,16 @@ func (c *AmiRestify) request(method, path string, queryParams map[string]string,
 	if body != nil {
 		reqBody, _ = json.Marshal(body)
 	}
-	request, err := http.NewRequest(method, u.String(), bytes.NewBuffer(reqBody))
+	request, err := http.NewRequestWithContext(ctx, method, u.String(), bytes.NewBuffer(reqBody))
 	if err != nil {
 		return nil, err
 	}
-	request.Header = c.h
+	request.Header = c.h.Clone()
+	if body != nil {
+		request.Header.Set("Content-Type", "application/json")
+	}
 	if c.debug {
-		log.Printf("Restify sending %s request to %s", method, u.String())
+		componentLog(config.AmiLogComponentRestify).Debug("sending request", "method", method, "url", u.String())
 	}
 	response, err := c.c.Do(request)
 	if err != nil {
@@ -111,25 +138,35 @@ func (c *AmiRestify) request(method, path string, queryParams map[string]string,
 }
 
 func (c *AmiRestify) do(method, path string, queryParams map[string]string, requestBody interface{}, result interface{}) error {
+	return c.Do(context.Background(), method, path, queryParams, requestBody, result)
+}
+
+// Do
+// Do sends the request, the JSON response of any 2xx status is decoded into the result (if not nil and not empty).
+// The other status codes are returned as *AmiRestifyError, after the retries allowed by the retry condition.
+func (c *AmiRestify) Do(ctx context.Context, method, path string, queryParams map[string]string, requestBody interface{}, result interface{}) error {
 	retries := 0
 	u, _ := url.Parse(fmt.Sprintf("%s%s", c.baseURL, path))
 	for {
-		response, err := c.request(method, path, queryParams, requestBody)
+		response, err := c.request(ctx, method, path, queryParams, requestBody)
 		if err != nil {
 			return err
 		}
-		defer response.Body.Close()
-		if response.StatusCode != http.StatusOK {
+		body, err := ioutil.ReadAll(response.Body)
+		response.Body.Close()
+		if response.StatusCode < http.StatusOK || response.StatusCode >= http.StatusMultipleChoices {
 			if c.retry != nil && c.retry(response, err) && retries < c.maxRetries {
 				retries++
 				continue
 			}
-			return fmt.Errorf("%s ::: %s request failed with status code %d", u.String(), method, response.StatusCode)
+			return &AmiRestifyError{Method: method, URL: u.String(), StatusCode: response.StatusCode, Body: string(body)}
 		}
-		body, err := ioutil.ReadAll(response.Body)
 		if err != nil {
 			return err
 		}
+		if result == nil || len(bytes.TrimSpace(body)) == 0 {
+			return nil
+		}
 		return json.Unmarshal(body, result)
 	}
 }
@@ -15,6 +15,7 @@ import (
 func NewAmiSocket() *AMISocket {
 	s := &AMISocket{
 		incoming:  make(chan string, 32),
+		actions:   make(chan struct{}, 1),
 		shutdown:  make(chan struct{}),
 		errors:    make(chan error),
 		DebugMode: false,
@@ -45,18 +46,64 @@ func (s *AMISocket) SetMaxConcurrencyMillis(value int64) *AMISocket {
 	return s
 }
 
+// SetLogger
+// SetLogger sets the logger of the socket and the actions sent on it, instead of the logger of the library (see SetLogger).
+func (s *AMISocket) SetLogger(value AmiLogger) *AMISocket {
+	s.logger = value
+	return s
+}
+
+// Logger
+// Logger returns the logger of the component on the socket, i.e: Logger(config.AmiLogComponentCommand).
+func (s *AMISocket) Logger(component string) AmiLogger {
+	l := s.logger
+	if l == nil {
+		l = Log()
+	}
+	return WithLogFields(l, config.AmiLogFieldComponent, component)
+}
+
+// SetTracer
+// SetTracer sets the tracer of the actions sent on the socket, instead of the tracer of the library (see SetTracer).
+func (s *AMISocket) SetTracer(value AmiTracer) *AMISocket {
+	s.tracer = value
+	return s
+}
+
+// Tracer
+// Tracer returns the tracer of the socket, the tracer of the library if not set.
+func (s *AMISocket) Tracer() AmiTracer {
+	if s.tracer == nil {
+		return Tracer()
+	}
+	return s.tracer
+}
+
 func (s *AMISocket) Json() string {
 	return JsonString(s)
 }
 
 // NewSocket provides a new socket client, connecting to a tcp server.
 func WithSocket(ctx context.Context, address string) (*AMISocket, error) {
+	return withSocket(ctx, address, nil)
+}
+
+// withSocket connects the socket to the tcp server, its frames are tapped if the tap is set
+func withSocket(ctx context.Context, address string, tap *AMITap) (*AMISocket, error) {
 	var dialer net.Dialer
 	conn, err := dialer.DialContext(ctx, config.AmiNetworkTcpKey, address)
 	if err != nil {
 		return nil, err
 	}
-	return WithAmiSocketOver(ctx, conn, true)
+	if tap != nil {
+		conn = tap.Wrap(conn)
+	}
+	s, err := WithAmiSocketOver(ctx, conn, true)
+	if err != nil {
+		return nil, err
+	}
+	s.tap = tap
+	return s, nil
 }
 
 // WithAmiSocketOver provides a new socket client, connecting to a tcp server.
@@ -137,11 +184,16 @@ func (s *AMISocket) Close(ctx context.Context) error {
 }
 
 // Send
-// Send the message to socket using fprintf format
+// Send the message to socket using fprintf format, in debug mode the message is logged with its secrets redacted
+// (the sensitive headers of the tap if the socket is tapped)
 func (s *AMISocket) Send(message string) error {
 	v, err := fmt.Fprintf(s.conn, message)
 	if s.DebugMode {
-		D().Info("Ami command, the number of %v byte(s) written and message: %v", v, message)
+		redacted := redactMessage(message)
+		if s.tap != nil {
+			redacted = s.tap.redact(message)
+		}
+		s.Logger(config.AmiLogComponentSocket).Debug("command written", "bytes", v, "message", redacted)
 	}
 	return err
 }
@@ -169,6 +221,20 @@ func (s *AMISocket) Received(ctx context.Context) (string, error) {
 	}
 }
 
+// lock waits for the action in flight on the socket, since the replies of the socket are read in order.
+// The returned func releases the socket for the next action.
+func (s *AMISocket) lock(ctx context.Context) (func(), error) {
+	if s.actions == nil {
+		return func() {}, nil
+	}
+	select {
+	case s.actions <- struct{}{}:
+		return func() { <-s.actions }, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
 func (s *AMISocket) Run(ctx context.Context, conn net.Conn) {
 	reader := bufio.NewReader(conn)
 	for {
@@ -0,0 +1,195 @@
+package ami
+
+import (
+	"context"
+	"strings"
+
+	"github.com/pnguyen215/voipkit/pkg/ami/config"
+)
+
+// AmiStasisHandlerFunc
+// AmiStasisHandlerFunc handles the channel entering the Stasis application, the context is cancelled on StasisEnd.
+type AmiStasisHandlerFunc func(ctx context.Context, c *AMIStasisChannel) error
+
+// NewAMIStasisApp
+// NewAMIStasisApp creates the Stasis application of the name, i.e: the dialplan Stasis(name,args)
+func NewAMIStasisApp(ari *AMIAri, name string) *AMIStasisApp {
+	app := &AMIStasisApp{Name: TrimStringSpaces(name), ari: ari}
+	app.channels = make(map[string]*AMIStasisChannel)
+	app.stream = NewAMIAriEventStream(ari, app.Name).OnEvent(app.Apply)
+	return app
+}
+
+// SetHandler
+// SetHandler sets the handler of the channels entering the application.
+func (app *AMIStasisApp) SetHandler(handler AmiStasisHandlerFunc) *AMIStasisApp {
+	app.mutex.Lock()
+	defer app.mutex.Unlock()
+	app.handler = handler
+	return app
+}
+
+// Stream
+// Stream returns the event stream of the application, i.e: to register more callbacks.
+func (app *AMIStasisApp) Stream() *AMIAriEventStream {
+	return app.stream
+}
+
+// Channel
+// Channel returns the channel of the id in the application.
+func (app *AMIStasisApp) Channel(channelId string) (*AMIStasisChannel, bool) {
+	app.mutex.RLock()
+	defer app.mutex.RUnlock()
+	c, ok := app.channels[channelId]
+	return c, ok
+}
+
+// Len
+// Len returns the number of channels in the application.
+func (app *AMIStasisApp) Len() int {
+	app.mutex.RLock()
+	defer app.mutex.RUnlock()
+	return len(app.channels)
+}
+
+// Apply
+// Apply runs the handler on StasisStart, forwards the events of the channel to it,
+// and cancels its context on StasisEnd or ChannelDestroyed.
+func (app *AMIStasisApp) Apply(e AMIAriEvent) {
+	if !IsStringEmpty(e.Application) && !strings.EqualFold(e.Application, app.Name) {
+		return
+	}
+	id := e.ChannelId()
+	if IsStringEmpty(id) {
+		return
+	}
+	switch e.Type {
+	case config.AmiAriEventStasisStart:
+		app.start(e)
+	case config.AmiAriEventStasisEnd, config.AmiAriEventChannelDestroyed:
+		app.mutex.Lock()
+		c, ok := app.channels[id]
+		delete(app.channels, id)
+		app.mutex.Unlock()
+		if ok {
+			c.forward(e)
+			c.cancel()
+		}
+	default:
+		if c, ok := app.Channel(id); ok {
+			c.forward(e)
+		}
+	}
+}
+
+// Open
+// Open runs the application until the context is done.
+func (app *AMIStasisApp) Open(ctx context.Context) {
+	app.stream.Open(ctx)
+	app.mutex.Lock()
+	for id, c := range app.channels {
+		c.cancel()
+		delete(app.channels, id)
+	}
+	app.mutex.Unlock()
+}
+
+func (app *AMIStasisApp) OpenAsyncFunc(ctx context.Context) {
+	go func() {
+		app.Open(ctx)
+	}()
+}
+
+func (app *AMIStasisApp) start(e AMIAriEvent) {
+	c := &AMIStasisChannel{Channel: *e.Channel, Args: e.Args, ari: app.ari}
+	c.events = make(chan AMIAriEvent, 64)
+	c.ctx, c.cancel = context.WithCancel(context.Background())
+	app.mutex.Lock()
+	previous, ok := app.channels[c.Channel.Id]
+	app.channels[c.Channel.Id] = c
+	handler := app.handler
+	app.mutex.Unlock()
+	if ok {
+		previous.cancel()
+	}
+	if handler == nil {
+		return
+	}
+	go func() {
+		defer func() {
+			if r := recover(); r != nil {
+				componentLog(config.AmiLogComponentAri).Error("handler panicked", "app", app.Name, config.AmiLogFieldChannel, c.Channel.Id, "panic", r)
+			}
+		}()
+		if err := handler(c.ctx, c); err != nil && c.ctx.Err() == nil {
+			componentLog(config.AmiLogComponentAri).Error("handler failed", "app", app.Name, config.AmiLogFieldChannel, c.Channel.Id, config.AmiLogFieldError, err)
+		}
+	}()
+}
+
+// forward delivers the event to the channel, it is dropped if the handler does not read its events
+func (c *AMIStasisChannel) forward(e AMIAriEvent) {
+	select {
+	case c.events <- e:
+	default:
+		componentLog(config.AmiLogComponentAri).Warn("dropping event", config.AmiLogFieldChannel, c.Channel.Id, config.AmiLogFieldEvent, e.Type)
+		Metrics().ObserveDroppedEvent(config.AmiMetricSourceStasis)
+	}
+}
+
+// Id
+// Id returns the id of the channel.
+func (c *AMIStasisChannel) Id() string {
+	return c.Channel.Id
+}
+
+// Context
+// Context returns the context of the channel, it is cancelled on StasisEnd.
+func (c *AMIStasisChannel) Context() context.Context {
+	return c.ctx
+}
+
+// Events
+// Events returns the events of the channel (i.e: ChannelDtmfReceived, PlaybackFinished), StasisEnd is the last one.
+func (c *AMIStasisChannel) Events() <-chan AMIAriEvent {
+	return c.events
+}
+
+// Ari
+// Ari returns the ARI client, i.e: to bridge the channel.
+func (c *AMIStasisChannel) Ari() *AMIAri {
+	return c.ari
+}
+
+func (c *AMIStasisChannel) Answer(ctx context.Context) error {
+	return c.ari.Answer(ctx, c.Channel.Id)
+}
+
+func (c *AMIStasisChannel) Hangup(ctx context.Context) error {
+	return c.ari.Hangup(ctx, c.Channel.Id, "")
+}
+
+func (c *AMIStasisChannel) Play(ctx context.Context, playbackId string, media ...string) (*AMIAriPlayback, error) {
+	return c.ari.Play(ctx, c.Channel.Id, playbackId, media...)
+}
+
+// Continue
+// Continue returns the channel to the dialplan, at the current location if empty.
+func (c *AMIStasisChannel) Continue(ctx context.Context, context, extension string, priority int) error {
+	return c.ari.Continue(ctx, c.Channel.Id, context, extension, priority)
+}
+
+// WaitDigit
+// WaitDigit waits for the next DTMF digit of the channel, the other events are skipped.
+func (c *AMIStasisChannel) WaitDigit(ctx context.Context) (string, error) {
+	for {
+		select {
+		case e := <-c.events:
+			if e.Type == config.AmiAriEventChannelDtmfReceived {
+				return e.Digit, nil
+			}
+		case <-ctx.Done():
+			return "", ctx.Err()
+		}
+	}
+}
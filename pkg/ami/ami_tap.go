@@ -0,0 +1,292 @@
+package ami
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/pnguyen215/voipkit/pkg/ami/config"
+)
+
+// amiTapConn is the connection whose reads and writes are cut into frames for the tap
+type amiTapConn struct {
+	net.Conn
+	tap *AMITap
+	in  amiTapBuffer
+	out amiTapBuffer
+}
+
+// amiTapBuffer is the frame being read or written on a direction of the connection
+type amiTapBuffer struct {
+	frame []byte
+	size  int
+	lines int
+	blank bool // the current line has no character but '\r'
+	skip  bool // the tap is or was disabled during the frame, it is not written
+}
+
+// NewAMITap
+// NewAMITap creates the tap writing the frames to w (stderr if nil) in the text format, enabled.
+// The headers of config.AmiTapSensitiveHeaders are redacted, frames are not truncated and events are not sampled.
+// Set it on the client (see AmiClient.SetTap) or wrap a connection by Wrap, i.e:
+//
+//	tap := ami.NewAMITap(os.Stderr).SetFormat(config.AmiTapFormatJson).SetMaxFrameBytes(4096)
+//	c, err := ami.NewClient(ami.NewTcp(), *ami.GetAmiClientSample().SetTap(tap))
+func NewAMITap(w io.Writer) *AMITap {
+	if w == nil {
+		w = os.Stderr
+	}
+	t := &AMITap{w: w, Format: config.AmiTapFormatText, enabled: true}
+	t.sensitive = make(map[string]bool)
+	t.SetSensitiveHeaders(config.AmiTapSensitiveHeaders...)
+	return t
+}
+
+// SetFormat
+// SetFormat sets the format of the frames, config.AmiTapFormatText or config.AmiTapFormatJson (one JSON frame per line).
+func (t *AMITap) SetFormat(value string) *AMITap {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	if value == config.AmiTapFormatText || value == config.AmiTapFormatJson {
+		t.Format = value
+	}
+	return t
+}
+
+// SetMaxFrameBytes
+// SetMaxFrameBytes sets the size the frames are truncated to, 0 for no limit.
+func (t *AMITap) SetMaxFrameBytes(value int) *AMITap {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	if value >= 0 {
+		t.MaxFrameBytes = value
+	}
+	return t
+}
+
+// SetSampling
+// SetSampling writes one event of every n received, 0 or 1 for all of them.
+// The actions and their responses are always written.
+func (t *AMITap) SetSampling(n int) *AMITap {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	if n >= 0 {
+		t.Sampling = n
+	}
+	return t
+}
+
+// SetSensitiveHeaders
+// SetSensitiveHeaders adds the headers whose values are redacted, regardless of case, i.e: "Variable".
+func (t *AMITap) SetSensitiveHeaders(headers ...string) *AMITap {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	for _, h := range headers {
+		if !IsStringEmpty(h) {
+			t.sensitive[strings.ToLower(strings.TrimSpace(h))] = true
+		}
+	}
+	return t
+}
+
+// SetEnabled
+// SetEnabled switches the tap at runtime. The frames are still cut while disabled,
+// so that the frame being transferred when enabled again is skipped and the next ones are written whole.
+func (t *AMITap) SetEnabled(value bool) *AMITap {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	t.enabled = value
+	return t
+}
+
+func (t *AMITap) IsEnabled() bool {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	return t.enabled
+}
+
+// Wrap
+// Wrap returns the connection tapping the frames read and written on conn.
+func (t *AMITap) Wrap(conn net.Conn) net.Conn {
+	if conn == nil {
+		return conn
+	}
+	if c, ok := conn.(*amiTapConn); ok && c.tap == t {
+		return c
+	}
+	return &amiTapConn{Conn: conn, tap: t, in: amiTapBuffer{blank: true}, out: amiTapBuffer{blank: true}}
+}
+
+func (c *amiTapConn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	if n > 0 {
+		c.tap.feed(&c.in, config.AmiTapDirectionIn, c.remote(), p[:n])
+	}
+	return n, err
+}
+
+func (c *amiTapConn) Write(p []byte) (int, error) {
+	n, err := c.Conn.Write(p)
+	if n > 0 {
+		c.tap.feed(&c.out, config.AmiTapDirectionOut, c.remote(), p[:n])
+	}
+	return n, err
+}
+
+func (c *amiTapConn) remote() string {
+	if addr := c.Conn.RemoteAddr(); addr != nil {
+		return addr.String()
+	}
+	return ""
+}
+
+// feed cuts the bytes into frames, ended by a blank line, and writes them.
+// The greeting line of Asterisk (Asterisk Call Manager/x.y.z) is a frame by itself.
+func (t *AMITap) feed(b *amiTapBuffer, direction, remote string, p []byte) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	if !t.enabled {
+		b.frame, b.skip = nil, true
+	}
+	for _, c := range p {
+		if !b.skip {
+			if t.MaxFrameBytes <= 0 || len(b.frame) < t.MaxFrameBytes {
+				b.frame = append(b.frame, c)
+			}
+			b.size++
+		}
+		switch c {
+		case '\n':
+			greeting := b.lines == 0 && strings.HasPrefix(string(b.frame), config.AmiCallManagerKey)
+			if b.blank || greeting {
+				if !b.skip {
+					t.write(b, direction, remote)
+				}
+				*b = amiTapBuffer{blank: true, skip: !t.enabled}
+				continue
+			}
+			b.lines++
+			b.blank = true
+		case '\r':
+		default:
+			b.blank = false
+		}
+	}
+}
+
+// write writes the frame of the buffer, the lock must be held
+func (t *AMITap) write(b *amiTapBuffer, direction, remote string) {
+	message := strings.TrimLeft(string(b.frame), "\r\n")
+	if IsStringEmpty(message) {
+		return
+	}
+	frame := AmiTapFrame{
+		Timestamp: time.Now(),
+		Direction: direction,
+		Remote:    remote,
+		Bytes:     b.size,
+		Truncated: len(b.frame) < b.size,
+	}
+	lines := strings.SplitAfter(message, "\n")
+	for i, line := range lines {
+		name, value, ok := strings.Cut(line, ":")
+		if !ok {
+			continue
+		}
+		value = strings.TrimSpace(value)
+		switch strings.ToLower(name) {
+		case "action":
+			frame.Action = value
+		case "actionid":
+			frame.ActionId = value
+		case "response":
+			frame.Response = value
+		case "event":
+			frame.Event = value
+		}
+		if t.sensitive[strings.ToLower(strings.TrimSpace(name))] {
+			lines[i] = redactLine(name, line)
+		}
+	}
+	frame.Message = strings.Join(lines, "")
+	if direction == config.AmiTapDirectionIn && !IsStringEmpty(frame.Event) && t.Sampling > 1 {
+		t.events++
+		if (t.events-1)%uint64(t.Sampling) != 0 {
+			return
+		}
+	}
+	var err error
+	switch t.Format {
+	case config.AmiTapFormatJson:
+		var v []byte
+		v, err = json.Marshal(frame)
+		if err == nil {
+			_, err = t.w.Write(append(v, '\n'))
+		}
+	default:
+		_, err = io.WriteString(t.w, frame.text())
+	}
+	if err != nil {
+		componentLog(config.AmiLogComponentSocket).Warn("writing tap frame failed", config.AmiLogFieldError, err)
+	}
+}
+
+// redactLine returns the line of the header with its value redacted, keeping the line ending
+func redactLine(name, line string) string {
+	return name + ": " + config.AmiTapRedacted + line[len(strings.TrimRight(line, "\r\n")):]
+}
+
+// redactMessage returns the message with the values of config.AmiTapSensitiveHeaders redacted, i.e: for the logs
+func redactMessage(message string) string {
+	return redactHeaders(message, func(name string) bool {
+		for _, h := range config.AmiTapSensitiveHeaders {
+			if strings.EqualFold(name, h) {
+				return true
+			}
+		}
+		return false
+	})
+}
+
+// redact returns the message with the values of the sensitive headers of the tap redacted, i.e: for the logs
+func (t *AMITap) redact(message string) string {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	return redactHeaders(message, func(name string) bool {
+		return t.sensitive[strings.ToLower(name)]
+	})
+}
+
+// redactHeaders returns the message with the values of the sensitive headers redacted
+func redactHeaders(message string, sensitive func(name string) bool) string {
+	lines := strings.SplitAfter(message, "\n")
+	for i, line := range lines {
+		name, _, ok := strings.Cut(line, ":")
+		if ok && sensitive(strings.TrimSpace(name)) {
+			lines[i] = redactLine(name, line)
+		}
+	}
+	return strings.Join(lines, "")
+}
+
+// text returns the frame in the text format: a header line then the message, i.e:
+//
+//	--- 2006-01-02T15:04:05.000Z07:00 out 127.0.0.1:5038 (52 bytes)
+//	Action: Login
+//	Username: admin
+//	Secret: ********
+func (f AmiTapFrame) text() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "--- %v %v %v (%v bytes)\n", f.Timestamp.Format("2006-01-02T15:04:05.000Z07:00"), f.Direction, f.Remote, f.Bytes)
+	b.WriteString(strings.TrimRight(strings.ReplaceAll(f.Message, "\r\n", "\n"), "\n"))
+	b.WriteString("\n")
+	if f.Truncated {
+		b.WriteString("... (truncated)\n")
+	}
+	b.WriteString("\n")
+	return b.String()
+}
@@ -0,0 +1,251 @@
+package ami
+
+import (
+	"context"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/pnguyen215/voipkit/pkg/ami/config"
+)
+
+// AmiTracer
+// AmiTracer is the instrumentation of the actions and the events. The context of OnActionStart is the one given to
+// the methods of AMICore, so that the spans of the callers (i.e: HTTP handlers) are the parents of the actions.
+// The context it returns is used to read the replies and given to OnActionEnd and to OnEvent for the events of the action.
+type AmiTracer interface {
+	OnActionStart(ctx context.Context, action AmiTraceAction) context.Context
+	OnActionEnd(ctx context.Context, action AmiTraceAction, err error)
+	OnEvent(ctx context.Context, event AmiReply)
+}
+
+// AmiSpan
+// AmiSpan is the span of an action, i.e: an OpenTelemetry span wrapped by the user.
+type AmiSpan interface {
+	SetAttributes(attributes map[string]string)
+	AddEvent(name string, attributes map[string]string)
+	RecordError(err error)
+	End()
+}
+
+// AmiSpanStarter starts the span of the name, child of the span of the context if any, and returns its context.
+type AmiSpanStarter func(ctx context.Context, name string, attributes map[string]string) (context.Context, AmiSpan)
+
+type amiNopTracer struct{}
+
+// amiActionIdKey is the key of the ActionID in the context
+type amiActionIdKey struct{}
+
+// amiSpanKey is the key of the span of the action in the context
+type amiSpanKey struct{}
+
+// amiTrace is the action being traced
+type amiTrace struct {
+	tracer AmiTracer
+	action AmiTraceAction
+}
+
+var tracer AmiTracer = NewAmiNopTracer()
+var tracerMutex sync.RWMutex
+
+// traces are the contexts of the actions in flight by their ActionID, for the events of the actions.
+// The actions of a socket share its UUID as ActionID (see AMICommand.SetId), so that the traced actions
+// are given their own ActionID: the UUID suffixed by traceSeq.
+var traces = make(map[string]context.Context)
+var tracesMutex sync.RWMutex
+var traceSeq uint64
+
+// SetTracer
+// SetTracer sets the tracer of the library, used by the clients and the sockets without their own tracer
+// (see AmiClient.SetTracer and AMISocket.SetTracer). The default tracer does nothing.
+func SetTracer(value AmiTracer) {
+	if value == nil {
+		value = NewAmiNopTracer()
+	}
+	tracerMutex.Lock()
+	defer tracerMutex.Unlock()
+	tracer = value
+}
+
+// Tracer
+// Tracer returns the tracer of the library.
+func Tracer() AmiTracer {
+	tracerMutex.RLock()
+	defer tracerMutex.RUnlock()
+	return tracer
+}
+
+// NewAmiNopTracer
+// NewAmiNopTracer creates the tracer doing nothing.
+func NewAmiNopTracer() AmiTracer {
+	return amiNopTracer{}
+}
+
+func (amiNopTracer) OnActionStart(ctx context.Context, action AmiTraceAction) context.Context {
+	return ctx
+}
+
+func (amiNopTracer) OnActionEnd(ctx context.Context, action AmiTraceAction, err error) {}
+func (amiNopTracer) OnEvent(ctx context.Context, event AmiReply)                       {}
+
+// WithActionId
+// WithActionId returns the context carrying the ActionID, it is used by the actions sent without ActionID.
+// The context given to the tracer carries the ActionID of the action.
+func WithActionId(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, amiActionIdKey{}, id)
+}
+
+// ActionIdFromContext
+// ActionIdFromContext returns the ActionID of the context, empty if none.
+func ActionIdFromContext(ctx context.Context) string {
+	if ctx == nil {
+		return ""
+	}
+	id, _ := ctx.Value(amiActionIdKey{}).(string)
+	return id
+}
+
+// startTrace starts the trace of the command sent on the socket, its ActionID is taken from the context if not set.
+// The command sent with the ActionID of the socket is given its own ActionID, i.e: <UUID>-1, <UUID>-2
+func startTrace(ctx context.Context, s AMISocket, c *AMICommand) (context.Context, *amiTrace) {
+	if IsStringEmpty(c.ID) {
+		c.ID = ActionIdFromContext(ctx)
+	}
+	t := s.Tracer()
+	if _, ok := t.(amiNopTracer); ok {
+		return ctx, nil
+	}
+	if IsStringEmpty(c.ID) || c.ID == s.UUID {
+		if IsStringEmpty(s.UUID) {
+			c.ID, _ = GenUUID()
+		} else {
+			c.ID = s.UUID + "-" + strconv.FormatUint(atomic.AddUint64(&traceSeq, 1), 10)
+		}
+	}
+	trace := &amiTrace{tracer: t, action: AmiTraceAction{Action: c.Action, ActionId: c.ID, Start: time.Now()}}
+	ctx = t.OnActionStart(WithActionId(ctx, c.ID), trace.action)
+	tracesMutex.Lock()
+	traces[c.ID] = ctx
+	tracesMutex.Unlock()
+	return ctx, trace
+}
+
+// end ends the trace of the action by its response, the trace might be nil (i.e: no tracer)
+func (t *amiTrace) end(ctx context.Context, response, uniqueId string, err error) {
+	if t == nil {
+		return
+	}
+	tracesMutex.Lock()
+	delete(traces, t.action.ActionId)
+	tracesMutex.Unlock()
+	t.action.Response = response
+	t.action.UniqueId = uniqueId
+	t.action.Duration = time.Since(t.action.Start)
+	t.tracer.OnActionEnd(ctx, t.action, err)
+}
+
+// event traces the event of the action, the trace might be nil (i.e: no tracer)
+func (t *amiTrace) event(ctx context.Context, event AmiReply) {
+	if t != nil {
+		t.tracer.OnEvent(ctx, event)
+	}
+}
+
+// traceContext returns the context of the action in flight of the ActionID, or ctx if none
+func traceContext(ctx context.Context, actionId string) context.Context {
+	if IsStringEmpty(actionId) {
+		return ctx
+	}
+	tracesMutex.RLock()
+	defer tracesMutex.RUnlock()
+	if v, ok := traces[actionId]; ok {
+		return v
+	}
+	return WithActionId(ctx, actionId)
+}
+
+// NewAMISpanTracer
+// NewAMISpanTracer creates the tracer recording each action as a span named "ami <Action>" with the attributes
+// config.AmiTraceAttribute*, the events of the action are added to its span.
+// It bridges to OpenTelemetry without depending on it, i.e:
+//
+//	otelTracer := otel.Tracer("voipkit")
+//	ami.SetTracer(ami.NewAMISpanTracer(func(ctx context.Context, name string, attributes map[string]string) (context.Context, ami.AmiSpan) {
+//		ctx, span := otelTracer.Start(ctx, name, trace.WithSpanKind(trace.SpanKindClient), trace.WithAttributes(toOtel(attributes)...))
+//		return ctx, otelSpan{span}
+//	}))
+func NewAMISpanTracer(start AmiSpanStarter) *AMISpanTracer {
+	return &AMISpanTracer{start: start}
+}
+
+// SetEventSpans
+// SetEventSpans records the events out of the actions as spans too, i.e: to trace the hangups.
+func (t *AMISpanTracer) SetEventSpans(value bool) *AMISpanTracer {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	t.eventSpans = value
+	return t
+}
+
+func (t *AMISpanTracer) OnActionStart(ctx context.Context, action AmiTraceAction) context.Context {
+	if t.start == nil {
+		return ctx
+	}
+	ctx, span := t.start(ctx, config.AmiTraceSpanPrefix+action.Action, map[string]string{
+		config.AmiTraceAttributeAction:   action.Action,
+		config.AmiTraceAttributeActionId: action.ActionId,
+	})
+	if span == nil {
+		return ctx
+	}
+	return context.WithValue(ctx, amiSpanKey{}, span)
+}
+
+func (t *AMISpanTracer) OnActionEnd(ctx context.Context, action AmiTraceAction, err error) {
+	span, ok := ctx.Value(amiSpanKey{}).(AmiSpan)
+	if !ok {
+		return
+	}
+	attributes := make(map[string]string)
+	if !IsStringEmpty(action.Response) {
+		attributes[config.AmiTraceAttributeResponse] = action.Response
+	}
+	if !IsStringEmpty(action.UniqueId) {
+		attributes[config.AmiTraceAttributeUniqueId] = action.UniqueId
+	}
+	if len(attributes) > 0 {
+		span.SetAttributes(attributes)
+	}
+	if err != nil {
+		span.RecordError(err)
+	}
+	span.End()
+}
+
+func (t *AMISpanTracer) OnEvent(ctx context.Context, event AmiReply) {
+	name := event.GetFold(config.AmiEventKey)
+	attributes := map[string]string{config.AmiTraceAttributeEvent: name}
+	for key, field := range map[string]string{
+		config.AmiTraceAttributeActionId: config.AmiActionIdKey,
+		config.AmiTraceAttributeUniqueId: config.AmiFieldUniqueId,
+		config.AmiTraceAttributeChannel:  config.AmiFieldChannel,
+	} {
+		if v := event.GetFold(field); !IsStringEmpty(v) {
+			attributes[key] = v
+		}
+	}
+	if span, ok := ctx.Value(amiSpanKey{}).(AmiSpan); ok {
+		span.AddEvent(name, attributes)
+		return
+	}
+	t.mutex.RLock()
+	eventSpans := t.eventSpans
+	t.mutex.RUnlock()
+	if !eventSpans || t.start == nil {
+		return
+	}
+	if _, span := t.start(ctx, config.AmiTraceSpanPrefix+name, attributes); span != nil {
+		span.End()
+	}
+}
@@ -0,0 +1,307 @@
+package ami
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/pnguyen215/voipkit/pkg/ami/config"
+)
+
+// DialWebSocket
+// DialWebSocket opens the WebSocket of the URL (ws, wss, http or https) with the extra headers of the handshake.
+func DialWebSocket(ctx context.Context, rawURL string, header http.Header) (*AMIWebSocket, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	secure := false
+	switch strings.ToLower(u.Scheme) {
+	case "ws", "http":
+	case "wss", "https":
+		secure = true
+	default:
+		return nil, fmt.Errorf(config.AmiErrorWebSocketHandshake, fmt.Sprintf("unsupported scheme '%v'", u.Scheme))
+	}
+	host := u.Host
+	if IsStringEmpty(u.Port()) {
+		host = net.JoinHostPort(u.Hostname(), map[bool]string{true: "443", false: "80"}[secure])
+	}
+	dialer := &net.Dialer{}
+	conn, err := dialer.DialContext(ctx, config.AmiNetworkTcpKey, host)
+	if err != nil {
+		return nil, err
+	}
+	if secure {
+		tc := tls.Client(conn, &tls.Config{ServerName: u.Hostname()})
+		if err := tc.HandshakeContext(ctx); err != nil {
+			conn.Close()
+			return nil, err
+		}
+		conn = tc
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+	key := make([]byte, 16)
+	rand.Read(key)
+	challenge := base64.StdEncoding.EncodeToString(key)
+	request := &http.Request{
+		Method:     http.MethodGet,
+		URL:        &url.URL{Path: u.Path, RawQuery: u.RawQuery},
+		Proto:      "HTTP/1.1",
+		ProtoMajor: 1,
+		ProtoMinor: 1,
+		Header:     make(http.Header),
+		Host:       u.Host,
+	}
+	for k, values := range header {
+		request.Header[k] = values
+	}
+	request.Header.Set("Upgrade", "websocket")
+	request.Header.Set("Connection", "Upgrade")
+	request.Header.Set("Sec-WebSocket-Key", challenge)
+	request.Header.Set("Sec-WebSocket-Version", "13")
+	if err := request.Write(conn); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	reader := bufio.NewReader(conn)
+	response, err := http.ReadResponse(reader, request)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	if response.StatusCode != http.StatusSwitchingProtocols {
+		conn.Close()
+		return nil, fmt.Errorf(config.AmiErrorWebSocketHandshake, response.Status)
+	}
+	if response.Header.Get("Sec-WebSocket-Accept") != webSocketAccept(challenge) {
+		conn.Close()
+		return nil, fmt.Errorf(config.AmiErrorWebSocketHandshake, "bad Sec-WebSocket-Accept")
+	}
+	conn.SetDeadline(time.Time{})
+	return &AMIWebSocket{conn: conn, reader: reader, client: true}, nil
+}
+
+// UpgradeWebSocket
+// UpgradeWebSocket upgrades the HTTP request into the WebSocket of the server side.
+func UpgradeWebSocket(w http.ResponseWriter, r *http.Request) (*AMIWebSocket, error) {
+	if !strings.EqualFold(r.Header.Get("Upgrade"), "websocket") || !containsFold(strings.Split(r.Header.Get("Connection"), ","), "upgrade") {
+		http.Error(w, "websocket upgrade required", http.StatusUpgradeRequired)
+		return nil, fmt.Errorf(config.AmiErrorWebSocketHandshake, "not a websocket upgrade")
+	}
+	challenge := r.Header.Get("Sec-WebSocket-Key")
+	if IsStringEmpty(challenge) || r.Header.Get("Sec-WebSocket-Version") != "13" {
+		http.Error(w, "bad websocket handshake", http.StatusBadRequest)
+		return nil, fmt.Errorf(config.AmiErrorWebSocketHandshake, "bad Sec-WebSocket-Key or version")
+	}
+	hijacker, ok := w.(http.Hijacker)
+	if !ok {
+		http.Error(w, "websocket not supported", http.StatusInternalServerError)
+		return nil, fmt.Errorf(config.AmiErrorWebSocketHandshake, "response can not be hijacked")
+	}
+	conn, rw, err := hijacker.Hijack()
+	if err != nil {
+		return nil, err
+	}
+	response := "HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n" +
+		"Sec-WebSocket-Accept: " + webSocketAccept(challenge) + "\r\n\r\n"
+	if _, err := conn.Write([]byte(response)); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return &AMIWebSocket{conn: conn, reader: rw.Reader}, nil
+}
+
+func webSocketAccept(challenge string) string {
+	h := sha1.New()
+	h.Write([]byte(challenge + config.AmiWebSocketGuid))
+	return base64.StdEncoding.EncodeToString(h.Sum(nil))
+}
+
+// ReadMessage
+// ReadMessage reads the next text or binary message, the fragments are joined and the pings are answered.
+// io.EOF is returned once the peer closed the connection.
+func (ws *AMIWebSocket) ReadMessage() (int, []byte, error) {
+	var message []byte
+	opcode := -1
+	for {
+		fin, op, payload, err := ws.readFrame()
+		if err != nil {
+			return 0, nil, err
+		}
+		switch op {
+		case config.AmiWebSocketPing:
+			if err := ws.writeFrame(config.AmiWebSocketPong, payload); err != nil {
+				return 0, nil, err
+			}
+			continue
+		case config.AmiWebSocketPong:
+			continue
+		case config.AmiWebSocketClose:
+			ws.writeFrame(config.AmiWebSocketClose, payload)
+			ws.conn.Close()
+			return 0, nil, io.EOF
+		case config.AmiWebSocketContinuation:
+			if opcode < 0 {
+				return 0, nil, fmt.Errorf(config.AmiErrorWebSocketProtocol, "unexpected continuation frame")
+			}
+		default:
+			if opcode >= 0 {
+				return 0, nil, fmt.Errorf(config.AmiErrorWebSocketProtocol, "unexpected data frame within a fragmented message")
+			}
+			opcode = op
+		}
+		message = append(message, payload...)
+		if len(message) > config.AmiWebSocketMaxPayload {
+			return 0, nil, fmt.Errorf(config.AmiErrorWebSocketProtocol, "message too large")
+		}
+		if fin {
+			return opcode, message, nil
+		}
+	}
+}
+
+func (ws *AMIWebSocket) readFrame() (bool, int, []byte, error) {
+	var header [2]byte
+	if _, err := io.ReadFull(ws.reader, header[:]); err != nil {
+		return false, 0, nil, err
+	}
+	fin := header[0]&0x80 != 0
+	opcode := int(header[0] & 0x0F)
+	masked := header[1]&0x80 != 0
+	length := uint64(header[1] & 0x7F)
+	switch length {
+	case 126:
+		var ext [2]byte
+		if _, err := io.ReadFull(ws.reader, ext[:]); err != nil {
+			return false, 0, nil, err
+		}
+		length = uint64(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		if _, err := io.ReadFull(ws.reader, ext[:]); err != nil {
+			return false, 0, nil, err
+		}
+		length = binary.BigEndian.Uint64(ext[:])
+	}
+	if length > config.AmiWebSocketMaxPayload {
+		return false, 0, nil, fmt.Errorf(config.AmiErrorWebSocketProtocol, "frame too large")
+	}
+	var mask [4]byte
+	if masked {
+		if _, err := io.ReadFull(ws.reader, mask[:]); err != nil {
+			return false, 0, nil, err
+		}
+	}
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(ws.reader, payload); err != nil {
+		return false, 0, nil, err
+	}
+	if masked {
+		for i := range payload {
+			payload[i] ^= mask[i%4]
+		}
+	}
+	return fin, opcode, payload, nil
+}
+
+// WriteMessage
+// WriteMessage writes the message of the opcode in a single frame.
+func (ws *AMIWebSocket) WriteMessage(opcode int, data []byte) error {
+	return ws.writeFrame(opcode, data)
+}
+
+// WriteText
+// WriteText writes the text message.
+func (ws *AMIWebSocket) WriteText(text string) error {
+	return ws.writeFrame(config.AmiWebSocketText, []byte(text))
+}
+
+// WriteJson
+// WriteJson writes the value as JSON text message.
+func (ws *AMIWebSocket) WriteJson(value interface{}) error {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+	return ws.writeFrame(config.AmiWebSocketText, data)
+}
+
+// Ping
+// Ping writes the ping frame, the pong is consumed by ReadMessage.
+func (ws *AMIWebSocket) Ping() error {
+	return ws.writeFrame(config.AmiWebSocketPing, nil)
+}
+
+func (ws *AMIWebSocket) writeFrame(opcode int, payload []byte) error {
+	ws.mutex.Lock()
+	defer ws.mutex.Unlock()
+	if ws.closed {
+		return net.ErrClosed
+	}
+	frame := make([]byte, 0, len(payload)+14)
+	frame = append(frame, 0x80|byte(opcode))
+	maskBit := byte(0)
+	if ws.client {
+		maskBit = 0x80
+	}
+	switch n := len(payload); {
+	case n < 126:
+		frame = append(frame, maskBit|byte(n))
+	case n <= 0xFFFF:
+		frame = append(frame, maskBit|126, byte(n>>8), byte(n))
+	default:
+		frame = append(frame, maskBit|127)
+		frame = binary.BigEndian.AppendUint64(frame, uint64(n))
+	}
+	if ws.client {
+		var mask [4]byte
+		rand.Read(mask[:])
+		frame = append(frame, mask[:]...)
+		start := len(frame)
+		frame = append(frame, payload...)
+		for i := range frame[start:] {
+			frame[start+i] ^= mask[i%4]
+		}
+	} else {
+		frame = append(frame, payload...)
+	}
+	_, err := ws.conn.Write(frame)
+	if opcode == config.AmiWebSocketClose {
+		ws.closed = true
+	}
+	return err
+}
+
+// SetReadDeadline
+// SetReadDeadline sets the deadline of ReadMessage, zero for none.
+func (ws *AMIWebSocket) SetReadDeadline(t time.Time) error {
+	return ws.conn.SetReadDeadline(t)
+}
+
+// RemoteAddr
+// RemoteAddr returns the address of the peer.
+func (ws *AMIWebSocket) RemoteAddr() net.Addr {
+	return ws.conn.RemoteAddr()
+}
+
+// Close
+// Close sends the close frame (normal closure) and closes the connection.
+func (ws *AMIWebSocket) Close() error {
+	ws.writeFrame(config.AmiWebSocketClose, []byte{0x03, 0xE8})
+	return ws.conn.Close()
+}
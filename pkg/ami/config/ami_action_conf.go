@@ -1,3 +1,5 @@
+//go:generate go run gen_action_fields.go
+
 package config
 
 const (
@@ -0,0 +1,166 @@
+// Code generated by gen_action_fields.go; DO NOT EDIT.
+
+package config
+
+// AmiActionFields holds the fields of the actions (without ActionID) by the action name,
+// as documented by the Syntax of the actions in ami_action_conf.go.
+var AmiActionFields = map[string][]string{
+	"AGI":                         {"Channel", "Command", "CommandID"},
+	"AOCMessage":                  {"Channel", "ChannelPrefix", "MsgType", "ChargeType", "UnitAmount(0)", "UnitType(0)", "CurrencyName", "CurrencyAmount", "CurrencyMultiplier", "TotalType", "AOCBillingId", "ChargingAssociationId", "ChargingAssociationNumber", "ChargingAssociationPlan"},
+	"AbsoluteTimeout":             {"Channel", "Timeout"},
+	"AgentLogoff":                 {"Agent", "Soft"},
+	"Agents":                      {},
+	"Atxfer":                      {"Channel", "Exten", "Context"},
+	"BlindTransfer":               {"Channel", "Context", "Exten"},
+	"Bridge":                      {"Channel1", "Channel2", "Tone"},
+	"BridgeDestroy":               {"BridgeUniqueid"},
+	"BridgeInfo":                  {"BridgeUniqueid"},
+	"BridgeKick":                  {"BridgeUniqueid", "Channel"},
+	"BridgeList":                  {"BridgeType"},
+	"BridgeTechnologyList":        {},
+	"BridgeTechnologySuspend":     {"BridgeTechnology"},
+	"BridgeTechnologyUnsuspend":   {"BridgeTechnology"},
+	"CancelAtxfer":                {"Channel"},
+	"Challenge":                   {"AuthType"},
+	"ChangeMonitor":               {"Channel", "File"},
+	"Command":                     {"Command"},
+	"ConfbridgeKick":              {"Conference", "Channel"},
+	"ConfbridgeList":              {"Conference"},
+	"ConfbridgeListRooms":         {},
+	"ConfbridgeLock":              {"Conference"},
+	"ConfbridgeMute":              {"Conference", "Channel"},
+	"ConfbridgeSetSingleVideoSrc": {"Conference", "Channel"},
+	"ConfbridgeStartRecord":       {"Conference", "RecordFile"},
+	"ConfbridgeStopRecord":        {"Conference"},
+	"ConfbridgeUnlock":            {"Conference"},
+	"ConfbridgeUnmute":            {"Conference", "Channel"},
+	"ControlPlayback":             {"Channel", "Control"},
+	"CoreSettings":                {},
+	"CoreShowChannels":            {},
+	"CoreStatus":                  {},
+	"CreateConfig":                {"Filename"},
+	"DAHDIDNDoff":                 {"DAHDIChannel"},
+	"DAHDIDNDon":                  {"DAHDIChannel"},
+	"DAHDIDialOffhook":            {"DAHDIChannel", "Number"},
+	"DAHDIHangup":                 {"DAHDIChannel"},
+	"DAHDIRestart":                {},
+	"DAHDIShowChannels":           {"DAHDIChannel"},
+	"DAHDITransfer":               {"DAHDIChannel"},
+	"DBDel":                       {"Family", "Key"},
+	"DBDelTree":                   {"Family", "Key"},
+	"DBGet":                       {"Family", "Key"},
+	"DBPut":                       {"Family", "Key", "Val"},
+	"DataGet":                     {},
+	"DeviceStateList":             {},
+	"DialplanExtensionAdd":        {"Context", "Extension", "Priority", "Application", "ApplicationData", "Replace"},
+	"DialplanExtensionRemove":     {"Context", "Extension", "Priority"},
+	"Events":                      {"EventMask"},
+	"ExtensionState":              {"Exten", "Context"},
+	"ExtensionStateList":          {},
+	"FAXSession":                  {"SessionNumber"},
+	"FAXSessions":                 {},
+	"FAXStats":                    {},
+	"Filter":                      {"Operation", "Filter"},
+	"GetConfig":                   {"Filename", "Category", "Filter"},
+	"GetConfigJSON":               {"Filename", "Category", "Filter"},
+	"Getvar":                      {"Channel", "Variable"},
+	"Hangup":                      {"Channel", "Cause"},
+	"IAXnetstats":                 {},
+	"IAXpeerlist":                 {},
+	"IAXpeers":                    {},
+	"IAXregistry":                 {},
+	"JabberSend":                  {"Jabber", "Message", "JID"},
+	"KSendSMS":                    {},
+	"ListCategories":              {"Filename"},
+	"ListCommands":                {},
+	"LocalOptimizeAway":           {"Channel"},
+	"LoggerRotate":                {},
+	"Login":                       {"Username", "Secret"},
+	"Logoff":                      {},
+	"MWIDelete":                   {"Mailbox"},
+	"MWIGet":                      {"Mailbox"},
+	"MWIUpdate":                   {"Mailbox", "OldMessages", "NewMessages"},
+	"MailboxCount":                {"Mailbox"},
+	"MailboxStatus":               {"Mailbox"},
+	"MeetmeList":                  {"Conference"},
+	"MeetmeListRooms":             {},
+	"MeetmeMute":                  {"Meetme", "Usernum"},
+	"MeetmeUnmute":                {"Meetme", "Usernum"},
+	"MessageSend":                 {"To", "From", "Body", "Base64Body", "Variable"},
+	"MixMonitor":                  {"Channel", "File", "options", "Command"},
+	"MixMonitorMute":              {"Channel", "Direction", "State"},
+	"ModuleCheck":                 {"Module"},
+	"ModuleLoad":                  {"Module", "LoadType"},
+	"Monitor":                     {"Channel", "File", "Format", "Mix"},
+	"MuteAudio":                   {"Channel", "Direction", "State"},
+	"Originate":                   {"Channel", "Exten", "Context", "Priority", "Application", "Data", "Timeout", "CallerID", "Variable", "Account", "EarlyMedia", "Async", "Codecs", "ChannelId", "OtherChannelId"},
+	"PJSIPNotify":                 {"Endpoint", "URI", "channel", "Variable"},
+	"PJSIPQualify":                {"Endpoint"},
+	"PJSIPRegister":               {"Registration"},
+	"PJSIPShowAors":               {},
+	"PJSIPShowAuths":              {},
+	"PJSIPShowContacts":           {},
+	"PJSIPShowEndpoint":           {"Endpoint"},
+	"PJSIPShowEndpoints":          {},
+	"PJSIPShowRegistrationInboundContactStatuses": {},
+	"PJSIPShowRegistrationsInbound":               {},
+	"PJSIPShowRegistrationsOutbound":              {},
+	"PJSIPShowResourceLists":                      {},
+	"PJSIPShowSubscriptionsInbound":               {},
+	"PJSIPShowSubscriptionsOutbound":              {},
+	"PJSIPUnregister":                             {"Registration"},
+	"PRIDebugFileSet":                             {"File"},
+	"PRIDebugFileUnset":                           {},
+	"PRIDebugSet":                                 {"Span", "Level"},
+	"PRIShowSpans":                                {"Span"},
+	"Park":                                        {"Channel", "TimeoutChannel", "AnnounceChannel", "Timeout", "Parkinglot"},
+	"ParkedCalls":                                 {"ParkingLot"},
+	"Parkinglots":                                 {},
+	"PauseMonitor":                                {"Channel"},
+	"Ping":                                        {},
+	"PlayDTMF":                                    {"Channel", "Digit", "Duration", "Receive"},
+	"PresenceState":                               {"Provider"},
+	"PresenceStateList":                           {},
+	"QueueAdd":                                    {"Queue", "Interface", "Penalty", "Paused", "MemberName", "StateInterface"},
+	"QueueChangePriorityCaller":                   {"Queue", "Caller", "Priority"},
+	"QueueLog":                                    {"Queue", "Event", "Uniqueid", "Interface", "Message"},
+	"QueueMemberRingInUse":                        {"Interface", "RingInUse", "Queue"},
+	"QueuePause":                                  {"Interface", "Paused", "Queue", "Reason"},
+	"QueuePenalty":                                {"Interface", "Penalty", "Queue"},
+	"QueueReload":                                 {"Queue", "Members", "Rules", "Parameters"},
+	"QueueRemove":                                 {"Queue", "Interface"},
+	"QueueReset":                                  {"Queue"},
+	"QueueRule":                                   {"Rule"},
+	"QueueStatus":                                 {"Queue", "Member"},
+	"QueueSummary":                                {"Queue"},
+	"Redirect":                                    {"Channel", "ExtraChannel", "Exten", "ExtraExten", "Context", "ExtraContext", "Priority", "ExtraPriority"},
+	"Reload":                                      {"Module"},
+	"SIPnotify":                                   {"Channel", "Variable", "Call-ID"},
+	"SIPpeers":                                    {},
+	"SIPpeerstatus":                               {"Peer"},
+	"SIPqualifypeer":                              {"Peer"},
+	"SIPshowpeer":                                 {"Peer"},
+	"SIPshowregistry":                             {},
+	"SKINNYdevices":                               {},
+	"SKINNYlines":                                 {},
+	"SKINNYshowdevice":                            {"Device"},
+	"SKINNYshowline":                              {"Line"},
+	"SendText":                                    {"Channel", "Message"},
+	"Setvar":                                      {"Channel", "Variable", "Value"},
+	"ShowDialPlan":                                {"Extension", "Context"},
+	"SorceryMemoryCacheExpire":                    {"Cache"},
+	"SorceryMemoryCacheExpireObject":              {"Cache", "Object"},
+	"SorceryMemoryCachePopulate":                  {"Cache"},
+	"SorceryMemoryCacheStale":                     {"Cache"},
+	"SorceryMemoryCacheStaleObject":               {"Cache", "Object", "Reload"},
+	"Status":                                      {"Channel", "Variables", "AllVariables"},
+	"StopMixMonitor":                              {"Channel", "MixMonitorID"},
+	"StopMonitor":                                 {"Channel"},
+	"UnpauseMonitor":                              {"Channel"},
+	"UpdateConfig":                                {"SrcFilename", "DstFilename", "Reload", "PreserveEffectiveContext", "Action-000000", "Cat-000000", "Var-000000", "Value-000000", "Match-000000", "Line-000000", "Options-000000"},
+	"UserEvent":                                   {"UserEvent", "Header1", "HeaderN"},
+	"VoicemailRefresh":                            {"Context", "Mailbox"},
+	"VoicemailUserStatus":                         {"Context", "Mailbox"},
+	"VoicemailUsersList":                          {},
+	"WaitEvent":                                   {"Timeout"},
+}
@@ -240,3 +240,27 @@ const (
 	// Syntax: WAIT FOR DIGIT TIMEOUT
 	AmiAgiCommandWaitForDigit = "WAIT FOR DIGIT"
 )
+
+// AGI reply codes, the reply line is: 200 result=1 (data) endpos=1234
+const (
+	AmiAgiCodeSuccess      = 200
+	AmiAgiCodeInvalid      = 510 // invalid or unknown command
+	AmiAgiCodeDeadChannel  = 511 // command not permitted on a dead channel
+	AmiAgiCodeUsage        = 520 // invalid command syntax, the usage follows on the 520- lines
+	AmiAgiHangupKey        = "HANGUP"
+	AmiAgiResultKey        = "result"
+	AmiAgiEndPosKey        = "endpos"
+	AmiAgiDefaultFastPort  = 4573
+	AmiAgiNetworkScriptKey = "agi_network_script"
+	AmiAgiRequestKey       = "agi_request"
+	AmiAgiChannelKey       = "agi_channel"
+	AmiAgiUniqueIdKey      = "agi_uniqueid"
+	AmiAgiCallerIdKey      = "agi_callerid"
+	AmiAgiCallerIdNameKey  = "agi_calleridname"
+	AmiAgiContextKey       = "agi_context"
+	AmiAgiExtensionKey     = "agi_extension"
+	AmiAgiPriorityKey      = "agi_priority"
+	AmiAgiDnidKey          = "agi_dnid"
+	AmiAgiLanguageKey      = "agi_language"
+	AmiAgiArgumentPrefix   = "agi_arg_"
+)
@@ -447,6 +447,7 @@ const (
 	AmiDigitExtensionRegexDefault    string = "^SIP/\\d{4}"
 	AmiDigitExtensionRegexWithDigits string = "^SIP/\\d{%v}"
 	AmiPubSubKeyRef                         = "ami-key"
+	AmiPubSubBufferSize                     = 1024 // the events buffered by subscriber, the next ones are dropped
 	AmiOmitemptyKeyRef                      = "omitempty"
 	AmiTagKeyRef                            = "ami"
 )
@@ -468,6 +469,29 @@ const (
 	AmiErrorNoExtensionsConfigured  string = "There's no extensions configured"
 	AmiErrorLoginFailed             string = "(Ami Authentication). login failed"
 	AmiErrorPingFailed              string = "(Ami Authentication). Ping failed for reason: %v"
+	AmiErrorCoreRequired            string = "Ami core is required to perform %v"
+	AmiErrorConferenceNotFound      string = "Conference '%v' not found"
+	AmiErrorActionFailed            string = "Action '%v' failed, response = %v"
+	AmiErrorCdrRuleInvalid          string = "CDR rule '%v' is invalid: %v"
+	AmiErrorCdrColumnUnknown        string = "CDR column '%v' is unknown"
+	AmiErrorCdrSqlIdentifierInvalid string = "CDR sql identifier '%v' is invalid"
+	AmiErrorCdrWriterClosed         string = "CDR writer was closed"
+	AmiErrorRateTableInvalid        string = "Rate table '%v' is invalid at line %v: %v"
+	AmiErrorRateNotFound            string = "No rate found for number '%v' on trunk '%v'"
+	AmiErrorRateDirectionIneligible string = "CDR direction '%v' is not rated"
+	AmiErrorQueueLogInvalid         string = "queue_log line is invalid: %v"
+	AmiErrorAgiReplyInvalid         string = "AGI reply is invalid: %v"
+	AmiErrorAgiCommandFailed        string = "AGI command '%v' failed, reply = %v"
+	AmiErrorAgiSessionClosed        string = "AGI session was closed"
+	AmiErrorIvrFlowInvalid          string = "IVR flow '%v' is invalid: %v"
+	AmiErrorIvrNodeFailed           string = "IVR node '%v' failed: %v"
+	AmiErrorIvrNodeKindUnknown      string = "IVR node kind '%v' is unknown"
+	AmiErrorIvrRestifyRequired      string = "IVR flow '%v' requires the restify client for the HTTP node '%v'"
+	AmiErrorAriRequestFailed        string = "ARI %v %v failed with status %v: %v"
+	AmiErrorWebSocketHandshake      string = "WebSocket handshake failed: %v"
+	AmiErrorWebSocketProtocol       string = "WebSocket protocol error: %v"
+	AmiErrorGatewayBodyInvalid      string = "Request body is invalid: %v"
+	AmiErrorGatewayFieldInvalid     string = "%v is invalid: %v"
 )
 
 // AMI Channel Protocols constants used for indicating the protocol of a channel
@@ -488,6 +512,10 @@ const (
 	// AmiH323ChannelProtocol represents the channel protocol "H323," indicating
 	// that the channel uses the H.323 protocol.
 	AmiH323ChannelProtocol string = "H323"
+
+	// AmiPJSIPChannelProtocol represents the channel protocol "PJSIP," indicating
+	// that the channel uses the SIP protocol through the res_pjsip stack.
+	AmiPJSIPChannelProtocol string = "PJSIP"
 )
 
 var (
@@ -598,6 +626,18 @@ const (
 	// indicating an inbound communication.
 	AmiInboundDirection = "inbound"
 
+	// AmiInternalDirection represents the communication direction "internal,"
+	// indicating a communication between two local extensions.
+	AmiInternalDirection = "internal"
+
+	// AmiTransferDirection represents the communication direction "transfer,"
+	// indicating a communication leg created by a blind or attended transfer.
+	AmiTransferDirection = "transfer"
+
+	// AmiSpyDirection represents the communication direction "spy,"
+	// indicating a supervisor monitoring, whispering or barging into a communication.
+	AmiSpyDirection = "spy"
+
 	// AmiUnknownDirection represents the communication direction "Unknown,"
 	// indicating an unknown or undefined communication direction.
 	AmiUnknownDirection = "Unknown"
@@ -621,12 +661,23 @@ const (
 	// AmiTypeChanSpyDirection represents the direction type "chan_spy,"
 	// indicating a communication direction for ChanSpy functionality.
 	AmiTypeChanSpyDirection = "chan_spy"
+
+	// AmiTypeInternalDialDirection represents the direction type "internal_dial,"
+	// indicating an extension to extension communication.
+	AmiTypeInternalDialDirection = "internal_dial"
+
+	// AmiTypeTransferDirection represents the direction type "transfer,"
+	// indicating a communication leg created by a transfer.
+	AmiTypeTransferDirection = "transfer"
 )
 
 var (
 	AmiCallDirection map[string]bool = map[string]bool{
 		AmiOutboundDirection: true,
 		AmiInboundDirection:  true,
+		AmiInternalDirection: true,
+		AmiTransferDirection: true,
+		AmiSpyDirection:      true,
 	}
 )
 
@@ -831,6 +882,17 @@ var (
 		AmiDeviceStateRingInUse:   AmiDeviceStatesString[AmiDeviceStateRingInUseString],
 		AmiDeviceStateOnHold:      AmiDeviceStatesString[AmiDeviceStateOnHoldString],
 	}
+	AmiDeviceStatesCode map[string]int = map[string]int{
+		AmiDeviceStateUnknownString:     AmiDeviceStateUnknown,
+		AmiDeviceStateNotInUseString:    AmiDeviceStateNotInUse,
+		AmiDeviceStateInUseString:       AmiDeviceStateInUse,
+		AmiDeviceStateBusyString:        AmiDeviceStateBusy,
+		AmiDeviceStateInvalidString:     AmiDeviceStateInvalid,
+		AmiDeviceStateUnavailableString: AmiDeviceStateUnavailable,
+		AmiDeviceStateRingingString:     AmiDeviceStateRinging,
+		AmiDeviceStateRingInUseString:   AmiDeviceStateRingInUse,
+		AmiDeviceStateOnHoldString:      AmiDeviceStateOnHold,
+	}
 	AmiChannelStatesText map[int]string = map[int]string{
 		AmiChannelStateDown:           "down",
 		AmiChannelStateReserved:       "reserved",
@@ -879,6 +941,125 @@ const (
 	// AmiPeerStatusReachable represents the peer status "Reachable," indicating that
 	// the peer is reachable and responsive.
 	AmiPeerStatusReachable = "Reachable"
+
+	// AmiPeerStatusUnreachable represents the peer status "Unreachable," indicating that
+	// the peer did not answer the qualify request in time.
+	AmiPeerStatusUnreachable = "Unreachable"
+)
+
+// AMI Contact Status constants used for indicating the status of a PJSIP contact in
+// ContactStatus events of Asterisk Manager Interface (AMI).
+const (
+	// AmiContactStatusCreated represents a contact that has been added to an AOR.
+	AmiContactStatusCreated = "Created"
+
+	// AmiContactStatusRemoved represents a contact that has been removed from an AOR,
+	// typically when the registration expired or the device unregistered.
+	AmiContactStatusRemoved = "Removed"
+
+	// AmiContactStatusReachable represents a contact that answered the qualify request.
+	AmiContactStatusReachable = "Reachable"
+
+	// AmiContactStatusUnreachable represents a contact that did not answer the qualify request.
+	AmiContactStatusUnreachable = "Unreachable"
+
+	// AmiContactStatusUnknown represents a contact whose status could not be determined.
+	AmiContactStatusUnknown = "Unknown"
+
+	// AmiContactStatusUpdated represents a contact whose details (expiration, user agent, ...)
+	// have been refreshed without any change of reachability.
+	AmiContactStatusUpdated = "Updated"
+
+	// AmiContactStatusNonQualified represents a contact that is not qualified at all.
+	AmiContactStatusNonQualified = "NonQualified"
+)
+
+// AMI Registry Status constants used for indicating the result of an outbound
+// registration in Registry events of Asterisk Manager Interface (AMI).
+const (
+	AmiRegistryStatusRegistered   = "Registered"
+	AmiRegistryStatusUnregistered = "Unregistered"
+	AmiRegistryStatusRejected     = "Rejected"
+	AmiRegistryStatusFailed       = "Failed"
+)
+
+// AMI Registration alert kinds raised by the endpoint registration tracker.
+const (
+	// AmiRegistrationAlertFlapping is raised when an endpoint toggles between reachable
+	// and unreachable too many times within the flap window.
+	AmiRegistrationAlertFlapping = "flapping"
+
+	// AmiRegistrationAlertUnreachable is raised when an endpoint stays unreachable longer
+	// than the configured threshold.
+	AmiRegistrationAlertUnreachable = "unreachable"
+
+	// AmiRegistrationAlertLatency is raised when the qualify round-trip time regresses
+	// noticeably compared to the baseline of the endpoint.
+	AmiRegistrationAlertLatency = "latency"
+)
+
+// AMI Originate reason constants carried by the Reason field of OriginateResponse events,
+// they are the control frames received by the originated channel.
+const (
+	// AmiOriginateReasonFailed represents a call which could not be placed at all.
+	AmiOriginateReasonFailed = 0
+
+	// AmiOriginateReasonHangup represents a call which has been hung up before being answered.
+	AmiOriginateReasonHangup = 1
+
+	// AmiOriginateReasonRinging represents a call which rang until the originate timeout.
+	AmiOriginateReasonRinging = 3
+
+	// AmiOriginateReasonAnswered represents a call which has been answered.
+	AmiOriginateReasonAnswered = 4
+
+	// AmiOriginateReasonBusy represents a call which has been rejected as busy.
+	AmiOriginateReasonBusy = 5
+
+	// AmiOriginateReasonCongestion represents a call which could not be routed (congestion).
+	AmiOriginateReasonCongestion = 8
+)
+
+// AMI Originate outcome constants used for indicating the outcome of an originated call.
+const (
+	AmiOriginateOutcomeAnswered   = "answered"
+	AmiOriginateOutcomeBusy       = "busy"
+	AmiOriginateOutcomeNoAnswer   = "no-answer"
+	AmiOriginateOutcomeCongestion = "congestion"
+	AmiOriginateOutcomeFailed     = "failed"
+)
+
+// AMI Originate response constants used for giving up the calls whose OriginateResponse event never arrives.
+const (
+	// AmiOriginateTimeoutDefault is the originate timeout of Asterisk when not set, in milliseconds.
+	AmiOriginateTimeoutDefault = 30000
+
+	// AmiOriginateResponseGraceDefault is the time waited for the OriginateResponse event past the originate timeout.
+	AmiOriginateResponseGraceDefault = 10 * time.Second
+)
+
+// AMI Presence State constants used for indicating the presence of a presentity in
+// PresenceStateChange events of Asterisk Manager Interface (AMI).
+const (
+	AmiPresenceStateNotSet      = "not_set"
+	AmiPresenceStateUnavailable = "unavailable"
+	AmiPresenceStateAvailable   = "available"
+	AmiPresenceStateAway        = "away"
+	AmiPresenceStateXa          = "xa" // extended away
+	AmiPresenceStateChat        = "chat"
+	AmiPresenceStateDnd         = "dnd" // do not disturb
+)
+
+// AMI BLF status constants used for indicating the effective status of an extension,
+// merged from its device state and presence state, for busy lamp field (BLF) consumers.
+const (
+	AmiBlfStatusIdle        = "idle"
+	AmiBlfStatusRinging     = "ringing"
+	AmiBlfStatusInUse       = "in-use"
+	AmiBlfStatusHold        = "hold"
+	AmiBlfStatusDnd         = "dnd"
+	AmiBlfStatusAway        = "away"
+	AmiBlfStatusUnavailable = "unavailable"
 )
 
 // AMI Call Detail Record (CDR) disposition constants used for indicating the result
@@ -934,6 +1115,173 @@ const (
 	// AmiLastApplicationChanSpy represents the key used for identifying the last executed
 	// application as "ChanSpy" in AMI responses.
 	AmiLastApplicationChanSpy = "ChanSpy"
+
+	// AmiLastApplicationTransfer represents the key used for identifying the last executed
+	// application as "Transfer" in AMI responses.
+	AmiLastApplicationTransfer = "Transfer"
+
+	// AmiLastApplicationBlindTransfer represents the key used for identifying the last executed
+	// application as "BlindTransfer" in AMI responses.
+	AmiLastApplicationBlindTransfer = "BlindTransfer"
+)
+
+// AMI CDR field keys used by the CDR classification rules to pick the value
+// of a CDR field (i.e: the extension, the number or the description of the call).
+const (
+	AmiCdrFieldChannel            = "channel"
+	AmiCdrFieldDestinationChannel = "destination_channel"
+	AmiCdrFieldSource             = "source"
+	AmiCdrFieldDestination        = "destination"
+	AmiCdrFieldDestinationContext = "destination_context"
+	AmiCdrFieldPhone              = "phone" // the destination without the dialing phone prefix
+	AmiCdrFieldLastApplication    = "last_application"
+	AmiCdrFieldLastData           = "last_data"
+	AmiCdrFieldAccountCode        = "account_code"
+	AmiCdrFieldCallerId           = "caller_id"
+	AmiCdrFieldUserField          = "user_field"
+	AmiCdrFieldEvent              = "event"
+	AmiCdrFieldStartTime          = "start_time"
+	AmiCdrFieldAnswerTime         = "answer_time"
+	AmiCdrFieldEndTime            = "end_time"
+	AmiCdrFieldDuration           = "duration"
+	AmiCdrFieldBillableSeconds    = "billable_seconds"
+	AmiCdrFieldDisposition        = "disposition"
+	AmiCdrFieldAmaFlags           = "ama_flags"
+	AmiCdrFieldUniqueId           = "unique_id"
+	AmiCdrFieldLinkedId           = "linked_id"
+	AmiCdrFieldDateReceivedAt     = "date_received_at"
+	AmiCdrFieldPrivilege          = "privilege"
+	AmiCdrFieldDirection          = "direction"
+	AmiCdrFieldDesc               = "desc"
+	AmiCdrFieldType               = "type"
+	AmiCdrFieldExtension          = "extension"
+	AmiCdrFieldNumber             = "number"
+	AmiCdrFieldMediaLink          = "media_link"
+	AmiCdrFieldCost               = "cost"
+	AmiCdrFieldCurrency           = "currency"
+)
+
+// AMI recording status constants used by the recording manager to track the MixMonitor lifecycle.
+const (
+	AmiRecordingStatusStarting  = "starting"  // the MixMonitor action was sent
+	AmiRecordingStatusRecording = "recording" // the MixMonitorStart event was received
+	AmiRecordingStatusPaused    = "paused"    // the recording is muted on both directions, i.e: while collecting card details
+	AmiRecordingStatusStopped   = "stopped"   // the MixMonitorStop event was received
+)
+
+// AMI MixMonitorMute directions
+const (
+	AmiMixMonitorDirectionRead  = "read"
+	AmiMixMonitorDirectionWrite = "write"
+	AmiMixMonitorDirectionBoth  = "both"
+)
+
+// AmiQueueLogVerb represents the event (verb) of a queue_log record
+type AmiQueueLogVerb string
+
+// AMI queue_log verbs written by app_queue into the queue_log file, in the form:
+// time|callid|queue|agent|event|data1|data2|data3|data4|data5
+const (
+	AmiQueueLogAbandon          AmiQueueLogVerb = "ABANDON"          // position|origposition|waittime
+	AmiQueueLogAddMember        AmiQueueLogVerb = "ADDMEMBER"        //
+	AmiQueueLogAgentDump        AmiQueueLogVerb = "AGENTDUMP"        //
+	AmiQueueLogAgentLogin       AmiQueueLogVerb = "AGENTLOGIN"       // channel
+	AmiQueueLogAgentLogoff      AmiQueueLogVerb = "AGENTLOGOFF"      // channel|logintime
+	AmiQueueLogAttendedTransfer AmiQueueLogVerb = "ATTENDEDTRANSFER" // method|data|holdtime|calltime|origposition
+	AmiQueueLogBlindTransfer    AmiQueueLogVerb = "BLINDTRANSFER"    // extension|context|holdtime|calltime|origposition
+	AmiQueueLogCompleteAgent    AmiQueueLogVerb = "COMPLETEAGENT"    // holdtime|calltime|origposition
+	AmiQueueLogCompleteCaller   AmiQueueLogVerb = "COMPLETECALLER"   // holdtime|calltime|origposition
+	AmiQueueLogConfigReload     AmiQueueLogVerb = "CONFIGRELOAD"     //
+	AmiQueueLogConnect          AmiQueueLogVerb = "CONNECT"          // holdtime|bridgedchanneluniqueid|ringtime
+	AmiQueueLogDid              AmiQueueLogVerb = "DID"              // did
+	AmiQueueLogEnterQueue       AmiQueueLogVerb = "ENTERQUEUE"       // url|callerid|position
+	AmiQueueLogExitEmpty        AmiQueueLogVerb = "EXITEMPTY"        // position|origposition|waittime
+	AmiQueueLogExitWithKey      AmiQueueLogVerb = "EXITWITHKEY"      // key|position|origposition|waittime
+	AmiQueueLogExitWithTimeout  AmiQueueLogVerb = "EXITWITHTIMEOUT"  // position|origposition|waittime
+	AmiQueueLogPause            AmiQueueLogVerb = "PAUSE"            // reason
+	AmiQueueLogPauseAll         AmiQueueLogVerb = "PAUSEALL"         //
+	AmiQueueLogQueueStart       AmiQueueLogVerb = "QUEUESTART"       //
+	AmiQueueLogRemoveMember     AmiQueueLogVerb = "REMOVEMEMBER"     //
+	AmiQueueLogRingCanceled     AmiQueueLogVerb = "RINGCANCELED"     // ringtime (ms)
+	AmiQueueLogRingNoAnswer     AmiQueueLogVerb = "RINGNOANSWER"     // ringtime (ms)
+	AmiQueueLogSysCompat        AmiQueueLogVerb = "SYSCOMPAT"        //
+	AmiQueueLogTransfer         AmiQueueLogVerb = "TRANSFER"         // extension|context|holdtime|calltime|origposition
+	AmiQueueLogUnpause          AmiQueueLogVerb = "UNPAUSE"          // reason
+	AmiQueueLogUnpauseAll       AmiQueueLogVerb = "UNPAUSEALL"       //
+)
+
+// AmiCelEventKind represents the kind (EventName) of a Channel Event Logging (CEL) event
+type AmiCelEventKind string
+
+// AMI CEL event kinds raised by the CEL event in the field EventName.
+const (
+	AmiCelChanStart        AmiCelEventKind = "CHAN_START"       // a channel was created
+	AmiCelChanEnd          AmiCelEventKind = "CHAN_END"         // a channel was terminated
+	AmiCelAnswer           AmiCelEventKind = "ANSWER"           // a channel was answered
+	AmiCelHangup           AmiCelEventKind = "HANGUP"           // a channel was hung up
+	AmiCelAppStart         AmiCelEventKind = "APP_START"        // an application was started
+	AmiCelAppEnd           AmiCelEventKind = "APP_END"          // an application ended
+	AmiCelBridgeEnter      AmiCelEventKind = "BRIDGE_ENTER"     // a channel entered a bridge
+	AmiCelBridgeExit       AmiCelEventKind = "BRIDGE_EXIT"      // a channel left a bridge
+	AmiCelParkStart        AmiCelEventKind = "PARK_START"       // a channel was parked
+	AmiCelParkEnd          AmiCelEventKind = "PARK_END"         // a channel left the parking lot
+	AmiCelLinkedIdEnd      AmiCelEventKind = "LINKEDID_END"     // the last channel of the linkedid was terminated
+	AmiCelUserDefined      AmiCelEventKind = "USER_DEFINED"     // a user defined event raised by the dialplan CELGenUserEvent
+	AmiCelBlindTransfer    AmiCelEventKind = "BLINDTRANSFER"    // a blind transfer was executed
+	AmiCelAttendedTransfer AmiCelEventKind = "ATTENDEDTRANSFER" // an attended transfer was executed
+	AmiCelPickup           AmiCelEventKind = "PICKUP"           // a call was picked up
+	AmiCelForward          AmiCelEventKind = "FORWARD"          // a call was forwarded
+	AmiCelLocalOptimize    AmiCelEventKind = "LOCAL_OPTIMIZE"   // a local channel pair was optimized away
+)
+
+// AMI CEL extra keys, the extra field of the CEL event is a JSON object.
+const (
+	AmiCelExtraHangupCause  = "hangupcause"
+	AmiCelExtraHangupSource = "hangupsource"
+	AmiCelExtraDialStatus   = "dialstatus"
+)
+
+// AMI CDR analytics keys used for grouping and bucketing the CDR statistics.
+const (
+	AmiCdrGroupAll       = "all"
+	AmiCdrGroupExtension = "extension"
+	AmiCdrGroupTrunk     = "trunk"
+	AmiCdrGroupDirection = "direction"
+	AmiCdrGroupDID       = "did"
+	AmiCdrBucketNone     = ""
+	AmiCdrBucketHour     = "hour"
+	AmiCdrBucketDay      = "day"
+)
+
+// AmiCdrBackupTimeFormat is the time format of the suffix of the rotated CDR files, i.e: cdr.jsonl.20060102150405.000000000
+const AmiCdrBackupTimeFormat = "20060102150405.000000000"
+
+var (
+	// AmiCdrColumns is the default columns order of the CDR sinks (CSV, database).
+	AmiCdrColumns []string = []string{
+		AmiCdrFieldUniqueId,
+		AmiCdrFieldStartTime,
+		AmiCdrFieldAnswerTime,
+		AmiCdrFieldEndTime,
+		AmiCdrFieldDuration,
+		AmiCdrFieldBillableSeconds,
+		AmiCdrFieldDisposition,
+		AmiCdrFieldAmaFlags,
+		AmiCdrFieldAccountCode,
+		AmiCdrFieldSource,
+		AmiCdrFieldDestination,
+		AmiCdrFieldDestinationContext,
+		AmiCdrFieldCallerId,
+		AmiCdrFieldChannel,
+		AmiCdrFieldDestinationChannel,
+		AmiCdrFieldLastApplication,
+		AmiCdrFieldLastData,
+		AmiCdrFieldUserField,
+		AmiCdrFieldDirection,
+		AmiCdrFieldType,
+		AmiCdrFieldExtension,
+		AmiCdrFieldNumber,
+	}
 )
 
 // AmiChanspySpy, AmiChanspyBarge, and AmiChanspyWhisper are constants representing different modes
@@ -957,3 +1305,199 @@ var (
 		AmiChanspyWhisper: true,
 	}
 )
+
+// AmiIvrNodeKind represents the kind of a node of the IVR flow
+type AmiIvrNodeKind string
+
+// AMI IVR node kinds, the flow runs the nodes over the AGI session
+const (
+	AmiIvrNodePlay      AmiIvrNodeKind = "play"      // STREAM FILE the prompt, the digit pressed is kept
+	AmiIvrNodeCollect   AmiIvrNodeKind = "collect"   // GET DATA with min/max digits, timeout and retries
+	AmiIvrNodeBranch    AmiIvrNodeKind = "branch"    // go to the node of the variable value
+	AmiIvrNodeHttp      AmiIvrNodeKind = "http"      // look up the HTTP service, the response fields are kept
+	AmiIvrNodeSet       AmiIvrNodeKind = "set"       // SET VARIABLE
+	AmiIvrNodeExec      AmiIvrNodeKind = "exec"      // EXEC the dialplan application
+	AmiIvrNodeTransfer  AmiIvrNodeKind = "transfer"  // continue in the dialplan at context, extension and priority
+	AmiIvrNodeQueue     AmiIvrNodeKind = "queue"     // EXEC Queue
+	AmiIvrNodeVoicemail AmiIvrNodeKind = "voicemail" // EXEC VoiceMail
+	AmiIvrNodeHangup    AmiIvrNodeKind = "hangup"    // HANGUP
+)
+
+// AMI IVR branch keys of the collect and http nodes
+const (
+	AmiIvrBranchDefault = "default"
+	AmiIvrBranchTimeout = "timeout"
+	AmiIvrBranchInvalid = "invalid"
+	AmiIvrBranchError   = "error"
+)
+
+var (
+	AmiIvrNodeKinds map[AmiIvrNodeKind]bool = map[AmiIvrNodeKind]bool{
+		AmiIvrNodePlay:      true,
+		AmiIvrNodeCollect:   true,
+		AmiIvrNodeBranch:    true,
+		AmiIvrNodeHttp:      true,
+		AmiIvrNodeSet:       true,
+		AmiIvrNodeExec:      true,
+		AmiIvrNodeTransfer:  true,
+		AmiIvrNodeQueue:     true,
+		AmiIvrNodeVoicemail: true,
+		AmiIvrNodeHangup:    true,
+	}
+)
+
+// AMI ARI constants, the REST interface of Asterisk 12+ served by the HTTP server of Asterisk (http.conf)
+const (
+	AmiAriBasePath                = "/ari"
+	AmiAriDefaultPort             = 8088
+	AmiAriSnoopNone               = "none"
+	AmiAriSnoopIn                 = "in"
+	AmiAriSnoopOut                = "out"
+	AmiAriSnoopBoth               = "both"
+	AmiAriBridgeMixing            = "mixing"
+	AmiAriBridgeHolding           = "holding"
+	AmiAriPlaybackRestart         = "restart"
+	AmiAriPlaybackPause           = "pause"
+	AmiAriPlaybackUnpause         = "unpause"
+	AmiAriPlaybackReverse         = "reverse"
+	AmiAriPlaybackForward         = "forward"
+	AmiAriRecordIfExistsFail      = "fail"
+	AmiAriRecordIfExistsOverwrite = "overwrite"
+	AmiAriRecordIfExistsAppend    = "append"
+)
+
+// AMI WebSocket (RFC 6455) opcodes
+const (
+	AmiWebSocketContinuation = 0x0
+	AmiWebSocketText         = 0x1
+	AmiWebSocketBinary       = 0x2
+	AmiWebSocketClose        = 0x8
+	AmiWebSocketPing         = 0x9
+	AmiWebSocketPong         = 0xA
+	AmiWebSocketGuid         = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+	AmiWebSocketMaxPayload   = 16 << 20
+)
+
+// AMI ARI event types
+const (
+	AmiAriEventStasisStart          = "StasisStart"
+	AmiAriEventStasisEnd            = "StasisEnd"
+	AmiAriEventChannelDtmfReceived  = "ChannelDtmfReceived"
+	AmiAriEventChannelDestroyed     = "ChannelDestroyed"
+	AmiAriEventChannelStateChange   = "ChannelStateChange"
+	AmiAriEventChannelHangupRequest = "ChannelHangupRequest"
+	AmiAriEventPlaybackStarted      = "PlaybackStarted"
+	AmiAriEventPlaybackFinished     = "PlaybackFinished"
+	AmiAriEventRecordingStarted     = "RecordingStarted"
+	AmiAriEventRecordingFinished    = "RecordingFinished"
+	AmiAriEventRecordingFailed      = "RecordingFailed"
+)
+
+// AMI gateway permissions, granted to the bearer tokens of the HTTP gateway
+const (
+	AmiGatewayPermissionAll           = "*"
+	AmiGatewayPermissionCallsWrite    = "calls:write"
+	AmiGatewayPermissionChannelsRead  = "channels:read"
+	AmiGatewayPermissionChannelsWrite = "channels:write"
+	AmiGatewayPermissionQueuesRead    = "queues:read"
+	AmiGatewayPermissionQueuesWrite   = "queues:write"
+	AmiGatewayPermissionPeersRead     = "peers:read"
+)
+
+// AMI gateway error codes, returned as {"error": {"code": "...", "message": "..."}}
+const (
+	AmiGatewayErrorUnauthorized     = "unauthorized"
+	AmiGatewayErrorForbidden        = "forbidden"
+	AmiGatewayErrorNotFound         = "not_found"
+	AmiGatewayErrorMethodNotAllowed = "method_not_allowed"
+	AmiGatewayErrorInvalidRequest   = "invalid_request"
+	AmiGatewayErrorActionFailed     = "action_failed"
+	AmiGatewayErrorUnavailable      = "unavailable"
+	AmiGatewayErrorTimeout          = "timeout"
+)
+
+// AMI event stream formats, selected by the format query parameter of the stream endpoint
+const (
+	AmiEventStreamFormatRaw        = "raw"        // the fields of Asterisk, ProduceMessage
+	AmiEventStreamFormatTranslated = "translated" // the fields translated by the dictionary, ProduceMessageTranslator
+	AmiEventStreamFormatPure       = "pure"       // ProduceMessagePure
+)
+
+// AMI event stream query parameters
+const (
+	AmiEventStreamQueryEvents      = "events"        // comma separated event names or patterns, i.e: Hangup,Queue*
+	AmiEventStreamQueryFormat      = "format"        // raw, translated or pure
+	AmiEventStreamQueryLastEventId = "last_event_id" // the Last-Event-ID of the clients not able to set the header, i.e: WebSocket
+)
+
+// AMI metrics labels
+const (
+	AmiMetricOutcomeSuccess = "success" // the action got the success response
+	AmiMetricOutcomeFailure = "failure" // the action got the error response of Asterisk
+	AmiMetricOutcomeError   = "error"   // the action got no response, i.e: network error or timeout
+	AmiMetricClientAmi      = "ami"
+	AmiMetricClientAri      = "ari"
+	AmiMetricSourceStream   = "event_stream"
+	AmiMetricSourceStasis   = "stasis"
+	AmiMetricSourcePubSub   = "pubsub"
+)
+
+var (
+	// AmiMetricLatencyBuckets are the upper bounds (in seconds) of the action latency histogram
+	AmiMetricLatencyBuckets []float64 = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+)
+
+const (
+	AmiLogFieldComponent = "component"
+	AmiLogFieldAction    = "action"
+	AmiLogFieldActionId  = "action_id"
+	AmiLogFieldEvent     = "event"
+	AmiLogFieldUniqueId  = "uniqueid"
+	AmiLogFieldChannel   = "channel"
+	AmiLogFieldError     = "error"
+)
+
+const (
+	AmiLogComponentClient     = "client"
+	AmiLogComponentSocket     = "socket"
+	AmiLogComponentCommand    = "command"
+	AmiLogComponentEvent      = "event"
+	AmiLogComponentPubSub     = "pubsub"
+	AmiLogComponentDictionary = "dictionary"
+	AmiLogComponentNetwork    = "network"
+	AmiLogComponentCall       = "call"
+	AmiLogComponentGateway    = "gateway"
+	AmiLogComponentStream     = "stream"
+	AmiLogComponentMetrics    = "metrics"
+	AmiLogComponentAri        = "ari"
+	AmiLogComponentAgi        = "agi"
+	AmiLogComponentCdr        = "cdr"
+	AmiLogComponentIvr        = "ivr"
+	AmiLogComponentQueue      = "queue"
+	AmiLogComponentConference = "conference"
+	AmiLogComponentPresence   = "presence"
+	AmiLogComponentRestify    = "restify"
+)
+
+const (
+	AmiTapFormatText   = "text"
+	AmiTapFormatJson   = "jsonl"
+	AmiTapDirectionIn  = "in"
+	AmiTapDirectionOut = "out"
+	AmiTapRedacted     = "********"
+)
+
+var (
+	// AmiTapSensitiveHeaders are the headers redacted by the traffic tap by default (the password of Login and its MD5 key)
+	AmiTapSensitiveHeaders []string = []string{"Secret", "Key"}
+)
+
+const (
+	AmiTraceSpanPrefix        = "ami "
+	AmiTraceAttributeAction   = "ami.action"
+	AmiTraceAttributeActionId = "ami.action_id"
+	AmiTraceAttributeResponse = "ami.response"
+	AmiTraceAttributeEvent    = "ami.event"
+	AmiTraceAttributeUniqueId = "ami.uniqueid"
+	AmiTraceAttributeChannel  = "ami.channel"
+)
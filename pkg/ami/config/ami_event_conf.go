@@ -160,6 +160,9 @@ const (
 	AmiListenerEventMeetMeTalkRequest                  = "MeetmeTalkRequest"                  // Raised when a MeetMe user has started talking.
 	AmiListenerEventMemoryLimit                        = "MemoryLimit"                        // Raised when a request fails due to an internal memory allocation failure.
 	AmiListenerEventMiniVoiceMail                      = "MiniVoiceMail"                      // Raised when a notification is sent out by a MiniVoiceMail application
+	AmiListenerEventMixMonitorStart                    = "MixMonitorStart"                    // Raised when monitoring has started on a channel.
+	AmiListenerEventMixMonitorStop                     = "MixMonitorStop"                     // Raised when monitoring has stopped on a channel.
+	AmiListenerEventMixMonitorMute                     = "MixMonitorMute"                     // Raised when monitoring is muted or unmuted on a channel.
 	AmiListenerEventMonitorStart                       = "MonitorStart"                       // Raised when monitoring has started on a channel.
 	AmiListenerEventMonitorStop                        = "MonitorStop"                        // Raised when monitoring has stopped on a channel
 	AmiListenerEventMusicOnHoldStart                   = "MusicOnHoldStart"                   // Raised when music on hold has started on a channel.
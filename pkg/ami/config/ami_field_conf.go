@@ -77,4 +77,41 @@ const (
 	AmiFieldRecordFile          = "RecordFile"
 	AmiFieldUsername            = "Username"
 	AmiFieldSecret              = "Secret"
+	AmiFieldChannelType         = "ChannelType"
+	AmiFieldPeerStatus          = "PeerStatus"
+	AmiFieldAddress             = "Address"
+	AmiFieldTime                = "Time"
+	AmiFieldContactStatus       = "ContactStatus"
+	AmiFieldAOR                 = "AOR"
+	AmiFieldEndpointName        = "EndpointName"
+	AmiFieldRoundtripUsec       = "RoundtripUsec"
+	AmiFieldUserAgent           = "UserAgent"
+	AmiFieldDomain              = "Domain"
+	AmiFieldStatus              = "Status"
+	AmiFieldHint                = "Hint"
+	AmiFieldStatusText          = "StatusText"
+	AmiFieldPresentity          = "Presentity"
+	AmiFieldSubtype             = "Subtype"
+	AmiFieldTimeoutChannel      = "TimeoutChannel"
+	AmiFieldAnnounceChannel     = "AnnounceChannel"
+	AmiFieldReason              = "Reason"
+	AmiFieldUniqueId            = "Uniqueid"
+	AmiFieldLinkedId            = "Linkedid"
+	AmiFieldActionId            = "ActionID"
+	AmiFieldResponse            = "Response"
+	AmiFieldCauseText           = "Cause-txt"
+	AmiFieldMixMonitorId        = "MixMonitorID"
+	AmiFieldMemberName          = "MemberName"
+	AmiFieldHoldTime            = "HoldTime"
+	AmiFieldTalkTime            = "TalkTime"
+	AmiFieldRingTime            = "RingTime"
+	AmiFieldPosition            = "Position"
+	AmiFieldOriginalPosition    = "OriginalPosition"
+	AmiFieldPaused              = "Paused"
+	AmiFieldPausedReason        = "PausedReason"
+	AmiFieldCallerIdNum         = "CallerIDNum"
+	AmiFieldTimestamp           = "Timestamp"
+	AmiFieldQueueLogEvent       = "QueueLogEvent"
+	AmiFieldEnv                 = "Env"
+	AmiFieldResult              = "Result"
 )
@@ -41,6 +41,19 @@ const (
 	AmiJsonFieldSource             = "source"
 	AmiJsonFieldStartTime          = "start_time"
 	AmiJsonFieldUserField          = "user_field"
+	AmiJsonFieldPresentity         = "presentity"
+	AmiJsonFieldSubtype            = "subtype"
+	AmiJsonFieldEventName          = "event_name"
+	AmiJsonFieldEventTime          = "event_time"
+	AmiJsonFieldCallerIdNumber     = "caller_id_number"
+	AmiJsonFieldCallerIdName       = "caller_id_name"
+	AmiJsonFieldCallerIdAni        = "caller_id_ani"
+	AmiJsonFieldCallerIdRdnis      = "caller_id_rdnis"
+	AmiJsonFieldCallerIdDnid       = "caller_id_dnid"
+	AmiJsonFieldApplication        = "application"
+	AmiJsonFieldAppData            = "app_data"
+	AmiJsonFieldPeerAccount        = "peer_account"
+	AmiJsonFieldExtra              = "extra"
 )
 
 var (
@@ -0,0 +1,122 @@
+//go:build ignore
+
+// gen_action_fields generates ami_action_fields_conf.go, the fields of the actions
+// read from the Syntax of their definitions in ami_action_conf.go.
+//
+// Run it by: go generate ./pkg/ami/config
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"go/ast"
+	"go/format"
+	"go/parser"
+	"go/token"
+	"log"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// syntaxField matches a field of the Syntax, i.e: "Channel: <value>", "[Timeout:] <value>" or "UnitAmount(0): <value>"
+var syntaxField = regexp.MustCompile(`^\[?([A-Za-z][A-Za-z0-9_-]*(?:\([A-Za-z0-9]+\))?):\]?\s*<value>`)
+
+func main() {
+	fset := token.NewFileSet()
+	file, err := parser.ParseFile(fset, "ami_action_conf.go", nil, parser.ParseComments)
+	if err != nil {
+		log.Fatal(err)
+	}
+	fields := make(map[string][]string)
+	for _, decl := range file.Decls {
+		gen, ok := decl.(*ast.GenDecl)
+		if !ok || gen.Tok != token.CONST {
+			continue
+		}
+		for _, spec := range gen.Specs {
+			value := spec.(*ast.ValueSpec)
+			for i, name := range value.Names {
+				if !strings.HasPrefix(name.Name, "AmiAction") || i >= len(value.Values) {
+					continue
+				}
+				lit, ok := value.Values[i].(*ast.BasicLit)
+				if !ok || lit.Kind != token.STRING {
+					continue
+				}
+				action, err := strconv.Unquote(lit.Value)
+				if err != nil {
+					log.Fatal(err)
+				}
+				fields[action] = parse(value.Doc)
+			}
+		}
+	}
+	actions := make([]string, 0, len(fields))
+	for action := range fields {
+		actions = append(actions, action)
+	}
+	sort.Strings(actions)
+
+	var b bytes.Buffer
+	b.WriteString("// Code generated by gen_action_fields.go; DO NOT EDIT.\n\n")
+	b.WriteString("package config\n\n")
+	b.WriteString("// AmiActionFields holds the fields of the actions (without ActionID) by the action name,\n")
+	b.WriteString("// as documented by the Syntax of the actions in ami_action_conf.go.\n")
+	b.WriteString("var AmiActionFields = map[string][]string{\n")
+	for _, action := range actions {
+		quoted := make([]string, 0, len(fields[action]))
+		for _, field := range fields[action] {
+			quoted = append(quoted, strconv.Quote(field))
+		}
+		fmt.Fprintf(&b, "%q: {%v},\n", action, strings.Join(quoted, ", "))
+	}
+	b.WriteString("}\n")
+	source, err := format.Source(b.Bytes())
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := os.WriteFile("ami_action_fields_conf.go", source, 0o644); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// parse returns the fields of the Syntax of the doc, until the Args of the action.
+// Without the Syntax header, the fields are read from the Action line.
+func parse(doc *ast.CommentGroup) []string {
+	var fields []string
+	if doc == nil {
+		return fields
+	}
+	syntax := false
+	for _, line := range strings.Split(doc.Text(), "\n") {
+		line = strings.TrimSpace(line)
+		switch {
+		case strings.HasPrefix(line, "Syntax:"):
+			syntax = true
+			continue
+		case strings.HasPrefix(line, "Action:"):
+			syntax = true
+			continue
+		case strings.HasPrefix(line, "Args:"), strings.HasPrefix(line, "Arguments:"):
+			syntax = false
+			continue
+		case !syntax:
+			continue
+		}
+		m := syntaxField.FindStringSubmatch(line)
+		if m == nil || m[1] == "Action" || m[1] == "ActionID" {
+			continue
+		}
+		duplicated := false
+		for _, field := range fields {
+			duplicated = duplicated || field == m[1]
+		}
+		if !duplicated {
+			fields = append(fields, m[1])
+		}
+	}
+	return fields
+}